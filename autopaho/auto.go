@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"sync"
@@ -34,37 +35,80 @@ type WebSocketConfig struct {
 	Header func(url *url.URL, tlsCfg *tls.Config) http.Header       // If non-nil this will be called before each connection attempt to get headers to include with request
 }
 
+// DialFunc establishes a network connection with the broker at the provided URL; tlsCfg is the TLS configuration for
+// the attempt (from ClientConfig.TlsCfgFunc or ClientConfig.TlsCfg).
+// The returned connection must be ready for MQTT packets to be exchanged (i.e. any handshake must be complete).
+type DialFunc func(ctx context.Context, u *url.URL, tlsCfg *tls.Config) (net.Conn, error)
+
 // ClientConfig adds a few values, required to manage the connection, to the standard paho.ClientConfig (note that
 // conn will be ignored)
 type ClientConfig struct {
-	BrokerUrls        []*url.URL       // URL(s) for the broker (schemes supported include 'mqtt' and 'tls')
+	BrokerUrls        []*url.URL       // URL(s) for the broker (schemes supported include 'mqtt', 'tls', 'ws', 'wss' and 'unix' along with any in Dialers)
 	TlsCfg            *tls.Config      // Configuration used when connecting using TLS
 	KeepAlive         uint16           // Keepalive period in seconds (the maximum time interval that is permitted to elapse between the point at which the Client finishes transmitting one MQTT Control Packet and the point it starts sending the next)
 	ConnectRetryDelay time.Duration    // How long to wait between connection attempts (defaults to 10s)
 	ConnectTimeout    time.Duration    // How long to wait for the connection process to complete (defaults to 10s)
 	WebSocketCfg      *WebSocketConfig // Enables customisation of the websocket connection
 
-	OnConnectionUp func(*ConnectionManager, *paho.Connack) // Called (within a goroutine) when a connection is made (including reconnection). Connection Manager passed to simplify subscriptions.
-	OnConnectError func(error)                             // Called (within a goroutine) whenever a connection attempt fails
-
-	Debug     paho.Logger // By default set to NOOPLogger{},set to a logger for debugging info
-	PahoDebug paho.Logger // debugger passed to the paho package (will default to NOOPLogger{})
+	// TlsCfgFunc, if non-nil, is called before each connection attempt to obtain the TLS configuration for the broker at
+	// the provided URL (it takes precedence over TlsCfg). This allows credentials to change between connections and
+	// per-broker settings such as ServerName (SNI); see also CertificateReloader. It is only called for schemes that use
+	// TLS (e.g. 'tls' and 'wss', but not 'mqtt' or 'unix') and those handled by Dialers.
+	TlsCfgFunc func(*url.URL) (*tls.Config, error)
+
+	// Proxy, if non-nil, returns the proxy to use when connecting to the broker at the provided URL (nil means connect
+	// directly). Applies to the mqtt, tls, ws and wss schemes; HTTP CONNECT ('http' and 'https' URLs) and SOCKS5
+	// ('socks5' and 'socks5h' URLs) proxies are supported, with credentials taken from the URL. See ProxyURL and
+	// ProxyFromEnvironment. If nil websocket connections use the proxy specified in the environment (if any).
+	Proxy func(*url.URL) (*url.URL, error)
+
+	// CredentialsProvider, if non-nil, is called before each connection attempt to obtain the credentials (e.g. a
+	// short-lived token) to use when connecting to the broker at the provided URL. These take precedence over values
+	// set via SetUsernamePassword.
+	CredentialsProvider func(ctx context.Context, u *url.URL) (*Credentials, error)
+	// CredentialsRefreshMargin, if non-zero, is how long before Credentials.Expiry new credentials will be obtained.
+	// If Credentials.AuthMethod is set these are sent using MQTT v5 re-authentication, otherwise (or if
+	// re-authentication fails) the connection is re-established using the new credentials. Credentials that expire
+	// within the margin are treated as a CredentialsProvider error, and refreshes are at least ConnectRetryDelay apart
+	// (unless the credentials expire sooner).
+	CredentialsRefreshMargin time.Duration
+
+	// Dialers enables the use of custom transports (e.g. in-memory pipes, vsock or tunnels); the key is the URL scheme
+	// (lower case). A DialFunc provided here takes precedence over the built-in support for that scheme.
+	Dialers map[string]DialFunc
+
+	// SessionExpiryInterval is the time, in seconds, that the broker should retain the session after the connection
+	// drops (0, the default, means the session ends when the connection is closed). If non-zero then CleanStart will
+	// only be set on the initial connection (so the session, including subscriptions and unacknowledged QoS1/2
+	// messages, resumes on reconnection). If no Persistence is set in paho.ClientConfig then a paho.MemoryPersistence
+	// will be used (and shared between connections) to enable in-flight messages to be resent.
+	SessionExpiryInterval uint32
+
+	RedirectPolicy RedirectPolicy // How requests from the server to use another server (reason codes 0x9C/0x9D with a ServerReference) are handled (defaults to RedirectIgnore)
+	MaxRedirects   int            // Maximum number of consecutive redirects that will be followed without a successful connection (defaults to 5)
+
+	OnConnectionUp   func(*ConnectionManager, *paho.Connack) // Called (within a goroutine) when a connection is made (including reconnection). Connection Manager passed to simplify subscriptions (if Connack.SessionPresent is true the broker has retained the existing subscriptions).
+	OnConnectError   func(error)                             // Called (within a goroutine) whenever a connection attempt fails
+	OnServerRedirect func(ServerRedirect)                    // Called (within a goroutine) whenever the server requests that the client use another server (whether or not the redirect is followed)
+
+	// Debug and PahoDebug receive all log entries from this package and the paho package respectively (by default
+	// set to NOOPLogger{}); they are ignored if a StructuredLogger is set via paho.ClientConfig.Logger (which will then
+	// be used by both packages).
+	Debug     paho.Logger
+	PahoDebug paho.Logger
+
+	log paho.LevelLogger // logger used within this package (set by NewConnection based upon Logger/Debug)
 
 	connectUsername string
 	connectPassword []byte
+	credentials     *Credentials // obtained from CredentialsProvider for the current connection attempt
 
-	willTopic           string
-	willPayload         []byte
-	willQos             byte
-	willRetain          bool
-	willPayloadFormat   byte
-	willMessageExpiry   uint32
-	willContentType     string
-	willResponseTopic   string
-	willCorrelationData []byte
+	will *willConfig // Will message (see SetWill); shared with the ConnectionManager so it can be changed between connections
 
 	connectPacketBuilder func(*paho.Connect) *paho.Connect
 
+	sessionEstablished bool // set once a connection has been made (after which CleanStart is not set if the session has an expiry interval)
+
 	// We include the full paho.ClientConfig in order to simplify moving between the two packages.
 	// Note that that Conn will be ignored.
 	paho.ClientConfig
@@ -74,10 +118,16 @@ type ClientConfig struct {
 type ConnectionManager struct {
 	cli    *paho.Client  // The client will only be set when the connection is up (only updated within NewBrokerConnection goRoutine)
 	connUp chan struct{} // Channel is closed when the connection is up
-	mu     sync.Mutex    // protects both of the above
+	mu     sync.Mutex    // protects both of the above (along with draining and disconnect)
+
+	draining   bool             // set when DisconnectGracefully is called; no new publications will be accepted
+	disconnect *paho.Disconnect // the DISCONNECT packet sent when shutting down (defaults to reason code 0)
 
 	cancelCtx context.CancelFunc // Calling this will shut things down cleanly
 
+	will   *willConfig    // The Will that will be sent on the next connection (see SetWill)
+	status *statusTracker // Tracks the state of the connection (see Status and StatusEvents)
+
 	done chan struct{} // Channel that will be closed when the process has cleanly shutdown
 }
 
@@ -101,11 +151,22 @@ func (cfg *ClientConfig) SetUsernamePassword(username string, password []byte) {
 
 // SetWillMessage configures the Will topic, payload, QOS and Retain facets of the client connection
 // These values are staged in the ClientConfig, for later preparation of the Connect packet.
+// Any previously set Will properties are retained; if topic is empty the Will is removed (an empty payload is valid, as
+// with SetWill, and results in a zero length Will message). Use SetWill to configure the full Will (with validation).
 func (cfg *ClientConfig) SetWillMessage(topic string, payload []byte, qos byte, retain bool) {
-	cfg.willTopic = topic
-	cfg.willPayload = payload
-	cfg.willQos = qos
-	cfg.willRetain = retain
+	if len(topic) == 0 {
+		cfg.ClearWill()
+		return
+	}
+	var p *paho.WillProperties
+	if cfg.will != nil {
+		cfg.will.mu.Lock()
+		p = cfg.will.properties
+		cfg.will.mu.Unlock()
+	}
+	w := &willConfig{}
+	w.set(&paho.WillMessage{Topic: topic, Payload: payload, QoS: qos, Retain: retain}, p)
+	cfg.will = w
 }
 
 // SetConnectPacketConfigurator assigns a callback for modification of the Connect packet, called before the connection is opened, allowing the application to adjust its configuration before establishing a connection.
@@ -120,9 +181,19 @@ func (cfg *ClientConfig) SetConnectPacketConfigurator(fn func(*paho.Connect) *pa
 func (cfg *ClientConfig) buildConnectPacket() *paho.Connect {
 
 	cp := &paho.Connect{
-		KeepAlive:  cfg.KeepAlive,
-		ClientID:   cfg.ClientID,
-		CleanStart: true, // while persistence is not supported we should probably start clean...
+		KeepAlive: cfg.KeepAlive,
+		ClientID:  cfg.ClientID,
+		// If the session expires when the connection is lost we may as well start clean; otherwise only the initial
+		// connection starts clean (with subsequent connections resuming the session)
+		CleanStart: cfg.SessionExpiryInterval == 0 || !cfg.sessionEstablished,
+	}
+
+	if cfg.SessionExpiryInterval != 0 {
+		sessionExpiryInterval := cfg.SessionExpiryInterval
+		cp.Properties = &paho.ConnectProperties{
+			SessionExpiryInterval: &sessionExpiryInterval,
+			RequestProblemInfo:    true, // the default when no properties are sent
+		}
 	}
 
 	if len(cfg.connectUsername) > 0 {
@@ -135,28 +206,9 @@ func (cfg *ClientConfig) buildConnectPacket() *paho.Connect {
 		cp.Password = cfg.connectPassword
 	}
 
-	if len(cfg.willTopic) > 0 && len(cfg.willPayload) > 0 {
-		cp.WillMessage = &paho.WillMessage{
-			Retain:  cfg.willRetain,
-			Payload: cfg.willPayload,
-			Topic:   cfg.willTopic,
-			QoS:     cfg.willQos,
-		}
+	cp.WillMessage, cp.WillProperties = cfg.will.get(cfg.KeepAlive)
 
-		// how the broker should wait before considering the client disconnected
-		// hopefully this default is sensible for most applications, tolerating short interruptions
-		willDelayInterval := uint32(2 * cfg.KeepAlive)
-
-		cp.WillProperties = &paho.WillProperties{
-			// Most of these are nil/empty or defaults until related methods are exposed for configuration
-			WillDelayInterval: &willDelayInterval,
-			PayloadFormat:     &cfg.willPayloadFormat,
-			MessageExpiry:     &cfg.willMessageExpiry,
-			ContentType:       cfg.willContentType,
-			ResponseTopic:     cfg.willResponseTopic,
-			CorrelationData:   cfg.willCorrelationData,
-		}
-	}
+	applyCredentials(cp, cfg.credentials)
 
 	if nil != cfg.connectPacketBuilder {
 		cp = cfg.connectPacketBuilder(cp)
@@ -170,30 +222,60 @@ func NewConnection(ctx context.Context, cfg ClientConfig) (*ConnectionManager, e
 	if cfg.Debug == nil {
 		cfg.Debug = paho.NOOPLogger{}
 	}
+	cfg.log = paho.LevelLogger{StructuredLogger: cfg.Logger}
+	if cfg.Logger == nil {
+		cfg.log = paho.LevelLogger{StructuredLogger: paho.NewLoggerAdapter(cfg.Debug, cfg.Debug)}
+	}
 	if cfg.ConnectRetryDelay == 0 {
 		cfg.ConnectRetryDelay = 10 * time.Second
 	}
 	if cfg.ConnectTimeout == 0 {
 		cfg.ConnectTimeout = 10 * time.Second
 	}
+	if cfg.SessionExpiryInterval != 0 && cfg.Persistence == nil {
+		// The persistence needs to outlive each paho.Client so in-flight messages can be resent after reconnection
+		p := &paho.MemoryPersistence{}
+		p.Open()
+		cfg.Persistence = p
+	}
+
+	// Take a copy of the Will so that changes made via the ConnectionManager do not impact the callers config
+	cfg.will = cfg.will.clone()
 
 	innerCtx, cancel := context.WithCancel(ctx)
 	c := ConnectionManager{
 		cli:       nil,
 		connUp:    make(chan struct{}),
 		cancelCtx: cancel,
+		will:      cfg.will,
+		status:    newStatusTracker(),
 		done:      make(chan struct{}),
 	}
-	errChan := make(chan error)
 
 	go func() {
 		defer close(c.done)
+		defer c.status.stop()
 
+		// Followed redirects are reported as a status change (in addition to calling the users callback)
+		rCfg := cfg
+		rCfg.OnServerRedirect = func(sr ServerRedirect) {
+			if sr.Followed {
+				c.status.redirected(sr)
+			}
+			if cfg.OnServerRedirect != nil {
+				cfg.OnServerRedirect(sr)
+			}
+		}
+		redirects := newRedirector(&rCfg)
 	mainLoop:
 		for {
-			// Error handler is used to guarantee that a single error will be received whenever the connection is lost
+			// Error handler is used to guarantee that a single error will be received whenever the connection is lost.
+			// Each connection has its own (buffered) channel so that an error from a connection that has been abandoned
+			// (e.g. when reconnecting to refresh credentials) never blocks, and cannot be mistaken for the loss of the
+			// next connection.
+			errChan := make(chan error, 1)
 			eh := errorHandler{
-				debug:                  cfg.Debug,
+				log:                    cfg.log,
 				mu:                     sync.Mutex{},
 				errChan:                errChan,
 				userOnClientError:      cfg.OnClientError,
@@ -203,19 +285,34 @@ func NewConnection(ctx context.Context, cfg ClientConfig) (*ConnectionManager, e
 			cliCfg.OnClientError = eh.onClientError
 			cliCfg.OnServerDisconnect = eh.onServerDisconnect
 
-			cli, connAck := establishBrokerConnection(innerCtx, cliCfg)
+			cli, connAck, brokerURL, creds := establishBrokerConnection(innerCtx, cliCfg, redirects, c.status)
 			if cli == nil {
+				c.status.shuttingDown()
 				break mainLoop // Only occurs when context is cancelled
 			}
+			if cfg.sessionEstablished && cfg.Metrics != nil {
+				cfg.Metrics.Reconnected()
+			}
+			cfg.sessionEstablished = true
+			// The client and status must be updated before connUp is closed so that anything waiting in
+			// AwaitConnection sees the connection as up when it returns.
+			c.status.up(brokerURL)
 			c.mu.Lock()
 			c.cli = cli
-			c.mu.Unlock()
 			close(c.connUp)
+			c.mu.Unlock()
+			cfg.log.Info("connection up", brokerField(brokerURL))
 
 			if cfg.PahoDebug != nil {
-				cli.SetDebugLogger(cfg.PahoDebug)
+				cli.SetDebugLogger(cfg.PahoDebug) // has no effect if Logger is set
 			}
 
+			// Credentials are refreshed (if required) for the lifetime of this connection
+			refreshCtx, cancelRefresh := context.WithCancel(innerCtx)
+			reconnect := make(chan error, 1)
+			refreshCfg := cfg // copy as cfg is modified by this goroutine
+			go refreshCredentials(refreshCtx, &refreshCfg, cli, brokerURL, creds, reconnect)
+
 			if cfg.OnConnectionUp != nil {
 				cfg.OnConnectionUp(&c, connAck)
 			}
@@ -223,25 +320,45 @@ func NewConnection(ctx context.Context, cfg ClientConfig) (*ConnectionManager, e
 			var err error
 			select {
 			case err = <-errChan: // Message on error channel indicates connection has (or will) drop.
+			case err = <-reconnect: // Credentials could not be refreshed on the current connection
+				if dErr := cli.Disconnect(&paho.Disconnect{ReasonCode: 0}); dErr != nil {
+					cfg.log.Warn("disconnect returned error", paho.ErrorField(dErr))
+				}
 			case <-innerCtx.Done():
+				c.status.shuttingDown()
 				// As the connection is up we call disconnect to shut things down cleanly
-				if err = c.cli.Disconnect(&paho.Disconnect{ReasonCode: 0}); err != nil {
-					cfg.Debug.Printf("disconnect returned error: %s\n", err)
+				c.mu.Lock()
+				d := c.disconnect
+				c.mu.Unlock()
+				if d == nil {
+					d = &paho.Disconnect{ReasonCode: 0}
+				}
+				if err = c.cli.Disconnect(d); err != nil {
+					cfg.log.Warn("disconnect returned error", paho.ErrorField(err))
 				}
 				if ctx.Err() != nil { // If this is due to outer context being cancelled then this will have happened before the inner one gets cancelled.
-					cfg.Debug.Printf("broker connection handler exiting due to context: %s\n", ctx.Err())
+					cfg.log.Info("broker connection handler exiting due to context", paho.ErrorField(ctx.Err()))
 				} else {
-					cfg.Debug.Printf("broker connection handler exiting due to Disconnect call: %s\n", innerCtx.Err())
+					cfg.log.Info("broker connection handler exiting due to Disconnect call", paho.ErrorField(innerCtx.Err()))
 				}
+				cancelRefresh()
 				break mainLoop
 			}
+			cancelRefresh()
 			c.mu.Lock()
 			c.cli = nil
 			c.connUp = make(chan struct{})
 			c.mu.Unlock()
-			cfg.Debug.Printf("connection to broker lost (%s); will reconnect\n", err)
+			cfg.log.Warn("connection to broker lost; will reconnect", brokerField(brokerURL), paho.ErrorField(err))
+			c.status.down(brokerURL, err)
+
+			// The server may have asked us to connect elsewhere (e.g. when shutting down for maintenance)
+			var de *DisconnectError
+			if errors.As(err, &de) && de.disconnect != nil && de.disconnect.Properties != nil {
+				redirects.handle(brokerURL, de.disconnect.ReasonCode, de.disconnect.Properties.ServerReference)
+			}
 		}
-		cfg.Debug.Println("connection manager has terminated")
+		cfg.log.Info("connection manager has terminated")
 	}()
 	return &c, nil
 }
@@ -258,6 +375,34 @@ func (c *ConnectionManager) Disconnect(ctx context.Context) error {
 	}
 }
 
+// DisconnectGracefully shuts down the connection manager after allowing in-flight messages to complete. New publications
+// are rejected (Publish returns paho.ErrDraining) and, if the connection is up, the call blocks until all outgoing QoS1/2
+// messages have been acknowledged by the broker and any manual acknowledgements have been sent. The DISCONNECT packet d
+// (which may be nil, meaning reason code 0) is then sent; this allows the reason code and session expiry interval to be
+// specified (e.g. reason code 0x04 "Disconnect with Will Message" or a SessionExpiryInterval of 0 to end the session).
+// If ctx is done before draining completes the connection is closed anyway (unacknowledged messages remain in the
+// Persistence) and ctx.Err() is returned.
+func (c *ConnectionManager) DisconnectGracefully(ctx context.Context, d *paho.Disconnect) error {
+	c.mu.Lock()
+	c.draining = true
+	c.disconnect = d
+	cli := c.cli
+	c.mu.Unlock()
+
+	if cli != nil {
+		// An error means that either ctx is done (handled below) or the connection has been lost; in the latter case
+		// there is nothing more we can do (unacknowledged messages will be resent if the session is resumed)
+		_ = cli.Drain(ctx)
+	}
+	c.cancelCtx()
+	select {
+	case <-c.done: // wait for goroutine to exit
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Done returns a channel that will be closed when the connection handler has shutdown cleanly
 // Note: We cannot currently tell when the mqtt has fully shutdown (so it may still be in the process of closing down)
 func (c *ConnectionManager) Done() <-chan struct{} {
@@ -319,8 +464,12 @@ func (c *ConnectionManager) Unsubscribe(ctx context.Context, u *paho.Unsubscribe
 func (c *ConnectionManager) Publish(ctx context.Context, p *paho.Publish) (*paho.PublishResponse, error) {
 	c.mu.Lock()
 	cli := c.cli
+	draining := c.draining
 	c.mu.Unlock()
 
+	if draining {
+		return nil, paho.ErrDraining
+	}
 	if cli == nil {
 		return nil, ConnectionDownError
 	}
@@ -92,3 +92,37 @@ func TestClientConfig_buildConnectPacket(t *testing.T) {
 	}
 
 }
+
+func TestClientConfig_buildConnectPacketSession(t *testing.T) {
+	config := ClientConfig{
+		KeepAlive:    5,
+		ClientConfig: paho.ClientConfig{ClientID: "test"},
+	}
+
+	cp := config.buildConnectPacket()
+	if !cp.CleanStart || cp.Properties != nil {
+		t.Errorf("Expected CleanStart and no properties when no session expiry set, got: cleanStart=%v properties=%v", cp.CleanStart, cp.Properties)
+	}
+	config.sessionEstablished = true
+	if cp = config.buildConnectPacket(); !cp.CleanStart {
+		t.Error("Expected CleanStart on reconnection when no session expiry set")
+	}
+
+	config.SessionExpiryInterval = 3600
+	config.sessionEstablished = false
+	cp = config.buildConnectPacket()
+	if !cp.CleanStart {
+		t.Error("Expected CleanStart on initial connection")
+	}
+	if cp.Properties == nil || cp.Properties.SessionExpiryInterval == nil || *cp.Properties.SessionExpiryInterval != 3600 {
+		t.Errorf("Expected session expiry interval of 3600, got: %v", cp.Properties)
+	}
+	if !cp.Properties.RequestProblemInfo {
+		t.Error("Expected RequestProblemInfo to retain its default value")
+	}
+
+	config.sessionEstablished = true
+	if cp = config.buildConnectPacket(); cp.CleanStart {
+		t.Error("Expected session to be resumed on reconnection")
+	}
+}
@@ -0,0 +1,105 @@
+package autopaho
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Certificate reloading functionality for AutoPaho
+
+// CertificateReloader provides a client certificate, loaded from a certificate and key file, that is reloaded when
+// either file changes. Files are checked whenever a certificate is requested (i.e. once per TLS handshake) so, when
+// used via GetClientCertificate, each connection attempt will use the current credentials without a restart.
+//
+// If reloading fails (e.g. the files are part way through being replaced) the previously loaded certificate continues
+// to be used and the error is passed to OnError (if set); the reload will be retried on the next request.
+type CertificateReloader struct {
+	certFile, keyFile string
+
+	OnError func(error) // If non-nil, called when the certificate cannot be reloaded (the previous certificate is used)
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod fileVersion
+	keyMod  fileVersion
+}
+
+// fileVersion is used to detect changes to a file
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFile returns the current version of the file
+func statFile(name string) (fileVersion, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// NewCertificateReloader loads the PEM encoded certificate and key from the specified files (returning an error if
+// this fails) and returns a CertificateReloader that will reload them if they change.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload unconditionally loads the certificate and key from the files (e.g. in response to SIGHUP); if this fails
+// the previously loaded certificate is retained.
+func (r *CertificateReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reload()
+}
+
+// reload loads the certificate and key (mu must be held)
+func (r *CertificateReloader) reload() error {
+	// Stat before loading so that changes made during the load are picked up next time
+	certMod, err := statFile(r.certFile)
+	if err != nil {
+		return fmt.Errorf("unable to access certificate: %w", err)
+	}
+	keyMod, err := statFile(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to access key: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate: %w", err)
+	}
+	r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+	return nil
+}
+
+// Certificate returns the current certificate, reloading it first if either file has changed
+func (r *CertificateReloader) Certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certMod, certErr := statFile(r.certFile)
+	keyMod, keyErr := statFile(r.keyFile)
+	if certErr != nil || keyErr != nil || certMod != r.certMod || keyMod != r.keyMod {
+		if err := r.reload(); err != nil {
+			if r.cert == nil {
+				return nil, err
+			}
+			if r.OnError != nil {
+				r.OnError(err)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// GetClientCertificate is suitable for use as tls.Config.GetClientCertificate
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
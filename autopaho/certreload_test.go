@@ -0,0 +1,171 @@
+package autopaho
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+// writeCertificate generates a self signed certificate with the specified common name and writes it (and the key)
+// to the files; modTime is applied to both files so that changes can be reliably detected
+func writeCertificate(t *testing.T, cn, certFile, keyFile string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{certFile, keyFile} {
+		if err = os.Chtimes(f, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// commonName returns the common name of the leaf certificate
+func commonName(t *testing.T, c *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autopaho")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if _, err = NewCertificateReloader(certFile, keyFile); err == nil {
+		t.Fatal("expected error when files do not exist")
+	}
+
+	now := time.Now()
+	writeCertificate(t, "first", certFile, keyFile, now.Add(-time.Minute))
+	r, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	r.OnError = func(err error) { errs = append(errs, err) }
+
+	c, err := r.GetClientCertificate(nil)
+	if err != nil || commonName(t, c) != "first" {
+		t.Fatalf("expected first certificate, got %v (err %v)", c, err)
+	}
+
+	writeCertificate(t, "second", certFile, keyFile, now)
+	if c, _ = r.Certificate(); commonName(t, c) != "second" {
+		t.Fatalf("expected reloaded certificate, got %s", commonName(t, c))
+	}
+
+	// A partially written certificate should not replace the current one
+	if err = ioutil.WriteFile(certFile, []byte("-----BEGIN CERT"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = r.Certificate(); err != nil || commonName(t, c) != "second" {
+		t.Fatalf("expected previous certificate to be retained, got %v (err %v)", c, err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected reload error to be reported, got %v", errs)
+	}
+}
+
+func TestConnectionManagerTlsCfgFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autopaho")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertificate(t, "device", certFile, keyFile, time.Now())
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest provides a server certificate valid for 127.0.0.1
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	serverCert := srv.TLS.Certificates[0]
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	srv.Close()
+
+	clientCN := make(chan string, 1)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err == nil {
+				clientCN <- leaf.Subject.CommonName
+			}
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveFakeBroker(l, &packets.Connack{Properties: &packets.Properties{}}, nil)
+
+	var mu sync.Mutex
+	var requested []string
+	u, _ := url.Parse("tls://" + l.Addr().String())
+	connectVia(t, ClientConfig{
+		TlsCfg: &tls.Config{}, // should be ignored as TlsCfgFunc is set
+		TlsCfgFunc: func(u *url.URL) (*tls.Config, error) {
+			mu.Lock()
+			requested = append(requested, u.String())
+			mu.Unlock()
+			return &tls.Config{RootCAs: pool, GetClientCertificate: reloader.GetClientCertificate}, nil
+		},
+	}, u)
+
+	if cn := <-clientCN; cn != "device" {
+		t.Fatalf("expected client certificate from reloader, got %s", cn)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requested) != 1 || requested[0] != u.String() {
+		t.Fatalf("expected TlsCfgFunc to be called for %s, got %v", u, requested)
+	}
+}
@@ -0,0 +1,145 @@
+package autopaho
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// Credential (e.g. short-lived token) management functionality for AutoPaho
+
+// Credentials are returned by ClientConfig.CredentialsProvider and used when connecting to the broker
+type Credentials struct {
+	Username string // If non-empty, replaces any username set via SetUsernamePassword
+	Password []byte // If non-empty, replaces any password set via SetUsernamePassword
+
+	// AuthMethod and AuthData are sent in the CONNECT properties when AuthMethod is non-empty (MQTT v5 enhanced
+	// authentication); credentials obtained in this way can be refreshed without reconnecting (see
+	// ClientConfig.CredentialsRefreshMargin).
+	AuthMethod string
+	AuthData   []byte
+
+	Expiry time.Time // When the credentials expire (zero if they do not)
+}
+
+// errCredentialsExpiring is passed to the connection manager when credentials could not be refreshed via
+// re-authentication (and the connection must be re-established in order to use new credentials)
+var errCredentialsExpiring = errors.New("credentials expiring, reconnecting to refresh")
+
+// errCredentialsTooShortLived is returned by getCredentials if the credentials expire within the
+// CredentialsRefreshMargin (using them would mean refreshing immediately)
+var errCredentialsTooShortLived = errors.New("credentials expire within CredentialsRefreshMargin")
+
+// applyCredentials sets the authentication related fields of the Connect packet based upon creds
+func applyCredentials(cp *paho.Connect, creds *Credentials) {
+	if creds == nil {
+		return
+	}
+	if len(creds.Username) > 0 {
+		cp.UsernameFlag = true
+		cp.Username = creds.Username
+	}
+	if len(creds.Password) > 0 {
+		cp.PasswordFlag = true
+		cp.Password = creds.Password
+	}
+	if creds.AuthMethod != "" {
+		if cp.Properties == nil {
+			cp.Properties = &paho.ConnectProperties{
+				RequestProblemInfo: true, // the default when no properties are sent
+			}
+		}
+		cp.Properties.AuthMethod = creds.AuthMethod
+		cp.Properties.AuthData = creds.AuthData
+	}
+}
+
+// getCredentials calls the credentials provider (if any) for the broker at u
+func getCredentials(ctx context.Context, cfg *ClientConfig, u *url.URL) (*Credentials, error) {
+	if cfg.CredentialsProvider == nil {
+		return nil, nil
+	}
+	creds, err := cfg.CredentialsProvider(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain credentials: %w", err)
+	}
+	if creds != nil && !creds.Expiry.IsZero() && cfg.CredentialsRefreshMargin > 0 &&
+		time.Until(creds.Expiry) <= cfg.CredentialsRefreshMargin {
+		return nil, fmt.Errorf("unable to obtain credentials: %w (expiry %s)", errCredentialsTooShortLived, creds.Expiry)
+	}
+	return creds, nil
+}
+
+// refreshCredentials runs (until ctx is done) for the duration of a connection, obtaining new credentials
+// CredentialsRefreshMargin before the current ones expire (but no more often than ConnectRetryDelay unless they expire
+// first). If enhanced authentication is in use the new credentials are sent via AUTH (re-authentication); otherwise,
+// or if re-authentication fails, an error is sent to reconnect (the connection manager will then disconnect and
+// reconnect using fresh credentials).
+func refreshCredentials(ctx context.Context, cfg *ClientConfig, cli *paho.Client, u *url.URL, creds *Credentials, reconnect chan<- error) {
+	if cfg.CredentialsRefreshMargin <= 0 {
+		return
+	}
+	for creds != nil && !creds.Expiry.IsZero() {
+		wait := time.Until(creds.Expiry.Add(-cfg.CredentialsRefreshMargin))
+		if wait < cfg.ConnectRetryDelay { // limit the refresh rate (but do not let the credentials expire)
+			wait = cfg.ConnectRetryDelay
+			if untilExpiry := time.Until(creds.Expiry); untilExpiry < wait {
+				wait = untilExpiry
+			}
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if creds.AuthMethod == "" { // Username/password can only be changed by reconnecting
+			reconnect <- errCredentialsExpiring
+			return
+		}
+		newCreds, err := getCredentials(ctx, cfg, u)
+		if err == nil && (newCreds == nil || newCreds.AuthMethod != creds.AuthMethod) {
+			err = errors.New("re-authentication must use the same authentication method")
+		}
+		if err == nil {
+			err = reauthenticate(ctx, cfg, cli, newCreds)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			cfg.log.Warn("re-authentication failed; reconnecting", paho.ErrorField(err))
+			reconnect <- fmt.Errorf("%w: %s", errCredentialsExpiring, err)
+			return
+		}
+		creds = newCreds
+	}
+}
+
+// reauthenticate sends an AUTH packet, with reason code 0x19 (Re-authenticate), containing the new credentials and
+// waits for the exchange to complete (any continuation is handled by the paho.ClientConfig.AuthHandler)
+func reauthenticate(ctx context.Context, cfg *ClientConfig, cli *paho.Client, creds *Credentials) error {
+	authCtx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
+	ar, err := cli.Authenticate(authCtx, &paho.Auth{
+		ReasonCode: packets.AuthReauthenticate,
+		Properties: &paho.AuthProperties{
+			AuthMethod: creds.AuthMethod,
+			AuthData:   creds.AuthData,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if !ar.Success || ar.ReasonCode != packets.AuthSuccess {
+		return fmt.Errorf("re-authentication rejected (reason: %d)", ar.ReasonCode)
+	}
+	return nil
+}
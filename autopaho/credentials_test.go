@@ -0,0 +1,219 @@
+package autopaho
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func TestClientConfig_buildConnectPacketCredentials(t *testing.T) {
+	config := ClientConfig{KeepAlive: 5, ClientConfig: paho.ClientConfig{ClientID: "test"}}
+	config.SetUsernamePassword("static", []byte("static"))
+	config.credentials = &Credentials{
+		Password:   []byte("token"),
+		AuthMethod: "TOKEN",
+		AuthData:   []byte("data"),
+	}
+
+	cp := config.buildConnectPacket()
+	if cp.Username != "static" || string(cp.Password) != "token" || !cp.PasswordFlag {
+		t.Errorf("expected provided password to replace static one, got: username=%s password=%s", cp.Username, cp.Password)
+	}
+	if cp.Properties == nil || cp.Properties.AuthMethod != "TOKEN" || string(cp.Properties.AuthData) != "data" {
+		t.Fatalf("expected auth method and data in properties, got: %+v", cp.Properties)
+	}
+	if !cp.Properties.RequestProblemInfo {
+		t.Error("expected RequestProblemInfo to retain its default value")
+	}
+}
+
+// awaitReconnect waits until the connection manager has reconnected
+func awaitReconnect(t *testing.T, cm *ConnectionManager) {
+	deadline := time.Now().Add(5 * time.Second)
+	for cm.Status().Reconnects == 0 || cm.Status().State != StateUp {
+		if time.Now().After(deadline) {
+			t.Fatalf("connection was not re-established: %+v", cm.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnectionManagerCredentialsRefreshByReconnect(t *testing.T) {
+	broker := fakeBroker(t, &packets.Connack{Properties: &packets.Properties{}})
+	defer broker.Close()
+
+	var mu sync.Mutex
+	var issued int
+	u, _ := url.Parse("tcp://" + broker.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cm, err := NewConnection(ctx, ClientConfig{
+		BrokerUrls:        []*url.URL{u},
+		KeepAlive:         30,
+		ConnectRetryDelay: 50 * time.Millisecond,
+		CredentialsProvider: func(context.Context, *url.URL) (*Credentials, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			issued++
+			expiry := time.Now().Add(time.Hour)
+			if issued > 1 {
+				expiry = expiry.Add(time.Hour) // only the first credentials need refreshing during the test
+			}
+			return &Credentials{Username: "user", Password: []byte("token"), Expiry: expiry}, nil
+		},
+		CredentialsRefreshMargin: time.Hour - 200*time.Millisecond, // first refresh after 200ms
+		ClientConfig:             paho.ClientConfig{ClientID: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cm.AwaitConnection(ctx); err != nil {
+		t.Fatalf("connection not established: %s", err)
+	}
+
+	awaitReconnect(t, cm)
+	time.Sleep(300 * time.Millisecond) // an error from the abandoned connection must not drop the new one
+	status := cm.Status()
+	if status.State != StateUp || status.Reconnects != 1 {
+		t.Errorf("expected exactly one reconnect, got %+v", status)
+	}
+	if !errors.Is(status.LastError, errCredentialsExpiring) {
+		t.Errorf("expected connection to be lost due to expiring credentials, got %v", status.LastError)
+	}
+	mu.Lock()
+	if issued != 2 {
+		t.Errorf("expected credentials to be obtained for each connection, got %d", issued)
+	}
+	mu.Unlock()
+
+	if err = cm.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// noContinuationAuther is a paho.Auther for authentication methods that need no continuation (0x18) exchange
+type noContinuationAuther struct{}
+
+func (noContinuationAuther) Authenticate(a *paho.Auth) *paho.Auth { return a }
+func (noContinuationAuther) Authenticated()                       {}
+
+func TestConnectionManagerCredentialsRefreshByReauthentication(t *testing.T) {
+	authData := make(chan string, 10)
+	broker := fakeBrokerFunc(t, &packets.Connack{Properties: &packets.Properties{AuthMethod: "TOKEN"}}, func(conn net.Conn, p *packets.ControlPacket) {
+		if a, ok := p.Content.(*packets.Auth); ok && a.ReasonCode == packets.AuthReauthenticate {
+			authData <- string(a.Properties.AuthData)
+			_, _ = (&packets.Auth{ReasonCode: packets.AuthSuccess, Properties: &packets.Properties{AuthMethod: "TOKEN"}}).WriteTo(conn)
+		}
+	})
+	defer broker.Close()
+
+	var mu sync.Mutex
+	var issued int
+	u, _ := url.Parse("tcp://" + broker.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cm, err := NewConnection(ctx, ClientConfig{
+		BrokerUrls:        []*url.URL{u},
+		KeepAlive:         30,
+		ConnectRetryDelay: 50 * time.Millisecond,
+		CredentialsProvider: func(context.Context, *url.URL) (*Credentials, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			issued++
+			return &Credentials{
+				AuthMethod: "TOKEN",
+				AuthData:   []byte{byte('0' + issued)},
+				Expiry:     time.Now().Add(time.Hour),
+			}, nil
+		},
+		CredentialsRefreshMargin: time.Hour - 200*time.Millisecond,
+		ClientConfig:             paho.ClientConfig{ClientID: "test", AuthHandler: noContinuationAuther{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cm.AwaitConnection(ctx); err != nil {
+		t.Fatalf("connection not established: %s", err)
+	}
+
+	for _, want := range []string{"2", "3"} {
+		select {
+		case got := <-authData:
+			if got != want {
+				t.Fatalf("expected re-authentication with token %s, got %s", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("re-authentication did not occur")
+		}
+	}
+	if s := cm.Status(); s.Reconnects != 0 {
+		t.Fatalf("expected credentials to be refreshed without reconnecting, got %+v", s)
+	}
+
+	if err = cm.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetCredentialsTooShortLived(t *testing.T) {
+	cfg := ClientConfig{
+		CredentialsProvider: func(context.Context, *url.URL) (*Credentials, error) {
+			return &Credentials{Username: "user", Expiry: time.Now().Add(time.Minute)}, nil
+		},
+		CredentialsRefreshMargin: time.Hour,
+	}
+	if _, err := getCredentials(context.Background(), &cfg, nil); !errors.Is(err, errCredentialsTooShortLived) {
+		t.Fatalf("expected errCredentialsTooShortLived, got %v", err)
+	}
+}
+
+func TestConnectionManagerCredentialsAlwaysShortLived(t *testing.T) {
+	broker := fakeBroker(t, &packets.Connack{Properties: &packets.Properties{}})
+	defer broker.Close()
+
+	for _, authMethod := range []string{"", "TOKEN"} {
+		var mu sync.Mutex
+		var issued int
+		u, _ := url.Parse("tcp://" + broker.Addr().String())
+		ctx, cancel := context.WithCancel(context.Background())
+		cm, err := NewConnection(ctx, ClientConfig{
+			BrokerUrls:        []*url.URL{u},
+			KeepAlive:         30,
+			ConnectRetryDelay: 50 * time.Millisecond,
+			CredentialsProvider: func(context.Context, *url.URL) (*Credentials, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				issued++
+				// The credentials are already within the refresh margin when issued
+				return &Credentials{Password: []byte("token"), AuthMethod: authMethod, Expiry: time.Now().Add(10 * time.Millisecond)}, nil
+			},
+			CredentialsRefreshMargin: time.Second,
+			ClientConfig:             paho.ClientConfig{ClientID: "test", AuthHandler: noContinuationAuther{}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+		mu.Lock()
+		n := issued
+		mu.Unlock()
+		// Each attempt fails and is followed by ConnectRetryDelay (so around 10 attempts)
+		if n == 0 || n > 20 {
+			t.Errorf("auth method %q: expected credentials to be requested once per connection attempt, got %d requests", authMethod, n)
+		}
+		status := cm.Status()
+		if status.State == StateUp || !errors.Is(status.LastError, errCredentialsTooShortLived) {
+			t.Errorf("auth method %q: expected connection attempts to fail due to short-lived credentials, got %+v", authMethod, status)
+		}
+		cancel()
+		<-cm.Done()
+	}
+}
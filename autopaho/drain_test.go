@@ -0,0 +1,122 @@
+package autopaho
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func TestConnectionManagerDisconnectGracefully(t *testing.T) {
+	var ackPublish = make(chan struct{})
+	disconnect := make(chan *packets.Disconnect, 1)
+	broker := fakeBrokerFunc(t, &packets.Connack{Properties: &packets.Properties{}}, func(conn net.Conn, p *packets.ControlPacket) {
+		switch c := p.Content.(type) {
+		case *packets.Publish:
+			go func() { // PUBACK is delayed until the test allows it
+				<-ackPublish
+				_, _ = (&packets.Puback{PacketID: c.PacketID, Properties: &packets.Properties{}}).WriteTo(conn)
+			}()
+		case *packets.Disconnect:
+			disconnect <- c
+		}
+	})
+	defer broker.Close()
+
+	u, _ := url.Parse("tcp://" + broker.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cm, err := NewConnection(ctx, ClientConfig{
+		BrokerUrls:   []*url.URL{u},
+		KeepAlive:    30,
+		ClientConfig: paho.ClientConfig{ClientID: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cm.AwaitConnection(ctx); err != nil {
+		t.Fatalf("connection not established: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond) // allow the client goroutines to start before disconnecting
+
+	published := make(chan error, 1)
+	go func() {
+		_, err := cm.Publish(ctx, &paho.Publish{Topic: "test", QoS: 1, Payload: []byte("in-flight")})
+		published <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // allow the PUBLISH to be sent
+
+	disconnected := make(chan error, 1)
+	expiry := uint32(0)
+	go func() {
+		disconnected <- cm.DisconnectGracefully(ctx, &paho.Disconnect{
+			ReasonCode: 0x04, // Disconnect with Will Message
+			Properties: &paho.DisconnectProperties{SessionExpiryInterval: &expiry},
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := cm.Publish(ctx, &paho.Publish{Topic: "test", Payload: []byte("too late")}); err != paho.ErrDraining {
+		t.Fatalf("expected ErrDraining, got %v", err)
+	}
+	select {
+	case <-disconnect:
+		t.Fatal("DISCONNECT sent before in-flight message acknowledged")
+	case <-disconnected:
+		t.Fatal("DisconnectGracefully returned before in-flight message acknowledged")
+	default:
+	}
+
+	close(ackPublish)
+	if err := <-published; err != nil {
+		t.Fatalf("in-flight publish failed: %s", err)
+	}
+	if err := <-disconnected; err != nil {
+		t.Fatalf("DisconnectGracefully failed: %s", err)
+	}
+	d := <-disconnect
+	if d.ReasonCode != 0x04 || d.Properties.SessionExpiryInterval == nil || *d.Properties.SessionExpiryInterval != 0 {
+		t.Fatalf("unexpected DISCONNECT: %+v", d)
+	}
+}
+
+func TestConnectionManagerDisconnectGracefullyTimeout(t *testing.T) {
+	broker := fakeBroker(t, &packets.Connack{Properties: &packets.Properties{}}) // PUBLISH never acknowledged
+	defer broker.Close()
+
+	u, _ := url.Parse("tcp://" + broker.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cm, err := NewConnection(ctx, ClientConfig{
+		BrokerUrls:   []*url.URL{u},
+		KeepAlive:    30,
+		ClientConfig: paho.ClientConfig{ClientID: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cm.AwaitConnection(ctx); err != nil {
+		t.Fatalf("connection not established: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond) // allow the client goroutines to start before disconnecting
+
+	go func() {
+		_, _ = cm.Publish(ctx, &paho.Publish{Topic: "test", QoS: 1, Payload: []byte("never acknowledged")})
+	}()
+	time.Sleep(50 * time.Millisecond) // allow the PUBLISH to be sent
+
+	dctx, dcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer dcancel()
+	if err := cm.DisconnectGracefully(dctx, nil); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	select {
+	case <-cm.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection manager did not shut down after drain timed out")
+	}
+}
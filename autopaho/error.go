@@ -2,6 +2,7 @@ package autopaho
 
 import (
 	"fmt"
+	"net/url"
 	"sync"
 
 	"github.com/eclipse/paho.golang/paho"
@@ -11,7 +12,7 @@ import (
 // of this is to pass a single error onto the error channel (the library may send multiple errors; only the first
 // will be processed).
 type errorHandler struct {
-	debug paho.Logger
+	log paho.LevelLogger
 
 	mu      sync.Mutex
 	errChan chan error // receives connection errors
@@ -32,7 +33,7 @@ func (e *errorHandler) onClientError(err error) {
 // clean broker shutdown). We want to begin attempting to reconnect when this occurs (and pass a detectable error
 // to the user)
 func (e *errorHandler) onServerDisconnect(d *paho.Disconnect) {
-	e.handleError(&DisconnectError{err: fmt.Sprintf("server requested disconnect (reason: %d)", d.ReasonCode)})
+	e.handleError(&DisconnectError{err: fmt.Sprintf("server requested disconnect (reason: %d)", d.ReasonCode), disconnect: d})
 	if e.userOnServerDisconnect != nil {
 		go e.userOnServerDisconnect(d)
 	}
@@ -43,17 +44,25 @@ func (e *errorHandler) handleError(err error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	if e.errChan != nil {
-		e.debug.Printf("received error: %s", err)
+		e.log.Debug("received error", paho.ErrorField(err))
 		e.errChan <- err
 		e.errChan = nil
 	} else {
-		e.debug.Printf("received extra error: %s", err)
+		e.log.Debug("received extra error", paho.ErrorField(err))
 	}
 }
 
 // DisconnectError will be passed when the server requests disconnection (allows this error type to be detected)
-type DisconnectError struct{ err string }
+type DisconnectError struct {
+	err        string
+	disconnect *paho.Disconnect
+}
 
 func (d *DisconnectError) Error() string {
 	return d.err
 }
+
+// brokerField returns a log field holding the broker URL
+func brokerField(u *url.URL) paho.Field {
+	return paho.Field{Key: "broker", Value: u.String()}
+}
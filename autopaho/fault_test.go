@@ -0,0 +1,139 @@
+package autopaho
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/mqtttest/broker"
+	"github.com/eclipse/paho.golang/mqtttest/faultconn"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// faultyConnection connects to an in-memory broker via connections wrapped with faultconn; faults[i] configures the
+// i'th connection (later connections use the last entry). It returns the ConnectionManager along with a channel that
+// receives a value each time the connection comes up and a function returning the connections made.
+func faultyConnection(t *testing.T, keepAlive uint16, faults ...faultconn.Config) (*ConnectionManager, chan struct{}, func() []*faultconn.Conn) {
+	t.Helper()
+	b := broker.New(broker.Config{})
+	t.Cleanup(func() { b.Close() })
+
+	var mu sync.Mutex
+	var conns []*faultconn.Conn
+	dial := func(ctx context.Context, u *url.URL, tlsCfg *tls.Config) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		cfg := faults[len(faults)-1]
+		if len(conns) < len(faults) {
+			cfg = faults[len(conns)]
+		}
+		c := faultconn.New(b.Pipe(), cfg)
+		conns = append(conns, c)
+		return c, nil
+	}
+
+	up := make(chan struct{}, 10)
+	u, _ := url.Parse("pipe://broker")
+	ctx, cancel := context.WithCancel(context.Background()) // cancelling stops reconnection attempts
+	t.Cleanup(cancel)
+	cm, err := NewConnection(ctx, ClientConfig{
+		BrokerUrls:        []*url.URL{u},
+		KeepAlive:         keepAlive,
+		ConnectRetryDelay: 50 * time.Millisecond,
+		Dialers:           map[string]DialFunc{"pipe": dial},
+		OnConnectionUp:    func(*ConnectionManager, *paho.Connack) { up <- struct{}{} },
+		ClientConfig:      paho.ClientConfig{ClientID: "faulty"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = cm.Disconnect(ctx)
+	})
+	return cm, up, func() []*faultconn.Conn {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*faultconn.Conn(nil), conns...)
+	}
+}
+
+// awaitUp waits for the connection to come up n times
+func awaitUp(t *testing.T, up chan struct{}, n int, timeout time.Duration) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for i := 0; i < n; i++ {
+		select {
+		case <-up:
+		case <-deadline:
+			t.Fatalf("connection came up %d times, expected %d", i, n)
+		}
+	}
+}
+
+func TestConnectionManagerReconnectAfterDisconnect(t *testing.T) {
+	cm, up, conns := faultyConnection(t, 30,
+		faultconn.Config{
+			Latency:    time.Millisecond,
+			WriteChunk: 2,
+			Schedule:   []faultconn.Event{{After: 200 * time.Millisecond, Fault: faultconn.Disconnect()}},
+		},
+		faultconn.Config{WriteChunk: 3},
+	)
+	awaitUp(t, up, 2, 5*time.Second)
+	if n := len(conns()); n != 2 {
+		t.Fatalf("expected 2 connections, got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := cm.Publish(ctx, &paho.Publish{Topic: "test", QoS: 1, Payload: []byte("after reconnect")}); err != nil {
+		t.Fatalf("publish after reconnection failed: %s", err)
+	}
+}
+
+func TestConnectionManagerReconnectMidPacket(t *testing.T) {
+	cm, up, conns := faultyConnection(t, 30, faultconn.Config{})
+	awaitUp(t, up, 1, 5*time.Second)
+
+	conns()[0].Inject(faultconn.DropAfter(5)) // the PUBLISH below is cut short
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := cm.Publish(ctx, &paho.Publish{Topic: "test", QoS: 1, Payload: []byte("lost")}); err == nil {
+		t.Fatal("expected publish on dropped connection to fail")
+	}
+
+	awaitUp(t, up, 1, 5*time.Second)
+	if _, err := cm.Publish(ctx, &paho.Publish{Topic: "test", QoS: 1, Payload: []byte("after reconnect")}); err != nil {
+		t.Fatalf("publish after reconnection failed: %s", err)
+	}
+}
+
+func TestConnectionManagerReconnectHalfOpen(t *testing.T) {
+	_, up, conns := faultyConnection(t, 1, faultconn.Config{})
+	awaitUp(t, up, 1, 5*time.Second)
+
+	// The pinger should detect the lack of PINGRESP (after 1.5 * keepalive) and the connection be re-established
+	conns()[0].Inject(faultconn.HalfOpen())
+	awaitUp(t, up, 1, 5*time.Second)
+}
+
+func TestConnectionManagerUpWhenAwaitConnectionReturns(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		cm, _, _ := faultyConnection(t, 30, faultconn.Config{})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := cm.AwaitConnection(ctx); err != nil {
+			cancel()
+			t.Fatal(err)
+		}
+		cancel()
+		if st := cm.Status(); st.State != StateUp {
+			t.Fatalf("expected status %s once AwaitConnection returns, got %s", StateUp, st.State)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package autopaho
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -21,78 +22,208 @@ import (
 // Network (establishing connection) functionality for AutoPaho
 
 // establishBrokerConnection - establishes a connection with the broker retrying until successful or the
-// context is cancelled (in which case nil will be returned).
-func establishBrokerConnection(ctx context.Context, cfg ClientConfig) (*paho.Client, *paho.Connack) {
+// context is cancelled (in which case nil will be returned). The brokers tried are provided by r (which will also
+// process any redirects requested by the server). The URL of the broker connected to, and the credentials used (if
+// any were obtained from the CredentialsProvider), are also returned.
+// Connection attempts (and failures) are reported to st (which may be nil).
+func establishBrokerConnection(ctx context.Context, cfg ClientConfig, r *redirector, st *statusTracker) (*paho.Client, *paho.Connack, *url.URL, *Credentials) {
 	// Note: We do not touch b.cli in order to avoid adding thread safety issues.
 	var err error
 
 	for {
-		for _, u := range cfg.BrokerUrls {
+		redirected := false
+		for _, u := range r.urls() {
+			st.connecting(u)
 			connectionCtx, cancelConnCtx := context.WithTimeout(ctx, cfg.ConnectTimeout)
 
-			switch strings.ToLower(u.Scheme) {
-			case "mqtt", "tcp", "":
-				cfg.Conn, err = attemptTCPConnection(connectionCtx, u.Host)
-			case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
-				cfg.Conn, err = attemptTLSConnection(connectionCtx, cfg.TlsCfg, u.Host)
-			case "ws":
-				cfg.Conn, err = attemptWebsocketConnection(connectionCtx, nil, cfg.WebSocketCfg, u)
-			case "wss":
-				cfg.Conn, err = attemptWebsocketConnection(connectionCtx, cfg.TlsCfg, cfg.WebSocketCfg, u)
-			default:
-				cfg.OnConnectError(fmt.Errorf("unsupported scheme (%s) user in url %s", u.Scheme, u.String()))
-				cancelConnCtx()
-				continue
+			// Credentials are obtained for each attempt (they may be short-lived)
+			cfg.credentials, err = getCredentials(connectionCtx, &cfg, u)
+			if err == nil {
+				cfg.Conn, err = attemptConnection(connectionCtx, cfg, u)
+				if errors.Is(err, errUnsupportedScheme) {
+					cfg.log.Error("unable to connect", brokerField(u), paho.ErrorField(err))
+					st.failed(err)
+					if cfg.OnConnectError != nil {
+						cfg.OnConnectError(err)
+					}
+					cancelConnCtx()
+					continue
+				}
 			}
 
+			var ca *paho.Connack
 			if err == nil {
 				cli := paho.NewClient(cfg.ClientConfig)
 				cp := cfg.buildConnectPacket()
-				var ca *paho.Connack
 				ca, err = cli.Connect(connectionCtx, cp) // will return an error if the connection is unsuccessful (checks the reason code)
 				if err == nil {                          // Successfully connected
 					cancelConnCtx()
-					return cli, ca
+					r.connected()
+					return cli, ca, u, cfg.credentials
 				}
 			}
 			cancelConnCtx()
 
 			// Possible failure was due to outer context being cancelled
 			if ctx.Err() != nil {
-				return nil, nil
+				return nil, nil, nil, nil
 			}
 
+			err = fmt.Errorf("failed to connect to %s: %w", u.String(), err)
+			cfg.log.Warn("connection attempt failed", brokerField(u), paho.ErrorField(err))
+			st.failed(err)
 			if cfg.OnConnectError != nil {
-				cfg.OnConnectError(fmt.Errorf("failed to connect to %s: %w", u.String(), err))
+				cfg.OnConnectError(err)
+			}
+
+			// The server may have asked us to use another server; if so we try that immediately
+			if ca != nil && ca.Properties != nil && r.handle(u, ca.ReasonCode, ca.Properties.ServerReference) {
+				redirected = true
+				break
 			}
 		}
+		if redirected {
+			continue
+		}
 
 		// Delay before attempting another connection
 		select {
 		case <-time.After(cfg.ConnectRetryDelay):
 		case <-ctx.Done():
-			return nil, nil
+			return nil, nil, nil, nil
 		}
 	}
 }
 
+// errUnsupportedScheme is returned by attemptConnection if the URL scheme is not supported
+var errUnsupportedScheme = errors.New("unsupported scheme")
+
+// attemptConnection - makes a single attempt at establishing a network connection with the broker at u using a
+// DialFunc from cfg.Dialers or the built-in support for the scheme
+func attemptConnection(ctx context.Context, cfg ClientConfig, u *url.URL) (net.Conn, error) {
+	scheme := strings.ToLower(u.Scheme)
+	customDial, custom := cfg.Dialers[scheme]
+	custom = custom && customDial != nil
+
+	// The TLS configuration is only needed for TLS schemes (a custom dialer may use TLS so is always passed it)
+	var tlsCfg *tls.Config
+	if custom || isTLSScheme(scheme) {
+		tlsCfg = cfg.TlsCfg
+		if cfg.TlsCfgFunc != nil {
+			var err error
+			if tlsCfg, err = cfg.TlsCfgFunc(u); err != nil {
+				return nil, fmt.Errorf("unable to obtain TLS configuration for %s: %w", u, err)
+			}
+		}
+	}
+
+	if custom {
+		conn, err := customDial(ctx, u, tlsCfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := conn.(sync.Locker); !ok {
+			conn = packets.NewThreadSafeConn(conn) // we cannot assume that concurrent writes are safe
+		}
+		return conn, nil
+	}
+
+	var dial dialContextFunc
+	switch scheme {
+	case "mqtt", "tcp", "", "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "ws", "wss":
+		var err error
+		if dial, err = proxyDialer(cfg.Proxy, u); err != nil {
+			return nil, err
+		}
+	}
+
+	switch scheme {
+	case "mqtt", "tcp", "":
+		return attemptTCPConnection(ctx, dial, u.Host)
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
+		return attemptTLSConnection(ctx, dial, tlsCfg, u.Host)
+	case "ws":
+		return attemptWebsocketConnection(ctx, proxiedDial(cfg, dial), nil, cfg.WebSocketCfg, u)
+	case "wss":
+		return attemptWebsocketConnection(ctx, proxiedDial(cfg, dial), tlsCfg, cfg.WebSocketCfg, u)
+	case "unix":
+		return attemptUnixConnection(ctx, unixSocketPath(u))
+	}
+	return nil, fmt.Errorf("%w (%s) used in url %s", errUnsupportedScheme, u.Scheme, u.String())
+}
+
+// isTLSScheme returns true if the built-in support for scheme establishes a TLS connection
+func isTLSScheme(scheme string) bool {
+	switch scheme {
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss":
+		return true
+	}
+	return false
+}
+
+// proxiedDial returns dial if cfg.Proxy is set (otherwise nil, meaning that the websocket library defaults, which
+// include proxies specified in the environment, apply)
+func proxiedDial(cfg ClientConfig, dial dialContextFunc) dialContextFunc {
+	if cfg.Proxy == nil {
+		return nil
+	}
+	return dial
+}
+
 // attemptTCPConnection - makes a single attempt at establishing a TCP connection with the broker
-func attemptTCPConnection(ctx context.Context, address string) (net.Conn, error) {
+func attemptTCPConnection(ctx context.Context, dial dialContextFunc, address string) (net.Conn, error) {
+	return dial(ctx, "tcp", address)
+}
+
+// attemptUnixConnection - makes a single attempt at establishing a connection with the broker via a unix domain socket
+func attemptUnixConnection(ctx context.Context, path string) (net.Conn, error) {
 	var d net.Dialer
-	return d.DialContext(ctx, "tcp", address)
+	return d.DialContext(ctx, "unix", path)
+}
+
+// unixSocketPath returns the path of the socket referenced by a unix URL; this may be absolute (unix:///run/mqtt.sock)
+// or relative (unix://mqtt.sock)
+func unixSocketPath(u *url.URL) string {
+	if u.Host != "" {
+		return u.Host + u.Path
+	}
+	return u.Path
 }
 
 // attemptTLSConnection - makes a single attempt at establishing a TLS connection with the broker
-func attemptTLSConnection(ctx context.Context, tlsCfg *tls.Config, address string) (net.Conn, error) {
-	d := tls.Dialer{
-		Config: tlsCfg,
+func attemptTLSConnection(ctx context.Context, dial dialContextFunc, tlsCfg *tls.Config, address string) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	// As per tls.Dialer, if no ServerName is configured it is taken from the address
+	cfg := tlsCfg
+	if cfg == nil {
+		cfg = &tls.Config{}
 	}
-	conn, err := d.DialContext(ctx, "tcp", address)
-	return packets.NewThreadSafeConn(conn), err
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, cfg)
+	clearDeadline := withDeadline(ctx, conn)
+	defer clearDeadline()
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return packets.NewThreadSafeConn(tlsConn), nil
 }
 
 // attemptWebsocketConnection - makes a single attempt at establishing a websocket connection with the broker
-func attemptWebsocketConnection(ctx context.Context, tlsc *tls.Config, cfg *WebSocketConfig, brokerURL *url.URL) (net.Conn, error) {
+// If dial is non-nil it will be used to establish the underlying connection (unless a custom websocket dialer is configured).
+func attemptWebsocketConnection(ctx context.Context, dial dialContextFunc, tlsc *tls.Config, cfg *WebSocketConfig, brokerURL *url.URL) (net.Conn, error) {
 	var dialer *websocket.Dialer
 	var requestHeader http.Header
 	if cfg != nil {
@@ -107,6 +238,10 @@ func attemptWebsocketConnection(ctx context.Context, tlsc *tls.Config, cfg *WebS
 		d := *websocket.DefaultDialer // Take a copy as we modify a few values
 		d.TLSClientConfig = tlsc
 		d.Subprotocols = []string{"mqtt"}
+		if dial != nil {
+			d.Proxy = nil // dial handles the proxy
+			d.NetDialContext = dial
+		}
 		dialer = &d
 	}
 	ws, _, err := dialer.DialContext(ctx, brokerURL.String(), requestHeader)
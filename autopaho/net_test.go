@@ -0,0 +1,132 @@
+package autopaho
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+func TestUnixSocketPath(t *testing.T) {
+	for ref, want := range map[string]string{
+		"unix:///run/mqtt/broker.sock": "/run/mqtt/broker.sock",
+		"unix://broker.sock":           "broker.sock",
+		"unix://sockets/broker.sock":   "sockets/broker.sock",
+	} {
+		u, err := url.Parse(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := unixSocketPath(u); got != want {
+			t.Errorf("unixSocketPath(%s) = %s, want %s", ref, got, want)
+		}
+	}
+}
+
+func TestAttemptConnectionUnsupportedScheme(t *testing.T) {
+	u, _ := url.Parse("carrier-pigeon://loft")
+	if _, err := attemptConnection(context.Background(), ClientConfig{}, u); !errors.Is(err, errUnsupportedScheme) {
+		t.Fatalf("expected errUnsupportedScheme, got %v", err)
+	}
+}
+
+// connectVia establishes a connection (using NewConnection) to the broker at u and then disconnects
+func connectVia(t *testing.T, cfg ClientConfig, u *url.URL) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cfg.BrokerUrls = []*url.URL{u}
+	cfg.KeepAlive = 30
+	cfg.ClientID = "test"
+	cm, err := NewConnection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cm.AwaitConnection(ctx); err != nil {
+		t.Fatalf("connection not established: %s", err)
+	}
+	if s := cm.Status(); s.BrokerURL != u {
+		t.Fatalf("expected connection to %s, got %s", u, s.BrokerURL)
+	}
+	time.Sleep(100 * time.Millisecond) // allow the client goroutines to start before disconnecting
+	if err = cm.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAttemptConnectionTlsCfgFunc(t *testing.T) {
+	errTLS := errors.New("tls configuration requested")
+	cfg := ClientConfig{TlsCfgFunc: func(*url.URL) (*tls.Config, error) { return nil, errTLS }}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// The TLS configuration is not needed for schemes that do not use TLS
+	for _, scheme := range []string{"mqtt", "tcp"} {
+		conn, err := attemptConnection(context.Background(), cfg, &url.URL{Scheme: scheme, Host: l.Addr().String()})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", scheme, err)
+		}
+		conn.Close()
+	}
+	for _, scheme := range []string{"tls", "wss"} {
+		if _, err := attemptConnection(context.Background(), cfg, &url.URL{Scheme: scheme, Host: l.Addr().String()}); !errors.Is(err, errTLS) {
+			t.Fatalf("%s: expected TlsCfgFunc to be called, got %v", scheme, err)
+		}
+	}
+}
+
+func TestConnectionManagerUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broker.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix domain sockets not supported: %s", err)
+	}
+	defer l.Close()
+	go serveFakeBroker(l, &packets.Connack{Properties: &packets.Properties{}}, nil)
+
+	// TlsCfgFunc is not called as the connection does not use TLS
+	noTLS := func(*url.URL) (*tls.Config, error) { return nil, errors.New("tls configuration requested") }
+	connectVia(t, ClientConfig{TlsCfgFunc: noTLS}, &url.URL{Scheme: "unix", Path: path})
+}
+
+func TestConnectionManagerCustomDialer(t *testing.T) {
+	var mu sync.Mutex
+	var dialed []string
+	dial := func(ctx context.Context, u *url.URL, tlsCfg *tls.Config) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, u.String())
+		mu.Unlock()
+		client, server := net.Pipe()
+		go fakeBrokerConn(server, &packets.Connack{Properties: &packets.Properties{}}, nil)
+		return client, nil
+	}
+
+	u, _ := url.Parse("pipe://broker")
+	connectVia(t, ClientConfig{Dialers: map[string]DialFunc{"pipe": dial}}, u)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) != 1 || dialed[0] != "pipe://broker" {
+		t.Fatalf("expected custom dialer to be used once, got %v", dialed)
+	}
+}
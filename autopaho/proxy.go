@@ -0,0 +1,284 @@
+package autopaho
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Proxy (HTTP CONNECT and SOCKS5) functionality for AutoPaho
+
+// dialContextFunc matches net.Dialer.DialContext
+type dialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// ProxyURL returns a function, suitable for use as ClientConfig.Proxy, that always returns the fixed proxy URL
+func ProxyURL(fixed *url.URL) func(*url.URL) (*url.URL, error) {
+	return func(*url.URL) (*url.URL, error) {
+		return fixed, nil
+	}
+}
+
+// ProxyFromEnvironment is a function, suitable for use as ClientConfig.Proxy, that returns the proxy specified in the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables (or their lowercase equivalents) as interpreted by
+// http.ProxyFromEnvironment. The ws scheme uses HTTP_PROXY and all other schemes use HTTPS_PROXY (as the MQTT traffic is
+// tunnelled using CONNECT).
+func ProxyFromEnvironment(u *url.URL) (*url.URL, error) {
+	scheme := "https"
+	if strings.ToLower(u.Scheme) == "ws" {
+		scheme = "http"
+	}
+	req := &http.Request{URL: &url.URL{Scheme: scheme, Host: u.Host}}
+	return http.ProxyFromEnvironment(req)
+}
+
+// proxyDialer returns a function that will establish connections with the broker at u using the proxy returned
+// by proxy (or directly if proxy is nil or returns nil)
+func proxyDialer(proxy func(*url.URL) (*url.URL, error), u *url.URL) (dialContextFunc, error) {
+	var d net.Dialer
+	if proxy == nil {
+		return d.DialContext, nil
+	}
+	p, err := proxy(u)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine proxy for %s: %w", u, err)
+	}
+	if p == nil {
+		return d.DialContext, nil
+	}
+
+	switch strings.ToLower(p.Scheme) {
+	case "http", "https":
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialHTTPProxy(ctx, p, address)
+		}, nil
+	case "socks5", "socks5h":
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialSOCKS5Proxy(ctx, p, address)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme (%s)", p.Scheme)
+}
+
+// proxyAddress returns the host:port of the proxy (applying the default port for the scheme if necessary)
+func proxyAddress(p *url.URL, defaultPort string) string {
+	if p.Port() != "" {
+		return p.Host
+	}
+	return net.JoinHostPort(p.Hostname(), defaultPort)
+}
+
+// withDeadline applies the context deadline (if any) to conn for the duration of a handshake; the returned function
+// clears the deadline
+func withDeadline(ctx context.Context, conn net.Conn) func() {
+	if d, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(d)
+		return func() { _ = conn.SetDeadline(time.Time{}) }
+	}
+	return func() {}
+}
+
+// dialHTTPProxy establishes a tunnel to address through the HTTP(S) proxy p using the CONNECT method. If the proxy URL
+// includes a username/password these are sent using basic authentication.
+func dialHTTPProxy(ctx context.Context, p *url.URL, address string) (net.Conn, error) {
+	var d net.Dialer
+	var conn net.Conn
+	var err error
+	if strings.ToLower(p.Scheme) == "https" {
+		td := tls.Dialer{Config: &tls.Config{ServerName: p.Hostname()}}
+		conn, err = td.DialContext(ctx, "tcp", proxyAddress(p, "443"))
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", proxyAddress(p, "80"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("proxy connection failed: %w", err)
+	}
+	clearDeadline := withDeadline(ctx, conn)
+	defer clearDeadline()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if p.User != nil {
+		pw, _ := p.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(p.User.Username() + ":" + pw))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT request failed: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT response invalid: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s failed: %s", address, resp.Status)
+	}
+	if br.Buffered() > 0 { // Unlikely (the client speaks first in MQTT) but we must not lose data
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn where data has already been read into a bufio.Reader
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read reads from the buffer (and then the underlying connection)
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
+
+// SOCKS5 constants (RFC 1928 and RFC 1929)
+const (
+	socks5Version        = 0x05
+	socks5AuthNone       = 0x00
+	socks5AuthPassword   = 0x02
+	socks5AuthNoAccept   = 0xff
+	socks5CmdConnect     = 0x01
+	socks5AddrIPv4       = 0x01
+	socks5AddrDomain     = 0x03
+	socks5AddrIPv6       = 0x04
+	socks5PasswordVer    = 0x01
+	socks5ReplySucceeded = 0x00
+)
+
+// dialSOCKS5Proxy establishes a connection to address through the SOCKS5 proxy p. If the proxy URL includes a
+// username/password then username/password authentication is offered. Host names are resolved by the proxy.
+func dialSOCKS5Proxy(ctx context.Context, p *url.URL, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s: %w", address, err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxyAddress(p, "1080"))
+	if err != nil {
+		return nil, fmt.Errorf("proxy connection failed: %w", err)
+	}
+	clearDeadline := withDeadline(ctx, conn)
+	defer clearDeadline()
+
+	if err := socks5Handshake(conn, p.User, host, uint16(port)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5 proxy: %w", err)
+	}
+	return conn, nil
+}
+
+// socks5Handshake negotiates authentication and requests a connection to host:port
+func socks5Handshake(rw io.ReadWriter, user *url.Userinfo, host string, port uint16) error {
+	methods := []byte{socks5AuthNone}
+	if user != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	if _, err := rw.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(rw, resp); err != nil {
+		return err
+	}
+	if resp[0] != socks5Version {
+		return fmt.Errorf("unexpected version %d", resp[0])
+	}
+	switch resp[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if user == nil {
+			return errors.New("proxy requested authentication but no credentials provided")
+		}
+		pw, _ := user.Password()
+		if len(user.Username()) > 255 || len(pw) > 255 {
+			return errors.New("username or password too long")
+		}
+		req := []byte{socks5PasswordVer, byte(len(user.Username()))}
+		req = append(req, user.Username()...)
+		req = append(req, byte(len(pw)))
+		req = append(req, pw...)
+		if _, err := rw.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(rw, resp); err != nil {
+			return err
+		}
+		if resp[1] != 0x00 {
+			return errors.New("authentication failed")
+		}
+	case socks5AuthNoAccept:
+		return errors.New("no acceptable authentication methods")
+	default:
+		return fmt.Errorf("unsupported authentication method %d", resp[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("host name too long")
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := rw.Write(req); err != nil {
+		return err
+	}
+
+	// Reply is VER, REP, RSV, ATYP, BND.ADDR, BND.PORT (the bound address is not needed)
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(rw, reply); err != nil {
+		return err
+	}
+	if reply[1] != socks5ReplySucceeded {
+		return fmt.Errorf("connect failed (reply code %d)", reply[1])
+	}
+	var skip int
+	switch reply[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len
+	case socks5AddrIPv6:
+		skip = net.IPv6len
+	case socks5AddrDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(rw, l); err != nil {
+			return err
+		}
+		skip = int(l[0])
+	default:
+		return fmt.Errorf("unexpected address type %d", reply[3])
+	}
+	_, err := io.ReadFull(rw, make([]byte, skip+2))
+	return err
+}
@@ -0,0 +1,209 @@
+package autopaho
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+// forward copies data between the two connections until either is closed
+func forward(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		a.Close()
+	}()
+	_, _ = io.Copy(b, a)
+	b.Close()
+}
+
+// fakeHTTPProxy accepts CONNECT requests (rejecting those without the expected credentials) and forwards traffic;
+// the CONNECT targets are sent to the returned channel
+func fakeHTTPProxy(t *testing.T, user, password string) (net.Listener, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := make(chan string, 10)
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					conn.Close()
+					return
+				}
+				targets <- req.Host
+				if req.Header.Get("Proxy-Authorization") != wantAuth {
+					_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					conn.Close()
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					conn.Close()
+					return
+				}
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				forward(conn, target)
+			}()
+		}
+	}()
+	return l, targets
+}
+
+// fakeSOCKS5Proxy implements enough of RFC 1928/1929 to accept a connection authenticated with user/password
+func fakeSOCKS5Proxy(t *testing.T, user, password string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				target, err := socks5Accept(conn, user, password)
+				if err != nil {
+					t.Errorf("socks5 handshake failed: %s", err)
+					conn.Close()
+					return
+				}
+				forward(conn, target)
+			}()
+		}
+	}()
+	return l
+}
+
+// socks5Accept performs the server side of the SOCKS5 handshake
+func socks5Accept(conn net.Conn, user, password string) (net.Conn, error) {
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		_, _ = io.ReadFull(conn, b)
+		return b
+	}
+	hdr := readBytes(2)
+	methods := readBytes(int(hdr[1]))
+	if !strings.Contains(string(methods), string([]byte{socks5AuthPassword})) {
+		_, _ = conn.Write([]byte{socks5Version, socks5AuthNoAccept})
+		return nil, io.ErrUnexpectedEOF
+	}
+	_, _ = conn.Write([]byte{socks5Version, socks5AuthPassword})
+	readBytes(1)
+	u := string(readBytes(int(readBytes(1)[0])))
+	p := string(readBytes(int(readBytes(1)[0])))
+	if u != user || p != password {
+		_, _ = conn.Write([]byte{socks5PasswordVer, 0x01})
+		return nil, io.ErrUnexpectedEOF
+	}
+	_, _ = conn.Write([]byte{socks5PasswordVer, 0x00})
+
+	req := readBytes(4)
+	var host string
+	switch req[3] {
+	case socks5AddrIPv4:
+		host = net.IP(readBytes(4)).String()
+	case socks5AddrDomain:
+		host = string(readBytes(int(readBytes(1)[0])))
+	}
+	port := readBytes(2)
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		_, _ = conn.Write([]byte{socks5Version, 0x05, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	_, _ = conn.Write([]byte{socks5Version, socks5ReplySucceeded, 0x00, socks5AddrIPv4, 127, 0, 0, 1, 0, 0})
+	return target, nil
+}
+
+func TestConnectionManagerHTTPProxy(t *testing.T) {
+	broker := fakeBroker(t, &packets.Connack{Properties: &packets.Properties{}})
+	defer broker.Close()
+	proxy, targets := fakeHTTPProxy(t, "user", "secret")
+	defer proxy.Close()
+
+	u, _ := url.Parse("tcp://" + broker.Addr().String())
+	p, _ := url.Parse("http://user:secret@" + proxy.Addr().String())
+	connectVia(t, ClientConfig{Proxy: ProxyURL(p)}, u)
+
+	if got := <-targets; got != broker.Addr().String() {
+		t.Fatalf("expected CONNECT to %s, got %s", broker.Addr(), got)
+	}
+}
+
+func TestHTTPProxyAuthenticationFailure(t *testing.T) {
+	proxy, _ := fakeHTTPProxy(t, "user", "secret")
+	defer proxy.Close()
+
+	p, _ := url.Parse("http://user:wrong@" + proxy.Addr().String())
+	_, err := dialHTTPProxy(context.Background(), p, "broker:1883")
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("expected proxy authentication failure, got %v", err)
+	}
+}
+
+func TestConnectionManagerSOCKS5Proxy(t *testing.T) {
+	broker := fakeBroker(t, &packets.Connack{Properties: &packets.Properties{}})
+	defer broker.Close()
+	proxy := fakeSOCKS5Proxy(t, "user", "secret")
+	defer proxy.Close()
+
+	u, _ := url.Parse("mqtt://" + broker.Addr().String())
+	p, _ := url.Parse("socks5://user:secret@" + proxy.Addr().String())
+	connectVia(t, ClientConfig{Proxy: ProxyURL(p)}, u)
+}
+
+func TestProxyDialerUnsupportedScheme(t *testing.T) {
+	u, _ := url.Parse("tcp://broker:1883")
+	p, _ := url.Parse("ftp://proxy")
+	if _, err := proxyDialer(ProxyURL(p), u); err == nil {
+		t.Fatal("expected error for unsupported proxy scheme")
+	}
+}
+
+func TestConnectionManagerTLSViaProxy(t *testing.T) {
+	// httptest provides a certificate valid for 127.0.0.1
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	cert := srv.TLS.Certificates[0]
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	srv.Close()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveFakeBroker(l, &packets.Connack{Properties: &packets.Properties{}}, nil)
+	proxy, targets := fakeHTTPProxy(t, "user", "secret")
+	defer proxy.Close()
+
+	u, _ := url.Parse("tls://" + l.Addr().String())
+	p, _ := url.Parse("http://user:secret@" + proxy.Addr().String())
+	connectVia(t, ClientConfig{Proxy: ProxyURL(p), TlsCfg: &tls.Config{RootCAs: pool}}, u)
+
+	if got := <-targets; got != l.Addr().String() {
+		t.Fatalf("expected CONNECT to %s, got %s", l.Addr(), got)
+	}
+}
@@ -0,0 +1,190 @@
+package autopaho
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+// Server redirection (MQTT v5 Server Reference) functionality for AutoPaho
+
+// RedirectPolicy determines how autopaho responds when the server asks the client to use another server (a CONNACK
+// or DISCONNECT with reason code 0x9C "Use another server" or 0x9D "Server moved" and a ServerReference property).
+type RedirectPolicy byte
+
+const (
+	// RedirectIgnore - server references are ignored and connection attempts continue using BrokerUrls (default)
+	RedirectIgnore RedirectPolicy = iota
+	// RedirectTemporary - all redirects are treated as temporary; the referenced server(s) will be tried once, after
+	// which autopaho reverts to BrokerUrls
+	RedirectTemporary
+	// RedirectFollow - "Use another server" (0x9C) is treated as temporary whereas "Server moved" (0x9D) replaces
+	// BrokerUrls for the lifetime of the ConnectionManager
+	RedirectFollow
+)
+
+// defaultMaxRedirects is the number of consecutive redirects that will be followed if ClientConfig.MaxRedirects is 0
+const defaultMaxRedirects = 5
+
+// ServerRedirect provides details of a request, from the server, that the client use another server. It is passed
+// to ClientConfig.OnServerRedirect.
+type ServerRedirect struct {
+	From            *url.URL   // The broker that issued the redirect
+	To              []*url.URL // The server(s) referenced (may be empty if ServerReference could not be parsed)
+	ServerReference string     // The ServerReference property as received from the server
+	ReasonCode      byte       // Either packets.ConnackUseAnotherServer (0x9C) or packets.ConnackServerMoved (0x9D)
+	Permanent       bool       // true if the server has moved (0x9D)
+	Followed        bool       // true if autopaho will attempt to connect to the server(s) in To
+	Err             error      // Reason the redirect will not be followed (nil if Followed or the policy is RedirectIgnore)
+}
+
+// isRedirect returns true if the reason code indicates that the client should use another server (CONNACK and
+// DISCONNECT share the same values)
+func isRedirect(reasonCode byte) bool {
+	return reasonCode == packets.ConnackUseAnotherServer || reasonCode == packets.ConnackServerMoved
+}
+
+// ParseServerReference converts a MQTT v5 Server Reference (a space separated list of references) into URLs.
+// The format of a reference is not defined by the specification; a reference may be a full URL
+// (e.g. "tls://example.com:8883") or just a host with an optional port (e.g. "example.com:1883" or "[fe80::1]").
+// References without a scheme inherit the scheme, port (if none is specified) and path of current.
+func ParseServerReference(ref string, current *url.URL) ([]*url.URL, error) {
+	var ret []*url.URL
+	for _, r := range strings.Fields(ref) {
+		if strings.Contains(r, "://") {
+			u, err := url.Parse(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid server reference %q: %w", r, err)
+			}
+			ret = append(ret, u)
+			continue
+		}
+
+		var scheme, port, path string
+		if current != nil {
+			scheme, port, path = current.Scheme, current.Port(), current.Path
+		}
+		u, err := url.Parse(scheme + "://" + r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server reference %q: %w", r, err)
+		}
+		if u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid server reference %q: no host", r)
+		}
+		if u.Port() == "" && port != "" {
+			u.Host = net.JoinHostPort(u.Hostname(), port)
+		}
+		if u.Path == "" {
+			u.Path = path
+		}
+		ret = append(ret, u)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("empty server reference")
+	}
+
+	return ret, nil
+}
+
+// redirector tracks redirects requested by the server and determines which brokers should be tried next.
+// It is only accessed from the connection management goroutine so is not thread safe.
+type redirector struct {
+	policy       RedirectPolicy
+	maxRedirects int
+	onRedirect   func(ServerRedirect)
+
+	brokers []*url.URL // the broker list (initially BrokerUrls; replaced by a permanent redirect)
+	pending []*url.URL // servers from a temporary redirect that will be tried (once) before brokers
+
+	count   int                 // number of redirects followed since the last successful connection
+	visited map[string]struct{} // servers redirected to since the last successful connection
+}
+
+// newRedirector creates a redirector based upon the configuration in cfg
+func newRedirector(cfg *ClientConfig) *redirector {
+	r := &redirector{
+		policy:       cfg.RedirectPolicy,
+		maxRedirects: cfg.MaxRedirects,
+		onRedirect:   cfg.OnServerRedirect,
+		brokers:      cfg.BrokerUrls,
+		visited:      make(map[string]struct{}),
+	}
+	if r.maxRedirects <= 0 {
+		r.maxRedirects = defaultMaxRedirects
+	}
+	return r
+}
+
+// urls returns the brokers that should be tried on the next pass; any servers from a temporary redirect are returned
+// first (and only once)
+func (r *redirector) urls() []*url.URL {
+	if len(r.pending) == 0 {
+		return r.brokers
+	}
+	u := make([]*url.URL, 0, len(r.pending)+len(r.brokers))
+	u = append(u, r.pending...)
+	u = append(u, r.brokers...)
+	r.pending = nil
+	return u
+}
+
+// connected should be called when a connection has been successfully established (resets loop protection)
+func (r *redirector) connected() {
+	r.count = 0
+	r.visited = make(map[string]struct{})
+}
+
+// handle processes a reason code and server reference received from the broker at from; it returns true if the
+// redirect will be followed (in which case urls() will return the referenced server(s) first)
+func (r *redirector) handle(from *url.URL, reasonCode byte, serverReference string) bool {
+	if !isRedirect(reasonCode) || serverReference == "" {
+		return false
+	}
+
+	sr := ServerRedirect{
+		From:            from,
+		ServerReference: serverReference,
+		ReasonCode:      reasonCode,
+		Permanent:       reasonCode == packets.ConnackServerMoved,
+	}
+	sr.To, sr.Err = ParseServerReference(serverReference, from)
+
+	if r.policy != RedirectIgnore && sr.Err == nil {
+		switch {
+		case r.count >= r.maxRedirects:
+			sr.Err = fmt.Errorf("maximum redirects (%d) exceeded", r.maxRedirects)
+		case r.seen(sr.To):
+			sr.Err = fmt.Errorf("redirect loop detected (%s)", serverReference)
+		default:
+			sr.Followed = true
+			r.count++
+			for _, u := range sr.To {
+				r.visited[u.String()] = struct{}{}
+			}
+			if sr.Permanent && r.policy == RedirectFollow {
+				r.brokers = sr.To
+				r.pending = nil
+			} else {
+				r.pending = sr.To
+			}
+		}
+	}
+
+	if r.onRedirect != nil {
+		r.onRedirect(sr)
+	}
+	return sr.Followed
+}
+
+// seen returns true if all of the urls have previously been redirected to (since the last successful connection)
+func (r *redirector) seen(urls []*url.URL) bool {
+	for _, u := range urls {
+		if _, ok := r.visited[u.String()]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+package autopaho
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func TestParseServerReference(t *testing.T) {
+	current, _ := url.Parse("ws://broker.example.com:8080/mqtt")
+	tests := []struct {
+		name    string
+		ref     string
+		want    []string
+		wantErr bool
+	}{
+		{"host", "other.example.com", []string{"ws://other.example.com:8080/mqtt"}, false},
+		{"hostPort", "other.example.com:9001", []string{"ws://other.example.com:9001/mqtt"}, false},
+		{"ipv6", "[fe80::1]", []string{"ws://[fe80::1]:8080/mqtt"}, false},
+		{"url", "tls://secure.example.com:8883", []string{"tls://secure.example.com:8883"}, false},
+		{"multiple", "a.example.com  10.10.151.22:1883", []string{"ws://a.example.com:8080/mqtt", "ws://10.10.151.22:1883/mqtt"}, false},
+		{"empty", " ", nil, true},
+		{"invalid", "bad%zz", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseServerReference(tt.ref, current)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseServerReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseServerReference() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].String() != tt.want[i] {
+					t.Errorf("ParseServerReference()[%d] = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRedirector(t *testing.T) {
+	a, _ := url.Parse("tcp://a:1883")
+	b, _ := url.Parse("tcp://b:1883")
+
+	var redirects []ServerRedirect
+	r := newRedirector(&ClientConfig{
+		BrokerUrls:       []*url.URL{a},
+		RedirectPolicy:   RedirectFollow,
+		MaxRedirects:     2,
+		OnServerRedirect: func(sr ServerRedirect) { redirects = append(redirects, sr) },
+	})
+
+	// Temporary redirect; b should be tried once, then a
+	if !r.handle(a, packets.ConnackUseAnotherServer, "b") {
+		t.Fatal("expected temporary redirect to be followed")
+	}
+	if u := r.urls(); len(u) != 2 || u[0].String() != b.String() || u[1] != a {
+		t.Fatalf("unexpected urls after temporary redirect: %v", u)
+	}
+	if u := r.urls(); len(u) != 1 || u[0] != a {
+		t.Fatalf("temporary redirect should only be tried once: %v", u)
+	}
+
+	// Being redirected back to b is a loop
+	if r.handle(a, packets.ConnackUseAnotherServer, "b") {
+		t.Fatal("expected redirect loop to be detected")
+	}
+	if redirects[1].Err == nil || redirects[1].Followed {
+		t.Fatalf("expected error to be reported: %+v", redirects[1])
+	}
+
+	// Successful connection resets loop protection; permanent redirect replaces the broker list
+	r.connected()
+	if !r.handle(a, packets.ConnackServerMoved, "b") {
+		t.Fatal("expected permanent redirect to be followed")
+	}
+	if u := r.urls(); len(u) != 1 || u[0].String() != b.String() {
+		t.Fatalf("unexpected urls after permanent redirect: %v", u)
+	}
+	if !redirects[2].Permanent {
+		t.Fatal("expected redirect to be reported as permanent")
+	}
+
+	// Other reason codes are not redirects
+	if r.handle(b, packets.ConnackServerBusy, "a") {
+		t.Fatal("only 0x9C and 0x9D should result in a redirect")
+	}
+	if len(redirects) != 3 {
+		t.Fatalf("expected 3 redirects to be reported, got %d", len(redirects))
+	}
+}
+
+func TestRedirectorIgnore(t *testing.T) {
+	a, _ := url.Parse("tcp://a:1883")
+
+	var reported bool
+	r := newRedirector(&ClientConfig{
+		BrokerUrls:       []*url.URL{a},
+		OnServerRedirect: func(sr ServerRedirect) { reported = true },
+	})
+	if r.handle(a, packets.ConnackServerMoved, "b") {
+		t.Fatal("redirect should not be followed when policy is RedirectIgnore")
+	}
+	if !reported {
+		t.Fatal("redirect should still be reported when policy is RedirectIgnore")
+	}
+	if u := r.urls(); len(u) != 1 || u[0] != a {
+		t.Fatalf("unexpected urls: %v", u)
+	}
+}
+
+// fakeBroker accepts connections and responds to CONNECT with the provided CONNACK
+func fakeBroker(t *testing.T, ca *packets.Connack) net.Listener {
+	return fakeBrokerFunc(t, ca, nil)
+}
+
+// fakeBrokerFunc is fakeBroker with the addition of a function that will be called with each packet received (other
+// than CONNECT and PINGREQ); it may be nil
+func fakeBrokerFunc(t *testing.T, ca *packets.Connack, onPacket func(net.Conn, *packets.ControlPacket)) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveFakeBroker(l, ca, onPacket)
+	return l
+}
+
+// serveFakeBroker accepts connections on l until it is closed (see fakeBrokerFunc)
+func serveFakeBroker(l net.Listener, ca *packets.Connack, onPacket func(net.Conn, *packets.ControlPacket)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go fakeBrokerConn(conn, ca, onPacket)
+	}
+}
+
+// fakeBrokerConn handles a single connection to the fake broker (see fakeBrokerFunc)
+func fakeBrokerConn(conn net.Conn, ca *packets.Connack, onPacket func(net.Conn, *packets.ControlPacket)) {
+	defer conn.Close()
+	for {
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p.Type {
+		case packets.CONNECT:
+			if _, err := ca.WriteTo(conn); err != nil {
+				return
+			}
+		case packets.PINGREQ:
+			if _, err := packets.NewControlPacket(packets.PINGRESP).WriteTo(conn); err != nil {
+				return
+			}
+		default:
+			if onPacket != nil {
+				onPacket(conn, p)
+			}
+			if p.Type == packets.DISCONNECT {
+				return
+			}
+		}
+	}
+}
+
+func TestConnectionManagerRedirect(t *testing.T) {
+	target := fakeBroker(t, &packets.Connack{Properties: &packets.Properties{}})
+	defer target.Close()
+	redirecting := fakeBroker(t, &packets.Connack{
+		ReasonCode: packets.ConnackUseAnotherServer,
+		Properties: &packets.Properties{ServerReference: target.Addr().String()},
+	})
+	defer redirecting.Close()
+
+	broker, _ := url.Parse("tcp://" + redirecting.Addr().String())
+	redirected := make(chan ServerRedirect, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cm, err := NewConnection(ctx, ClientConfig{
+		BrokerUrls:        []*url.URL{broker},
+		KeepAlive:         30,
+		ConnectRetryDelay: time.Minute, // Redirect should be followed immediately
+		RedirectPolicy:    RedirectFollow,
+		OnServerRedirect:  func(sr ServerRedirect) { redirected <- sr },
+		ClientConfig:      paho.ClientConfig{ClientID: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cm.AwaitConnection(ctx); err != nil {
+		t.Fatalf("connection not established: %s", err)
+	}
+
+	sr := <-redirected
+	if !sr.Followed || len(sr.To) != 1 || sr.To[0].Host != target.Addr().String() {
+		t.Fatalf("unexpected redirect: %+v", sr)
+	}
+
+	time.Sleep(100 * time.Millisecond) // allow the client goroutines to start before disconnecting
+	if err = cm.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
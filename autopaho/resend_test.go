@@ -0,0 +1,159 @@
+package autopaho
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/mqtttest/fakeserver"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// sessionConnection returns a ConnectionManager, with a session expiry interval, whose i'th connection is served by
+// servers[i]
+func sessionConnection(t *testing.T, servers ...*fakeserver.Server) *ConnectionManager {
+	t.Helper()
+	var mu sync.Mutex
+	var dialled int
+	dial := func(ctx context.Context, u *url.URL, tlsCfg *tls.Config) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if dialled == len(servers) {
+			return nil, errors.New("no more servers")
+		}
+		dialled++
+		return servers[dialled-1].ClientConn(), nil
+	}
+
+	u, _ := url.Parse("pipe://broker")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cm, err := NewConnection(ctx, ClientConfig{
+		BrokerUrls:            []*url.URL{u},
+		KeepAlive:             30,
+		ConnectRetryDelay:     50 * time.Millisecond,
+		Dialers:               map[string]DialFunc{"pipe": dial},
+		SessionExpiryInterval: 60,
+		ClientConfig:          paho.ClientConfig{ClientID: "resend"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = cm.Disconnect(ctx)
+	})
+	return cm
+}
+
+// expectResume checks that a CONNECT resumes the existing session
+func expectResume(cp *packets.ControlPacket) error {
+	if cp.Content.(*packets.Connect).CleanStart {
+		return errors.New("CleanStart set when resuming session")
+	}
+	return nil
+}
+
+// publishUnacknowledged publishes p in the background (the publish will fail when the connection drops)
+func publishUnacknowledged(t *testing.T, cm *ConnectionManager, p *paho.Publish) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := cm.AwaitConnection(ctx); err != nil {
+		t.Fatal(err)
+	}
+	go func() { _, _ = cm.Publish(context.Background(), p) }()
+}
+
+func TestConnectionManagerResendPublishOnSessionResume(t *testing.T) {
+	connack := &packets.Connack{ReasonCode: packets.ConnackSuccess, Properties: &packets.Properties{}}
+	resumed := &packets.Connack{ReasonCode: packets.ConnackSuccess, SessionPresent: true, Properties: &packets.Properties{}}
+
+	var id uint16
+	first := fakeserver.New()
+	first.Expect(packets.CONNECT).Respond(connack)
+	first.Expect(packets.PUBLISH).Match(func(cp *packets.ControlPacket) error {
+		p := cp.Content.(*packets.Publish)
+		if p.Duplicate {
+			return errors.New("DUP set on first transmission")
+		}
+		id = p.PacketID
+		return nil
+	})
+	first.Close() // drop the connection before the PUBACK is sent
+
+	second := fakeserver.New()
+	second.Expect(packets.CONNECT).Match(expectResume).Respond(resumed)
+	second.Expect(packets.PUBLISH).Match(func(cp *packets.ControlPacket) error {
+		p := cp.Content.(*packets.Publish)
+		if !p.Duplicate {
+			return errors.New("DUP not set on resent PUBLISH")
+		}
+		if p.PacketID != id {
+			return errors.New("resent PUBLISH has a different packet identifier")
+		}
+		if p.Topic != "test/resend" || string(p.Payload) != "hello" {
+			return errors.New("resent PUBLISH does not match the original")
+		}
+		return nil
+	}).Respond(&packets.Puback{Properties: &packets.Properties{}})
+
+	cm := sessionConnection(t, first, second)
+	publishUnacknowledged(t, cm, &paho.Publish{QoS: 1, Topic: "test/resend", Payload: []byte("hello")})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := first.Wait(ctx); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	if err := second.Wait(ctx); err != nil {
+		t.Fatalf("second connection: %v", err)
+	}
+}
+
+func TestConnectionManagerResendPubrelOnSessionResume(t *testing.T) {
+	connack := &packets.Connack{ReasonCode: packets.ConnackSuccess, Properties: &packets.Properties{}}
+	resumed := &packets.Connack{ReasonCode: packets.ConnackSuccess, SessionPresent: true, Properties: &packets.Properties{}}
+
+	var id uint16
+	first := fakeserver.New()
+	first.Expect(packets.CONNECT).Respond(connack)
+	first.Expect(packets.PUBLISH).Match(func(cp *packets.ControlPacket) error {
+		id = cp.Content.(*packets.Publish).PacketID
+		return nil
+	}).Respond(&packets.Pubrec{Properties: &packets.Properties{}})
+	first.Expect(packets.PUBREL)
+	first.Close() // drop the connection before the PUBCOMP is sent
+
+	second := fakeserver.New()
+	second.Expect(packets.CONNECT).Match(expectResume).Respond(resumed)
+	second.Expect(packets.PUBREL).Match(func(cp *packets.ControlPacket) error {
+		if cp.Content.(*packets.Pubrel).PacketID != id {
+			return errors.New("resent PUBREL has a different packet identifier")
+		}
+		return nil
+	}).Respond(&packets.Pubcomp{Properties: &packets.Properties{}})
+
+	cm := sessionConnection(t, first, second)
+	publishUnacknowledged(t, cm, &paho.Publish{QoS: 2, Topic: "test/resend", Payload: []byte("hello")})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := first.Wait(ctx); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	if err := second.Wait(ctx); err != nil {
+		t.Fatalf("second connection: %v", err)
+	}
+	// The PUBLISH has been released so must not be sent again
+	if ids := second.PacketIDs(packets.PUBLISH); len(ids) != 0 {
+		t.Errorf("PUBLISH resent after PUBREL: %v", ids)
+	}
+}
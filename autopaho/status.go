@@ -0,0 +1,203 @@
+package autopaho
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Connection status reporting functionality for AutoPaho
+
+// ConnectionState indicates the state of the connection managed by a ConnectionManager
+type ConnectionState byte
+
+const (
+	StateConnecting   ConnectionState = iota // Attempting to connect to a broker
+	StateUp                                  // Connection is up
+	StateDown                                // Connection has been lost (a reconnection will be attempted)
+	StateRedirected                          // The server has requested that the client use another server (and the redirect will be followed)
+	StateShuttingDown                        // The ConnectionManager is shutting down (Disconnect called or context cancelled)
+	StateStopped                             // The ConnectionManager has shut down
+)
+
+// String returns a human readable representation of the state
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateUp:
+		return "up"
+	case StateDown:
+		return "down"
+	case StateRedirected:
+		return "redirected"
+	case StateShuttingDown:
+		return "shutting down"
+	case StateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// Status is a snapshot of the state of the connection (as returned by ConnectionManager.Status)
+type Status struct {
+	State       ConnectionState // The current state
+	Since       time.Time       // When the current state was entered
+	BrokerURL   *url.URL        // The broker connected to (or being connected to); nil if no attempt has been made
+	ConnectedAt time.Time       // When the most recent connection was established (zero if no connection has been made)
+	Reconnects  int             // Number of times the connection has been re-established after being lost
+	LastError   error           // The most recent error (connection attempt failure or cause of connection loss)
+}
+
+// StatusEvent describes a transition in the state of the connection (see ConnectionManager.StatusEvents)
+type StatusEvent struct {
+	State     ConnectionState // The state entered
+	Time      time.Time       // When the transition occurred
+	BrokerURL *url.URL        // The broker relevant to the event (may be nil)
+	Err       error           // The cause of the connection being lost (StateDown only)
+	Redirect  *ServerRedirect // Details of the redirect (StateRedirected only)
+}
+
+// statusTracker maintains the Status of a ConnectionManager and distributes StatusEvent's to subscribers.
+// Methods may be called on a nil statusTracker (in which case they do nothing).
+type statusTracker struct {
+	mu          sync.Mutex
+	status      Status
+	subscribers map[chan StatusEvent]struct{}
+	stopped     bool
+}
+
+// newStatusTracker creates a statusTracker in the StateConnecting state
+func newStatusTracker() *statusTracker {
+	return &statusTracker{
+		status:      Status{State: StateConnecting, Since: time.Now()},
+		subscribers: make(map[chan StatusEvent]struct{}),
+	}
+}
+
+// snapshot returns the current Status
+func (s *statusTracker) snapshot() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// subscribe returns a channel that will receive StatusEvent's along with a function that cancels the subscription
+func (s *statusTracker) subscribe(size int) (<-chan StatusEvent, func()) {
+	ch := make(chan StatusEvent, size)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		close(ch)
+		return ch, func() {}
+	}
+	s.subscribers[ch] = struct{}{}
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// connecting should be called before each connection attempt
+func (s *statusTracker) connecting(u *url.URL) {
+	s.transition(StatusEvent{State: StateConnecting, BrokerURL: u})
+}
+
+// failed should be called when a connection attempt fails
+func (s *statusTracker) failed(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status.LastError = err
+	s.mu.Unlock()
+}
+
+// up should be called when a connection has been established
+func (s *statusTracker) up(u *url.URL) {
+	s.transition(StatusEvent{State: StateUp, BrokerURL: u})
+}
+
+// down should be called when the connection is lost
+func (s *statusTracker) down(u *url.URL, err error) {
+	s.transition(StatusEvent{State: StateDown, BrokerURL: u, Err: err})
+}
+
+// redirected should be called when a redirect is going to be followed
+func (s *statusTracker) redirected(sr ServerRedirect) {
+	s.transition(StatusEvent{State: StateRedirected, BrokerURL: sr.From, Redirect: &sr})
+}
+
+// shuttingDown should be called when the ConnectionManager begins shutting down
+func (s *statusTracker) shuttingDown() {
+	s.transition(StatusEvent{State: StateShuttingDown})
+}
+
+// stop should be called when the ConnectionManager has shut down; all subscriptions will be closed
+func (s *statusTracker) stop() {
+	if s == nil {
+		return
+	}
+	s.transition(StatusEvent{State: StateStopped})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	for ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = nil
+}
+
+// transition updates the status and sends the event to all subscribers (events are dropped if a subscribers channel
+// is full, we do not want to delay connection management due to a slow subscriber)
+func (s *statusTracker) transition(ev StatusEvent) {
+	if s == nil {
+		return
+	}
+	ev.Time = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	switch ev.State {
+	case StateUp:
+		if !s.status.ConnectedAt.IsZero() {
+			s.status.Reconnects++
+		}
+		s.status.ConnectedAt = ev.Time
+	case StateDown:
+		s.status.LastError = ev.Err
+	}
+	if ev.BrokerURL != nil {
+		s.status.BrokerURL = ev.BrokerURL
+	}
+	s.status.State = ev.State
+	s.status.Since = ev.Time
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Status returns a snapshot of the current state of the connection
+func (c *ConnectionManager) Status() Status {
+	return c.status.snapshot()
+}
+
+// StatusEvents returns a channel (with the specified buffer size) that will receive an event whenever the connection
+// state changes, along with a function that should be called to cancel the subscription (which closes the channel).
+// Events will be dropped if the channel is full (connection management is never blocked by a slow receiver), so use
+// Status to retrieve the current state if required. The channel is closed after the StateStopped event is sent.
+func (c *ConnectionManager) StatusEvents(size int) (<-chan StatusEvent, func()) {
+	return c.status.subscribe(size)
+}
@@ -0,0 +1,107 @@
+package autopaho
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func TestStatusTracker(t *testing.T) {
+	a, _ := url.Parse("tcp://a:1883")
+	s := newStatusTracker()
+	events, cancel := s.subscribe(10)
+	slow, _ := s.subscribe(0) // Unbuffered and never read; must not block
+
+	s.connecting(a)
+	s.failed(errors.New("refused"))
+	if st := s.snapshot(); st.State != StateConnecting || st.BrokerURL != a || st.LastError == nil {
+		t.Fatalf("unexpected status after failed attempt: %+v", st)
+	}
+	s.up(a)
+	lost := errors.New("connection lost")
+	s.down(a, lost)
+	s.connecting(a)
+	s.up(a)
+
+	st := s.snapshot()
+	if st.State != StateUp || st.Reconnects != 1 || st.LastError != lost || st.ConnectedAt.IsZero() {
+		t.Fatalf("unexpected status after reconnection: %+v", st)
+	}
+
+	want := []ConnectionState{StateConnecting, StateUp, StateDown, StateConnecting, StateUp}
+	for i, w := range want {
+		ev := <-events
+		if ev.State != w {
+			t.Fatalf("event %d: expected %s, got %s", i, w, ev.State)
+		}
+		if ev.State == StateDown && ev.Err != lost {
+			t.Fatalf("expected down event to include cause, got %v", ev.Err)
+		}
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatal("expected channel to be closed when subscription cancelled")
+	}
+	cancel() // Should be safe to call multiple times
+
+	s.stop()
+	if _, ok := <-slow; ok {
+		t.Fatal("expected channel to be closed when tracker stopped")
+	}
+	late, _ := s.subscribe(1)
+	if _, ok := <-late; ok {
+		t.Fatal("expected subscription after stop to be closed")
+	}
+	if s.snapshot().State != StateStopped {
+		t.Fatalf("expected stopped state, got %s", s.snapshot().State)
+	}
+}
+
+func TestConnectionManagerStatus(t *testing.T) {
+	broker := fakeBroker(t, &packets.Connack{Properties: &packets.Properties{}})
+	defer broker.Close()
+
+	u, _ := url.Parse("tcp://" + broker.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cm, err := NewConnection(ctx, ClientConfig{
+		BrokerUrls:   []*url.URL{u},
+		KeepAlive:    30,
+		ClientConfig: paho.ClientConfig{ClientID: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, stop := cm.StatusEvents(10)
+	defer stop()
+	if err = cm.AwaitConnection(ctx); err != nil {
+		t.Fatalf("connection not established: %s", err)
+	}
+
+	st := cm.Status()
+	if st.State != StateUp || st.BrokerURL != u || st.Reconnects != 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	time.Sleep(100 * time.Millisecond) // allow the client goroutines to start before disconnecting
+	if err = cm.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []ConnectionState
+	for ev := range events {
+		got = append(got, ev.State)
+	}
+	if len(got) < 2 || got[len(got)-2] != StateShuttingDown || got[len(got)-1] != StateStopped {
+		t.Fatalf("unexpected events: %v", got)
+	}
+	if cm.Status().State != StateStopped {
+		t.Fatalf("expected stopped, got %s", cm.Status().State)
+	}
+}
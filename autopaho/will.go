@@ -0,0 +1,228 @@
+package autopaho
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// Will (Last Will and Testament) functionality for AutoPaho
+
+// willConfig holds the Will message that will be sent in the CONNECT packet. The ClientConfig methods replace the
+// pointer (rather than modifying the willConfig) so copies of a ClientConfig are independent; NewConnection takes a
+// copy which is shared with the ConnectionManager so that the Will can be changed between connections.
+type willConfig struct {
+	mu         sync.Mutex
+	message    *paho.WillMessage
+	properties *paho.WillProperties
+}
+
+// set replaces the Will (a nil message removes it)
+func (w *willConfig) set(m *paho.WillMessage, p *paho.WillProperties) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if m == nil {
+		w.message, w.properties = nil, nil
+		return
+	}
+	w.message, w.properties = copyWillMessage(m), copyWillProperties(p)
+}
+
+// get returns copies of the Will message and properties (nil if no Will is configured). If the Will Delay Interval
+// has not been set it defaults to 2 * keepAlive.
+func (w *willConfig) get(keepAlive uint16) (*paho.WillMessage, *paho.WillProperties) {
+	if w == nil {
+		return nil, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.message == nil {
+		return nil, nil
+	}
+	p := copyWillProperties(w.properties)
+	if p == nil {
+		p = &paho.WillProperties{}
+	}
+	if p.WillDelayInterval == nil {
+		// how the broker should wait before considering the client disconnected
+		// hopefully this default is sensible for most applications, tolerating short interruptions
+		willDelayInterval := uint32(2 * keepAlive)
+		p.WillDelayInterval = &willDelayInterval
+	}
+	return copyWillMessage(w.message), p
+}
+
+// clone returns a new willConfig with the same content (or an empty one if w is nil)
+func (w *willConfig) clone() *willConfig {
+	n := &willConfig{}
+	if w != nil {
+		w.mu.Lock()
+		n.message, n.properties = copyWillMessage(w.message), copyWillProperties(w.properties)
+		w.mu.Unlock()
+	}
+	return n
+}
+
+// SetWill configures the Will message, and its properties (which may be nil), that will be sent when connecting.
+// If the Will Delay Interval is not set it will default to 2 * KeepAlive. A nil message removes the Will.
+// The message and properties are validated (an error is returned, and the configuration is unchanged, if they are not
+// acceptable) and copied (so may be modified after this call).
+func (cfg *ClientConfig) SetWill(m *paho.WillMessage, p *paho.WillProperties) error {
+	if err := ValidateWill(m, p); err != nil {
+		return err
+	}
+	cfg.will = &willConfig{}
+	cfg.will.set(m, p)
+	return nil
+}
+
+// SetWillProperties replaces the properties of the Will message configured via SetWill or SetWillMessage.
+// An error is returned if no Will has been configured or the properties are invalid.
+func (cfg *ClientConfig) SetWillProperties(p *paho.WillProperties) error {
+	m, _ := cfg.will.get(cfg.KeepAlive)
+	if m == nil {
+		return errors.New("no will message configured")
+	}
+	return cfg.SetWill(m, p)
+}
+
+// ClearWill removes any configured Will message
+func (cfg *ClientConfig) ClearWill() {
+	cfg.will = nil
+}
+
+// SetWill replaces the Will message (and properties) that will be sent when the next connection is established; a nil
+// message removes the Will. MQTT provides no way to change the Will associated with an existing connection, so this
+// does not affect the current connection (if any). A common use is to embed the last known state of the client in the
+// Will (e.g. calling SetWill whenever the state changes).
+// The same validation as ClientConfig.SetWill is applied.
+func (c *ConnectionManager) SetWill(m *paho.WillMessage, p *paho.WillProperties) error {
+	if err := ValidateWill(m, p); err != nil {
+		return err
+	}
+	c.will.set(m, p)
+	return nil
+}
+
+// ValidateWill checks that the Will message and properties are acceptable under the MQTT v5 specification
+// (a nil message is valid and means that there is no Will; an empty payload is valid and results in a zero length
+// Will message being published).
+func ValidateWill(m *paho.WillMessage, p *paho.WillProperties) error {
+	if m == nil {
+		if p != nil {
+			return errors.New("will properties provided without a will message")
+		}
+		return nil
+	}
+	if err := validateTopicName("will topic", m.Topic); err != nil {
+		return err
+	}
+	if m.QoS > 2 {
+		return fmt.Errorf("invalid will QoS (%d)", m.QoS)
+	}
+	if len(m.Payload) > 65535 {
+		return fmt.Errorf("will payload too long (%d bytes)", len(m.Payload))
+	}
+	if p == nil {
+		return nil
+	}
+	if p.PayloadFormat != nil {
+		switch *p.PayloadFormat {
+		case 0:
+		case 1:
+			if !utf8.Valid(m.Payload) {
+				return errors.New("will payload format indicates UTF-8 but payload is not valid UTF-8")
+			}
+		default:
+			return fmt.Errorf("invalid will payload format (%d)", *p.PayloadFormat)
+		}
+	}
+	if err := validateString("will content type", p.ContentType); err != nil {
+		return err
+	}
+	if p.ResponseTopic != "" {
+		if err := validateTopicName("will response topic", p.ResponseTopic); err != nil {
+			return err
+		}
+	}
+	if len(p.CorrelationData) > 65535 {
+		return fmt.Errorf("will correlation data too long (%d bytes)", len(p.CorrelationData))
+	}
+	for _, u := range p.User {
+		if err := validateString("will user property key", u.Key); err != nil {
+			return err
+		}
+		if err := validateString("will user property value", u.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTopicName checks that t is a valid topic name (non-empty, no wildcards)
+func validateTopicName(name, t string) error {
+	if t == "" {
+		return fmt.Errorf("%s must not be empty", name)
+	}
+	if strings.ContainsAny(t, "+#") {
+		return fmt.Errorf("%s must not contain wildcards (%q)", name, t)
+	}
+	return validateString(name, t)
+}
+
+// validateString checks that s can be encoded as a MQTT UTF-8 Encoded String
+func validateString(name, s string) error {
+	if len(s) > 65535 {
+		return fmt.Errorf("%s too long (%d bytes)", name, len(s))
+	}
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("%s is not valid UTF-8", name)
+	}
+	if strings.ContainsRune(s, 0) {
+		return fmt.Errorf("%s must not contain the null character", name)
+	}
+	return nil
+}
+
+// copyWillMessage returns a deep copy of m
+func copyWillMessage(m *paho.WillMessage) *paho.WillMessage {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	if m.Payload != nil {
+		c.Payload = append([]byte{}, m.Payload...)
+	}
+	return &c
+}
+
+// copyWillProperties returns a deep copy of p
+func copyWillProperties(p *paho.WillProperties) *paho.WillProperties {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	if p.WillDelayInterval != nil {
+		v := *p.WillDelayInterval
+		c.WillDelayInterval = &v
+	}
+	if p.PayloadFormat != nil {
+		v := *p.PayloadFormat
+		c.PayloadFormat = &v
+	}
+	if p.MessageExpiry != nil {
+		v := *p.MessageExpiry
+		c.MessageExpiry = &v
+	}
+	if p.CorrelationData != nil {
+		c.CorrelationData = append([]byte{}, p.CorrelationData...)
+	}
+	if p.User != nil {
+		c.User = append(paho.UserProperties{}, p.User...)
+	}
+	return &c
+}
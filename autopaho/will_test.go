@@ -0,0 +1,196 @@
+package autopaho
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func TestValidateWill(t *testing.T) {
+	utf8Format, badFormat := byte(1), byte(2)
+	tests := []struct {
+		name    string
+		m       *paho.WillMessage
+		p       *paho.WillProperties
+		wantErr bool
+	}{
+		{"none", nil, nil, false},
+		{"propertiesOnly", nil, &paho.WillProperties{}, true},
+		{"valid", &paho.WillMessage{Topic: "a/b", Payload: []byte("x"), QoS: 1}, nil, false},
+		{"emptyPayload", &paho.WillMessage{Topic: "a/b"}, nil, false},
+		{"emptyTopic", &paho.WillMessage{Payload: []byte("x")}, nil, true},
+		{"wildcard", &paho.WillMessage{Topic: "a/+"}, nil, true},
+		{"nullInTopic", &paho.WillMessage{Topic: "a\x00b"}, nil, true},
+		{"qos", &paho.WillMessage{Topic: "a", QoS: 3}, nil, true},
+		{"utf8Payload", &paho.WillMessage{Topic: "a", Payload: []byte("ok")}, &paho.WillProperties{PayloadFormat: &utf8Format}, false},
+		{"invalidUtf8Payload", &paho.WillMessage{Topic: "a", Payload: []byte{0xff}}, &paho.WillProperties{PayloadFormat: &utf8Format}, true},
+		{"payloadFormat", &paho.WillMessage{Topic: "a"}, &paho.WillProperties{PayloadFormat: &badFormat}, true},
+		{"responseTopic", &paho.WillMessage{Topic: "a"}, &paho.WillProperties{ResponseTopic: "b/#"}, true},
+		{"contentType", &paho.WillMessage{Topic: "a"}, &paho.WillProperties{ContentType: string([]byte{0xff})}, true},
+		{"userProperty", &paho.WillMessage{Topic: "a"}, &paho.WillProperties{User: paho.UserProperties{{Key: "k", Value: "v\x00"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateWill(tt.m, tt.p); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateWill() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientConfig_SetWill(t *testing.T) {
+	config := ClientConfig{KeepAlive: 5, ClientConfig: paho.ClientConfig{ClientID: "test"}}
+
+	if err := config.SetWill(&paho.WillMessage{Topic: "a/#"}, nil); err == nil {
+		t.Fatal("expected invalid will to be rejected")
+	}
+	if err := config.SetWillProperties(&paho.WillProperties{}); err == nil {
+		t.Fatal("expected error setting properties with no will message")
+	}
+
+	delay, expiry := uint32(0), uint32(60)
+	payload := []byte("offline")
+	props := &paho.WillProperties{
+		WillDelayInterval: &delay,
+		MessageExpiry:     &expiry,
+		ContentType:       "text/plain",
+		User:              paho.UserProperties{{Key: "state", Value: "idle"}},
+	}
+	if err := config.SetWill(&paho.WillMessage{Topic: "client/test/state", Payload: payload, QoS: 1}, props); err != nil {
+		t.Fatal(err)
+	}
+	payload[0] = 'X' // The will should be copied
+	props.User[0].Value = "changed"
+
+	cp := config.buildConnectPacket()
+	if cp.WillMessage == nil || string(cp.WillMessage.Payload) != "offline" {
+		t.Fatalf("unexpected will message: %+v", cp.WillMessage)
+	}
+	if *cp.WillProperties.WillDelayInterval != 0 {
+		t.Errorf("expected will delay interval of 0, got %d", *cp.WillProperties.WillDelayInterval)
+	}
+	if *cp.WillProperties.MessageExpiry != 60 || cp.WillProperties.ContentType != "text/plain" {
+		t.Errorf("unexpected will properties: %+v", cp.WillProperties)
+	}
+	if len(cp.WillProperties.User) != 1 || cp.WillProperties.User[0].Value != "idle" {
+		t.Errorf("unexpected will user properties: %v", cp.WillProperties.User)
+	}
+	if cp.WillProperties.PayloadFormat != nil {
+		t.Error("payload format should not be sent unless set")
+	}
+
+	// SetWillMessage retains the properties
+	config.SetWillMessage("client/test/state", []byte("gone"), 0, false)
+	if cp = config.buildConnectPacket(); string(cp.WillMessage.Payload) != "gone" || cp.WillProperties.ContentType != "text/plain" {
+		t.Errorf("unexpected will after SetWillMessage: %+v %+v", cp.WillMessage, cp.WillProperties)
+	}
+
+	config.ClearWill()
+	if cp = config.buildConnectPacket(); cp.WillMessage != nil || cp.WillProperties != nil {
+		t.Errorf("expected no will, got %+v %+v", cp.WillMessage, cp.WillProperties)
+	}
+}
+
+func TestClientConfig_WillOnWire(t *testing.T) {
+	config := ClientConfig{KeepAlive: 5, ClientConfig: paho.ClientConfig{ClientID: "test"}}
+	utf8Format, delay, expiry := byte(1), uint32(10), uint32(60)
+	props := &paho.WillProperties{
+		WillDelayInterval: &delay,
+		PayloadFormat:     &utf8Format,
+		MessageExpiry:     &expiry,
+		ContentType:       "text/plain",
+		ResponseTopic:     "client/test/response",
+		CorrelationData:   []byte("id"),
+		User:              paho.UserProperties{{Key: "state", Value: "idle"}},
+	}
+	if err := config.SetWill(&paho.WillMessage{Topic: "client/test/state", Payload: []byte("offline"), QoS: 1}, props); err != nil {
+		t.Fatal(err)
+	}
+
+	ccp := config.buildConnectPacket().Packet()
+	ccp.ProtocolName, ccp.ProtocolVersion = "MQTT", 5 // as set by paho.Client.Connect
+	var b bytes.Buffer
+	if _, err := ccp.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := packets.ReadPacketStrict(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cp.Content.(*packets.Connect)
+	want := &packets.Properties{
+		WillDelayInterval: &delay,
+		PayloadFormat:     &utf8Format,
+		MessageExpiry:     &expiry,
+		ContentType:       "text/plain",
+		ResponseTopic:     "client/test/response",
+		CorrelationData:   []byte("id"),
+		User:              []packets.User{{Key: "state", Value: "idle"}},
+	}
+	if !c.WillFlag || c.WillTopic != "client/test/state" || !reflect.DeepEqual(c.WillProperties, want) {
+		t.Errorf("will not received as sent: %s", c)
+	}
+}
+
+func TestConnectionManager_SetWill(t *testing.T) {
+	config := ClientConfig{KeepAlive: 5, ClientConfig: paho.ClientConfig{ClientID: "test"}}
+	config.SetWillMessage("client/test/state", []byte("initial"), 1, true)
+
+	// Replicates the setup performed by NewConnection
+	cfg := config
+	cfg.will = cfg.will.clone()
+	c := ConnectionManager{will: cfg.will}
+
+	if err := c.SetWill(&paho.WillMessage{Topic: "client/test/state", Payload: []byte("last state: running"), QoS: 1, Retain: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetWill(&paho.WillMessage{Topic: ""}, nil); err == nil {
+		t.Fatal("expected invalid will to be rejected")
+	}
+
+	cp := cfg.buildConnectPacket()
+	if string(cp.WillMessage.Payload) != "last state: running" {
+		t.Errorf("expected updated will to be used on next connection, got %q", cp.WillMessage.Payload)
+	}
+	if *cp.WillProperties.WillDelayInterval != 10 {
+		t.Errorf("expected default will delay interval of 10, got %d", *cp.WillProperties.WillDelayInterval)
+	}
+	if cp = config.buildConnectPacket(); string(cp.WillMessage.Payload) != "initial" {
+		t.Errorf("callers config should not be changed, got %q", cp.WillMessage.Payload)
+	}
+}
+
+func TestClientConfig_WillCopiesIndependent(t *testing.T) {
+	config := ClientConfig{KeepAlive: 5, ClientConfig: paho.ClientConfig{ClientID: "test"}}
+	config.SetWillMessage("client/test/state", []byte("original"), 1, true)
+
+	copied := config
+	copied.SetWillMessage("client/test/state", []byte("copy"), 1, true)
+	if cp := config.buildConnectPacket(); string(cp.WillMessage.Payload) != "original" {
+		t.Errorf("will changed via copy of config, got %q", cp.WillMessage.Payload)
+	}
+	copied.ClearWill()
+	if cp := config.buildConnectPacket(); cp.WillMessage == nil {
+		t.Error("will cleared via copy of config")
+	}
+}
+
+func TestClientConfig_WillEmptyPayload(t *testing.T) {
+	config := ClientConfig{KeepAlive: 5, ClientConfig: paho.ClientConfig{ClientID: "test"}}
+	config.SetWillMessage("client/test/state", nil, 1, true)
+	cp := config.buildConnectPacket()
+	if cp.WillMessage == nil || len(cp.WillMessage.Payload) != 0 {
+		t.Fatalf("expected will with empty payload, got %+v", cp.WillMessage)
+	}
+	if err := ValidateWill(cp.WillMessage, cp.WillProperties); err != nil {
+		t.Errorf("will with empty payload rejected: %v", err)
+	}
+
+	config.SetWillMessage("", []byte("gone"), 1, true)
+	if cp = config.buildConnectPacket(); cp.WillMessage != nil {
+		t.Errorf("expected empty topic to remove the will, got %+v", cp.WillMessage)
+	}
+}
@@ -0,0 +1,360 @@
+// Package broker provides a minimal, in-memory, MQTT v5 broker intended for testing clients (such as paho and
+// autopaho) end-to-end without external network services.
+//
+// The broker supports:
+//
+//	CONNECT with simple (username/password) and enhanced (AUTH) authentication, including re-authentication
+//	subscriptions with wildcards, shared subscriptions ($share/group/filter), subscription identifiers and the
+//	  No Local, Retain As Published and Retain Handling options
+//	retained messages, QoS 0, 1 and 2 (with Receive Maximum flow control), topic aliases sent by the client
+//	wills (including the Will Delay Interval), session expiry and session takeover
+//	message expiry
+//
+// Connections are accepted from a net.Listener (Serve), any net.Conn (ServeConn), or an in-memory net.Pipe (Pipe).
+// It is not intended for production use; all state is held in memory and there is no persistence.
+package broker
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// ErrClosed is returned by Serve once the broker has been closed
+var ErrClosed = errors.New("broker closed")
+
+// Config holds the broker configuration; the zero value provides a broker supporting QoS 2 with no authentication
+type Config struct {
+	// MaximumQoS is the maximum QoS supported (if nil, 2)
+	MaximumQoS *byte
+	// ReceiveMaximum is the number of QoS 1 and 2 publications the broker will process concurrently for each
+	// client (if 0, 65535)
+	ReceiveMaximum uint16
+	// TopicAliasMaximum is the number of topic aliases each client may use when publishing (0 means that topic
+	// aliases are not accepted)
+	TopicAliasMaximum uint16
+	// Authenticate, if set, is called to validate the username and password in each CONNECT
+	Authenticate func(clientID, username string, password []byte) bool
+	// EnhancedAuth, if set, handles enhanced authentication; it is called with the authentication method and data
+	// from the CONNECT, or AUTH, packet and returns the reason code (packets.AuthSuccess,
+	// packets.AuthContinueAuthentication or an error code such as packets.ConnackNotAuthorized) along with any data
+	// to return to the client. If nil CONNECT packets specifying an authentication method are rejected.
+	EnhancedAuth func(clientID, method string, data []byte) (byte, []byte)
+	// Logger receives structured log entries from the broker
+	Logger paho.StructuredLogger
+}
+
+// Broker is an in-memory MQTT v5 broker
+type Broker struct {
+	cfg        Config
+	maximumQoS byte
+	log        paho.LevelLogger
+
+	mu        sync.Mutex
+	sessions  map[string]*session
+	retained  map[string]*message
+	shared    map[string]int // round robin position for each shared subscription
+	conns     map[*conn]struct{}
+	listeners map[net.Listener]struct{}
+	nextID    int // used to generate client identifiers
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// message is an application message being distributed by the broker
+type message struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+	props   *packets.Properties // PUBLISH properties forwarded to subscribers
+	expiry  time.Time           // zero if the message does not expire
+	sender  *session            // the session that published the message (nil for retained messages)
+}
+
+// subscription is a subscription held by a session
+type subscription struct {
+	packets.SubOptions        // Topic holds the topic filter (excluding any $share prefix)
+	group              string // share name (empty if not a shared subscription)
+	id                 int    // subscription identifier (0 if none)
+}
+
+// New returns a Broker using the provided configuration
+func New(cfg Config) *Broker {
+	b := &Broker{
+		cfg:        cfg,
+		maximumQoS: 2,
+		log:        paho.LevelLogger{StructuredLogger: cfg.Logger},
+		sessions:   make(map[string]*session),
+		retained:   make(map[string]*message),
+		shared:     make(map[string]int),
+		conns:      make(map[*conn]struct{}),
+		listeners:  make(map[net.Listener]struct{}),
+	}
+	if cfg.MaximumQoS != nil && *cfg.MaximumQoS < 2 {
+		b.maximumQoS = *cfg.MaximumQoS
+	}
+	if b.cfg.ReceiveMaximum == 0 {
+		b.cfg.ReceiveMaximum = 65535
+	}
+	return b
+}
+
+// Serve accepts connections from l, handling each in a new goroutine, until the broker is closed (when ErrClosed is
+// returned) or Accept fails. l is closed when the broker is closed.
+func (b *Broker) Serve(l net.Listener) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.listeners[l] = struct{}{}
+	b.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			delete(b.listeners, l)
+			b.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+		go b.ServeConn(nc)
+	}
+}
+
+// ServeConn handles the MQTT connection nc, returning when the connection is closed
+func (b *Broker) ServeConn(nc net.Conn) {
+	c := newConn(b, nc)
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		nc.Close()
+		return
+	}
+	b.conns[c] = struct{}{}
+	b.wg.Add(1)
+	b.mu.Unlock()
+	defer b.wg.Done()
+
+	c.serve()
+
+	b.mu.Lock()
+	delete(b.conns, c)
+	b.mu.Unlock()
+}
+
+// Pipe returns the client side of an in-memory connection (created with net.Pipe) to the broker
+func (b *Broker) Pipe() net.Conn {
+	client, server := net.Pipe()
+	go b.ServeConn(server)
+	return client
+}
+
+// Close disconnects all clients (with reason code packets.DisconnectServerShuttingDown), closes any listeners passed
+// to Serve and waits for the connections to close. Session state is discarded.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	for l := range b.listeners {
+		l.Close()
+	}
+	for c := range b.conns {
+		c.disconnect(packets.DisconnectServerShuttingDown)
+	}
+	for _, s := range b.sessions {
+		s.stopTimers()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
+
+// Connected reports whether a client with the provided identifier is currently connected
+func (b *Broker) Connected(clientID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.sessions[clientID]
+	return ok && s.conn != nil
+}
+
+// Disconnect sends a DISCONNECT, with reason code rc, to the client with the provided identifier and closes the
+// connection (the session, and will, are handled as for any other server initiated disconnection). It reports
+// whether the client was connected.
+func (b *Broker) Disconnect(clientID string, rc byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.sessions[clientID]
+	if !ok || s.conn == nil {
+		return false
+	}
+	s.conn.disconnect(rc)
+	return true
+}
+
+// Retained returns the retained message for topic (nil if there is none)
+func (b *Broker) Retained(topic string) *packets.Publish {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.retained[topic]
+	if !ok || m.expired(time.Now()) {
+		return nil
+	}
+	return m.packet(m.qos, true, 0)
+}
+
+// assignClientID returns a client identifier that is not in use (b.mu must be held)
+func (b *Broker) assignClientID() string {
+	for {
+		b.nextID++
+		id := "broker-assigned-" + strconv.Itoa(b.nextID)
+		if _, ok := b.sessions[id]; !ok {
+			return id
+		}
+	}
+}
+
+// publish retains (if required) and distributes m, reporting whether any subscriptions matched (b.mu must be held)
+func (b *Broker) publish(m *message) bool {
+	b.log.Debug("distributing message", paho.TopicField(m.topic), paho.Field{Key: "qos", Value: m.qos})
+	if m.retain {
+		if len(m.payload) == 0 {
+			delete(b.retained, m.topic)
+		} else {
+			r := *m
+			r.sender = nil
+			b.retained[m.topic] = &r
+		}
+	}
+
+	type candidate struct {
+		s   *session
+		sub *subscription
+	}
+	var matched bool
+	shared := make(map[string][]candidate)
+	for _, s := range b.sessions {
+		var best *subscription
+		var id int
+		for _, sub := range s.subs {
+			if !matchTopic(sub.Topic, m.topic) {
+				continue
+			}
+			if sub.group != "" {
+				key := sub.group + "/" + sub.Topic
+				shared[key] = append(shared[key], candidate{s, sub})
+				continue
+			}
+			if sub.NoLocal && m.sender == s {
+				continue
+			}
+			if best == nil || sub.QoS > best.QoS {
+				best = sub
+			}
+			if id == 0 {
+				id = sub.id
+			}
+		}
+		if best != nil {
+			matched = true
+			s.enqueue(m, best.QoS, m.retain && best.RetainAsPublished, id)
+		}
+	}
+
+	// Each shared subscription receives the message once; a connected session is preferred
+	for key, cands := range shared {
+		matched = true
+		sort.Slice(cands, func(i, j int) bool { return cands[i].s.clientID < cands[j].s.clientID })
+		start := b.shared[key]
+		b.shared[key]++
+		chosen := cands[start%len(cands)]
+		for i := range cands {
+			if c := cands[(start+i)%len(cands)]; c.s.conn != nil {
+				chosen = c
+				break
+			}
+		}
+		chosen.s.enqueue(m, chosen.sub.QoS, m.retain && chosen.sub.RetainAsPublished, chosen.sub.id)
+	}
+	return matched
+}
+
+// sendRetained sends the retained messages matching sub to s (b.mu must be held)
+func (b *Broker) sendRetained(s *session, sub *subscription) {
+	now := time.Now()
+	for topic, m := range b.retained {
+		if m.expired(now) {
+			delete(b.retained, topic)
+			continue
+		}
+		if matchTopic(sub.Topic, topic) {
+			s.enqueue(m, sub.QoS, true, sub.id)
+		}
+	}
+}
+
+// expired reports whether the message has expired
+func (m *message) expired(now time.Time) bool {
+	return !m.expiry.IsZero() && !now.Before(m.expiry)
+}
+
+// packet returns a PUBLISH packet for the message
+func (m *message) packet(qos byte, retain bool, subID int) *packets.Publish {
+	props := &packets.Properties{}
+	if m.props != nil {
+		*props = *m.props
+	}
+	if !m.expiry.IsZero() {
+		remaining := uint32((time.Until(m.expiry) + time.Second - 1) / time.Second)
+		props.MessageExpiry = &remaining
+	}
+	if subID != 0 {
+		props.SubscriptionIdentifier = &subID
+	}
+	return &packets.Publish{
+		Topic:      m.topic,
+		Payload:    m.payload,
+		QoS:        qos,
+		Retain:     retain,
+		Properties: props,
+	}
+}
+
+// newMessage returns a message holding the content of the PUBLISH p (whose topic has been resolved)
+func newMessage(p *packets.Publish, topic string, sender *session) *message {
+	m := &message{
+		topic:   topic,
+		payload: p.Payload,
+		qos:     p.QoS,
+		retain:  p.Retain,
+		sender:  sender,
+	}
+	if p.Properties != nil {
+		m.props = &packets.Properties{
+			PayloadFormat:   p.Properties.PayloadFormat,
+			ContentType:     p.Properties.ContentType,
+			ResponseTopic:   p.Properties.ResponseTopic,
+			CorrelationData: p.Properties.CorrelationData,
+			User:            p.Properties.User,
+		}
+		if p.Properties.MessageExpiry != nil {
+			m.expiry = time.Now().Add(time.Duration(*p.Properties.MessageExpiry) * time.Second)
+		}
+	}
+	return m
+}
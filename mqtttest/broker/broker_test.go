@@ -0,0 +1,569 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const testTimeout = 5 * time.Second
+
+// testClient is a paho.Client connected to the broker along with a channel receiving its messages
+type testClient struct {
+	*paho.Client
+	msgs    chan *paho.Publish
+	connack *paho.Connack
+}
+
+// connect connects a client to b (via a pipe) using cp
+func connect(t *testing.T, b *Broker, cp *paho.Connect, cfg paho.ClientConfig) *testClient {
+	t.Helper()
+	tc, err := tryConnect(b, cp, cfg)
+	if err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	t.Cleanup(func() { _ = tc.Disconnect(&paho.Disconnect{}) })
+	return tc
+}
+
+// tryConnect connects a client to b (via a pipe) using cp, returning any error
+func tryConnect(b *Broker, cp *paho.Connect, cfg paho.ClientConfig) (*testClient, error) {
+	tc := &testClient{msgs: make(chan *paho.Publish, 100)}
+	cfg.Conn = b.Pipe()
+	cfg.Router = paho.NewSingleHandlerRouter(func(p *paho.Publish) { tc.msgs <- p })
+	tc.Client = paho.NewClient(cfg)
+	if cp.KeepAlive == 0 {
+		cp.KeepAlive = 30
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	ca, err := tc.Connect(ctx, cp)
+	tc.connack = ca
+	if err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+func (tc *testClient) subscribe(t *testing.T, opts ...paho.SubscribeOptions) *paho.Suback {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	sa, err := tc.Subscribe(ctx, &paho.Subscribe{Subscriptions: opts})
+	if err != nil {
+		t.Fatalf("subscribe failed: %s", err)
+	}
+	return sa
+}
+
+func (tc *testClient) publish(t *testing.T, p *paho.Publish) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := tc.Publish(ctx, p); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+}
+
+// expect waits for a message and returns it
+func (tc *testClient) expect(t *testing.T) *paho.Publish {
+	t.Helper()
+	select {
+	case p := <-tc.msgs:
+		return p
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for message")
+		return nil
+	}
+}
+
+// expectNone checks that no message is received within a short period
+func (tc *testClient) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case p := <-tc.msgs:
+		t.Fatalf("unexpected message on %s: %s", p.Topic, p.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func newBroker(t *testing.T, cfg Config) *Broker {
+	b := New(cfg)
+	t.Cleanup(func() { _ = b.Close() })
+	return b
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	b := newBroker(t, Config{})
+	sub := connect(t, b, &paho.Connect{ClientID: "sub", CleanStart: true}, paho.ClientConfig{})
+	sa := sub.subscribe(t,
+		paho.SubscribeOptions{Topic: "test/+/value", QoS: 2},
+		paho.SubscribeOptions{Topic: "test/#", QoS: 1},
+	)
+	if want := []byte{2, 1}; string(sa.Reasons) != string(want) {
+		t.Fatalf("expected reasons %v, got %v", want, sa.Reasons)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	sa, err := sub.Subscribe(ctx, &paho.Subscribe{Subscriptions: []paho.SubscribeOptions{{Topic: "test/#/invalid", QoS: 1}}})
+	if err == nil || sa.Reasons[0] != packets.SubackTopicFilterinvalid {
+		t.Fatalf("expected invalid filter to be rejected, got %v %v", sa, err)
+	}
+
+	pub := connect(t, b, &paho.Connect{ClientID: "pub", CleanStart: true}, paho.ClientConfig{})
+	for qos := byte(0); qos <= 2; qos++ {
+		pub.publish(t, &paho.Publish{Topic: "test/a/value", QoS: qos, Payload: []byte{qos}})
+		// Overlapping subscriptions result in a single delivery at the maximum QoS
+		p := sub.expect(t)
+		if p.Topic != "test/a/value" || p.QoS != qos || p.Payload[0] != qos {
+			t.Fatalf("unexpected message %+v", p)
+		}
+	}
+	pub.publish(t, &paho.Publish{Topic: "test/b", QoS: 2, Payload: []byte("b")})
+	if p := sub.expect(t); p.Topic != "test/b" || p.QoS != 1 {
+		t.Fatalf("expected message downgraded to QoS1, got %+v", p)
+	}
+	pub.publish(t, &paho.Publish{Topic: "other", QoS: 1})
+	sub.expectNone(t)
+
+	ua, err := sub.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{"test/#", "unknown"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{packets.UnsubackSuccess, packets.UnsubackNoSubscriptionFound}; string(ua.Reasons) != string(want) {
+		t.Fatalf("expected reasons %v, got %v", want, ua.Reasons)
+	}
+	pub.publish(t, &paho.Publish{Topic: "test/b", QoS: 1})
+	sub.expectNone(t)
+}
+
+func TestSubscriptionOptions(t *testing.T) {
+	b := newBroker(t, Config{})
+	c := connect(t, b, &paho.Connect{ClientID: "c", CleanStart: true}, paho.ClientConfig{})
+	subID := 7
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := c.Subscribe(ctx, &paho.Subscribe{
+		Properties: &paho.SubscribeProperties{SubscriptionIdentifier: &subID},
+		Subscriptions: []paho.SubscribeOptions{
+			{Topic: "local", QoS: 1, NoLocal: true},
+			{Topic: "rap", QoS: 1, RetainAsPublished: true},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.publish(t, &paho.Publish{Topic: "local", QoS: 1})
+	c.expectNone(t)
+
+	c.publish(t, &paho.Publish{Topic: "rap", QoS: 1, Retain: true, Payload: []byte("x")})
+	p := c.expect(t)
+	if !p.Retain {
+		t.Fatal("expected retain flag to be kept")
+	}
+	if p.Properties == nil || p.Properties.SubscriptionIdentifier == nil || *p.Properties.SubscriptionIdentifier != subID {
+		t.Fatalf("expected subscription identifier %d, got %+v", subID, p.Properties)
+	}
+}
+
+func TestRetained(t *testing.T) {
+	b := newBroker(t, Config{})
+	pub := connect(t, b, &paho.Connect{ClientID: "pub", CleanStart: true}, paho.ClientConfig{})
+	pub.publish(t, &paho.Publish{Topic: "r/1", QoS: 1, Retain: true, Payload: []byte("one")})
+	pub.publish(t, &paho.Publish{Topic: "r/2", QoS: 0, Retain: true, Payload: []byte("two")})
+	if r := b.Retained("r/1"); r == nil || string(r.Payload) != "one" {
+		t.Fatalf("unexpected retained message %v", r)
+	}
+
+	sub := connect(t, b, &paho.Connect{ClientID: "sub", CleanStart: true}, paho.ClientConfig{})
+	sub.subscribe(t, paho.SubscribeOptions{Topic: "r/#", QoS: 1})
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		p := sub.expect(t)
+		if !p.Retain {
+			t.Fatalf("expected retain flag on %s", p.Topic)
+		}
+		got[string(p.Payload)] = true
+	}
+	if !got["one"] || !got["two"] {
+		t.Fatalf("unexpected retained messages %v", got)
+	}
+
+	// Live messages do not have the retain flag set (unless Retain As Published)
+	pub.publish(t, &paho.Publish{Topic: "r/1", QoS: 1, Retain: true, Payload: []byte("three")})
+	if p := sub.expect(t); p.Retain || string(p.Payload) != "three" {
+		t.Fatalf("unexpected message %+v", p)
+	}
+
+	// RetainHandling 1 only sends retained messages for new subscriptions; 2 never does
+	sub.subscribe(t, paho.SubscribeOptions{Topic: "r/#", QoS: 1, RetainHandling: 0x10})
+	sub.subscribe(t, paho.SubscribeOptions{Topic: "r/+", QoS: 1, RetainHandling: 0x20})
+	sub.expectNone(t)
+
+	// An empty payload clears the retained message
+	pub.publish(t, &paho.Publish{Topic: "r/1", QoS: 1, Retain: true})
+	sub.expect(t)
+	if r := b.Retained("r/1"); r != nil {
+		t.Fatalf("expected retained message to be cleared, got %v", r)
+	}
+}
+
+func TestSharedSubscription(t *testing.T) {
+	b := newBroker(t, Config{})
+	var subs []*testClient
+	for i := 0; i < 2; i++ {
+		c := connect(t, b, &paho.Connect{ClientID: fmt.Sprintf("sub%d", i), CleanStart: true}, paho.ClientConfig{})
+		c.subscribe(t, paho.SubscribeOptions{Topic: "$share/group/shared/#", QoS: 1})
+		subs = append(subs, c)
+	}
+	pub := connect(t, b, &paho.Connect{ClientID: "pub", CleanStart: true}, paho.ClientConfig{})
+	for i := 0; i < 4; i++ {
+		pub.publish(t, &paho.Publish{Topic: "shared/topic", QoS: 1, Payload: []byte{byte(i)}})
+	}
+	for _, c := range subs {
+		c.expect(t)
+		c.expect(t)
+		c.expectNone(t)
+	}
+}
+
+func TestWill(t *testing.T) {
+	b := newBroker(t, Config{})
+	sub := connect(t, b, &paho.Connect{ClientID: "sub", CleanStart: true}, paho.ClientConfig{})
+	sub.subscribe(t, paho.SubscribeOptions{Topic: "will", QoS: 1})
+
+	cp := &paho.Connect{
+		ClientID:    "willclient",
+		CleanStart:  true,
+		WillMessage: &paho.WillMessage{Topic: "will", QoS: 1, Payload: []byte("gone")},
+	}
+
+	// A clean disconnection discards the will
+	c := connect(t, b, cp, paho.ClientConfig{})
+	if err := c.Disconnect(&paho.Disconnect{}); err != nil {
+		t.Fatal(err)
+	}
+	sub.expectNone(t)
+
+	// Losing the connection causes the will to be published
+	connect(t, b, cp, paho.ClientConfig{})
+	if !b.Disconnect("willclient", packets.DisconnectAdministrativeAction) {
+		t.Fatal("client not connected")
+	}
+	if p := sub.expect(t); string(p.Payload) != "gone" {
+		t.Fatalf("unexpected will %+v", p)
+	}
+	if b.Connected("willclient") {
+		t.Fatal("client still connected")
+	}
+}
+
+func TestWillDelay(t *testing.T) {
+	b := newBroker(t, Config{})
+	sub := connect(t, b, &paho.Connect{ClientID: "sub", CleanStart: true}, paho.ClientConfig{})
+	sub.subscribe(t, paho.SubscribeOptions{Topic: "will", QoS: 1})
+
+	expiry := uint32(60)
+	cp := &paho.Connect{
+		ClientID:       "willclient",
+		CleanStart:     true,
+		Properties:     &paho.ConnectProperties{SessionExpiryInterval: &expiry},
+		WillMessage:    &paho.WillMessage{Topic: "will", QoS: 1, Payload: []byte("gone")},
+		WillProperties: &paho.WillProperties{WillDelayInterval: paho.Uint32(1)},
+	}
+	connect(t, b, cp, paho.ClientConfig{})
+	b.Disconnect("willclient", packets.DisconnectAdministrativeAction)
+
+	// Reconnecting within the delay prevents the will being published
+	cp.CleanStart = false
+	cp.WillMessage = nil
+	cp.WillProperties = nil
+	c := connect(t, b, cp, paho.ClientConfig{})
+	if !c.connack.SessionPresent {
+		t.Fatal("expected session to be present")
+	}
+	select {
+	case p := <-sub.msgs:
+		t.Fatalf("unexpected will %+v", p)
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
+
+func TestSessionResumption(t *testing.T) {
+	b := newBroker(t, Config{})
+	expiry := uint32(60)
+	cp := &paho.Connect{
+		ClientID:   "persistent",
+		CleanStart: true,
+		Properties: &paho.ConnectProperties{SessionExpiryInterval: &expiry},
+	}
+	c := connect(t, b, cp, paho.ClientConfig{})
+	if c.connack.SessionPresent {
+		t.Fatal("unexpected session present")
+	}
+	c.subscribe(t, paho.SubscribeOptions{Topic: "queued", QoS: 2})
+	if err := c.Disconnect(&paho.Disconnect{}); err != nil {
+		t.Fatal(err)
+	}
+
+	pub := connect(t, b, &paho.Connect{ClientID: "pub", CleanStart: true}, paho.ClientConfig{})
+	pub.publish(t, &paho.Publish{Topic: "queued", QoS: 0, Payload: []byte("dropped")})
+	pub.publish(t, &paho.Publish{Topic: "queued", QoS: 1, Payload: []byte("one")})
+	pub.publish(t, &paho.Publish{Topic: "queued", QoS: 2, Payload: []byte("two")})
+
+	cp.CleanStart = false
+	c = connect(t, b, cp, paho.ClientConfig{})
+	if !c.connack.SessionPresent {
+		t.Fatal("expected session to be present")
+	}
+	for _, want := range []string{"one", "two"} {
+		if p := c.expect(t); string(p.Payload) != want {
+			t.Fatalf("expected %q, got %q", want, p.Payload)
+		}
+	}
+	c.expectNone(t)
+
+	// Session takeover
+	c2 := connect(t, b, cp, paho.ClientConfig{})
+	if !c2.connack.SessionPresent {
+		t.Fatal("expected session to be present")
+	}
+	pub.publish(t, &paho.Publish{Topic: "queued", QoS: 1, Payload: []byte("three")})
+	if p := c2.expect(t); string(p.Payload) != "three" {
+		t.Fatalf("unexpected message %q", p.Payload)
+	}
+
+	// Clean start discards the session
+	cp.CleanStart = true
+	c3 := connect(t, b, cp, paho.ClientConfig{})
+	if c3.connack.SessionPresent {
+		t.Fatal("unexpected session present")
+	}
+	pub.publish(t, &paho.Publish{Topic: "queued", QoS: 1})
+	c3.expectNone(t)
+}
+
+func TestAssignedClientID(t *testing.T) {
+	b := newBroker(t, Config{})
+	c := connect(t, b, &paho.Connect{CleanStart: true}, paho.ClientConfig{})
+	if c.connack.Properties == nil || c.connack.Properties.AssignedClientID == "" {
+		t.Fatal("expected client identifier to be assigned")
+	}
+	if !b.Connected(c.connack.Properties.AssignedClientID) {
+		t.Fatal("assigned client identifier not connected")
+	}
+}
+
+func TestTopicAlias(t *testing.T) {
+	b := newBroker(t, Config{TopicAliasMaximum: 5})
+	sub := connect(t, b, &paho.Connect{ClientID: "sub", CleanStart: true}, paho.ClientConfig{})
+	sub.subscribe(t, paho.SubscribeOptions{Topic: "alias/topic", QoS: 1})
+
+	pub := connect(t, b, &paho.Connect{ClientID: "pub", CleanStart: true}, paho.ClientConfig{})
+	pub.publish(t, &paho.Publish{Topic: "alias/topic", QoS: 1, Properties: &paho.PublishProperties{TopicAlias: paho.Uint16(1)}})
+	// The client library requires a topic when publishing, so an aliased publish is sent directly
+	if _, err := (&packets.Publish{QoS: 0, Payload: []byte("aliased"), Properties: &packets.Properties{TopicAlias: paho.Uint16(1)}}).WriteTo(pub.Conn); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"", "aliased"} {
+		if p := sub.expect(t); p.Topic != "alias/topic" || string(p.Payload) != want {
+			t.Fatalf("unexpected message %+v", p)
+		}
+	}
+}
+
+func TestMaximumQoS(t *testing.T) {
+	b := newBroker(t, Config{MaximumQoS: paho.Byte(1)})
+	c := connect(t, b, &paho.Connect{ClientID: "c", CleanStart: true}, paho.ClientConfig{})
+	if sa := c.subscribe(t, paho.SubscribeOptions{Topic: "t", QoS: 2}); sa.Reasons[0] != 1 {
+		t.Fatalf("expected QoS 1 to be granted, got %v", sa.Reasons)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := c.Publish(ctx, &paho.Publish{Topic: "t", QoS: 2}); err == nil {
+		t.Fatal("expected QoS 2 publish to be refused")
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	b := newBroker(t, Config{
+		Authenticate: func(clientID, username string, password []byte) bool {
+			return username == "user" && string(password) == "pass"
+		},
+	})
+	cp := &paho.Connect{ClientID: "c", CleanStart: true, UsernameFlag: true, Username: "user", PasswordFlag: true, Password: []byte("pass")}
+	connect(t, b, cp, paho.ClientConfig{})
+
+	cp.Password = []byte("wrong")
+	tc, err := tryConnect(b, cp, paho.ClientConfig{})
+	if err == nil {
+		tc.Disconnect(&paho.Disconnect{})
+		t.Fatal("expected connection to be refused")
+	}
+}
+
+// challengeAuther responds to the broker's challenge with the expected response
+type challengeAuther struct {
+	response      string
+	authenticated chan struct{}
+}
+
+func (a *challengeAuther) Authenticate(in *paho.Auth) *paho.Auth {
+	return &paho.Auth{
+		ReasonCode: packets.AuthContinueAuthentication,
+		Properties: &paho.AuthProperties{AuthMethod: "challenge", AuthData: []byte(a.response)},
+	}
+}
+
+func (a *challengeAuther) Authenticated() {
+	a.authenticated <- struct{}{}
+}
+
+func TestEnhancedAuth(t *testing.T) {
+	b := newBroker(t, Config{
+		EnhancedAuth: func(clientID, method string, data []byte) (byte, []byte) {
+			switch string(data) {
+			case "hello":
+				return packets.AuthContinueAuthentication, []byte("challenge")
+			case "response":
+				return packets.AuthSuccess, []byte("welcome")
+			}
+			return packets.ConnackNotAuthorized, nil
+		},
+	})
+	a := &challengeAuther{response: "response", authenticated: make(chan struct{}, 2)}
+	cp := &paho.Connect{
+		ClientID:   "c",
+		CleanStart: true,
+		Properties: &paho.ConnectProperties{AuthMethod: "challenge", AuthData: []byte("hello")},
+	}
+	c := connect(t, b, cp, paho.ClientConfig{AuthHandler: a})
+	if string(c.connack.Properties.AuthData) != "welcome" {
+		t.Fatalf("unexpected CONNACK auth data %q", c.connack.Properties.AuthData)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	ar, err := c.Authenticate(ctx, &paho.Auth{
+		ReasonCode: packets.AuthReauthenticate,
+		Properties: &paho.AuthProperties{AuthMethod: "challenge", AuthData: []byte("hello")},
+	})
+	if err != nil || !ar.Success {
+		t.Fatalf("re-authentication failed: %v %+v", err, ar)
+	}
+
+	a.response = "wrong"
+	if _, err := tryConnect(b, cp, paho.ClientConfig{AuthHandler: a}); err == nil {
+		t.Fatal("expected connection to be refused")
+	}
+	cp.Properties.AuthMethod = "unknown"
+	if _, err := tryConnect(newBroker(t, Config{}), cp, paho.ClientConfig{AuthHandler: a}); err == nil {
+		t.Fatal("expected unsupported authentication method to be refused")
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	b := newBroker(t, Config{})
+	conn := b.Pipe()
+	defer conn.Close()
+	if _, err := (&packets.Connect{ProtocolName: "MQTT", ProtocolVersion: 5, ClientID: "idle", KeepAlive: 1, CleanStart: true, Properties: &packets.Properties{}}).WriteTo(conn); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(testTimeout))
+	if p, err := packets.ReadPacket(conn); err != nil || p.Type != packets.CONNACK {
+		t.Fatalf("expected CONNACK, got %v %v", p, err)
+	}
+	p, err := packets.ReadPacket(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := p.Content.(*packets.Disconnect); !ok || d.ReasonCode != packets.DisconnectKeepAliveTimeout {
+		t.Fatalf("expected keep alive DISCONNECT, got %v", p)
+	}
+}
+
+func TestAutopaho(t *testing.T) {
+	b := newBroker(t, Config{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve(l)
+	u, _ := url.Parse("tcp://" + l.Addr().String())
+
+	msgs := make(chan *paho.Publish, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	cm, err := autopaho.NewConnection(ctx, autopaho.ClientConfig{
+		BrokerUrls: []*url.URL{u},
+		KeepAlive:  30,
+		ClientConfig: paho.ClientConfig{
+			ClientID: "autopaho",
+			Router:   paho.NewSingleHandlerRouter(func(p *paho.Publish) { msgs <- p }),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.AwaitConnection(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.Subscribe(ctx, &paho.Subscribe{Subscriptions: []paho.SubscribeOptions{{Topic: "auto", QoS: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.Publish(ctx, &paho.Publish{Topic: "auto", QoS: 1, Payload: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-msgs:
+		if string(p.Payload) != "hello" {
+			t.Fatalf("unexpected payload %q", p.Payload)
+		}
+	case <-ctx.Done():
+		t.Fatal("message not received")
+	}
+	_ = cm.Disconnect(ctx)
+}
+
+func TestInvalidPacket(t *testing.T) {
+	b := newBroker(t, Config{})
+	for _, tt := range []struct {
+		name string
+		data []byte
+		rc   byte
+	}{
+		{"qos 3", []byte{0x36, 0x06, 0x00, 0x01, 'a', 0x00, 0x01, 0x00}, packets.DisconnectMalformedPacket},
+		{"wildcard topic", []byte{0x30, 0x04, 0x00, 0x01, '#', 0x00}, packets.DisconnectProtocolError},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := b.Pipe()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(testTimeout))
+			cp := packets.NewControlPacket(packets.CONNECT)
+			cp.Content.(*packets.Connect).ClientID = "invalid"
+			if _, err := cp.WriteTo(conn); err != nil {
+				t.Fatal(err)
+			}
+			if recv, err := packets.ReadPacket(conn); err != nil || recv.Type != packets.CONNACK {
+				t.Fatalf("expected CONNACK, got %v, %v", recv, err)
+			}
+			if _, err := conn.Write(tt.data); err != nil {
+				t.Fatal(err)
+			}
+			recv, err := packets.ReadPacket(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d, ok := recv.Content.(*packets.Disconnect); !ok || d.ReasonCode != tt.rc {
+				t.Fatalf("expected DISCONNECT with reason code %X, got %v", tt.rc, recv.Content)
+			}
+		})
+	}
+}
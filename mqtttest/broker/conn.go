@@ -0,0 +1,472 @@
+package broker
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	// connectTimeout is the time allowed for the client to send CONNECT (and each AUTH during authentication)
+	connectTimeout = 10 * time.Second
+	// closeTimeout is the time allowed for outstanding packets to be written when a connection is closing
+	closeTimeout = time.Second
+)
+
+// conn handles a single network connection
+type conn struct {
+	b          *Broker
+	nc         net.Conn
+	sess       *session // the session (nil until CONNECT is accepted, or after it is taken over); protected by b.mu
+	clientID   string
+	keepAlive  time.Duration
+	authMethod string            // the enhanced authentication method in use (if any)
+	aliases    map[uint16]string // topic aliases set by the client
+
+	// Packets are queued to be written by the writer goroutine so that the broker never blocks sending to a client
+	outMu      sync.Mutex
+	out        []packets.Packet // a nil entry causes the connection to be closed
+	closing    bool             // nil has been queued or the connection has failed
+	signal     chan struct{}
+	writerDone chan struct{}
+}
+
+func newConn(b *Broker, nc net.Conn) *conn {
+	return &conn{
+		b:          b,
+		nc:         nc,
+		aliases:    make(map[uint16]string),
+		signal:     make(chan struct{}, 1),
+		writerDone: make(chan struct{}),
+	}
+}
+
+// send queues p to be written to the client; if p is nil the connection will be closed once the previously queued
+// packets have been written
+func (c *conn) send(p packets.Packet) {
+	c.outMu.Lock()
+	if !c.closing {
+		c.out = append(c.out, p)
+		c.closing = p == nil
+	}
+	c.outMu.Unlock()
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+// disconnect sends a DISCONNECT with the reason code rc and then closes the connection
+func (c *conn) disconnect(rc byte) {
+	c.send(&packets.Disconnect{ReasonCode: rc, Properties: &packets.Properties{}})
+	c.send(nil)
+}
+
+// writer writes queued packets to the network connection
+func (c *conn) writer() {
+	defer close(c.writerDone)
+	for range c.signal {
+		c.outMu.Lock()
+		out := c.out
+		c.out = nil
+		c.outMu.Unlock()
+		for _, p := range out {
+			if p == nil {
+				c.nc.Close()
+				return
+			}
+			if _, err := p.WriteTo(c.nc); err != nil {
+				c.nc.Close()
+				c.outMu.Lock()
+				c.closing = true
+				c.out = nil
+				c.outMu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// serve processes packets from the client until the connection is closed
+func (c *conn) serve() {
+	go c.writer()
+	publishWill := c.run()
+
+	c.b.mu.Lock()
+	c.b.connectionLost(c, publishWill)
+	c.b.mu.Unlock()
+
+	_ = c.nc.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.send(nil)
+	<-c.writerDone
+}
+
+// read reads a packet from the client, failing if none is received within timeout (0 means no timeout)
+func (c *conn) read(timeout time.Duration) (*packets.ControlPacket, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := c.nc.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	return packets.ReadPacketStrict(c.nc)
+}
+
+// run reads and handles packets until the connection fails or is closed, returning whether the will should be
+// published (i.e. the client did not disconnect cleanly)
+func (c *conn) run() bool {
+	recv, err := c.read(connectTimeout)
+	if err != nil {
+		return true
+	}
+	cp, ok := recv.Content.(*packets.Connect)
+	if !ok {
+		c.b.log.Warn("first packet was not CONNECT", paho.PacketTypeField(recv.Type))
+		return true
+	}
+	if !c.connect(cp) {
+		return true
+	}
+
+	for {
+		recv, err := c.read(c.keepAlive * 3 / 2)
+		if err != nil {
+			var ve *packets.ValidationError
+			if errors.As(err, &ve) {
+				c.b.log.Warn("invalid packet", paho.ClientIDField(c.clientID), paho.ErrorField(err))
+				c.disconnect(ve.ReasonCode)
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				c.b.log.Warn("keep alive timeout", paho.ClientIDField(c.clientID))
+				c.disconnect(packets.DisconnectKeepAliveTimeout)
+			}
+			return true
+		}
+		if !c.handle(recv) {
+			return true
+		}
+		if d, ok := recv.Content.(*packets.Disconnect); ok {
+			return d.ReasonCode == packets.DisconnectDisconnectWithWillMessage
+		}
+	}
+}
+
+// handle processes a packet received after CONNECT, returning false if the connection should be closed
+func (c *conn) handle(recv *packets.ControlPacket) bool {
+	if p, ok := recv.Content.(*packets.Publish); ok {
+		return c.publish(p)
+	}
+	if p, ok := recv.Content.(*packets.Auth); ok {
+		return c.auth(p)
+	}
+
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	s := c.sess
+	if s == nil {
+		return false // session taken over
+	}
+	switch p := recv.Content.(type) {
+	case *packets.Puback:
+		s.puback(p)
+	case *packets.Pubrec:
+		s.pubrec(p)
+	case *packets.Pubcomp:
+		s.pubcomp(p)
+	case *packets.Pubrel:
+		rc := byte(packets.PubcompSuccess)
+		if _, ok := s.received[p.PacketID]; !ok {
+			rc = packets.PubcompPacketIdentifierNotFound
+		}
+		delete(s.received, p.PacketID)
+		c.send(&packets.Pubcomp{PacketID: p.PacketID, ReasonCode: rc})
+	case *packets.Subscribe:
+		return c.subscribe(s, p)
+	case *packets.Unsubscribe:
+		reasons := make([]byte, len(p.Topics))
+		for i, t := range p.Topics {
+			if _, ok := s.subs[t]; !ok {
+				reasons[i] = packets.UnsubackNoSubscriptionFound
+			}
+			delete(s.subs, t)
+		}
+		c.send(&packets.Unsuback{PacketID: p.PacketID, Reasons: reasons, Properties: &packets.Properties{}})
+	case *packets.Pingreq:
+		c.send(&packets.Pingresp{})
+	case *packets.Disconnect:
+		if p.Properties.SessionExpiryInterval != nil {
+			if s.expiry == 0 && *p.Properties.SessionExpiryInterval != 0 {
+				c.disconnect(packets.DisconnectProtocolError)
+				return false
+			}
+			s.expiry = *p.Properties.SessionExpiryInterval
+		}
+		c.b.log.Debug("client sent DISCONNECT", paho.ClientIDField(c.clientID), paho.ReasonCodeField(p.ReasonCode))
+	default:
+		c.b.log.Warn("unexpected packet", paho.ClientIDField(c.clientID), paho.PacketTypeField(recv.Type))
+		c.disconnect(packets.DisconnectProtocolError)
+		return false
+	}
+	return true
+}
+
+// connect processes the CONNECT packet, returning true if the connection was accepted
+func (c *conn) connect(cp *packets.Connect) bool {
+	b := c.b
+	if cp.ProtocolName != "MQTT" || cp.ProtocolVersion != 5 {
+		c.send(&packets.Connack{ReasonCode: packets.ConnackUnsupportedProtocolVersion})
+		return false
+	}
+	if b.cfg.Authenticate != nil && !b.cfg.Authenticate(cp.ClientID, cp.Username, cp.Password) {
+		c.send(&packets.Connack{ReasonCode: packets.ConnackBadUsernameOrPassword})
+		return false
+	}
+	if cp.WillFlag {
+		if !validTopicName(cp.WillTopic) {
+			c.send(&packets.Connack{ReasonCode: packets.ConnackTopicNameInvalid})
+			return false
+		}
+		if cp.WillQOS > b.maximumQoS {
+			c.send(&packets.Connack{ReasonCode: packets.ConnackQoSNotSupported})
+			return false
+		}
+	}
+
+	receiveMax, aliasMax := b.cfg.ReceiveMaximum, b.cfg.TopicAliasMaximum
+	props := &packets.Properties{ReceiveMaximum: &receiveMax}
+	if aliasMax > 0 {
+		props.TopicAliasMaximum = &aliasMax
+	}
+	if b.maximumQoS < 2 {
+		maxQoS := b.maximumQoS
+		props.MaximumQOS = &maxQoS
+	}
+	if cp.Properties.AuthMethod != "" {
+		data, ok := c.enhancedAuth(cp.ClientID, cp.Properties.AuthMethod, cp.Properties.AuthData)
+		if !ok {
+			return false
+		}
+		props.AuthMethod, props.AuthData = c.authMethod, data
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	clientID := cp.ClientID
+	if clientID == "" {
+		clientID = b.assignClientID()
+		props.AssignedClientID = clientID
+	}
+	c.clientID = clientID
+
+	s, present := b.sessions[clientID]
+	if present && s.conn != nil {
+		b.log.Info("session taken over", paho.ClientIDField(clientID))
+		old := s.conn
+		old.disconnect(packets.DisconnectSessionTakenOver)
+		b.connectionLost(old, true)
+		s, present = b.sessions[clientID] // the session may have ended
+	}
+	if present && cp.CleanStart {
+		b.endSession(s)
+		present = false
+	}
+	if !present {
+		s = newSession(b, clientID)
+		b.sessions[clientID] = s
+	}
+
+	// Reconnecting cancels the will delay (any previous will is replaced) and session expiry
+	s.stopTimers()
+	s.will = nil
+	if cp.WillFlag {
+		s.will = newMessage(&packets.Publish{
+			Topic:      cp.WillTopic,
+			Payload:    cp.WillMessage,
+			QoS:        cp.WillQOS,
+			Retain:     cp.WillRetain,
+			Properties: cp.WillProperties,
+		}, cp.WillTopic, s)
+		s.willDelay = 0
+		if cp.WillProperties.WillDelayInterval != nil {
+			s.willDelay = *cp.WillProperties.WillDelayInterval
+		}
+	}
+	s.expiry = 0
+	if cp.Properties.SessionExpiryInterval != nil {
+		s.expiry = *cp.Properties.SessionExpiryInterval
+	}
+	s.receiveMax = 65535
+	if cp.Properties.ReceiveMaximum != nil && *cp.Properties.ReceiveMaximum > 0 {
+		s.receiveMax = int(*cp.Properties.ReceiveMaximum)
+	}
+	s.conn = c
+	c.sess = s
+	c.keepAlive = time.Duration(cp.KeepAlive) * time.Second
+
+	b.log.Debug("client connected", paho.ClientIDField(clientID), paho.Field{Key: "session_present", Value: present})
+	c.send(&packets.Connack{ReasonCode: packets.ConnackSuccess, SessionPresent: present, Properties: props})
+	s.resend()
+	return true
+}
+
+// enhancedAuth carries out the enhanced authentication exchange during connection, returning the data to be
+// included in the CONNACK and true if authentication succeeded
+func (c *conn) enhancedAuth(clientID, method string, data []byte) ([]byte, bool) {
+	if c.b.cfg.EnhancedAuth == nil {
+		c.send(&packets.Connack{ReasonCode: packets.ConnackBadAuthenticationMethod})
+		return nil, false
+	}
+	for {
+		rc, resp := c.b.cfg.EnhancedAuth(clientID, method, data)
+		switch rc {
+		case packets.AuthSuccess:
+			c.authMethod = method
+			return resp, true
+		case packets.AuthContinueAuthentication:
+			c.send(&packets.Auth{
+				ReasonCode: packets.AuthContinueAuthentication,
+				Properties: &packets.Properties{AuthMethod: method, AuthData: resp},
+			})
+		default:
+			c.send(&packets.Connack{ReasonCode: rc})
+			return nil, false
+		}
+
+		recv, err := c.read(connectTimeout)
+		if err != nil {
+			return nil, false
+		}
+		a, ok := recv.Content.(*packets.Auth)
+		if !ok || a.ReasonCode != packets.AuthContinueAuthentication || a.Properties.AuthMethod != method {
+			c.send(&packets.Connack{ReasonCode: packets.DisconnectProtocolError})
+			return nil, false
+		}
+		data = a.Properties.AuthData
+	}
+}
+
+// auth handles an AUTH packet received after the connection is established (re-authentication)
+func (c *conn) auth(a *packets.Auth) bool {
+	if c.authMethod == "" || a.Properties.AuthMethod != c.authMethod ||
+		(a.ReasonCode != packets.AuthReauthenticate && a.ReasonCode != packets.AuthContinueAuthentication) {
+		c.disconnect(packets.DisconnectProtocolError)
+		return false
+	}
+	rc, resp := c.b.cfg.EnhancedAuth(c.clientID, c.authMethod, a.Properties.AuthData)
+	if rc != packets.AuthSuccess && rc != packets.AuthContinueAuthentication {
+		c.disconnect(rc)
+		return false
+	}
+	c.send(&packets.Auth{
+		ReasonCode: rc,
+		Properties: &packets.Properties{AuthMethod: c.authMethod, AuthData: resp},
+	})
+	return true
+}
+
+// publish handles a PUBLISH from the client
+func (c *conn) publish(p *packets.Publish) bool {
+	b := c.b
+	if p.QoS > b.maximumQoS {
+		c.disconnect(packets.DisconnectQoSNotSupported)
+		return false
+	}
+	topic := p.Topic
+	if p.Properties.TopicAlias != nil {
+		alias := *p.Properties.TopicAlias
+		if alias == 0 || alias > b.cfg.TopicAliasMaximum {
+			c.disconnect(packets.DisconnectTopicAliasInvalid)
+			return false
+		}
+		if topic != "" {
+			c.aliases[alias] = topic
+		} else if t, ok := c.aliases[alias]; ok {
+			topic = t
+		} else {
+			c.disconnect(packets.DisconnectProtocolError)
+			return false
+		}
+	}
+	if !validTopicName(topic) {
+		c.disconnect(packets.DisconnectTopicNameInvalid)
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := c.sess
+	if s == nil {
+		return false // session taken over
+	}
+	switch p.QoS {
+	case 0:
+		b.publish(newMessage(p, topic, s))
+	case 1:
+		rc := byte(packets.PubackSuccess)
+		if !b.publish(newMessage(p, topic, s)) {
+			rc = packets.PubackNoMatchingSubscribers
+		}
+		c.send(&packets.Puback{PacketID: p.PacketID, ReasonCode: rc, Properties: &packets.Properties{}})
+	case 2:
+		rc := byte(packets.PubrecSuccess)
+		if _, dup := s.received[p.PacketID]; !dup {
+			if len(s.received) >= int(b.cfg.ReceiveMaximum) {
+				c.disconnect(packets.DisconnectReceiveMaximumExceeded)
+				return false
+			}
+			s.received[p.PacketID] = struct{}{}
+			if !b.publish(newMessage(p, topic, s)) {
+				rc = packets.PubrecNoMatchingSubscribers
+			}
+		}
+		c.send(&packets.Pubrec{PacketID: p.PacketID, ReasonCode: rc, Properties: &packets.Properties{}})
+	}
+	return true
+}
+
+// subscribe handles a SUBSCRIBE from the client (b.mu must be held)
+func (c *conn) subscribe(s *session, p *packets.Subscribe) bool {
+	var subID int
+	if p.Properties.SubscriptionIdentifier != nil {
+		subID = *p.Properties.SubscriptionIdentifier
+	}
+	reasons := make([]byte, len(p.Subscriptions))
+	var retained []*subscription
+	for i, o := range p.Subscriptions {
+		group, filter, ok := parseFilter(o.Topic)
+		if !ok {
+			reasons[i] = packets.SubackTopicFilterinvalid
+			continue
+		}
+		if group != "" && o.NoLocal {
+			c.disconnect(packets.DisconnectProtocolError)
+			return false
+		}
+		_, existed := s.subs[o.Topic]
+		sub := &subscription{SubOptions: o, group: group, id: subID}
+		sub.Topic = filter
+		if sub.QoS > c.b.maximumQoS {
+			sub.QoS = c.b.maximumQoS
+		}
+		s.subs[o.Topic] = sub
+		reasons[i] = sub.QoS
+		c.b.log.Debug("subscribed", paho.ClientIDField(c.clientID), paho.TopicField(o.Topic))
+
+		// RetainHandling holds the option bits unshifted (0x00 send, 0x10 send if new, 0x20 do not send)
+		if group == "" && (o.RetainHandling == 0 || (o.RetainHandling == 0x10 && !existed)) {
+			retained = append(retained, sub)
+		}
+	}
+	c.send(&packets.Suback{PacketID: p.PacketID, Reasons: reasons, Properties: &packets.Properties{}})
+	for _, sub := range retained {
+		c.b.sendRetained(s, sub)
+	}
+	return true
+}
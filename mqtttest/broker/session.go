@@ -0,0 +1,237 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// sessionNeverExpires is the Session Expiry Interval indicating that a session does not expire
+const sessionNeverExpires = 0xFFFFFFFF
+
+// session holds the state of a client session; all fields are protected by the broker mutex
+type session struct {
+	b        *Broker
+	clientID string
+	subs     map[string]*subscription // keyed by the filter as subscribed (including any $share prefix)
+	conn     *conn                    // the current connection (nil if the client is not connected)
+	expiry   uint32                   // Session Expiry Interval (seconds)
+
+	// Outbound QoS 1 and 2 messages
+	inflight   map[uint16]*delivery // sent but not yet fully acknowledged
+	order      []uint16             // packet identifiers of inflight messages in the order they were sent
+	queue      []*delivery          // waiting for the client to connect or for a free slot (see receiveMax)
+	receiveMax int                  // the client's Receive Maximum
+	lastID     uint16
+
+	// Inbound QoS 2 messages for which a PUBREL has not been received
+	received map[uint16]struct{}
+
+	will        *message
+	willDelay   uint32 // Will Delay Interval (seconds)
+	willTimer   *time.Timer
+	expiryTimer *time.Timer
+}
+
+// delivery is a message being delivered to a session at QoS 1 or 2
+type delivery struct {
+	m        *message
+	qos      byte
+	retain   bool
+	subID    int
+	pub      *packets.Publish // set when the message is sent
+	released bool             // PUBREC received (and PUBREL sent)
+}
+
+func newSession(b *Broker, clientID string) *session {
+	return &session{
+		b:          b,
+		clientID:   clientID,
+		subs:       make(map[string]*subscription),
+		inflight:   make(map[uint16]*delivery),
+		received:   make(map[uint16]struct{}),
+		receiveMax: 65535,
+	}
+}
+
+// enqueue sends m to the client at the lower of its QoS and the subscription QoS; QoS 0 messages are discarded if
+// the client is not connected
+func (s *session) enqueue(m *message, subQoS byte, retain bool, subID int) {
+	if m.expired(time.Now()) {
+		return
+	}
+	qos := m.qos
+	if subQoS < qos {
+		qos = subQoS
+	}
+	if qos == 0 {
+		if s.conn != nil {
+			s.conn.send(m.packet(0, retain, subID))
+		}
+		return
+	}
+	s.queue = append(s.queue, &delivery{m: m, qos: qos, retain: retain, subID: subID})
+	s.flush()
+}
+
+// flush sends queued messages while the client is connected and its Receive Maximum allows
+func (s *session) flush() {
+	now := time.Now()
+	for s.conn != nil && len(s.inflight) < s.receiveMax && len(s.queue) > 0 {
+		d := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		if d.m.expired(now) {
+			continue
+		}
+		d.pub = d.m.packet(d.qos, d.retain, d.subID)
+		d.pub.PacketID = s.packetID()
+		s.inflight[d.pub.PacketID] = d
+		s.order = append(s.order, d.pub.PacketID)
+		s.conn.send(d.pub)
+	}
+}
+
+// packetID returns an unused packet identifier
+func (s *session) packetID() uint16 {
+	for {
+		s.lastID++
+		if _, ok := s.inflight[s.lastID]; s.lastID != 0 && !ok {
+			return s.lastID
+		}
+	}
+}
+
+// resend retransmits unacknowledged messages (following a reconnection) and sends any queued messages
+func (s *session) resend() {
+	for _, id := range s.order {
+		d := s.inflight[id]
+		if d.released {
+			s.conn.send(&packets.Pubrel{PacketID: id})
+			continue
+		}
+		pub := *d.pub
+		pub.Duplicate = true
+		s.conn.send(&pub)
+	}
+	s.flush()
+}
+
+// complete removes the inflight message with the provided packet identifier
+func (s *session) complete(id uint16) {
+	delete(s.inflight, id)
+	for i, v := range s.order {
+		if v == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.flush()
+}
+
+// puback handles a PUBACK from the client
+func (s *session) puback(p *packets.Puback) {
+	if d, ok := s.inflight[p.PacketID]; ok && d.qos == 1 {
+		s.complete(p.PacketID)
+	}
+}
+
+// pubrec handles a PUBREC from the client
+func (s *session) pubrec(p *packets.Pubrec) {
+	d, ok := s.inflight[p.PacketID]
+	if !ok || d.qos != 2 {
+		s.conn.send(&packets.Pubrel{PacketID: p.PacketID, ReasonCode: packets.PubcompPacketIdentifierNotFound})
+		return
+	}
+	if p.ReasonCode >= 0x80 {
+		s.complete(p.PacketID)
+		return
+	}
+	d.released = true
+	s.conn.send(&packets.Pubrel{PacketID: p.PacketID})
+}
+
+// pubcomp handles a PUBCOMP from the client
+func (s *session) pubcomp(p *packets.Pubcomp) {
+	if d, ok := s.inflight[p.PacketID]; ok && d.released {
+		s.complete(p.PacketID)
+	}
+}
+
+// stopTimers stops the will delay and session expiry timers
+func (s *session) stopTimers() {
+	if s.willTimer != nil {
+		s.willTimer.Stop()
+		s.willTimer = nil
+	}
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+		s.expiryTimer = nil
+	}
+}
+
+// connectionLost handles the closure of connection c, publishing the will (if publishWill is set) and starting the
+// session expiry timer (b.mu must be held)
+func (b *Broker) connectionLost(c *conn, publishWill bool) {
+	s := c.sess
+	c.sess = nil
+	if s == nil || s.conn != c {
+		return
+	}
+	s.conn = nil
+	b.log.Debug("client disconnected", paho.ClientIDField(s.clientID))
+	if !publishWill {
+		s.will = nil
+	}
+	if s.expiry == 0 {
+		b.endSession(s)
+		return
+	}
+	if w := s.will; w != nil {
+		if s.willDelay == 0 {
+			b.publishWill(s)
+		} else {
+			delay := s.willDelay
+			if s.expiry < delay {
+				delay = s.expiry
+			}
+			s.willTimer = time.AfterFunc(time.Duration(delay)*time.Second, func() {
+				b.mu.Lock()
+				defer b.mu.Unlock()
+				if !b.closed && s.will == w && s.conn == nil {
+					b.publishWill(s)
+				}
+			})
+		}
+	}
+	if s.expiry != sessionNeverExpires {
+		s.expiryTimer = time.AfterFunc(time.Duration(s.expiry)*time.Second, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if !b.closed && b.sessions[s.clientID] == s && s.conn == nil {
+				b.endSession(s)
+			}
+		})
+	}
+}
+
+// endSession discards the session, publishing any outstanding will (b.mu must be held)
+func (b *Broker) endSession(s *session) {
+	b.log.Debug("session ended", paho.ClientIDField(s.clientID))
+	s.stopTimers()
+	if b.sessions[s.clientID] == s {
+		delete(b.sessions, s.clientID)
+	}
+	if s.will != nil {
+		b.publishWill(s)
+	}
+}
+
+// publishWill publishes, and clears, the session's will (b.mu must be held)
+func (b *Broker) publishWill(s *session) {
+	w := s.will
+	s.will = nil
+	b.log.Debug("publishing will", paho.ClientIDField(s.clientID), paho.TopicField(w.topic))
+	b.publish(w)
+}
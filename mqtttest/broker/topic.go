@@ -0,0 +1,65 @@
+package broker
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// sharePrefix is the prefix used in shared subscription topic filters
+const sharePrefix = "$share/"
+
+// validTopicName reports whether t is a valid topic name (as used in a PUBLISH)
+func validTopicName(t string) bool {
+	return t != "" && utf8.ValidString(t) && !strings.ContainsAny(t, "+#\x00")
+}
+
+// validTopicFilter reports whether f is a valid topic filter (as used in a SUBSCRIBE)
+func validTopicFilter(f string) bool {
+	if f == "" || !utf8.ValidString(f) || strings.ContainsRune(f, 0) {
+		return false
+	}
+	levels := strings.Split(f, "/")
+	for i, l := range levels {
+		if strings.ContainsAny(l, "+#") && len(l) > 1 {
+			return false // wildcards must occupy an entire level
+		}
+		if l == "#" && i != len(levels)-1 {
+			return false // multi-level wildcard must be the last level
+		}
+	}
+	return true
+}
+
+// parseFilter splits a subscription topic filter into the share name (empty if it is not a shared subscription)
+// and the topic filter, reporting whether both are valid
+func parseFilter(f string) (group, filter string, ok bool) {
+	if !strings.HasPrefix(f, sharePrefix) {
+		return "", f, validTopicFilter(f)
+	}
+	rest := f[len(sharePrefix):]
+	i := strings.IndexByte(rest, '/')
+	if i < 1 {
+		return "", "", false
+	}
+	group, filter = rest[:i], rest[i+1:]
+	return group, filter, !strings.ContainsAny(group, "+#") && validTopicFilter(filter)
+}
+
+// matchTopic reports whether the topic name matches the topic filter. Topics beginning with '$' are not matched by
+// filters beginning with a wildcard.
+func matchTopic(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) || (f != "+" && f != tl[i]) {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
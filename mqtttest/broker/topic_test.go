@@ -0,0 +1,50 @@
+package broker
+
+import "testing"
+
+func TestMatchTopic(t *testing.T) {
+	for _, tt := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"+/+", "/b", true},
+		{"+", "a/b", false},
+		{"#", "$SYS/x", false},
+		{"+/x", "$SYS/x", false},
+		{"$SYS/#", "$SYS/x", true},
+	} {
+		if got := matchTopic(tt.filter, tt.topic); got != tt.match {
+			t.Errorf("matchTopic(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.match)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	for _, tt := range []struct {
+		in            string
+		group, filter string
+		ok            bool
+	}{
+		{"a/b", "", "a/b", true},
+		{"a/#", "", "a/#", true},
+		{"a/#/b", "", "", false},
+		{"a/b+", "", "", false},
+		{"", "", "", false},
+		{"$share/g/a/+", "g", "a/+", true},
+		{"$share//a", "", "", false},
+		{"$share/g", "", "", false},
+		{"$share/g+/a", "", "", false},
+	} {
+		group, filter, ok := parseFilter(tt.in)
+		if ok != tt.ok || (ok && (group != tt.group || filter != tt.filter)) {
+			t.Errorf("parseFilter(%q) = %q, %q, %v", tt.in, group, filter, ok)
+		}
+	}
+}
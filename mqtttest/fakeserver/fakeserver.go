@@ -0,0 +1,445 @@
+// Package fakeserver provides a scriptable fake MQTT server for deterministic unit testing of code using paho.
+//
+// A Server runs a script, a sequence of steps built with Expect, Send, SendRaw, Sleep, Disconnect and Close, against
+// a single connection. Send can be used for any server initiated packet (e.g. PUBLISH or AUTH) and SendRaw for
+// malformed data. Each Expect step waits for a packet of the given type (optionally checking it with Match) and
+// replies with scripted responses (optionally after a delay). Packets that do not match the current step are passed
+// to any handler registered with Handle (e.g. to answer PINGREQ whenever it arrives), otherwise they are recorded as
+// errors. Wait returns once the script is complete, reporting the first error, and Received/PacketIDs allow the
+// packets sent by the client (e.g. the order of acknowledgements) to be checked.
+//
+//	s := fakeserver.New()
+//	s.Handle(packets.PINGREQ, fakeserver.Reply(&packets.Pingresp{}))
+//	s.Expect(packets.CONNECT).Respond(&packets.Connack{ReasonCode: packets.ConnackSuccess})
+//	s.Expect(packets.SUBSCRIBE).Respond(&packets.Suback{Reasons: []byte{1}})
+//	s.Send(&packets.Publish{Topic: "a", QoS: 1, PacketID: 1})
+//	s.Expect(packets.PUBACK)
+//	c := paho.NewClient(paho.ClientConfig{Conn: s.ClientConn()})
+//	...
+//	if err := s.Wait(ctx); err != nil { ... }
+package fakeserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+// DefaultTimeout is the default time an Expect step waits for a packet
+const DefaultTimeout = 5 * time.Second
+
+// Responder returns the packets to be sent in response to a received packet
+type Responder func(*packets.ControlPacket) []packets.Packet
+
+// Reply returns a Responder that always responds with ps (packet identifiers are set as described in
+// Expectation.Respond)
+func Reply(ps ...packets.Packet) Responder {
+	return func(recv *packets.ControlPacket) []packets.Packet {
+		out := make([]packets.Packet, len(ps))
+		for i, p := range ps {
+			out[i] = withPacketID(p, PacketID(recv))
+		}
+		return out
+	}
+}
+
+// step is a single step in the script
+type step struct {
+	name string
+	run  func(s *Server) error
+}
+
+// Server is a scriptable fake MQTT server; the script must be built before the connection is served
+type Server struct {
+	// Timeout is the time an Expect step waits for a packet (DefaultTimeout if 0)
+	Timeout time.Duration
+
+	steps    []step
+	handlers map[byte]Responder
+
+	conn    net.Conn
+	writeMu sync.Mutex
+	packets chan *packets.ControlPacket // packets read from the connection
+	readErr chan error                  // receives the error that ended reading
+
+	mu       sync.Mutex
+	received []*packets.ControlPacket
+	errs     []error
+	done     chan struct{} // closed when the script is complete
+}
+
+// New returns a Server with an empty script
+func New() *Server {
+	return &Server{
+		handlers: make(map[byte]Responder),
+		packets:  make(chan *packets.ControlPacket, 1000),
+		readErr:  make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// Handle registers a Responder for packets of type pt that are received when not expected by the script (both
+// while the script runs and after it completes)
+func (s *Server) Handle(pt byte, r Responder) *Server {
+	s.handlers[pt] = r
+	return s
+}
+
+// Expectation is a script step that waits for a packet from the client
+type Expectation struct {
+	pt      byte
+	match   func(*packets.ControlPacket) error
+	respond Responder
+	delay   time.Duration
+}
+
+// Expect adds a step that waits for a packet of type pt (e.g. packets.CONNECT)
+func (s *Server) Expect(pt byte) *Expectation {
+	e := &Expectation{pt: pt}
+	s.steps = append(s.steps, step{
+		name: "expect " + packetTypeName(pt),
+		run:  e.run,
+	})
+	return e
+}
+
+// Match sets a function that checks the received packet; a non-nil error fails the script
+func (e *Expectation) Match(fn func(*packets.ControlPacket) error) *Expectation {
+	e.match = fn
+	return e
+}
+
+// Respond sets the packets sent in response to the expected packet. Where a response is a PUBACK, PUBREC, PUBREL,
+// PUBCOMP, SUBACK or UNSUBACK with a packet identifier of 0, the identifier of the received packet is used.
+func (e *Expectation) Respond(ps ...packets.Packet) *Expectation {
+	e.respond = Reply(ps...)
+	return e
+}
+
+// RespondWith sets a function that returns the packets to send in response to the expected packet
+func (e *Expectation) RespondWith(r Responder) *Expectation {
+	e.respond = r
+	return e
+}
+
+// After delays the response by d
+func (e *Expectation) After(d time.Duration) *Expectation {
+	e.delay = d
+	return e
+}
+
+// run waits for the expected packet and sends the response
+func (e *Expectation) run(s *Server) error {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		var recv *packets.ControlPacket
+		select {
+		case recv = <-s.packets:
+		case err := <-s.readErr:
+			s.readErr <- err // leave the error for subsequent steps
+			return fmt.Errorf("connection lost waiting for %s: %w", packetTypeName(e.pt), err)
+		case <-timer.C:
+			return fmt.Errorf("timed out waiting for %s", packetTypeName(e.pt))
+		}
+		if recv.Type != e.pt {
+			if err := s.handleUnexpected(recv); err != nil {
+				return err
+			}
+			continue
+		}
+		if e.match != nil {
+			if err := e.match(recv); err != nil {
+				return fmt.Errorf("%s did not match: %w", packetTypeName(e.pt), err)
+			}
+		}
+		if e.respond == nil {
+			return nil
+		}
+		if e.delay > 0 {
+			time.Sleep(e.delay)
+		}
+		return s.write(e.respond(recv)...)
+	}
+}
+
+// Send adds a step that sends ps to the client
+func (s *Server) Send(ps ...packets.Packet) *Server {
+	s.steps = append(s.steps, step{
+		name: "send",
+		run:  func(s *Server) error { return s.write(ps...) },
+	})
+	return s
+}
+
+// SendRaw adds a step that writes b to the connection (e.g. to send a malformed packet)
+func (s *Server) SendRaw(b []byte) *Server {
+	s.steps = append(s.steps, step{
+		name: "send raw",
+		run: func(s *Server) error {
+			s.writeMu.Lock()
+			defer s.writeMu.Unlock()
+			_, err := s.conn.Write(b)
+			return err
+		},
+	})
+	return s
+}
+
+// Sleep adds a step that pauses the script for d (packets received meanwhile are handled by the next Expect step)
+func (s *Server) Sleep(d time.Duration) *Server {
+	s.steps = append(s.steps, step{
+		name: "sleep",
+		run: func(s *Server) error {
+			time.Sleep(d)
+			return nil
+		},
+	})
+	return s
+}
+
+// Disconnect adds a step that sends a DISCONNECT with reason code rc and closes the connection
+func (s *Server) Disconnect(rc byte) *Server {
+	s.Send(&packets.Disconnect{ReasonCode: rc, Properties: &packets.Properties{}})
+	return s.Close()
+}
+
+// Close adds a step that closes the connection (without sending DISCONNECT)
+func (s *Server) Close() *Server {
+	s.steps = append(s.steps, step{
+		name: "close",
+		run:  func(s *Server) error { return s.conn.Close() },
+	})
+	return s
+}
+
+// Do adds a step that calls fn (e.g. to synchronise with the test)
+func (s *Server) Do(fn func() error) *Server {
+	s.steps = append(s.steps, step{name: "do", run: func(*Server) error { return fn() }})
+	return s
+}
+
+// ClientConn returns the client end of an in-memory connection (net.Pipe) and serves the other end
+func (s *Server) ClientConn() net.Conn {
+	client, server := net.Pipe()
+	go s.Serve(server)
+	return client
+}
+
+// Serve runs the script against conn, returning when the script is complete (or fails). Packets received after the
+// script completes continue to be passed to the handlers until the connection is closed.
+func (s *Server) Serve(conn net.Conn) {
+	s.conn = conn
+	go s.read()
+	defer close(s.done)
+	for i, st := range s.steps {
+		if err := st.run(s); err != nil {
+			s.fail(fmt.Errorf("step %d (%s): %w", i+1, st.name, err))
+			conn.Close()
+			return
+		}
+	}
+	go s.drain()
+}
+
+// read reads packets from the connection until it fails
+func (s *Server) read() {
+	for {
+		recv, err := packets.ReadPacket(s.conn)
+		if err != nil {
+			s.readErr <- err
+			return
+		}
+		s.mu.Lock()
+		s.received = append(s.received, recv)
+		s.mu.Unlock()
+		s.packets <- recv
+	}
+}
+
+// drain handles packets received once the script is complete
+func (s *Server) drain() {
+	for {
+		select {
+		case recv := <-s.packets:
+			if err := s.handleUnexpected(recv); err != nil {
+				s.fail(err)
+			}
+		case err := <-s.readErr:
+			s.readErr <- err
+			return
+		}
+	}
+}
+
+// handleUnexpected passes a packet not expected by the script to its handler (returning an error if there is none)
+func (s *Server) handleUnexpected(recv *packets.ControlPacket) error {
+	h, ok := s.handlers[recv.Type]
+	if !ok {
+		return fmt.Errorf("unexpected %s", packetTypeName(recv.Type))
+	}
+	return s.write(h(recv)...)
+}
+
+// write sends ps to the client
+func (s *Server) write(ps ...packets.Packet) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	for _, p := range ps {
+		if _, err := p.WriteTo(s.conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fail records an error
+func (s *Server) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+// Wait waits for the script to complete, returning the first error encountered (or ctx.Err())
+func (s *Server) Wait(ctx context.Context) error {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.Err()
+}
+
+// Err returns the first error encountered (nil if there has been none)
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errs) == 0 {
+		return nil
+	}
+	return s.errs[0]
+}
+
+// Received returns the packets received from the client, in order
+func (s *Server) Received() []*packets.ControlPacket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*packets.ControlPacket(nil), s.received...)
+}
+
+// PacketIDs returns the packet identifiers of the packets of type pt received from the client, in the order
+// received (e.g. PacketIDs(packets.PUBACK) to check the order of acknowledgements)
+func (s *Server) PacketIDs(pt byte) []uint16 {
+	var ids []uint16
+	for _, recv := range s.Received() {
+		if recv.Type == pt {
+			ids = append(ids, PacketID(recv))
+		}
+	}
+	return ids
+}
+
+// ErrNoPacketID is returned by CheckOrder if a packet type without an identifier is specified
+var ErrNoPacketID = errors.New("packet type has no packet identifier")
+
+// CheckOrder returns an error unless the packets of type pt received from the client had the packet identifiers ids
+// (in that order)
+func (s *Server) CheckOrder(pt byte, ids ...uint16) error {
+	switch pt {
+	case packets.PUBLISH, packets.PUBACK, packets.PUBREC, packets.PUBREL, packets.PUBCOMP, packets.SUBSCRIBE, packets.UNSUBSCRIBE:
+	default:
+		return ErrNoPacketID
+	}
+	got := s.PacketIDs(pt)
+	if len(got) != len(ids) {
+		return fmt.Errorf("expected %s packet identifiers %v, received %v", packetTypeName(pt), ids, got)
+	}
+	for i := range ids {
+		if got[i] != ids[i] {
+			return fmt.Errorf("expected %s packet identifiers %v, received %v", packetTypeName(pt), ids, got)
+		}
+	}
+	return nil
+}
+
+// PacketID returns the packet identifier of cp (0 if it does not have one)
+func PacketID(cp *packets.ControlPacket) uint16 {
+	switch p := cp.Content.(type) {
+	case *packets.Publish:
+		return p.PacketID
+	case *packets.Puback:
+		return p.PacketID
+	case *packets.Pubrec:
+		return p.PacketID
+	case *packets.Pubrel:
+		return p.PacketID
+	case *packets.Pubcomp:
+		return p.PacketID
+	case *packets.Subscribe:
+		return p.PacketID
+	case *packets.Unsubscribe:
+		return p.PacketID
+	}
+	return 0
+}
+
+// withPacketID returns p, or a copy of p with the packet identifier set to id if p is an acknowledgement with a
+// packet identifier of 0
+func withPacketID(p packets.Packet, id uint16) packets.Packet {
+	switch v := p.(type) {
+	case *packets.Puback:
+		if v.PacketID == 0 {
+			c := *v
+			c.PacketID = id
+			return &c
+		}
+	case *packets.Pubrec:
+		if v.PacketID == 0 {
+			c := *v
+			c.PacketID = id
+			return &c
+		}
+	case *packets.Pubrel:
+		if v.PacketID == 0 {
+			c := *v
+			c.PacketID = id
+			return &c
+		}
+	case *packets.Pubcomp:
+		if v.PacketID == 0 {
+			c := *v
+			c.PacketID = id
+			return &c
+		}
+	case *packets.Suback:
+		if v.PacketID == 0 {
+			c := *v
+			c.PacketID = id
+			return &c
+		}
+	case *packets.Unsuback:
+		if v.PacketID == 0 {
+			c := *v
+			c.PacketID = id
+			return &c
+		}
+	}
+	return p
+}
+
+// packetTypeName returns the name of the packet type pt (e.g. CONNECT)
+func packetTypeName(pt byte) string {
+	if pt == 0 || pt > packets.AUTH {
+		return fmt.Sprintf("packet type %d", pt)
+	}
+	return (&packets.ControlPacket{FixedHeader: packets.FixedHeader{Type: pt}}).PacketType()
+}
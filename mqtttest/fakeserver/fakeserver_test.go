@@ -0,0 +1,208 @@
+package fakeserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func connack() *packets.Connack {
+	return &packets.Connack{ReasonCode: packets.ConnackSuccess, Properties: &packets.Properties{}}
+}
+
+func connect(t *testing.T, s *Server, cfg paho.ClientConfig) (*paho.Client, error) {
+	t.Helper()
+	cfg.Conn = s.ClientConn()
+	c := paho.NewClient(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := c.Connect(ctx, &paho.Connect{ClientID: "test", KeepAlive: 30, CleanStart: true})
+	return c, err
+}
+
+func wait(t *testing.T, s *Server) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.Wait(ctx)
+}
+
+func TestScript(t *testing.T) {
+	s := New()
+	s.Expect(packets.CONNECT).Match(func(cp *packets.ControlPacket) error {
+		if cp.Content.(*packets.Connect).ClientID != "test" {
+			return errors.New("unexpected client identifier")
+		}
+		return nil
+	}).Respond(connack())
+	s.Expect(packets.SUBSCRIBE).Respond(&packets.Suback{Reasons: []byte{1}, Properties: &packets.Properties{}})
+	for i := uint16(1); i <= 3; i++ {
+		s.Send(&packets.Publish{Topic: "test", QoS: 1, PacketID: i, Properties: &packets.Properties{}})
+	}
+	for i := 0; i < 3; i++ {
+		s.Expect(packets.PUBACK)
+	}
+
+	received := make(chan *paho.Publish, 3)
+	c, err := connect(t, s, paho.ClientConfig{
+		Router: paho.NewSingleHandlerRouter(func(p *paho.Publish) { received <- p }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(&paho.Disconnect{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sa, err := c.Subscribe(ctx, &paho.Subscribe{Subscriptions: []paho.SubscribeOptions{{Topic: "test", QoS: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa.Reasons[0] != 1 {
+		t.Fatalf("unexpected SUBACK reasons %v", sa.Reasons)
+	}
+
+	if err := wait(t, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckOrder(packets.PUBACK, 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(received))
+	}
+}
+
+func TestUnexpectedPacket(t *testing.T) {
+	s := New()
+	s.Expect(packets.CONNECT).Respond(connack())
+	s.Expect(packets.SUBSCRIBE)
+
+	c, err := connect(t, s, paho.ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(&paho.Disconnect{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = c.Publish(ctx, &paho.Publish{Topic: "test", QoS: 0})
+
+	if err := wait(t, s); err == nil || !strings.Contains(err.Error(), "unexpected PUBLISH") {
+		t.Fatalf("expected unexpected PUBLISH error, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := New()
+	s.Handle(packets.PUBLISH, Reply(&packets.Puback{Properties: &packets.Properties{}}))
+	s.Expect(packets.CONNECT).Respond(connack())
+
+	c, err := connect(t, s, paho.ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(&paho.Disconnect{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := c.Publish(ctx, &paho.Publish{Topic: "test", QoS: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wait(t, s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := s.PacketIDs(packets.PUBLISH); len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("unexpected PUBLISH packet identifiers %v", ids)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	s := New()
+	s.Expect(packets.CONNECT).Respond(connack()).After(200 * time.Millisecond)
+
+	start := time.Now()
+	c, err := connect(t, s, paho.ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(&paho.Disconnect{})
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("CONNACK not delayed (%s)", d)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := New()
+	s.Timeout = 50 * time.Millisecond
+	s.Expect(packets.CONNECT)
+
+	conn := s.ClientConn()
+	defer conn.Close()
+	if err := wait(t, s); err == nil || !strings.Contains(err.Error(), "timed out waiting for CONNECT") {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	s := New()
+	s.Expect(packets.CONNECT).Respond(connack())
+	s.SendRaw([]byte{0x00, 0x00}) // reserved packet type
+
+	errs := make(chan error, 1)
+	c, err := connect(t, s, paho.ClientConfig{OnClientError: func(err error) { errs <- err }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(&paho.Disconnect{})
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected client error")
+	}
+}
+
+func TestServerDisconnect(t *testing.T) {
+	s := New()
+	s.Expect(packets.CONNECT).Respond(connack())
+	s.Disconnect(packets.DisconnectServerShuttingDown)
+
+	disconnected := make(chan *paho.Disconnect, 1)
+	c, err := connect(t, s, paho.ClientConfig{OnServerDisconnect: func(d *paho.Disconnect) { disconnected <- d }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(&paho.Disconnect{})
+	select {
+	case d := <-disconnected:
+		if d.ReasonCode != packets.DisconnectServerShuttingDown {
+			t.Fatalf("unexpected reason code %d", d.ReasonCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("DISCONNECT not received")
+	}
+	if err := wait(t, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	s := New()
+	s.received = []*packets.ControlPacket{
+		{FixedHeader: packets.FixedHeader{Type: packets.PUBACK}, Content: &packets.Puback{PacketID: 2}},
+		{FixedHeader: packets.FixedHeader{Type: packets.PUBACK}, Content: &packets.Puback{PacketID: 1}},
+	}
+	if err := s.CheckOrder(packets.PUBACK, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckOrder(packets.PUBACK, 1, 2); err == nil {
+		t.Fatal("expected order mismatch")
+	}
+	if err := s.CheckOrder(packets.CONNECT); !errors.Is(err, ErrNoPacketID) {
+		t.Fatalf("expected ErrNoPacketID, got %v", err)
+	}
+}
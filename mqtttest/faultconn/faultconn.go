@@ -0,0 +1,333 @@
+// Package faultconn provides a net.Conn wrapper that injects network faults (latency, bandwidth limits, fragmented
+// writes, read stalls, half-open connections, disconnections and byte corruption) so that the resilience of code
+// using paho and autopaho can be tested.
+//
+// Faults are either applied continuously (as configured in Config), triggered on a timed schedule (Config.Schedule)
+// or injected directly with Conn.Inject. The wrapper is normally applied to the client side of a connection, e.g.
+//
+//	conn := faultconn.New(b.Pipe(), faultconn.Config{
+//		Latency:  10 * time.Millisecond,
+//		Schedule: []faultconn.Event{{After: time.Second, Fault: faultconn.Disconnect()}},
+//	})
+package faultconn
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDisconnected is returned by Read and Write once the connection has been dropped by an injected fault
+	ErrDisconnected = errors.New("faultconn: connection dropped")
+	// ErrClosed is returned by a Read, blocked by a stall or half-open connection, when the connection is closed
+	ErrClosed = errors.New("faultconn: connection closed")
+)
+
+// Config holds the faults applied to a Conn; the zero value passes data through unaltered
+type Config struct {
+	// Latency is added to every Read and Write
+	Latency time.Duration
+	// Bandwidth limits the throughput, in bytes per second, in each direction (0 means unlimited)
+	Bandwidth int
+	// WriteChunk, if non-zero, is the maximum number of bytes passed to the underlying connection in a single Write;
+	// larger writes are fragmented (so MQTT packets may arrive in pieces)
+	WriteChunk int
+	// CorruptRate is the probability that each byte read or written is corrupted
+	CorruptRate float64
+	// DisconnectRate is the probability that each Write drops the connection part way through the data
+	DisconnectRate float64
+	// Seed seeds the random number generator used by CorruptRate and DisconnectRate (so runs are repeatable)
+	Seed int64
+	// Schedule lists faults to be injected at specific times
+	Schedule []Event
+}
+
+// Event is a Fault to be injected once After has elapsed (measured from the creation of the Conn)
+type Event struct {
+	After time.Duration
+	Fault Fault
+}
+
+// Fault is a fault that can be injected into a Conn
+type Fault func(*Conn)
+
+// Conn is a net.Conn that injects faults into the data passing through the wrapped connection
+type Conn struct {
+	net.Conn
+	cfg Config
+
+	mu           sync.Mutex
+	rnd          *rand.Rand
+	stallUntil   time.Time     // reads block until this time
+	stallChange  chan struct{} // closed when stallUntil, or halfOpen, changes
+	halfOpen     bool          // writes are discarded and reads block
+	corruptRead  int           // number of bytes still to be corrupted when read
+	corruptWrite int           // number of bytes still to be corrupted when written
+	dropAfter    int           // connection is dropped after this many more bytes are written (-1 if not scheduled)
+	dropped      bool
+	timers       []*time.Timer
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+// New wraps conn, injecting the faults specified in cfg
+func New(conn net.Conn, cfg Config) *Conn {
+	c := &Conn{
+		Conn:        conn,
+		cfg:         cfg,
+		rnd:         rand.New(rand.NewSource(cfg.Seed)),
+		stallChange: make(chan struct{}),
+		dropAfter:   -1,
+		closed:      make(chan struct{}),
+	}
+	c.mu.Lock()
+	for _, e := range cfg.Schedule {
+		f := e.Fault
+		c.timers = append(c.timers, time.AfterFunc(e.After, func() { c.Inject(f) }))
+	}
+	c.mu.Unlock()
+	return c
+}
+
+// Inject applies the fault f immediately
+func (c *Conn) Inject(f Fault) {
+	f(c)
+}
+
+// Stall blocks reads for d (data sent by the peer is delayed, not lost)
+func Stall(d time.Duration) Fault {
+	return func(c *Conn) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.stallUntil = time.Now().Add(d)
+		c.notify()
+	}
+}
+
+// HalfOpen simulates a connection that has silently failed; writes succeed but the data is discarded and reads block
+// until the connection is closed
+func HalfOpen() Fault {
+	return func(c *Conn) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.halfOpen = true
+		c.notify()
+	}
+}
+
+// Disconnect drops the connection (closing the underlying connection)
+func Disconnect() Fault {
+	return func(c *Conn) {
+		c.drop()
+	}
+}
+
+// DropAfter drops the connection once a further n bytes have been written; this allows the connection to be lost
+// part way through a packet
+func DropAfter(n int) Fault {
+	return func(c *Conn) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.dropAfter = n
+	}
+}
+
+// CorruptRead corrupts the next n bytes read
+func CorruptRead(n int) Fault {
+	return func(c *Conn) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.corruptRead += n
+	}
+}
+
+// CorruptWrite corrupts the next n bytes written
+func CorruptWrite(n int) Fault {
+	return func(c *Conn) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.corruptWrite += n
+	}
+}
+
+// Read reads data from the underlying connection, applying any faults
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.waitReadable(); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		halfOpen := c.halfOpen
+		c.mu.Unlock()
+		if halfOpen { // data arriving after the connection became half-open is lost
+			if err := c.waitReadable(); err != nil {
+				return 0, err
+			}
+		}
+		c.corrupt(b[:n], &c.corruptRead)
+		c.delay(n)
+	}
+	if err != nil && c.isDropped() {
+		err = ErrDisconnected
+	}
+	return n, err
+}
+
+// Write writes data to the underlying connection, applying any faults
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.isDropped() {
+		return 0, ErrDisconnected
+	}
+	c.mu.Lock()
+	if c.halfOpen {
+		c.mu.Unlock()
+		return len(b), nil
+	}
+	limit := len(b)
+	if c.dropAfter >= 0 && c.dropAfter < limit {
+		limit = c.dropAfter
+	}
+	if c.cfg.DisconnectRate > 0 && c.rnd.Float64() < c.cfg.DisconnectRate {
+		limit = c.rnd.Intn(len(b) + 1)
+	}
+	if c.dropAfter >= 0 {
+		c.dropAfter -= limit
+	}
+	c.mu.Unlock()
+
+	data := make([]byte, limit)
+	copy(data, b)
+	c.corrupt(data, &c.corruptWrite)
+	if c.cfg.Latency > 0 {
+		time.Sleep(c.cfg.Latency)
+	}
+
+	var written int
+	for written < len(data) {
+		chunk := data[written:]
+		if c.cfg.WriteChunk > 0 && len(chunk) > c.cfg.WriteChunk {
+			chunk = chunk[:c.cfg.WriteChunk]
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			if c.isDropped() {
+				err = ErrDisconnected
+			}
+			return written, err
+		}
+		c.throttle(n)
+	}
+	if limit < len(b) {
+		c.drop()
+		return written, ErrDisconnected
+	}
+	return written, nil
+}
+
+// Close stops any scheduled faults and closes the underlying connection
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		for _, t := range c.timers {
+			t.Stop()
+		}
+		c.mu.Unlock()
+		close(c.closed)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// drop closes the connection as the result of a fault
+func (c *Conn) drop() {
+	c.mu.Lock()
+	c.dropped = true
+	c.mu.Unlock()
+	c.Close()
+}
+
+// isDropped reports whether the connection has been dropped by a fault
+func (c *Conn) isDropped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// notify wakes any blocked readers so they reassess the stall/half-open state (c.mu must be held)
+func (c *Conn) notify() {
+	close(c.stallChange)
+	c.stallChange = make(chan struct{})
+}
+
+// waitReadable blocks whilst reads are stalled, or the connection is half-open, returning an error if the connection
+// is closed in the meantime
+func (c *Conn) waitReadable() error {
+	for {
+		c.mu.Lock()
+		halfOpen, until, change := c.halfOpen, c.stallUntil, c.stallChange
+		c.mu.Unlock()
+
+		var t *time.Timer
+		var wait <-chan time.Time
+		if !halfOpen {
+			d := time.Until(until)
+			if d <= 0 {
+				return nil
+			}
+			t = time.NewTimer(d)
+			wait = t.C
+		}
+		select {
+		case <-c.closed:
+			if t != nil {
+				t.Stop()
+			}
+			if c.isDropped() {
+				return ErrDisconnected
+			}
+			return ErrClosed
+		case <-change:
+		case <-wait:
+		}
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
+
+// corrupt flips bits in b; the first *pending bytes are always corrupted, others with probability CorruptRate
+func (c *Conn) corrupt(b []byte, pending *int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range b {
+		if *pending > 0 {
+			*pending--
+			b[i] ^= 0xFF
+			continue
+		}
+		if c.cfg.CorruptRate > 0 && c.rnd.Float64() < c.cfg.CorruptRate {
+			b[i] ^= byte(1 << uint(c.rnd.Intn(8)))
+		}
+	}
+}
+
+// delay applies the latency and bandwidth limit to n bytes that have been read
+func (c *Conn) delay(n int) {
+	if c.cfg.Latency > 0 {
+		time.Sleep(c.cfg.Latency)
+	}
+	c.throttle(n)
+}
+
+// throttle sleeps for the time that transferring n bytes takes at the configured bandwidth
+func (c *Conn) throttle(n int) {
+	if c.cfg.Bandwidth > 0 {
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(c.cfg.Bandwidth))
+	}
+}
@@ -0,0 +1,216 @@
+package faultconn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/mqtttest/broker"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// pipe returns a Conn wrapping one end of a net.Pipe along with the other end
+func pipe(cfg Config) (*Conn, net.Conn) {
+	client, server := net.Pipe()
+	return New(client, cfg), server
+}
+
+// readAll reads from conn until it is closed, returning the data along with the size of each Read
+func readAll(conn net.Conn) ([]byte, []int) {
+	var data []byte
+	var sizes []int
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			data = append(data, buf[:n]...)
+			sizes = append(sizes, n)
+		}
+		if err != nil {
+			return data, sizes
+		}
+	}
+}
+
+func TestWriteChunkAndLatency(t *testing.T) {
+	c, server := pipe(Config{WriteChunk: 3, Latency: 50 * time.Millisecond})
+	type result struct {
+		data  []byte
+		sizes []int
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, sizes := readAll(server)
+		done <- result{data, sizes}
+	}()
+
+	start := time.Now()
+	if n, err := c.Write([]byte("abcdefgh")); n != 8 || err != nil {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("write not delayed (%s)", d)
+	}
+	c.Close()
+	r := <-done
+	if string(r.data) != "abcdefgh" {
+		t.Fatalf("unexpected data %q", r.data)
+	}
+	if len(r.sizes) != 3 {
+		t.Fatalf("expected 3 fragments, got %v", r.sizes)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	c, server := pipe(Config{Bandwidth: 1000})
+	go readAll(server)
+	defer c.Close()
+
+	start := time.Now()
+	if _, err := c.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("write not throttled (%s)", d)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	c, server := pipe(Config{})
+	defer c.Close()
+
+	c.Inject(CorruptWrite(2))
+	go c.Write([]byte{0x01, 0x02, 0x03})
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte{0xFE, 0xFD, 0x03}) {
+		t.Fatalf("unexpected data written %v", buf)
+	}
+
+	c.Inject(CorruptRead(1))
+	go server.Write([]byte{0x01, 0x02})
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:2], []byte{0xFE, 0x02}) {
+		t.Fatalf("unexpected data read %v", buf[:2])
+	}
+}
+
+func TestDropAfter(t *testing.T) {
+	c, server := pipe(Config{})
+	done := make(chan []byte, 1)
+	go func() {
+		data, _ := readAll(server)
+		done <- data
+	}()
+
+	c.Inject(DropAfter(3))
+	if n, err := c.Write([]byte("abcde")); n != 3 || !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	if data := <-done; string(data) != "abc" {
+		t.Fatalf("unexpected data %q", data)
+	}
+	if _, err := c.Write([]byte("f")); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("expected ErrDisconnected, got %v", err)
+	}
+}
+
+func TestDisconnectRate(t *testing.T) {
+	c, server := pipe(Config{DisconnectRate: 1})
+	go readAll(server)
+	if _, err := c.Write([]byte("abcde")); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("expected ErrDisconnected, got %v", err)
+	}
+}
+
+func TestStall(t *testing.T) {
+	c, server := pipe(Config{})
+	defer c.Close()
+
+	c.Inject(Stall(100 * time.Millisecond))
+	go server.Write([]byte("a"))
+	start := time.Now()
+	buf := make([]byte, 1)
+	if _, err := c.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("read not stalled (%s)", d)
+	}
+}
+
+func TestHalfOpen(t *testing.T) {
+	c, server := pipe(Config{})
+
+	c.Inject(HalfOpen())
+	if n, err := c.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := server.Read(make([]byte, 3)); err == nil {
+		t.Fatal("data written to half-open connection was delivered")
+	}
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		read <- err
+	}()
+	go server.Write([]byte("a"))
+	select {
+	case err := <-read:
+		t.Fatalf("read from half-open connection returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Close()
+	if err := <-read; !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	c, _ := pipe(Config{Schedule: []Event{{After: 50 * time.Millisecond, Fault: Disconnect()}}})
+	start := time.Now()
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("expected ErrDisconnected, got %v", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("disconnected early (%s)", d)
+	}
+}
+
+// TestPingTimeout checks that the paho pinger detects a half-open connection
+func TestPingTimeout(t *testing.T) {
+	b := broker.New(broker.Config{})
+	defer b.Close()
+	conn := New(b.Pipe(), Config{})
+	defer conn.Close()
+
+	pingFailed := make(chan error, 1)
+	c := paho.NewClient(paho.ClientConfig{
+		Conn: conn,
+		PingHandler: paho.DefaultPingerWithCustomFailHandler(func(err error) {
+			pingFailed <- err
+		}),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Connect(ctx, &paho.Connect{ClientID: "pinger", KeepAlive: 1, CleanStart: true}); err != nil {
+		t.Fatal(err)
+	}
+	conn.Inject(HalfOpen())
+
+	select {
+	case <-pingFailed:
+	case <-ctx.Done():
+		t.Fatal("ping timeout not detected")
+	}
+}
@@ -2,10 +2,8 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
-	"strings"
 )
 
 // Auth is the Variable Header definition for a Auth control packet
@@ -22,16 +20,7 @@ const (
 )
 
 func (a *Auth) String() string {
-	var b strings.Builder
-
-	fmt.Fprintf(&b, "AUTH: ReasonCode:%X", a.ReasonCode)
-	if a.Properties != nil {
-		fmt.Fprintf(&b, " Properties:\n%s", a.Properties)
-	} else {
-		fmt.Fprint(&b, "\n")
-	}
-
-	return b.String()
+	return newText(AUTH).reason("ReasonCode", a.ReasonCode).properties("Properties", a.Properties).String()
 }
 
 // Unpack is the implementation of the interface required function for a packet
@@ -75,3 +64,13 @@ func (a *Auth) WriteTo(w io.Writer) (int64, error) {
 
 	return cp.WriteTo(w)
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (a *Auth) Validate() error {
+	switch a.ReasonCode {
+	case AuthSuccess, AuthContinueAuthentication, AuthReauthenticate:
+	default:
+		return protocolError(AUTH, "invalid reason code %X", a.ReasonCode)
+	}
+	return a.Properties.Validate(AUTH)
+}
@@ -0,0 +1,426 @@
+package packets
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	// defaultBufferSize is the size of the buffer used by NewReader and
+	// NewWriter
+	defaultBufferSize = 4096
+	// maxRetainedBuffer is the largest content buffer that is kept for reuse;
+	// a larger buffer (following an unusually large packet) is released
+	maxRetainedBuffer = 1024 * 1024
+)
+
+// decoder holds the buffers used when reading a packet; these are reused
+// for subsequent packets so must not be referenced by a decoded packet
+// (unless reusePayload is set, in which case the payload of a PUBLISH refers
+// to the content buffer)
+type decoder struct {
+	content      bytes.Buffer
+	limited      io.LimitedReader
+	b            [1]byte
+	reusePayload bool
+}
+
+// decoderPool holds decoders for use by ReadPacket and ReadPacketStrict
+var decoderPool = sync.Pool{New: func() interface{} { return &decoder{} }}
+
+// readByte reads a single byte from r
+func (d *decoder) readByte(r io.Reader) (byte, error) {
+	if br, ok := r.(io.ByteReader); ok {
+		return br.ReadByte()
+	}
+	if _, err := io.ReadFull(r, d.b[:]); err != nil {
+		return 0, err
+	}
+	return d.b[0], nil
+}
+
+// readVBI reads a variable byte integer, of at most four bytes, from r
+func (d *decoder) readVBI(r io.Reader) (int, error) {
+	var vbi uint32
+	for i := uint(0); i < maxVBILength; i++ {
+		digit, err := d.readByte(r)
+		if err != nil {
+			return 0, err
+		}
+		vbi |= uint32(digit&127) << (7 * i)
+		if digit&128 == 0 {
+			return int(vbi), nil
+		}
+	}
+	return 0, ErrInvalidVBI
+}
+
+// readContent reads the n byte content of a packet from r into the
+// decoder's content buffer
+func (d *decoder) readContent(r io.Reader, n int) (*bytes.Buffer, error) {
+	d.content.Reset()
+	// The remaining length has not been verified, so only a limited amount of
+	// memory is allocated before the content is read. ReadFrom requires
+	// bytes.MinRead bytes of free space before each read, so that is added
+	// to avoid growing the buffer again.
+	if n <= maxPreallocate {
+		d.content.Grow(n + bytes.MinRead)
+	} else {
+		d.content.Grow(maxPreallocate + bytes.MinRead)
+	}
+
+	d.limited.R, d.limited.N = r, int64(n)
+	read, err := d.content.ReadFrom(&d.limited)
+	d.limited.R = nil
+	if err != nil {
+		return nil, err
+	}
+	if read != int64(n) {
+		return nil, io.EOF // as returned by io.CopyN
+	}
+	return &d.content, nil
+}
+
+// trim releases the content buffer if it is too large to be retained
+func (d *decoder) trim() {
+	if d.content.Cap() > maxRetainedBuffer {
+		d.content = bytes.Buffer{}
+	}
+}
+
+// Reader reads control packets from an io.Reader. Reads from the
+// underlying reader are buffered, so a packet rarely requires a read (system
+// call) of its own, and the memory used to hold the content of each packet
+// is reused. By default the returned packet does not reference the Reader's
+// buffers (the payload of a PUBLISH is copied); see ReusePayloads.
+// A Reader is not safe for concurrent use.
+type Reader struct {
+	r *bufio.Reader
+	d decoder
+}
+
+// NewReader returns a Reader, with a buffer of the default size, that
+// reads from r
+func NewReader(r io.Reader) *Reader {
+	return NewReaderSize(r, defaultBufferSize)
+}
+
+// NewReaderSize returns a Reader, with a buffer of at least size bytes,
+// that reads from r
+func NewReaderSize(r io.Reader, size int) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, size)}
+}
+
+// Reset discards any buffered data and switches the Reader to read from r
+func (r *Reader) Reset(rd io.Reader) {
+	r.r.Reset(rd)
+}
+
+// ReusePayloads sets whether the Payload of a PUBLISH packet returned by
+// the Reader refers to the Reader's buffer rather than to a copy. This
+// avoids allocating, and copying, every payload but the payload is only
+// valid until the next packet is read; the caller must copy it if it is
+// retained (or passed to another goroutine). The rest of the packet (topic,
+// properties etc) is never affected.
+func (r *Reader) ReusePayloads(reuse bool) {
+	r.d.reusePayload = reuse
+}
+
+// ReadPacket reads the next control packet, see ReadPacket
+func (r *Reader) ReadPacket() (*ControlPacket, error) {
+	return r.d.read(r.r, false)
+}
+
+// ReadPacketStrict reads the next control packet and checks that it
+// conforms to the specification, see ReadPacketStrict
+func (r *Reader) ReadPacketStrict() (*ControlPacket, error) {
+	return r.d.read(r.r, true)
+}
+
+// Writer writes control packets to an io.Writer. Packets are encoded into a
+// buffer, which is reused, and written to the underlying writer when Flush
+// is called (or the buffer is full); PUBLISH and acknowledgement packets
+// are encoded directly from their fields. A packet that does not fit in the
+// buffer is written in full before WritePacket returns, so packets are never
+// interleaved with other writes.
+// A Writer is safe for concurrent use and, if the underlying writer is a
+// sync.Locker (see NewThreadSafeConn), it is locked during each call to
+// WritePacket and Flush.
+type Writer struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	out     *sink
+	locker  sync.Locker
+	err     error
+	scratch [1 + maxVBILength]byte
+}
+
+// sink records whether the buffer has been written to the underlying writer
+type sink struct {
+	w     io.Writer
+	wrote bool
+}
+
+func (s *sink) Write(b []byte) (int, error) {
+	s.wrote = true
+	return s.w.Write(b)
+}
+
+// NewWriter returns a Writer, with a buffer of the default size, that
+// writes to w
+func NewWriter(w io.Writer) *Writer {
+	return NewWriterSize(w, defaultBufferSize)
+}
+
+// NewWriterSize returns a Writer, with a buffer of at least size bytes,
+// that writes to w
+func NewWriterSize(w io.Writer, size int) *Writer {
+	out := &sink{w: w}
+	l, _ := w.(sync.Locker)
+	return &Writer{w: bufio.NewWriterSize(out, size), out: out, locker: l}
+}
+
+// Reset discards any buffered packets, clears any error and switches the
+// Writer to write to w
+func (w *Writer) Reset(wr io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out.w = wr
+	w.locker, _ = wr.(sync.Locker)
+	w.w.Reset(w.out)
+	w.err = nil
+}
+
+// Buffered returns the number of bytes that have been written into the
+// buffer but not yet to the underlying writer
+func (w *Writer) Buffered() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Buffered()
+}
+
+// lock locks the Writer, and the underlying writer if it is a sync.Locker
+func (w *Writer) lock() {
+	w.mu.Lock()
+	if w.locker != nil {
+		w.locker.Lock()
+	}
+}
+
+func (w *Writer) unlock() {
+	if w.locker != nil {
+		w.locker.Unlock()
+	}
+	w.mu.Unlock()
+}
+
+// Flush writes any buffered packets to the underlying writer
+func (w *Writer) Flush() error {
+	w.lock()
+	defer w.unlock()
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+// WritePacket encodes p into the buffer, returning the size of the encoded
+// packet. The packet may not be written to the underlying writer until
+// Flush is called. Once an error has occurred all further writes will
+// fail (until Reset is called).
+func (w *Writer) WritePacket(p Packet) (int64, error) {
+	w.lock()
+	defer w.unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.out.wrote = false
+
+	var n int
+	switch p := p.(type) {
+	case *Publish:
+		n = w.writePublish(p)
+	case *Puback:
+		n = w.writeAck(PUBACK<<4, p.PacketID, p.ReasonCode, p.Properties.Pack(PUBACK), true)
+	case *Pubrec:
+		n = w.writeAck(PUBREC<<4, p.PacketID, p.ReasonCode, p.Properties.Pack(PUBREC), false)
+	case *Pubrel:
+		n = w.writeAck(PUBREL<<4|2, p.PacketID, p.ReasonCode, p.Properties.Pack(PUBREL), false)
+	case *Pubcomp:
+		n = w.writeAck(PUBCOMP<<4, p.PacketID, p.ReasonCode, p.Properties.Pack(PUBCOMP), false)
+	default:
+		h, ok := headerByte(p)
+		if !ok {
+			// Not a packet from this package so it must encode itself
+			var written int64
+			written, w.err = p.WriteTo(w.w)
+			n = int(written)
+			break
+		}
+		n = w.writeBuffers(h, p.Buffers())
+	}
+	if w.out.wrote && w.err == nil {
+		// Part of the packet has been written so the remainder must follow
+		w.err = w.w.Flush()
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return int64(n), nil
+}
+
+// headerByte returns the first byte of the fixed header (the packet type
+// and flags) for p
+func headerByte(p Packet) (byte, bool) {
+	switch p := p.(type) {
+	case *Connect:
+		return CONNECT << 4, true
+	case *Connack:
+		return CONNACK << 4, true
+	case *Publish:
+		return PUBLISH<<4 | p.flags(), true
+	case *Puback:
+		return PUBACK << 4, true
+	case *Pubrec:
+		return PUBREC << 4, true
+	case *Pubrel:
+		return PUBREL<<4 | 2, true
+	case *Pubcomp:
+		return PUBCOMP << 4, true
+	case *Subscribe:
+		return SUBSCRIBE<<4 | 2, true
+	case *Suback:
+		return SUBACK << 4, true
+	case *Unsubscribe:
+		return UNSUBSCRIBE<<4 | 2, true
+	case *Unsuback:
+		return UNSUBACK << 4, true
+	case *Pingreq:
+		return PINGREQ << 4, true
+	case *Pingresp:
+		return PINGRESP << 4, true
+	case *Disconnect:
+		return DISCONNECT << 4, true
+	case *Auth:
+		return AUTH << 4, true
+	}
+	return 0, false
+}
+
+// The following functions encode into the buffer, recording the first error
+// encountered in w.err, and return the number of bytes encoded
+
+func (w *Writer) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+func (w *Writer) writeString(s string) {
+	if w.err == nil {
+		_, w.err = w.w.WriteString(s)
+	}
+}
+
+func (w *Writer) writeUint16(u uint16) {
+	w.scratch[0], w.scratch[1] = byte(u>>8), byte(u)
+	w.write(w.scratch[:2])
+}
+
+func (w *Writer) writeVBI(v int) {
+	w.write(w.scratch[:putVBI(w.scratch[:], v)])
+}
+
+// writeHeader encodes the fixed header, returning the size of the packet
+func (w *Writer) writeHeader(h byte, remaining int) int {
+	w.scratch[0] = h
+	n := 1 + putVBI(w.scratch[1:], remaining)
+	w.write(w.scratch[:n])
+	return n + remaining
+}
+
+func (w *Writer) writeBuffers(h byte, buffers net.Buffers) int {
+	var remaining int
+	for _, b := range buffers {
+		remaining += len(b)
+	}
+	n := w.writeHeader(h, remaining)
+	for _, b := range buffers {
+		w.write(b)
+	}
+	return n
+}
+
+// writePublish encodes p as Publish.Buffers would
+func (w *Writer) writePublish(p *Publish) int {
+	props := p.Properties.Pack(PUBLISH)
+	remaining := 2 + len(p.Topic) + vbiLength(len(props)) + len(props) + len(p.Payload)
+	if p.QoS > 0 {
+		remaining += 2
+	}
+	n := w.writeHeader(PUBLISH<<4|p.flags(), remaining)
+	w.writeUint16(uint16(len(p.Topic)))
+	w.writeString(p.Topic)
+	if p.QoS > 0 {
+		w.writeUint16(p.PacketID)
+	}
+	w.writeVBI(len(props))
+	w.write(props)
+	w.write(p.Payload)
+	return n
+}
+
+// writeAck encodes a PUBACK, PUBREC, PUBREL or PUBCOMP as their Buffers
+// would; the property length is omitted when there are no properties
+// unless propLen is set
+func (w *Writer) writeAck(h byte, id uint16, reason byte, props []byte, propLen bool) int {
+	remaining := 3
+	propLen = propLen || len(props) > 0
+	if propLen {
+		remaining += vbiLength(len(props)) + len(props)
+	}
+	n := w.writeHeader(h, remaining)
+	w.writeUint16(id)
+	w.scratch[0] = reason
+	w.write(w.scratch[:1])
+	if propLen {
+		w.writeVBI(len(props))
+		w.write(props)
+	}
+	return n
+}
+
+// putVBI encodes v as a variable byte integer into b, which must have room
+// for at least four bytes, returning the number of bytes used
+func putVBI(b []byte, v int) int {
+	var x int
+	for {
+		digit := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			digit |= 0x80
+		}
+		b[x] = digit
+		x++
+		if v == 0 {
+			return x
+		}
+	}
+}
+
+// vbiLength returns the number of bytes needed to encode v as a variable
+// byte integer
+func vbiLength(v int) int {
+	switch {
+	case v < 128:
+		return 1
+	case v < 16384:
+		return 2
+	case v < 2097152:
+		return 3
+	}
+	return 4
+}
@@ -0,0 +1,388 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// allProperties returns Properties with every property set
+func allProperties() *Properties {
+	b := byte(1)
+	u16 := uint16(10)
+	u32 := uint32(100)
+	id := 5
+	return &Properties{
+		PayloadFormat:          &b,
+		MessageExpiry:          &u32,
+		ContentType:            "text/plain",
+		ResponseTopic:          "response",
+		CorrelationData:        []byte{1, 2, 3},
+		SubscriptionIdentifier: &id,
+		SessionExpiryInterval:  &u32,
+		AssignedClientID:       "assigned",
+		ServerKeepAlive:        &u16,
+		AuthMethod:             "method",
+		AuthData:               []byte{4, 5, 6},
+		RequestProblemInfo:     &b,
+		WillDelayInterval:      &u32,
+		RequestResponseInfo:    &b,
+		ResponseInfo:           "info",
+		ServerReference:        "server",
+		ReasonString:           "reason",
+		ReceiveMaximum:         &u16,
+		TopicAliasMaximum:      &u16,
+		TopicAlias:             &u16,
+		MaximumQOS:             &b,
+		RetainAvailable:        &b,
+		User:                   []User{{"k", "v"}},
+		MaximumPacketSize:      &u32,
+		WildcardSubAvailable:   &b,
+		SubIDAvailable:         &b,
+		SharedSubAvailable:     &b,
+	}
+}
+
+// testPackets returns examples of every packet type (with, and without, properties)
+func testPackets() []Packet {
+	var packets []Packet
+	for _, props := range []*Properties{{}, allProperties()} {
+		packets = append(packets,
+			&Connect{ProtocolName: "MQTT", ProtocolVersion: 5, ClientID: "client", KeepAlive: 30, CleanStart: true,
+				UsernameFlag: true, Username: "user", PasswordFlag: true, Password: []byte("pass"),
+				WillFlag: true, WillTopic: "will", WillMessage: []byte("gone"), WillQOS: 1, WillProperties: props,
+				Properties: props},
+			&Connack{SessionPresent: true, Properties: props},
+			&Publish{Topic: "a/b", QoS: 0, Payload: []byte("payload"), Properties: props},
+			&Publish{Topic: "a/b", QoS: 2, PacketID: 7, Retain: true, Duplicate: true, Payload: []byte("payload"), Properties: props},
+			&Puback{PacketID: 1, ReasonCode: PubackNoMatchingSubscribers, Properties: props},
+			&Pubrec{PacketID: 1, ReasonCode: PubrecNoMatchingSubscribers, Properties: props},
+			&Pubrel{PacketID: 1, ReasonCode: 0x92, Properties: props},
+			&Pubcomp{PacketID: 1, ReasonCode: PubcompPacketIdentifierNotFound, Properties: props},
+			&Subscribe{PacketID: 1, Subscriptions: []SubOptions{{Topic: "a/#", QoS: 1, NoLocal: true, RetainHandling: 0x10}}, Properties: props},
+			&Suback{PacketID: 1, Reasons: []byte{0, 1, 0x80}, Properties: props},
+			&Unsubscribe{PacketID: 1, Topics: []string{"a/#", "b"}, Properties: props},
+			&Unsuback{PacketID: 1, Reasons: []byte{0, 0x11}, Properties: props},
+			&Pingreq{},
+			&Pingresp{},
+			&Disconnect{ReasonCode: DisconnectServerMoved, Properties: props},
+			&Auth{ReasonCode: AuthContinueAuthentication, Properties: props},
+		)
+	}
+	return packets
+}
+
+func TestWriter(t *testing.T) {
+	large := &Publish{Topic: "large", QoS: 1, PacketID: 2, Payload: bytes.Repeat([]byte{'x'}, 2*defaultBufferSize)}
+	for _, p := range append(testPackets(), large, &Puback{PacketID: 3}, &Pubrel{PacketID: 4}) {
+		var want bytes.Buffer
+		_, err := p.WriteTo(&want)
+		require.NoError(t, err)
+
+		var got bytes.Buffer
+		w := NewWriter(&got)
+		n, err := w.WritePacket(p)
+		require.NoError(t, err)
+		assert.Equal(t, int64(want.Len()), n)
+		require.NoError(t, w.Flush())
+		assert.Equal(t, want.Bytes(), got.Bytes(), "%T", p)
+	}
+}
+
+func TestWriterBuffers(t *testing.T) {
+	var out countingWriter
+	w := NewWriter(&out)
+	for i := 0; i < 10; i++ {
+		_, err := w.WritePacket(&Puback{PacketID: uint16(i + 1)})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 0, out.writes)
+	assert.Equal(t, 10*6, w.Buffered())
+	require.NoError(t, w.Flush())
+	assert.Equal(t, 1, out.writes)
+	assert.Equal(t, 0, w.Buffered())
+
+	// A packet that does not fit in the buffer is written in full
+	_, err := w.WritePacket(&Puback{PacketID: 1})
+	require.NoError(t, err)
+	_, err = w.WritePacket(&Publish{Topic: "large", Payload: make([]byte, defaultBufferSize)})
+	require.NoError(t, err)
+	assert.Equal(t, 0, w.Buffered())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestWriterError(t *testing.T) {
+	w := NewWriter(failingWriter{})
+	_, err := w.WritePacket(&Pingreq{})
+	require.NoError(t, err)
+	assert.Equal(t, io.ErrClosedPipe, w.Flush())
+	_, err = w.WritePacket(&Pingreq{})
+	assert.Equal(t, io.ErrClosedPipe, err)
+
+	var b bytes.Buffer
+	w.Reset(&b)
+	_, err = w.WritePacket(&Pingreq{})
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, []byte{PINGREQ << 4, 0}, b.Bytes())
+}
+
+type lockingWriter struct {
+	bytes.Buffer
+	locks, unlocks int
+}
+
+func (l *lockingWriter) Lock()   { l.locks++ }
+func (l *lockingWriter) Unlock() { l.unlocks++ }
+
+func TestWriterLocker(t *testing.T) {
+	var l lockingWriter
+	w := NewWriter(&l)
+	_, err := w.WritePacket(&Pingreq{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, l.locks)
+	assert.Equal(t, 0, l.Len())
+	require.NoError(t, w.Flush())
+	assert.Equal(t, 2, l.locks)
+	assert.Equal(t, 2, l.unlocks)
+	assert.Equal(t, 2, l.Len())
+}
+
+func TestReader(t *testing.T) {
+	var stream bytes.Buffer
+	var want []*ControlPacket
+	for _, p := range testPackets() {
+		var b bytes.Buffer
+		_, err := p.WriteTo(&b)
+		require.NoError(t, err)
+		encoded := append([]byte(nil), b.Bytes()...)
+		cp, err := ReadPacket(&b)
+		if err != nil {
+			continue // Pack does not filter out properties that are not permitted
+		}
+		stream.Write(encoded)
+		want = append(want, cp)
+	}
+
+	// The packets are read in small pieces to check that the buffering does
+	// not lose or duplicate data
+	r := NewReaderSize(&chunkedReader{r: &stream, size: 7}, 16)
+	for _, w := range want {
+		cp, err := r.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, w, cp)
+	}
+	_, err := r.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReaderStrict(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{0x30, 0x04, 0x00, 0x01, '#', 0x00, 0x41, 0x02, 0x00, 0x01}))
+	_, err := r.ReadPacketStrict()
+	assert.ErrorIs(t, err, ErrProtocolError)
+	_, err = r.ReadPacketStrict()
+	assert.ErrorIs(t, err, ErrMalformedPacket)
+}
+
+func TestReaderTruncated(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{0x30, 0x04, 0x00, 0x01}))
+	_, err := r.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+// The buffers used by a Reader are reused, so decoded packets must not
+// reference them
+func TestReaderDoesNotAlias(t *testing.T) {
+	var stream bytes.Buffer
+	for _, p := range []Packet{
+		&Publish{Topic: "a", Payload: []byte("first")},
+		&Suback{PacketID: 1, Reasons: []byte{1, 2}},
+		&Publish{Topic: "b", Payload: []byte("other")},
+		&Suback{PacketID: 1, Reasons: []byte{3, 4}},
+	} {
+		_, err := p.WriteTo(&stream)
+		require.NoError(t, err)
+	}
+
+	r := NewReader(&stream)
+	var got []*ControlPacket
+	for i := 0; i < 4; i++ {
+		cp, err := r.ReadPacket()
+		require.NoError(t, err)
+		got = append(got, cp)
+	}
+	assert.Equal(t, []byte("first"), got[0].Content.(*Publish).Payload)
+	assert.Equal(t, []byte{1, 2}, got[1].Content.(*Suback).Reasons)
+	assert.Equal(t, []byte("other"), got[2].Content.(*Publish).Payload)
+	assert.Equal(t, []byte{3, 4}, got[3].Content.(*Suback).Reasons)
+}
+
+func TestReaderReusePayloads(t *testing.T) {
+	var stream bytes.Buffer
+	for _, p := range []Packet{
+		&Publish{Topic: "a", Payload: []byte("first"), Properties: &Properties{ContentType: "text/plain"}},
+		&Publish{Topic: "b", Payload: []byte("other"), Properties: &Properties{ContentType: "text/other"}},
+	} {
+		_, err := p.WriteTo(&stream)
+		require.NoError(t, err)
+	}
+
+	r := NewReader(&stream)
+	r.ReusePayloads(true)
+	cp, err := r.ReadPacket()
+	require.NoError(t, err)
+	first := cp.Content.(*Publish)
+	assert.Equal(t, []byte("first"), first.Payload)
+	cp, err = r.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("other"), cp.Content.(*Publish).Payload)
+
+	// Only the payload refers to the Reader's buffer, which has now been
+	// reused (the packets have the same layout so it has been overwritten)
+	assert.Equal(t, "a", first.Topic)
+	assert.Equal(t, "text/plain", first.Properties.ContentType)
+	assert.NotEqual(t, []byte("first"), first.Payload)
+}
+
+func TestVBILength(t *testing.T) {
+	for _, v := range []int{0, 127, 128, 16383, 16384, 2097151, 2097152, 268435455} {
+		assert.Equal(t, len(encodeVBI(v)), vbiLength(v), v)
+		var b [maxVBILength]byte
+		assert.Equal(t, encodeVBI(v), b[:putVBI(b[:], v)], v)
+	}
+}
+
+// chunkedReader returns at most size bytes from each Read
+type chunkedReader struct {
+	r    io.Reader
+	size int
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if len(p) > c.size {
+		p = p[:c.size]
+	}
+	return c.r.Read(p)
+}
+
+// loopReader repeatedly returns data, counting the calls to Read (which
+// would each be a syscall on a net.Conn)
+type loopReader struct {
+	data  []byte
+	off   int
+	reads int
+}
+
+func (l *loopReader) Read(p []byte) (int, error) {
+	l.reads++
+	var n int
+	for n < len(p) {
+		c := copy(p[n:], l.data[l.off:])
+		l.off = (l.off + c) % len(l.data)
+		n += c
+	}
+	return n, nil
+}
+
+// countingWriter discards data, counting the calls to Write
+type countingWriter struct {
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return len(p), nil
+}
+
+func benchmarkPublish() *Publish {
+	return &Publish{Topic: "telemetry/device/1234", QoS: 1, PacketID: 1, Payload: bytes.Repeat([]byte{'x'}, 128),
+		Properties: &Properties{}}
+}
+
+func encoded(b *testing.B, p Packet) []byte {
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkReadPacket and BenchmarkReader compare reading PUBLISH packets
+// directly from a connection with reading them through a Reader (with, and
+// without, reusing payloads)
+func BenchmarkReadPacket(b *testing.B) {
+	r := &loopReader{data: encoded(b, benchmarkPublish())}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadPacket(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(r.reads)/float64(b.N), "reads/op")
+}
+
+func BenchmarkReader(b *testing.B) {
+	for _, reuse := range []bool{false, true} {
+		b.Run(fmt.Sprintf("reusePayloads=%t", reuse), func(b *testing.B) {
+			r := &loopReader{data: encoded(b, benchmarkPublish())}
+			pr := NewReader(r)
+			pr.ReusePayloads(reuse)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := pr.ReadPacket(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(r.reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+// BenchmarkWritePacket compares ControlPacket.WriteTo with a Writer that is
+// flushed after every packet, or after every 16 packets (as would happen
+// when packets are queued)
+func BenchmarkWritePacket(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		p    Packet
+	}{
+		{"publish", benchmarkPublish()},
+		{"puback", &Puback{PacketID: 1, Properties: &Properties{}}},
+	} {
+		b.Run(bm.name+"/WriteTo", func(b *testing.B) {
+			var w countingWriter
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.p.WriteTo(&w); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(w.writes)/float64(b.N), "writes/op")
+		})
+		for _, every := range []int{1, 16} {
+			b.Run(fmt.Sprintf("%s/Writer/flush%d", bm.name, every), func(b *testing.B) {
+				var w countingWriter
+				pw := NewWriter(&w)
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := pw.WritePacket(bm.p); err != nil {
+						b.Fatal(err)
+					}
+					if (i+1)%every == 0 {
+						if err := pw.Flush(); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+				b.ReportMetric(float64(w.writes)/float64(b.N), "writes/op")
+			})
+		}
+	}
+}
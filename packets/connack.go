@@ -2,7 +2,6 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
 )
@@ -18,7 +17,7 @@ const (
 	ConnackSuccess                     = 0x00
 	ConnackUnspecifiedError            = 0x80
 	ConnackMalformedPacket             = 0x81
-	ConnackProtocolError               = 0x81
+	ConnackProtocolError               = 0x82
 	ConnackImplementationSpecificError = 0x83
 	ConnackUnsupportedProtocolVersion  = 0x84
 	ConnackInvalidClientID             = 0x85
@@ -40,7 +39,8 @@ const (
 )
 
 func (c *Connack) String() string {
-	return fmt.Sprintf("CONNACK: ReasonCode:%d SessionPresent:%t\nProperties:\n%s", c.ReasonCode, c.SessionPresent, c.Properties)
+	return newText(CONNACK).reason("ReasonCode", c.ReasonCode).field("SessionPresent", c.SessionPresent).
+		properties("Properties", c.Properties).String()
 }
 
 //Unpack is the implementation of the interface required function for a packet
@@ -143,3 +143,11 @@ func (c *Connack) Reason() string {
 
 	return ""
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (c *Connack) Validate() error {
+	if c.SessionPresent && c.ReasonCode >= 0x80 {
+		return protocolError(CONNACK, "session present set on a failed connection")
+	}
+	return c.Properties.Validate(CONNACK)
+}
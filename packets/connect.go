@@ -2,10 +2,8 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
-	"strings"
 )
 
 // Connect is the Variable Header definition for a connect control packet
@@ -29,27 +27,24 @@ type Connect struct {
 }
 
 func (c *Connect) String() string {
-	var b strings.Builder
-
-	fmt.Fprintf(&b, "CONNECT: ProtocolName:%s ProtocolVersion:%d ClientID:%s KeepAlive:%d CleanStart:%t", c.ProtocolName, c.ProtocolVersion, c.ClientID, c.KeepAlive, c.CleanStart)
+	t := newText(CONNECT).field("ProtocolName", c.ProtocolName).field("ProtocolVersion", c.ProtocolVersion).
+		field("ClientID", c.ClientID).field("KeepAlive", c.KeepAlive).field("CleanStart", c.CleanStart)
 	if c.UsernameFlag {
-		fmt.Fprintf(&b, " Username:%s", c.Username)
+		t.field("Username", c.Username)
 	}
 	if c.PasswordFlag {
-		fmt.Fprintf(&b, " Password:%s", c.Password)
+		t.secret("Password", c.Password)
 	}
-	fmt.Fprint(&b, "\n")
 	if c.WillFlag {
-		fmt.Fprintf(&b, " WillTopic:%s WillQOS:%d WillRetain:%t WillMessage:\n%s\n", c.WillTopic, c.WillQOS, c.WillRetain, c.WillMessage)
-		if c.WillProperties != nil {
-			fmt.Fprintf(&b, "WillProperties:\n%s", c.WillProperties)
-		}
+		t.field("WillTopic", c.WillTopic).field("WillQOS", c.WillQOS).field("WillRetain", c.WillRetain).
+			binary("WillMessage", c.WillMessage)
 	}
-	if c.Properties != nil {
-		fmt.Fprintf(&b, "Properties:\n%s", c.Properties)
+	t.properties("Properties", c.Properties)
+	if c.WillFlag {
+		t.properties("WillProperties", c.WillProperties)
 	}
 
-	return b.String()
+	return t.String()
 }
 
 // PackFlags takes the Connect flags and packs them into the single byte
@@ -119,7 +114,7 @@ func (c *Connect) Unpack(r *bytes.Buffer) error {
 
 	if c.WillFlag {
 		c.WillProperties = &Properties{}
-		err = c.WillProperties.Unpack(r, CONNECT)
+		err = c.WillProperties.Unpack(r, WILLPROPERTIES)
 		if err != nil {
 			return err
 		}
@@ -164,7 +159,7 @@ func (c *Connect) Buffers() net.Buffers {
 
 	writeString(c.ClientID, &cp)
 	if c.WillFlag {
-		willIdvp := c.WillProperties.Pack(CONNECT)
+		willIdvp := c.WillProperties.Pack(WILLPROPERTIES)
 		encodeVBIdirect(len(willIdvp), &cp)
 		cp.Write(willIdvp)
 		writeString(c.WillTopic, &cp)
@@ -187,3 +182,36 @@ func (c *Connect) WriteTo(w io.Writer) (int64, error) {
 
 	return cp.WriteTo(w)
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (c *Connect) Validate() error {
+	if c.ProtocolName != "MQTT" || c.ProtocolVersion != 5 {
+		return protocolError(CONNECT, "unsupported protocol %s version %d", c.ProtocolName, c.ProtocolVersion)
+	}
+	if !validString(c.ClientID) || !validString(c.Username) {
+		return malformed(CONNECT, "client identifier or username is not a valid UTF-8 string")
+	}
+	if len(c.Password) > 65535 {
+		return malformed(CONNECT, "password too long")
+	}
+	if c.WillQOS > 2 {
+		return malformed(CONNECT, "invalid will QoS %d", c.WillQOS)
+	}
+	if c.WillFlag {
+		if !validString(c.WillTopic) {
+			return malformed(CONNECT, "will topic is not a valid UTF-8 string")
+		}
+		if c.WillTopic == "" || !validTopicName(c.WillTopic) {
+			return protocolError(CONNECT, "invalid will topic %q", c.WillTopic)
+		}
+		if len(c.WillMessage) > 65535 {
+			return malformed(CONNECT, "will message too long")
+		}
+		if err := c.WillProperties.Validate(WILLPROPERTIES); err != nil {
+			return err
+		}
+	} else if c.WillQOS != 0 || c.WillRetain {
+		return malformed(CONNECT, "will QoS or retain set without a will")
+	}
+	return c.Properties.Validate(CONNECT)
+}
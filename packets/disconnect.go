@@ -2,7 +2,6 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
 )
@@ -14,7 +13,7 @@ type Disconnect struct {
 }
 
 func (d *Disconnect) String() string {
-	return fmt.Sprintf("DISCONNECT: ReasonCode:%X Properties\n%s", d.ReasonCode, d.Properties)
+	return newText(DISCONNECT).reason("ReasonCode", d.ReasonCode).properties("Properties", d.Properties).String()
 }
 
 // DisconnectNormalDisconnection, etc are the list of valid disconnection reason codes.
@@ -52,6 +51,9 @@ const (
 
 // Unpack is the implementation of the interface required function for a packet
 func (d *Disconnect) Unpack(r *bytes.Buffer) error {
+	if r.Len() == 0 {
+		return nil // a remaining length of 0 indicates Normal disconnection
+	}
 	var err error
 	d.ReasonCode, err = r.ReadByte()
 	if err != nil {
@@ -150,3 +152,8 @@ func (d *Disconnect) Reason() string {
 
 	return ""
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (d *Disconnect) Validate() error {
+	return d.Properties.Validate(DISCONNECT)
+}
@@ -0,0 +1,137 @@
+package packets
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTextBinary is the number of bytes of binary data (such as a payload)
+// included in the textual form of a packet; longer data is truncated
+const maxTextBinary = 256
+
+// textBuilder builds the textual form used by all packets: the packet type
+// followed by Name:value fields on one line, then any properties (indented,
+// one per line). Strings are quoted, reason codes are in hex, binary data is
+// shown as quoted text (if it is valid UTF-8) or hex, and secrets (such as
+// passwords) are redacted.
+type textBuilder struct {
+	b         strings.Builder
+	fields    bool // set once the colon following the packet type has been written
+	lineStart bool // set when a new line has been started
+}
+
+func newText(packetType byte) *textBuilder {
+	t := &textBuilder{}
+	t.b.WriteString(packetTypeName(packetType))
+	return t
+}
+
+// name writes the name of a field
+func (t *textBuilder) name(name string) {
+	if !t.fields {
+		t.b.WriteByte(':')
+		t.fields = true
+	}
+	if !t.lineStart {
+		t.b.WriteByte(' ')
+	}
+	t.lineStart = false
+	t.b.WriteString(name)
+	t.b.WriteByte(':')
+}
+
+// field adds a field; strings are quoted and other values are formatted
+// with %v
+func (t *textBuilder) field(name string, value interface{}) *textBuilder {
+	if s, ok := value.(string); ok {
+		value = strconv.Quote(s)
+	}
+	t.name(name)
+	fmt.Fprint(&t.b, value)
+	return t
+}
+
+// reason adds a reason code field
+func (t *textBuilder) reason(name string, code byte) *textBuilder {
+	t.name(name)
+	t.b.WriteString(formatReason(code))
+	return t
+}
+
+// reasons adds a list of reason codes
+func (t *textBuilder) reasons(name string, codes []byte) *textBuilder {
+	r := make([]string, len(codes))
+	for i, c := range codes {
+		r[i] = formatReason(c)
+	}
+	t.name(name)
+	fmt.Fprintf(&t.b, "[%s]", strings.Join(r, " "))
+	return t
+}
+
+// binary adds a binary field
+func (t *textBuilder) binary(name string, data []byte) *textBuilder {
+	t.name(name)
+	t.b.WriteString(formatBinary(data))
+	return t
+}
+
+// secret adds a field whose value must not be shown
+func (t *textBuilder) secret(name string, data []byte) *textBuilder {
+	t.name(name)
+	t.b.WriteString(formatSecret(data))
+	return t
+}
+
+// line starts a new, indented, line
+func (t *textBuilder) line() *textBuilder {
+	t.b.WriteString("\n\t")
+	t.lineStart = true
+	return t
+}
+
+// properties adds the properties p on following lines (nothing is added if
+// there are no properties)
+func (t *textBuilder) properties(name string, p *Properties) *textBuilder {
+	if s := p.String(); s != "" {
+		fmt.Fprintf(&t.b, "\n%s:\n%s", name, strings.TrimSuffix(s, "\n"))
+	}
+	return t
+}
+
+func (t *textBuilder) String() string {
+	return t.b.String()
+}
+
+func formatReason(code byte) string {
+	return fmt.Sprintf("0x%02X", code)
+}
+
+// formatBinary returns data as quoted text, if it is valid UTF-8, otherwise
+// as hex; at most maxTextBinary bytes are included
+func formatBinary(data []byte) string {
+	shown := data
+	if len(shown) > maxTextBinary {
+		shown = shown[:maxTextBinary]
+		// Avoid splitting a multi-byte character
+		for i := 0; i < utf8.UTFMax-1 && !utf8.Valid(shown) && utf8.Valid(data); i++ {
+			shown = shown[:len(shown)-1]
+		}
+	}
+	var s string
+	if utf8.Valid(shown) {
+		s = strconv.Quote(string(shown))
+	} else {
+		s = fmt.Sprintf("0x%X", shown)
+	}
+	if len(shown) < len(data) {
+		s += fmt.Sprintf("...(%d bytes)", len(data))
+	}
+	return s
+}
+
+func formatSecret(data []byte) string {
+	return fmt.Sprintf("<redacted %d bytes>", len(data))
+}
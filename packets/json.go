@@ -0,0 +1,502 @@
+package packets
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// BinaryEncoding determines how binary data (payloads, will messages and
+// correlation data) is represented by MarshalPacketJSON
+type BinaryEncoding int
+
+const (
+	// EncodeAuto uses text if the data is valid UTF-8, and base64 otherwise
+	EncodeAuto BinaryEncoding = iota
+	// EncodeText always uses text; invalid UTF-8 is replaced so the data may
+	// not be recoverable
+	EncodeText
+	// EncodeHex uses hex
+	EncodeHex
+	// EncodeBase64 uses standard base64
+	EncodeBase64
+)
+
+// JSONOptions control the JSON produced by MarshalPacketJSON; the zero value
+// is used by the MarshalJSON methods of the packets
+type JSONOptions struct {
+	Binary BinaryEncoding
+	// ShowSecrets includes passwords and authentication data, which are
+	// otherwise redacted
+	ShowSecrets bool
+}
+
+// ErrRedacted is returned when parsing JSON in which secrets were redacted
+var ErrRedacted = errors.New("redacted data cannot be parsed")
+
+// jsonBinary is the JSON representation of binary data; exactly one of the
+// fields is set. Redacted holds the length of data that has been redacted.
+type jsonBinary struct {
+	Text     *string `json:"text,omitempty"`
+	Hex      *string `json:"hex,omitempty"`
+	Base64   *string `json:"base64,omitempty"`
+	Redacted *int    `json:"redacted,omitempty"`
+}
+
+func encodeBinary(data []byte, o JSONOptions, secret bool) *jsonBinary {
+	var s string
+	switch {
+	case secret && !o.ShowSecrets:
+		n := len(data)
+		return &jsonBinary{Redacted: &n}
+	case o.Binary == EncodeText, o.Binary == EncodeAuto && utf8.Valid(data):
+		s = string(data)
+		return &jsonBinary{Text: &s}
+	case o.Binary == EncodeHex:
+		s = hex.EncodeToString(data)
+		return &jsonBinary{Hex: &s}
+	}
+	s = base64.StdEncoding.EncodeToString(data)
+	return &jsonBinary{Base64: &s}
+}
+
+// encodeOptional encodes data, returning nil if it is empty
+func encodeOptional(data []byte, o JSONOptions, secret bool) *jsonBinary {
+	if len(data) == 0 {
+		return nil
+	}
+	return encodeBinary(data, o, secret)
+}
+
+func (b *jsonBinary) decode() ([]byte, error) {
+	if b == nil {
+		return nil, nil
+	}
+	switch {
+	case b.Redacted != nil:
+		return nil, ErrRedacted
+	case b.Text != nil:
+		return []byte(*b.Text), nil
+	case b.Hex != nil:
+		return hex.DecodeString(*b.Hex)
+	case b.Base64 != nil:
+		return base64.StdEncoding.DecodeString(*b.Base64)
+	}
+	return nil, errors.New("binary data must have one of text, hex or base64")
+}
+
+type jsonUser struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type jsonProperties struct {
+	PayloadFormat          *byte       `json:"payloadFormat,omitempty"`
+	MessageExpiry          *uint32     `json:"messageExpiry,omitempty"`
+	ContentType            string      `json:"contentType,omitempty"`
+	ResponseTopic          string      `json:"responseTopic,omitempty"`
+	CorrelationData        *jsonBinary `json:"correlationData,omitempty"`
+	SubscriptionIdentifier *int        `json:"subscriptionIdentifier,omitempty"`
+	SessionExpiryInterval  *uint32     `json:"sessionExpiryInterval,omitempty"`
+	AssignedClientID       string      `json:"assignedClientId,omitempty"`
+	ServerKeepAlive        *uint16     `json:"serverKeepAlive,omitempty"`
+	AuthMethod             string      `json:"authMethod,omitempty"`
+	AuthData               *jsonBinary `json:"authData,omitempty"`
+	RequestProblemInfo     *byte       `json:"requestProblemInfo,omitempty"`
+	WillDelayInterval      *uint32     `json:"willDelayInterval,omitempty"`
+	RequestResponseInfo    *byte       `json:"requestResponseInfo,omitempty"`
+	ResponseInfo           string      `json:"responseInfo,omitempty"`
+	ServerReference        string      `json:"serverReference,omitempty"`
+	ReasonString           string      `json:"reasonString,omitempty"`
+	ReceiveMaximum         *uint16     `json:"receiveMaximum,omitempty"`
+	TopicAliasMaximum      *uint16     `json:"topicAliasMaximum,omitempty"`
+	TopicAlias             *uint16     `json:"topicAlias,omitempty"`
+	MaximumQOS             *byte       `json:"maximumQos,omitempty"`
+	RetainAvailable        *byte       `json:"retainAvailable,omitempty"`
+	User                   []jsonUser  `json:"user,omitempty"`
+	MaximumPacketSize      *uint32     `json:"maximumPacketSize,omitempty"`
+	WildcardSubAvailable   *byte       `json:"wildcardSubAvailable,omitempty"`
+	SubIDAvailable         *byte       `json:"subIdAvailable,omitempty"`
+	SharedSubAvailable     *byte       `json:"sharedSubAvailable,omitempty"`
+}
+
+// encodeProperties returns the JSON representation of p, or nil if no
+// properties are set
+func encodeProperties(p *Properties, o JSONOptions) *jsonProperties {
+	if p == nil || len(p.present()) == 0 {
+		return nil
+	}
+	j := &jsonProperties{
+		PayloadFormat:          p.PayloadFormat,
+		MessageExpiry:          p.MessageExpiry,
+		ContentType:            p.ContentType,
+		ResponseTopic:          p.ResponseTopic,
+		CorrelationData:        encodeOptional(p.CorrelationData, o, false),
+		SubscriptionIdentifier: p.SubscriptionIdentifier,
+		SessionExpiryInterval:  p.SessionExpiryInterval,
+		AssignedClientID:       p.AssignedClientID,
+		ServerKeepAlive:        p.ServerKeepAlive,
+		AuthMethod:             p.AuthMethod,
+		AuthData:               encodeOptional(p.AuthData, o, true),
+		RequestProblemInfo:     p.RequestProblemInfo,
+		WillDelayInterval:      p.WillDelayInterval,
+		RequestResponseInfo:    p.RequestResponseInfo,
+		ResponseInfo:           p.ResponseInfo,
+		ServerReference:        p.ServerReference,
+		ReasonString:           p.ReasonString,
+		ReceiveMaximum:         p.ReceiveMaximum,
+		TopicAliasMaximum:      p.TopicAliasMaximum,
+		TopicAlias:             p.TopicAlias,
+		MaximumQOS:             p.MaximumQOS,
+		RetainAvailable:        p.RetainAvailable,
+		MaximumPacketSize:      p.MaximumPacketSize,
+		WildcardSubAvailable:   p.WildcardSubAvailable,
+		SubIDAvailable:         p.SubIDAvailable,
+		SharedSubAvailable:     p.SharedSubAvailable,
+	}
+	for _, u := range p.User {
+		j.User = append(j.User, jsonUser{Key: u.Key, Value: u.Value})
+	}
+	return j
+}
+
+// decode returns the Properties represented by j (which are never nil, as
+// with packets that have been read)
+func (j *jsonProperties) decode() (*Properties, error) {
+	if j == nil {
+		return &Properties{}, nil
+	}
+	p := &Properties{
+		PayloadFormat:          j.PayloadFormat,
+		MessageExpiry:          j.MessageExpiry,
+		ContentType:            j.ContentType,
+		ResponseTopic:          j.ResponseTopic,
+		SubscriptionIdentifier: j.SubscriptionIdentifier,
+		SessionExpiryInterval:  j.SessionExpiryInterval,
+		AssignedClientID:       j.AssignedClientID,
+		ServerKeepAlive:        j.ServerKeepAlive,
+		AuthMethod:             j.AuthMethod,
+		RequestProblemInfo:     j.RequestProblemInfo,
+		WillDelayInterval:      j.WillDelayInterval,
+		RequestResponseInfo:    j.RequestResponseInfo,
+		ResponseInfo:           j.ResponseInfo,
+		ServerReference:        j.ServerReference,
+		ReasonString:           j.ReasonString,
+		ReceiveMaximum:         j.ReceiveMaximum,
+		TopicAliasMaximum:      j.TopicAliasMaximum,
+		TopicAlias:             j.TopicAlias,
+		MaximumQOS:             j.MaximumQOS,
+		RetainAvailable:        j.RetainAvailable,
+		MaximumPacketSize:      j.MaximumPacketSize,
+		WildcardSubAvailable:   j.WildcardSubAvailable,
+		SubIDAvailable:         j.SubIDAvailable,
+		SharedSubAvailable:     j.SharedSubAvailable,
+	}
+	var err error
+	if p.CorrelationData, err = j.CorrelationData.decode(); err != nil {
+		return nil, fmt.Errorf("correlationData: %w", err)
+	}
+	if p.AuthData, err = j.AuthData.decode(); err != nil {
+		return nil, fmt.Errorf("authData: %w", err)
+	}
+	for _, u := range j.User {
+		p.User = append(p.User, User{Key: u.Key, Value: u.Value})
+	}
+	return p, nil
+}
+
+type jsonWill struct {
+	Topic      string          `json:"topic"`
+	QoS        byte            `json:"qos,omitempty"`
+	Retain     bool            `json:"retain,omitempty"`
+	Message    *jsonBinary     `json:"message,omitempty"`
+	Properties *jsonProperties `json:"properties,omitempty"`
+}
+
+type jsonSubscription struct {
+	Topic             string `json:"topic"`
+	QoS               byte   `json:"qos,omitempty"`
+	RetainHandling    byte   `json:"retainHandling,omitempty"` // 0, 1 or 2 (SubOptions holds this shifted left 4 bits)
+	NoLocal           bool   `json:"noLocal,omitempty"`
+	RetainAsPublished bool   `json:"retainAsPublished,omitempty"`
+}
+
+// jsonPacket is the JSON representation of all packets; only the fields
+// relevant to Type are used
+type jsonPacket struct {
+	Type       string `json:"type"`
+	PacketID   uint16 `json:"packetId,omitempty"`
+	ReasonCode byte   `json:"reasonCode,omitempty"`
+	Reasons    []int  `json:"reasons,omitempty"`
+
+	ProtocolName    string      `json:"protocolName,omitempty"`
+	ProtocolVersion byte        `json:"protocolVersion,omitempty"`
+	ClientID        string      `json:"clientId,omitempty"`
+	KeepAlive       uint16      `json:"keepAlive,omitempty"`
+	CleanStart      bool        `json:"cleanStart,omitempty"`
+	Username        *string     `json:"username,omitempty"`
+	Password        *jsonBinary `json:"password,omitempty"`
+	Will            *jsonWill   `json:"will,omitempty"`
+
+	SessionPresent bool `json:"sessionPresent,omitempty"`
+
+	Topic     string      `json:"topic,omitempty"`
+	QoS       byte        `json:"qos,omitempty"`
+	Duplicate bool        `json:"duplicate,omitempty"`
+	Retain    bool        `json:"retain,omitempty"`
+	Payload   *jsonBinary `json:"payload,omitempty"`
+
+	Subscriptions []jsonSubscription `json:"subscriptions,omitempty"`
+	Topics        []string           `json:"topics,omitempty"`
+
+	Properties *jsonProperties `json:"properties,omitempty"`
+}
+
+func reasonsToJSON(r []byte) []int {
+	if len(r) == 0 {
+		return nil
+	}
+	j := make([]int, len(r))
+	for i, c := range r {
+		j[i] = int(c)
+	}
+	return j
+}
+
+func reasonsFromJSON(j []int) ([]byte, error) {
+	r := make([]byte, len(j))
+	for i, c := range j {
+		if c < 0 || c > 255 {
+			return nil, fmt.Errorf("invalid reason code %d", c)
+		}
+		r[i] = byte(c)
+	}
+	return r, nil
+}
+
+// MarshalPacketJSON returns the JSON representation of p. The packet type is
+// held in the "type" field (e.g. "PUBLISH"), and fields that have their zero
+// value are omitted. Binary data is an object with one of "text", "hex" or
+// "base64" set (as determined by o) and secrets (passwords and
+// authentication data) are replaced by {"redacted":<length>} unless
+// o.ShowSecrets is set.
+func MarshalPacketJSON(p Packet, o JSONOptions) ([]byte, error) {
+	var j jsonPacket
+	switch p := p.(type) {
+	case *Connect:
+		j = jsonPacket{
+			Type:            "CONNECT",
+			ProtocolName:    p.ProtocolName,
+			ProtocolVersion: p.ProtocolVersion,
+			ClientID:        p.ClientID,
+			KeepAlive:       p.KeepAlive,
+			CleanStart:      p.CleanStart,
+			Properties:      encodeProperties(p.Properties, o),
+		}
+		if p.UsernameFlag {
+			j.Username = &p.Username
+		}
+		if p.PasswordFlag {
+			j.Password = encodeBinary(p.Password, o, true)
+		}
+		if p.WillFlag {
+			j.Will = &jsonWill{
+				Topic:      p.WillTopic,
+				QoS:        p.WillQOS,
+				Retain:     p.WillRetain,
+				Message:    encodeOptional(p.WillMessage, o, false),
+				Properties: encodeProperties(p.WillProperties, o),
+			}
+		}
+	case *Connack:
+		j = jsonPacket{Type: "CONNACK", ReasonCode: p.ReasonCode, SessionPresent: p.SessionPresent,
+			Properties: encodeProperties(p.Properties, o)}
+	case *Publish:
+		j = jsonPacket{Type: "PUBLISH", PacketID: p.PacketID, Topic: p.Topic, QoS: p.QoS, Duplicate: p.Duplicate,
+			Retain: p.Retain, Payload: encodeOptional(p.Payload, o, false), Properties: encodeProperties(p.Properties, o)}
+	case *Puback:
+		j = jsonPacket{Type: "PUBACK", PacketID: p.PacketID, ReasonCode: p.ReasonCode, Properties: encodeProperties(p.Properties, o)}
+	case *Pubrec:
+		j = jsonPacket{Type: "PUBREC", PacketID: p.PacketID, ReasonCode: p.ReasonCode, Properties: encodeProperties(p.Properties, o)}
+	case *Pubrel:
+		j = jsonPacket{Type: "PUBREL", PacketID: p.PacketID, ReasonCode: p.ReasonCode, Properties: encodeProperties(p.Properties, o)}
+	case *Pubcomp:
+		j = jsonPacket{Type: "PUBCOMP", PacketID: p.PacketID, ReasonCode: p.ReasonCode, Properties: encodeProperties(p.Properties, o)}
+	case *Subscribe:
+		j = jsonPacket{Type: "SUBSCRIBE", PacketID: p.PacketID, Properties: encodeProperties(p.Properties, o)}
+		for _, s := range p.Subscriptions {
+			j.Subscriptions = append(j.Subscriptions, jsonSubscription{Topic: s.Topic, QoS: s.QoS,
+				RetainHandling: s.RetainHandling >> 4, NoLocal: s.NoLocal, RetainAsPublished: s.RetainAsPublished})
+		}
+	case *Suback:
+		j = jsonPacket{Type: "SUBACK", PacketID: p.PacketID, Reasons: reasonsToJSON(p.Reasons), Properties: encodeProperties(p.Properties, o)}
+	case *Unsubscribe:
+		j = jsonPacket{Type: "UNSUBSCRIBE", PacketID: p.PacketID, Topics: p.Topics, Properties: encodeProperties(p.Properties, o)}
+	case *Unsuback:
+		j = jsonPacket{Type: "UNSUBACK", PacketID: p.PacketID, Reasons: reasonsToJSON(p.Reasons), Properties: encodeProperties(p.Properties, o)}
+	case *Pingreq:
+		j = jsonPacket{Type: "PINGREQ"}
+	case *Pingresp:
+		j = jsonPacket{Type: "PINGRESP"}
+	case *Disconnect:
+		j = jsonPacket{Type: "DISCONNECT", ReasonCode: p.ReasonCode, Properties: encodeProperties(p.Properties, o)}
+	case *Auth:
+		j = jsonPacket{Type: "AUTH", ReasonCode: p.ReasonCode, Properties: encodeProperties(p.Properties, o)}
+	default:
+		return nil, fmt.Errorf("cannot marshal packet of type %T", p)
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalPacketJSON returns the packet represented by data, which is in the
+// form produced by MarshalPacketJSON. Omitted fields take their zero value,
+// except that the protocol name and version of a CONNECT default to "MQTT"
+// and 5, and Properties are never nil. ErrRedacted is returned if data
+// contains a redacted secret.
+func UnmarshalPacketJSON(data []byte) (Packet, error) {
+	var j jsonPacket
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	props, err := j.Properties.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToUpper(j.Type) {
+	case "CONNECT":
+		c := &Connect{
+			ProtocolName:    j.ProtocolName,
+			ProtocolVersion: j.ProtocolVersion,
+			ClientID:        j.ClientID,
+			KeepAlive:       j.KeepAlive,
+			CleanStart:      j.CleanStart,
+			Properties:      props,
+		}
+		if c.ProtocolName == "" {
+			c.ProtocolName = "MQTT"
+		}
+		if c.ProtocolVersion == 0 {
+			c.ProtocolVersion = 5
+		}
+		if j.Username != nil {
+			c.UsernameFlag, c.Username = true, *j.Username
+		}
+		if j.Password != nil {
+			c.PasswordFlag = true
+			if c.Password, err = j.Password.decode(); err != nil {
+				return nil, fmt.Errorf("password: %w", err)
+			}
+		}
+		if j.Will != nil {
+			c.WillFlag, c.WillTopic, c.WillQOS, c.WillRetain = true, j.Will.Topic, j.Will.QoS, j.Will.Retain
+			if c.WillMessage, err = j.Will.Message.decode(); err != nil {
+				return nil, fmt.Errorf("will message: %w", err)
+			}
+			if c.WillProperties, err = j.Will.Properties.decode(); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	case "CONNACK":
+		return &Connack{ReasonCode: j.ReasonCode, SessionPresent: j.SessionPresent, Properties: props}, nil
+	case "PUBLISH":
+		p := &Publish{PacketID: j.PacketID, Topic: j.Topic, QoS: j.QoS, Duplicate: j.Duplicate, Retain: j.Retain,
+			Properties: props}
+		if p.Payload, err = j.Payload.decode(); err != nil {
+			return nil, fmt.Errorf("payload: %w", err)
+		}
+		return p, nil
+	case "PUBACK":
+		return &Puback{PacketID: j.PacketID, ReasonCode: j.ReasonCode, Properties: props}, nil
+	case "PUBREC":
+		return &Pubrec{PacketID: j.PacketID, ReasonCode: j.ReasonCode, Properties: props}, nil
+	case "PUBREL":
+		return &Pubrel{PacketID: j.PacketID, ReasonCode: j.ReasonCode, Properties: props}, nil
+	case "PUBCOMP":
+		return &Pubcomp{PacketID: j.PacketID, ReasonCode: j.ReasonCode, Properties: props}, nil
+	case "SUBSCRIBE":
+		s := &Subscribe{PacketID: j.PacketID, Properties: props}
+		for _, o := range j.Subscriptions {
+			s.Subscriptions = append(s.Subscriptions, SubOptions{Topic: o.Topic, QoS: o.QoS,
+				RetainHandling: o.RetainHandling << 4, NoLocal: o.NoLocal, RetainAsPublished: o.RetainAsPublished})
+		}
+		return s, nil
+	case "SUBACK":
+		s := &Suback{PacketID: j.PacketID, Properties: props}
+		if s.Reasons, err = reasonsFromJSON(j.Reasons); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "UNSUBSCRIBE":
+		return &Unsubscribe{PacketID: j.PacketID, Topics: j.Topics, Properties: props}, nil
+	case "UNSUBACK":
+		u := &Unsuback{PacketID: j.PacketID, Properties: props}
+		if u.Reasons, err = reasonsFromJSON(j.Reasons); err != nil {
+			return nil, err
+		}
+		return u, nil
+	case "PINGREQ":
+		return &Pingreq{}, nil
+	case "PINGRESP":
+		return &Pingresp{}, nil
+	case "DISCONNECT":
+		return &Disconnect{ReasonCode: j.ReasonCode, Properties: props}, nil
+	case "AUTH":
+		return &Auth{ReasonCode: j.ReasonCode, Properties: props}, nil
+	}
+	return nil, fmt.Errorf("unknown packet type %q", j.Type)
+}
+
+// unmarshalInto parses data, which must represent a packet of the same type
+// as dst, into dst
+func unmarshalInto(data []byte, dst Packet) error {
+	p, err := UnmarshalPacketJSON(data)
+	if err != nil {
+		return err
+	}
+	if reflect.TypeOf(p) != reflect.TypeOf(dst) {
+		return fmt.Errorf("cannot unmarshal %T into %T", p, dst)
+	}
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(p).Elem())
+	return nil
+}
+
+// The packets implement json.Marshaler, and json.Unmarshaler, using
+// MarshalPacketJSON (with the default options) and UnmarshalPacketJSON
+
+func (c *Connect) MarshalJSON() ([]byte, error)        { return MarshalPacketJSON(c, JSONOptions{}) }
+func (c *Connect) UnmarshalJSON(data []byte) error     { return unmarshalInto(data, c) }
+func (c *Connack) MarshalJSON() ([]byte, error)        { return MarshalPacketJSON(c, JSONOptions{}) }
+func (c *Connack) UnmarshalJSON(data []byte) error     { return unmarshalInto(data, c) }
+func (p *Publish) MarshalJSON() ([]byte, error)        { return MarshalPacketJSON(p, JSONOptions{}) }
+func (p *Publish) UnmarshalJSON(data []byte) error     { return unmarshalInto(data, p) }
+func (p *Puback) MarshalJSON() ([]byte, error)         { return MarshalPacketJSON(p, JSONOptions{}) }
+func (p *Puback) UnmarshalJSON(data []byte) error      { return unmarshalInto(data, p) }
+func (p *Pubrec) MarshalJSON() ([]byte, error)         { return MarshalPacketJSON(p, JSONOptions{}) }
+func (p *Pubrec) UnmarshalJSON(data []byte) error      { return unmarshalInto(data, p) }
+func (p *Pubrel) MarshalJSON() ([]byte, error)         { return MarshalPacketJSON(p, JSONOptions{}) }
+func (p *Pubrel) UnmarshalJSON(data []byte) error      { return unmarshalInto(data, p) }
+func (p *Pubcomp) MarshalJSON() ([]byte, error)        { return MarshalPacketJSON(p, JSONOptions{}) }
+func (p *Pubcomp) UnmarshalJSON(data []byte) error     { return unmarshalInto(data, p) }
+func (s *Subscribe) MarshalJSON() ([]byte, error)      { return MarshalPacketJSON(s, JSONOptions{}) }
+func (s *Subscribe) UnmarshalJSON(data []byte) error   { return unmarshalInto(data, s) }
+func (s *Suback) MarshalJSON() ([]byte, error)         { return MarshalPacketJSON(s, JSONOptions{}) }
+func (s *Suback) UnmarshalJSON(data []byte) error      { return unmarshalInto(data, s) }
+func (u *Unsubscribe) MarshalJSON() ([]byte, error)    { return MarshalPacketJSON(u, JSONOptions{}) }
+func (u *Unsubscribe) UnmarshalJSON(data []byte) error { return unmarshalInto(data, u) }
+func (u *Unsuback) MarshalJSON() ([]byte, error)       { return MarshalPacketJSON(u, JSONOptions{}) }
+func (u *Unsuback) UnmarshalJSON(data []byte) error    { return unmarshalInto(data, u) }
+func (p *Pingreq) MarshalJSON() ([]byte, error)        { return MarshalPacketJSON(p, JSONOptions{}) }
+func (p *Pingreq) UnmarshalJSON(data []byte) error     { return unmarshalInto(data, p) }
+func (p *Pingresp) MarshalJSON() ([]byte, error)       { return MarshalPacketJSON(p, JSONOptions{}) }
+func (p *Pingresp) UnmarshalJSON(data []byte) error    { return unmarshalInto(data, p) }
+func (d *Disconnect) MarshalJSON() ([]byte, error)     { return MarshalPacketJSON(d, JSONOptions{}) }
+func (d *Disconnect) UnmarshalJSON(data []byte) error  { return unmarshalInto(data, d) }
+func (a *Auth) MarshalJSON() ([]byte, error)           { return MarshalPacketJSON(a, JSONOptions{}) }
+func (a *Auth) UnmarshalJSON(data []byte) error        { return unmarshalInto(data, a) }
@@ -0,0 +1,118 @@
+package packets
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketJSONRoundTrip(t *testing.T) {
+	for _, enc := range []BinaryEncoding{EncodeAuto, EncodeHex, EncodeBase64} {
+		for _, p := range testPackets() {
+			data, err := MarshalPacketJSON(p, JSONOptions{Binary: enc, ShowSecrets: true})
+			require.NoError(t, err)
+			got, err := UnmarshalPacketJSON(data)
+			require.NoError(t, err, string(data))
+			assert.Equal(t, p, got, string(data))
+		}
+	}
+}
+
+func TestPacketJSONMarshaler(t *testing.T) {
+	p := &Publish{Topic: "a/b", QoS: 1, PacketID: 2, Payload: []byte("hello"), Properties: &Properties{ContentType: "text/plain"}}
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"PUBLISH","packetId":2,"topic":"a/b","qos":1,"payload":{"text":"hello"},
+		"properties":{"contentType":"text/plain"}}`, string(data))
+
+	var got Publish
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, p, &got)
+
+	var wrong Puback
+	assert.Error(t, json.Unmarshal(data, &wrong))
+}
+
+func TestPacketJSONBinary(t *testing.T) {
+	p := &Publish{Topic: "a", Payload: []byte{0xff, 0x00}}
+	for _, tt := range []struct {
+		enc  BinaryEncoding
+		want string
+	}{
+		{EncodeAuto, `{"base64":"/wA="}`},
+		{EncodeHex, `{"hex":"ff00"}`},
+		{EncodeBase64, `{"base64":"/wA="}`},
+	} {
+		data, err := MarshalPacketJSON(p, JSONOptions{Binary: tt.enc})
+		require.NoError(t, err)
+		var j struct{ Payload json.RawMessage }
+		require.NoError(t, json.Unmarshal(data, &j))
+		assert.JSONEq(t, tt.want, string(j.Payload))
+	}
+}
+
+func TestPacketJSONRedacted(t *testing.T) {
+	c := &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, UsernameFlag: true, Username: "user", PasswordFlag: true,
+		Password: []byte("secret"), Properties: &Properties{AuthMethod: "SCRAM-SHA-1", AuthData: []byte("secret")}}
+	data, err := json.Marshal(c)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.Contains(t, string(data), `"password":{"redacted":6}`)
+
+	_, err = UnmarshalPacketJSON(data)
+	assert.True(t, errors.Is(err, ErrRedacted), err)
+
+	data, err = MarshalPacketJSON(c, JSONOptions{ShowSecrets: true})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"password":{"text":"secret"}`)
+}
+
+func TestUnmarshalPacketJSON(t *testing.T) {
+	p, err := UnmarshalPacketJSON([]byte(`{"type":"subscribe","packetId":1,
+		"subscriptions":[{"topic":"a/#","qos":1,"retainHandling":2,"noLocal":true}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, &Subscribe{PacketID: 1, Properties: &Properties{},
+		Subscriptions: []SubOptions{{Topic: "a/#", QoS: 1, RetainHandling: 0x20, NoLocal: true}}}, p)
+
+	p, err = UnmarshalPacketJSON([]byte(`{"type":"CONNECT","clientId":"c"}`))
+	require.NoError(t, err)
+	assert.Equal(t, &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, ClientID: "c", Properties: &Properties{}}, p)
+
+	for _, bad := range []string{
+		`{"type":"NOPE"}`,
+		`{"type":"PUBLISH","payload":{}}`,
+		`{"type":"PUBLISH","payload":{"hex":"zz"}}`,
+		`{"type":"SUBACK","reasons":[256]}`,
+		`[]`,
+	} {
+		_, err := UnmarshalPacketJSON([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPacketString(t *testing.T) {
+	for _, p := range testPackets() {
+		s := p.(interface{ String() string }).String()
+		h, _ := headerByte(p)
+		assert.True(t, strings.HasPrefix(s, packetTypeName(h>>4)), s)
+		assert.NotContains(t, s, "pass", "%T", p)
+		assert.False(t, strings.HasSuffix(s, "\n"), s)
+	}
+
+	c := &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, ClientID: "c", PasswordFlag: true, Password: []byte("pass")}
+	assert.Equal(t, `CONNECT: ProtocolName:"MQTT" ProtocolVersion:5 ClientID:"c" KeepAlive:0 CleanStart:false Password:<redacted 4 bytes>`, c.String())
+
+	p := &Publish{Topic: "a", QoS: 1, PacketID: 1, Payload: []byte{0xff, 0x01}, Properties: &Properties{ContentType: "bin"}}
+	assert.Equal(t, "PUBLISH: PacketID:1 QOS:1 Topic:\"a\" Duplicate:false Retain:false Payload:0xFF01\nProperties:\n\tContentType:\"bin\"", p.String())
+
+	p.Payload = []byte(strings.Repeat("x", maxTextBinary+10))
+	assert.Contains(t, p.String(), `xx"...(266 bytes)`)
+
+	assert.Equal(t, "SUBACK: PacketID:1 Reasons:[0x00 0x87]", (&Suback{PacketID: 1, Reasons: []byte{0, 0x87}}).String())
+	assert.Equal(t, "PINGREQ", (&Pingreq{}).String())
+	assert.Equal(t, "UNSUBSCRIBE: PacketID:1\n\tTopic:\"a\"\n\tTopic:\"b\"", (&Unsubscribe{PacketID: 1, Topics: []string{"a", "b"}}).String())
+}
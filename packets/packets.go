@@ -2,12 +2,25 @@ package packets
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
 )
 
+const (
+	// maxVBILength is the maximum number of bytes in a variable byte integer
+	maxVBILength = 4
+	// maxPreallocate is the largest buffer allocated, when reading a packet,
+	// before the content has been received
+	maxPreallocate = 64 * 1024
+)
+
+// ErrInvalidVBI is returned when a variable byte integer is longer than four
+// bytes
+var ErrInvalidVBI = errors.New("variable byte integer exceeds 4 bytes")
+
 // PacketType is a type alias to byte representing the different
 // MQTT control packet types
 // type PacketType byte
@@ -33,6 +46,12 @@ const (
 	AUTH
 )
 
+// WILLPROPERTIES is not a control packet type; it is passed to the
+// Properties methods (Pack, Unpack, Validate etc) in place of a packet type
+// to select the properties permitted in the will properties of a CONNECT
+// packet
+const WILLPROPERTIES byte = 99
+
 type (
 	// Packet is the interface defining the unique parts of a controlpacket
 	Packet interface {
@@ -109,8 +128,21 @@ func (c *ControlPacket) PacketID() uint16 {
 	}
 }
 
+// Size returns the number of bytes that the packet occupied on the wire
+// (including the fixed header); it is only valid for packets returned by
+// ReadPacket or that have been written with WriteTo
+func (c *ControlPacket) Size() int {
+	return 1 + len(encodeVBI(c.remainingLength)) + c.remainingLength
+}
+
+// PacketType returns the name of the packet type (e.g. "PUBLISH")
 func (c *ControlPacket) PacketType() string {
-	return [...]string{
+	return packetTypeName(c.FixedHeader.Type)
+}
+
+// packetTypeName returns the name of packet type t
+func packetTypeName(t byte) string {
+	names := [...]string{
 		"",
 		"CONNECT",
 		"CONNACK",
@@ -127,7 +159,11 @@ func (c *ControlPacket) PacketType() string {
 		"PINGRESP",
 		"DISCONNECT",
 		"AUTH",
-	}[c.FixedHeader.Type]
+	}
+	if int(t) >= len(names) {
+		return ""
+	}
+	return names[t]
 }
 
 // NewControlPacket takes a packetType and returns a pointer to a
@@ -157,10 +193,7 @@ func NewControlPacket(t byte) *ControlPacket {
 		cp.Content = &Pubcomp{Properties: &Properties{}}
 	case SUBSCRIBE:
 		cp.Flags = 2
-		cp.Content = &Subscribe{
-			Subscriptions: make(map[string]SubOptions),
-			Properties:    &Properties{},
-		}
+		cp.Content = &Subscribe{Properties: &Properties{}}
 	case SUBACK:
 		cp.Content = &Suback{Properties: &Properties{}}
 	case UNSUBSCRIBE:
@@ -175,7 +208,6 @@ func NewControlPacket(t byte) *ControlPacket {
 	case DISCONNECT:
 		cp.Content = &Disconnect{Properties: &Properties{}}
 	case AUTH:
-		cp.Flags = 1
 		cp.Content = &Auth{Properties: &Properties{}}
 	default:
 		return nil
@@ -186,17 +218,40 @@ func NewControlPacket(t byte) *ControlPacket {
 // ReadPacket reads a control packet from a io.Reader and returns a completed
 // struct with the appropriate data
 func ReadPacket(r io.Reader) (*ControlPacket, error) {
-	t := [1]byte{}
-	_, err := io.ReadFull(r, t[:])
+	return readPacket(r, false)
+}
+
+// ReadPacketStrict reads a control packet from a io.Reader, as ReadPacket,
+// but also checks that the packet conforms to the specification. A packet
+// that cannot be decoded, or is not valid, results in a *ValidationError
+// whose ReasonCode can be used in the DISCONNECT sent in response; other
+// errors come from reading r.
+func ReadPacketStrict(r io.Reader) (*ControlPacket, error) {
+	return readPacket(r, true)
+}
+
+// readPacket implements ReadPacket and ReadPacketStrict using a pooled
+// decoder
+func readPacket(r io.Reader, strict bool) (*ControlPacket, error) {
+	d := decoderPool.Get().(*decoder)
+	defer decoderPool.Put(d)
+	return d.read(r, strict)
+}
+
+// read reads a packet from r using the decoder's buffers (which may be
+// reused once read returns)
+func (d *decoder) read(r io.Reader, strict bool) (*ControlPacket, error) {
+	defer d.trim()
+	t, err := d.readByte(r)
 	if err != nil {
 		return nil, err
 	}
-	// cp := NewControlPacket(PacketType(t[0] >> 4))
+	// cp := NewControlPacket(PacketType(t >> 4))
 	// if cp == nil {
-	// 	return nil, fmt.Errorf("invalid packet type requested, %d", t[0]>>4)
+	// 	return nil, fmt.Errorf("invalid packet type requested, %d", t>>4)
 	// }
 
-	pt := t[0] >> 4
+	pt := t >> 4
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: pt}}
 	switch pt {
 	case CONNECT:
@@ -220,10 +275,7 @@ func ReadPacket(r io.Reader) (*ControlPacket, error) {
 		cp.Content = &Pubcomp{Properties: &Properties{}}
 	case SUBSCRIBE:
 		cp.Flags = 2
-		cp.Content = &Subscribe{
-			Subscriptions: make(map[string]SubOptions),
-			Properties:    &Properties{},
-		}
+		cp.Content = &Subscribe{Properties: &Properties{}}
 	case SUBACK:
 		cp.Content = &Suback{Properties: &Properties{}}
 	case UNSUBSCRIBE:
@@ -238,38 +290,51 @@ func ReadPacket(r io.Reader) (*ControlPacket, error) {
 	case DISCONNECT:
 		cp.Content = &Disconnect{Properties: &Properties{}}
 	case AUTH:
-		cp.Flags = 1
 		cp.Content = &Auth{Properties: &Properties{}}
 	default:
+		if strict {
+			return nil, malformed(pt, "unknown packet type %d", pt)
+		}
 		return nil, fmt.Errorf("unknown packet type %d requested", pt)
 	}
 
-	cp.Flags = t[0] & 0xF
+	cp.Flags = t & 0xF
 	if cp.Type == PUBLISH {
-		cp.Content.(*Publish).QoS = (cp.Flags & 0x6) >> 1
+		pub := cp.Content.(*Publish)
+		pub.QoS = (cp.Flags & 0x6) >> 1
+		pub.Duplicate = cp.Flags&0x8 != 0
+		pub.Retain = cp.Flags&0x1 != 0
 	}
-	vbi, err := getVBI(r)
-	if err != nil {
-		return nil, err
+	cp.remainingLength, err = d.readVBI(r)
+	if err == ErrInvalidVBI && strict {
+		return nil, malformed(pt, "%s", err)
 	}
-	cp.remainingLength, err = decodeVBI(vbi)
 	if err != nil {
 		return nil, err
 	}
 
-	var content bytes.Buffer
-	content.Grow(cp.remainingLength)
-
-	n, err := io.CopyN(&content, r, int64(cp.remainingLength))
+	content, err := d.readContent(r, cp.remainingLength)
 	if err != nil {
 		return nil, err
 	}
-
-	if n != int64(cp.remainingLength) {
-		return nil, fmt.Errorf("failed to read packet, expected %d bytes, read %d", cp.remainingLength, n)
+	if pub, ok := cp.Content.(*Publish); ok {
+		err = pub.unpack(content, !d.reusePayload)
+	} else {
+		err = cp.Content.Unpack(content)
+	}
+	if !strict {
+		if err != nil {
+			return nil, err
+		}
+		return cp, nil
 	}
-	err = cp.Content.Unpack(&content)
 	if err != nil {
+		return nil, malformed(pt, "%s", err)
+	}
+	if content.Len() > 0 {
+		return nil, malformed(pt, "%d unexpected bytes at end of packet", content.Len())
+	}
+	if err = cp.Validate(); err != nil {
 		return nil, err
 	}
 	return cp, nil
@@ -279,6 +344,7 @@ func ReadPacket(r io.Reader) (*ControlPacket, error) {
 // a control packet.
 func (c *ControlPacket) WriteTo(w io.Writer) (int64, error) {
 	buffers := c.Content.Buffers()
+	c.remainingLength = 0 // the packet may have been read, or written, before
 	for _, b := range buffers {
 		c.remainingLength += len(b)
 	}
@@ -332,27 +398,19 @@ func encodeVBIdirect(length int, buf *bytes.Buffer) {
 	}
 }
 
-func getVBI(r io.Reader) (*bytes.Buffer, error) {
-	var ret bytes.Buffer
-	digit := [1]byte{}
-	for {
-		_, err := io.ReadFull(r, digit[:])
-		if err != nil {
-			return nil, err
-		}
-		ret.WriteByte(digit[0])
-		if digit[0] <= 0x7f {
-			return &ret, nil
-		}
-	}
-}
-
+// decodeVBI decodes a variable byte integer, of at most four bytes, from r
 func decodeVBI(r *bytes.Buffer) (int, error) {
 	var vbi uint32
 	var multiplier uint32
-	for {
+	for i := 0; ; i++ {
+		if i == maxVBILength {
+			return 0, ErrInvalidVBI
+		}
 		digit, err := r.ReadByte()
-		if err != nil && err != io.EOF {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
 			return 0, err
 		}
 		vbi |= uint32(digit&127) << multiplier
@@ -431,17 +489,23 @@ func readBinary(b *bytes.Buffer) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	var s bytes.Buffer
-	s.Grow(int(size))
-	if _, err := io.CopyN(&s, b, int64(size)); err != nil {
-		return nil, err
+	if int(size) > b.Len() {
+		return nil, io.ErrUnexpectedEOF
 	}
 
-	return s.Bytes(), nil
+	s := make([]byte, size)
+	copy(s, b.Next(int(size)))
+	return s, nil
 }
 
 func readString(b *bytes.Buffer) (string, error) {
-	s, err := readBinary(b)
-	return string(s), err
+	size, err := readUint16(b)
+	if err != nil {
+		return "", err
+	}
+	if int(size) > b.Len() {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	return string(b.Next(int(size))), nil
 }
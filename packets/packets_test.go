@@ -3,9 +3,11 @@ package packets
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"net"
 	"reflect"
+	"runtime"
 	"sync"
 	"testing"
 
@@ -82,7 +84,7 @@ func TestDecodeVBI12(t *testing.T) {
 }
 
 func TestDecodeVBI127(t *testing.T) {
-	x, err := decodeVBI(bytes.NewBuffer([]byte{0xff}))
+	x, err := decodeVBI(bytes.NewBuffer([]byte{0x7f}))
 
 	require.Nil(t, err)
 	assert.Equal(t, 127, x)
@@ -106,6 +108,67 @@ func TestDecodeVBIMax(t *testing.T) {
 	assert.Equal(t, 268435455, x)
 }
 
+func TestDecodeVBIInvalid(t *testing.T) {
+	_, err := decodeVBI(bytes.NewBuffer([]byte{0xff, 0xff, 0xff, 0xff, 0x01}))
+	assert.Equal(t, ErrInvalidVBI, err)
+
+	_, err = decodeVBI(bytes.NewBuffer([]byte{0xff}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadPacketInvalidVBI(t *testing.T) {
+	data := []byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}
+	_, err := ReadPacket(bytes.NewReader(data))
+	assert.Equal(t, ErrInvalidVBI, err)
+
+	_, err = ReadPacketStrict(bytes.NewReader(data))
+	assert.True(t, errors.Is(err, ErrMalformedPacket))
+}
+
+func TestReadPacketLargeRemainingLength(t *testing.T) {
+	// The maximum remaining length is declared but little content follows; the
+	// declared length must not be allocated up front
+	data := []byte{0x30, 0xff, 0xff, 0xff, 0x7f, 0x00, 0x01, 'a'}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ReadPacket(bytes.NewReader(data))
+	runtime.ReadMemStats(&after)
+
+	assert.Error(t, err)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
+
+func TestReadBinaryTruncated(t *testing.T) {
+	_, err := readBinary(bytes.NewBuffer([]byte{0x00, 0x05, 'a'}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestPropertiesUnpackTruncated(t *testing.T) {
+	// Declares 10 bytes of properties but only 2 are present
+	var p Properties
+	err := p.Unpack(bytes.NewBuffer([]byte{0x0a, PropReceiveMaximum, 0x00}), CONNECT)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// A truncated subscription identifier
+	err = p.Unpack(bytes.NewBuffer([]byte{0x02, PropSubscriptionIdentifier, 0x80}), PUBLISH)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestWriteToAfterRead(t *testing.T) {
+	// Writing a packet that was read must not include the remaining length
+	// from the original packet
+	data := []byte{0x30, 0x06, 0x00, 0x01, 'a', 0x00, 'h', 'i'}
+	cp, err := ReadPacket(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		var b bytes.Buffer
+		_, err = cp.WriteTo(&b)
+		require.NoError(t, err)
+		assert.Equal(t, data, b.Bytes())
+	}
+}
+
 func TestNewControlPacketConnect(t *testing.T) {
 	var b bytes.Buffer
 	x := NewControlPacket(CONNECT)
@@ -138,6 +201,46 @@ func TestReadPacketConnect(t *testing.T) {
 	assert.Equal(t, uint32(30), *c.Content.(*Connect).Properties.SessionExpiryInterval)
 }
 
+func TestConnectWillPropertiesRoundTrip(t *testing.T) {
+	pf, expiry, delay := byte(1), uint32(60), uint32(5)
+	c := &Connect{
+		ProtocolName:    "MQTT",
+		ProtocolVersion: 5,
+		ClientID:        "testClient",
+		WillFlag:        true,
+		WillTopic:       "will/topic",
+		WillMessage:     []byte("offline"),
+		Properties:      &Properties{},
+		WillProperties: &Properties{
+			PayloadFormat:     &pf,
+			MessageExpiry:     &expiry,
+			ContentType:       "text/plain",
+			ResponseTopic:     "response/topic",
+			CorrelationData:   []byte("correlation"),
+			WillDelayInterval: &delay,
+			User:              []User{{"k", "v"}},
+		},
+	}
+	var b bytes.Buffer
+	_, err := c.WriteTo(&b)
+	require.NoError(t, err)
+
+	cp, err := ReadPacketStrict(&b)
+	require.NoError(t, err)
+	assert.Equal(t, c.WillProperties, cp.Content.(*Connect).WillProperties)
+	assert.Nil(t, cp.Content.(*Connect).Properties.WillDelayInterval)
+
+	// Properties that are not permitted in the will are not sent
+	alias := uint16(1)
+	c.WillProperties = &Properties{TopicAlias: &alias, AuthMethod: "method", ReasonString: "reason"}
+	b.Reset()
+	_, err = c.WriteTo(&b)
+	require.NoError(t, err)
+	cp, err = ReadPacket(&b)
+	require.NoError(t, err)
+	assert.Equal(t, &Properties{}, cp.Content.(*Connect).WillProperties)
+}
+
 func TestReadStringWriteString(t *testing.T) {
 	var b bytes.Buffer
 	writeString("Test string", &b)
@@ -147,6 +250,46 @@ func TestReadStringWriteString(t *testing.T) {
 	assert.Equal(t, "Test string", s)
 }
 
+func TestSubscribeOrder(t *testing.T) {
+	var b bytes.Buffer
+	s := &Subscribe{
+		PacketID:   1,
+		Properties: &Properties{},
+		Subscriptions: []SubOptions{
+			{Topic: "c/#", QoS: 2, NoLocal: true},
+			{Topic: "a/b", QoS: 0, RetainAsPublished: true},
+			{Topic: "b/+", QoS: 1},
+		},
+	}
+
+	_, err := s.WriteTo(&b)
+	require.Nil(t, err)
+
+	c, err := ReadPacket(&b)
+	require.Nil(t, err)
+	assert.Equal(t, s.Subscriptions, c.Content.(*Subscribe).Subscriptions)
+}
+
+func TestReadPacketPublishFlags(t *testing.T) {
+	var b bytes.Buffer
+	p := &Publish{
+		PacketID:   1,
+		Topic:      "a/b",
+		QoS:        1,
+		Duplicate:  true,
+		Retain:     true,
+		Properties: &Properties{},
+		Payload:    []byte("payload"),
+	}
+
+	_, err := p.WriteTo(&b)
+	require.Nil(t, err)
+
+	c, err := ReadPacket(&b)
+	require.Nil(t, err)
+	assert.Equal(t, p, c.Content.(*Publish))
+}
+
 func TestNewControlPacket(t *testing.T) {
 	tests := []struct {
 		name string
@@ -218,10 +361,7 @@ func TestNewControlPacket(t *testing.T) {
 			args: SUBSCRIBE,
 			want: &ControlPacket{
 				FixedHeader: FixedHeader{Type: SUBSCRIBE, Flags: 2},
-				Content: &Subscribe{
-					Properties:    &Properties{},
-					Subscriptions: make(map[string]SubOptions),
-				},
+				Content:     &Subscribe{Properties: &Properties{}},
 			},
 		},
 		{
@@ -276,7 +416,7 @@ func TestNewControlPacket(t *testing.T) {
 			name: "auth",
 			args: AUTH,
 			want: &ControlPacket{
-				FixedHeader: FixedHeader{Type: AUTH, Flags: 1},
+				FixedHeader: FixedHeader{Type: AUTH},
 				Content:     &Auth{Properties: &Properties{}},
 			},
 		},
@@ -2,7 +2,6 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
 )
@@ -12,7 +11,7 @@ type Pingreq struct {
 }
 
 func (p *Pingreq) String() string {
-	return fmt.Sprintf("PINGREQ")
+	return newText(PINGREQ).String()
 }
 
 //Unpack is the implementation of the interface required function for a packet
@@ -32,3 +31,8 @@ func (p *Pingreq) WriteTo(w io.Writer) (int64, error) {
 
 	return cp.WriteTo(w)
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (p *Pingreq) Validate() error {
+	return nil
+}
@@ -2,7 +2,6 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
 )
@@ -12,7 +11,7 @@ type Pingresp struct {
 }
 
 func (p *Pingresp) String() string {
-	return fmt.Sprintf("PINGRESP")
+	return newText(PINGRESP).String()
 }
 
 //Unpack is the implementation of the interface required function for a packet
@@ -32,3 +31,8 @@ func (p *Pingresp) WriteTo(w io.Writer) (int64, error) {
 
 	return cp.WriteTo(w)
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (p *Pingresp) Validate() error {
+	return nil
+}
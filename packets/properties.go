@@ -139,7 +139,11 @@ type Properties struct {
 	SharedSubAvailable *byte
 }
 
+// String returns the properties that are set, one per line
 func (p *Properties) String() string {
+	if p == nil {
+		return ""
+	}
 	var b strings.Builder
 	if p.PayloadFormat != nil {
 		fmt.Fprintf(&b, "\tPayloadFormat:%d\n", *p.PayloadFormat)
@@ -148,13 +152,13 @@ func (p *Properties) String() string {
 		fmt.Fprintf(&b, "\tMessageExpiry:%d\n", *p.MessageExpiry)
 	}
 	if p.ContentType != "" {
-		fmt.Fprintf(&b, "\tContentType:%s\n", p.ContentType)
+		fmt.Fprintf(&b, "\tContentType:%q\n", p.ContentType)
 	}
 	if p.ResponseTopic != "" {
-		fmt.Fprintf(&b, "\tResponseTopic:%s\n", p.ResponseTopic)
+		fmt.Fprintf(&b, "\tResponseTopic:%q\n", p.ResponseTopic)
 	}
 	if len(p.CorrelationData) > 0 {
-		fmt.Fprintf(&b, "\tCorrelationData:%X\n", p.CorrelationData)
+		fmt.Fprintf(&b, "\tCorrelationData:%s\n", formatBinary(p.CorrelationData))
 	}
 	if p.SubscriptionIdentifier != nil {
 		fmt.Fprintf(&b, "\tSubscriptionIdentifier:%d\n", *p.SubscriptionIdentifier)
@@ -163,16 +167,16 @@ func (p *Properties) String() string {
 		fmt.Fprintf(&b, "\tSessionExpiryInterval:%d\n", *p.SessionExpiryInterval)
 	}
 	if p.AssignedClientID != "" {
-		fmt.Fprintf(&b, "\tAssignedClientID:%s\n", p.AssignedClientID)
+		fmt.Fprintf(&b, "\tAssignedClientID:%q\n", p.AssignedClientID)
 	}
 	if p.ServerKeepAlive != nil {
 		fmt.Fprintf(&b, "\tServerKeepAlive:%d\n", *p.ServerKeepAlive)
 	}
 	if p.AuthMethod != "" {
-		fmt.Fprintf(&b, "\tAuthMethod:%s\n", p.AuthMethod)
+		fmt.Fprintf(&b, "\tAuthMethod:%q\n", p.AuthMethod)
 	}
 	if len(p.AuthData) > 0 {
-		fmt.Fprintf(&b, "\tAuthData:%X\n", p.AuthData)
+		fmt.Fprintf(&b, "\tAuthData:%s\n", formatSecret(p.AuthData))
 	}
 	if p.RequestProblemInfo != nil {
 		fmt.Fprintf(&b, "\tRequestProblemInfo:%d\n", *p.RequestProblemInfo)
@@ -183,11 +187,14 @@ func (p *Properties) String() string {
 	if p.RequestResponseInfo != nil {
 		fmt.Fprintf(&b, "\tRequestResponseInfo:%d\n", *p.RequestResponseInfo)
 	}
+	if p.ResponseInfo != "" {
+		fmt.Fprintf(&b, "\tResponseInfo:%q\n", p.ResponseInfo)
+	}
 	if p.ServerReference != "" {
-		fmt.Fprintf(&b, "\tServerReference:%s\n", p.ServerReference)
+		fmt.Fprintf(&b, "\tServerReference:%q\n", p.ServerReference)
 	}
 	if p.ReasonString != "" {
-		fmt.Fprintf(&b, "\tReasonString:%s\n", p.ReasonString)
+		fmt.Fprintf(&b, "\tReasonString:%q\n", p.ReasonString)
 	}
 	if p.ReceiveMaximum != nil {
 		fmt.Fprintf(&b, "\tReceiveMaximum:%d\n", *p.ReceiveMaximum)
@@ -219,7 +226,7 @@ func (p *Properties) String() string {
 	if len(p.User) > 0 {
 		fmt.Fprint(&b, "\tUser Properties:\n")
 		for _, v := range p.User {
-			fmt.Fprintf(&b, "\t\t%s:%s\n", v.Key, v.Value)
+			fmt.Fprintf(&b, "\t\t%q:%q\n", v.Key, v.Value)
 		}
 	}
 
@@ -235,7 +242,7 @@ func (i *Properties) Pack(p byte) []byte {
 		return nil
 	}
 
-	if p == PUBLISH {
+	if p == PUBLISH || p == WILLPROPERTIES {
 		if i.PayloadFormat != nil {
 			b.WriteByte(PropPayloadFormat)
 			b.WriteByte(*i.PayloadFormat)
@@ -261,6 +268,9 @@ func (i *Properties) Pack(p byte) []byte {
 			writeBinary(i.CorrelationData, &b)
 		}
 
+	}
+
+	if p == PUBLISH {
 		if i.TopicAlias != nil {
 			b.WriteByte(PropTopicAlias)
 			writeUint16(*i.TopicAlias, &b)
@@ -339,17 +349,19 @@ func (i *Properties) Pack(p byte) []byte {
 			b.WriteByte(*i.RequestProblemInfo)
 		}
 
-		if i.WillDelayInterval != nil {
-			b.WriteByte(PropWillDelayInterval)
-			writeUint32(*i.WillDelayInterval, &b)
-		}
-
 		if i.RequestResponseInfo != nil {
 			b.WriteByte(PropRequestResponseInfo)
 			b.WriteByte(*i.RequestResponseInfo)
 		}
 	}
 
+	if p == WILLPROPERTIES {
+		if i.WillDelayInterval != nil {
+			b.WriteByte(PropWillDelayInterval)
+			writeUint32(*i.WillDelayInterval, &b)
+		}
+	}
+
 	if p == CONNECT || p == CONNACK || p == DISCONNECT {
 		if i.SessionExpiryInterval != nil {
 			b.WriteByte(PropSessionExpiryInterval)
@@ -376,7 +388,7 @@ func (i *Properties) Pack(p byte) []byte {
 		}
 	}
 
-	if p != CONNECT {
+	if p != CONNECT && p != WILLPROPERTIES {
 		if i.ReasonString != "" {
 			b.WriteByte(PropReasonString)
 			writeString(i.ReasonString, &b)
@@ -403,7 +415,7 @@ func (i *Properties) PackBuf(p byte) *bytes.Buffer {
 		return nil
 	}
 
-	if p == PUBLISH {
+	if p == PUBLISH || p == WILLPROPERTIES {
 		if i.PayloadFormat != nil {
 			b.WriteByte(PropPayloadFormat)
 			b.WriteByte(*i.PayloadFormat)
@@ -429,6 +441,9 @@ func (i *Properties) PackBuf(p byte) *bytes.Buffer {
 			writeBinary(i.CorrelationData, &b)
 		}
 
+	}
+
+	if p == PUBLISH {
 		if i.TopicAlias != nil {
 			b.WriteByte(PropTopicAlias)
 			writeUint16(*i.TopicAlias, &b)
@@ -507,17 +522,19 @@ func (i *Properties) PackBuf(p byte) *bytes.Buffer {
 			b.WriteByte(*i.RequestProblemInfo)
 		}
 
-		if i.WillDelayInterval != nil {
-			b.WriteByte(PropWillDelayInterval)
-			writeUint32(*i.WillDelayInterval, &b)
-		}
-
 		if i.RequestResponseInfo != nil {
 			b.WriteByte(PropRequestResponseInfo)
 			b.WriteByte(*i.RequestResponseInfo)
 		}
 	}
 
+	if p == WILLPROPERTIES {
+		if i.WillDelayInterval != nil {
+			b.WriteByte(PropWillDelayInterval)
+			writeUint32(*i.WillDelayInterval, &b)
+		}
+	}
+
 	if p == CONNECT || p == CONNACK || p == DISCONNECT {
 		if i.SessionExpiryInterval != nil {
 			b.WriteByte(PropSessionExpiryInterval)
@@ -544,7 +561,7 @@ func (i *Properties) PackBuf(p byte) *bytes.Buffer {
 		}
 	}
 
-	if p != CONNECT {
+	if p != CONNECT && p != WILLPROPERTIES {
 		if i.ReasonString != "" {
 			b.WriteByte(PropReasonString)
 			writeString(i.ReasonString, &b)
@@ -564,17 +581,16 @@ func (i *Properties) PackBuf(p byte) *bytes.Buffer {
 // filling in the appropriate entries in the struct, it returns the number
 // of bytes used to store the Prop data and any error in decoding them
 func (i *Properties) Unpack(r *bytes.Buffer, p byte) error {
-	vbi, err := getVBI(r)
-	if err != nil {
-		return err
-	}
-	size, err := decodeVBI(vbi)
+	size, err := decodeVBI(r)
 	if err != nil {
 		return err
 	}
 	if size == 0 {
 		return nil
 	}
+	if size > r.Len() {
+		return io.ErrUnexpectedEOF
+	}
 
 	buf := bytes.NewBuffer(r.Next(size))
 	for {
@@ -764,13 +780,14 @@ func (i *Properties) Unpack(r *bytes.Buffer, p byte) error {
 }
 
 // ValidProperties is a map of the various properties and the
-// PacketTypes that property is valid for.
+// PacketTypes that property is valid for (WILLPROPERTIES is used for
+// the will properties of a CONNECT packet).
 var ValidProperties = map[byte]map[byte]struct{}{
-	PropPayloadFormat:          {PUBLISH: {}},
-	PropMessageExpiry:          {PUBLISH: {}},
-	PropContentType:            {PUBLISH: {}},
-	PropResponseTopic:          {PUBLISH: {}},
-	PropCorrelationData:        {PUBLISH: {}},
+	PropPayloadFormat:          {PUBLISH: {}, WILLPROPERTIES: {}},
+	PropMessageExpiry:          {PUBLISH: {}, WILLPROPERTIES: {}},
+	PropContentType:            {PUBLISH: {}, WILLPROPERTIES: {}},
+	PropResponseTopic:          {PUBLISH: {}, WILLPROPERTIES: {}},
+	PropCorrelationData:        {PUBLISH: {}, WILLPROPERTIES: {}},
 	PropTopicAlias:             {PUBLISH: {}},
 	PropSubscriptionIdentifier: {PUBLISH: {}, SUBSCRIBE: {}},
 	PropSessionExpiryInterval:  {CONNECT: {}, CONNACK: {}, DISCONNECT: {}},
@@ -784,7 +801,7 @@ var ValidProperties = map[byte]map[byte]struct{}{
 	PropAuthMethod:             {CONNECT: {}, CONNACK: {}, AUTH: {}},
 	PropAuthData:               {CONNECT: {}, CONNACK: {}, AUTH: {}},
 	PropRequestProblemInfo:     {CONNECT: {}},
-	PropWillDelayInterval:      {CONNECT: {}},
+	PropWillDelayInterval:      {WILLPROPERTIES: {}},
 	PropRequestResponseInfo:    {CONNECT: {}},
 	PropServerReference:        {CONNACK: {}, DISCONNECT: {}},
 	PropReasonString:           {CONNACK: {}, PUBACK: {}, PUBREC: {}, PUBREL: {}, PUBCOMP: {}, SUBACK: {}, UNSUBACK: {}, DISCONNECT: {}, AUTH: {}},
@@ -792,7 +809,7 @@ var ValidProperties = map[byte]map[byte]struct{}{
 	PropTopicAliasMaximum:      {CONNECT: {}, CONNACK: {}},
 	PropMaximumQOS:             {CONNECT: {}, CONNACK: {}},
 	PropMaximumPacketSize:      {CONNECT: {}, CONNACK: {}},
-	PropUser:                   {CONNECT: {}, CONNACK: {}, PUBLISH: {}, PUBACK: {}, PUBREC: {}, PUBREL: {}, PUBCOMP: {}, SUBSCRIBE: {}, UNSUBSCRIBE: {}, SUBACK: {}, UNSUBACK: {}, DISCONNECT: {}, AUTH: {}},
+	PropUser:                   {CONNECT: {}, CONNACK: {}, PUBLISH: {}, PUBACK: {}, PUBREC: {}, PUBREL: {}, PUBCOMP: {}, SUBSCRIBE: {}, UNSUBSCRIBE: {}, SUBACK: {}, UNSUBACK: {}, DISCONNECT: {}, AUTH: {}, WILLPROPERTIES: {}},
 }
 
 // ValidateID takes a PacketType and a property name and returns
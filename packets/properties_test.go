@@ -66,8 +66,12 @@ func TestPropertiess(t *testing.T) {
 		t.Fatalf("'requestProblemInfo' is valid for 'CONNECT' packets")
 	}
 
-	if !ValidateID(CONNECT, PropWillDelayInterval) {
-		t.Fatalf("'willDelayInterval' is valid for 'CONNECT' packets")
+	if !ValidateID(WILLPROPERTIES, PropWillDelayInterval) {
+		t.Fatalf("'willDelayInterval' is valid for will properties")
+	}
+
+	if ValidateID(CONNECT, PropWillDelayInterval) {
+		t.Fatalf("'willDelayInterval' is only valid in the will properties of 'CONNECT' packets")
 	}
 
 	if !ValidateID(CONNECT, PropRequestResponseInfo) {
@@ -154,5 +158,5 @@ func BenchmarkPropertyCreationStruct(b *testing.B) {
 			CorrelationData: []byte("corelid"),
 		}
 	}
-	fmt.Sprintln(p)
+	_ = fmt.Sprintln(p)
 }
@@ -2,10 +2,8 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
-	"strings"
 )
 
 // Puback is the Variable Header definition for a Puback control packet
@@ -29,16 +27,8 @@ const (
 )
 
 func (p *Puback) String() string {
-	var b strings.Builder
-
-	fmt.Fprintf(&b, "PUBACK: PacketID:%d ReasonCode:%X", p.PacketID, p.ReasonCode)
-	if p.Properties != nil {
-		fmt.Fprintf(&b, " Properties:\n%s", p.Properties)
-	} else {
-		fmt.Fprint(&b, "\n")
-	}
-
-	return b.String()
+	return newText(PUBACK).field("PacketID", p.PacketID).reason("ReasonCode", p.ReasonCode).
+		properties("Properties", p.Properties).String()
 }
 
 //Unpack is the implementation of the interface required function for a packet
@@ -113,3 +103,11 @@ func (p *Puback) Reason() string {
 
 	return ""
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (p *Puback) Validate() error {
+	if err := validatePacketID(PUBACK, p.PacketID); err != nil {
+		return err
+	}
+	return p.Properties.Validate(PUBACK)
+}
@@ -2,10 +2,8 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
-	"strings"
 )
 
 // Pubcomp is the Variable Header definition for a Pubcomp control packet
@@ -22,16 +20,8 @@ const (
 )
 
 func (p *Pubcomp) String() string {
-	var b strings.Builder
-
-	fmt.Fprintf(&b, "PUBCOMP: ReasonCode:%X PacketID:%d", p.ReasonCode, p.PacketID)
-	if p.Properties != nil {
-		fmt.Fprintf(&b, " Properties:\n%s", p.Properties)
-	} else {
-		fmt.Fprint(&b, "\n")
-	}
-
-	return b.String()
+	return newText(PUBCOMP).field("PacketID", p.PacketID).reason("ReasonCode", p.ReasonCode).
+		properties("Properties", p.Properties).String()
 }
 
 //Unpack is the implementation of the interface required function for a packet
@@ -93,3 +83,11 @@ func (p *Pubcomp) Reason() string {
 
 	return ""
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (p *Pubcomp) Validate() error {
+	if err := validatePacketID(PUBCOMP, p.PacketID); err != nil {
+		return err
+	}
+	return p.Properties.Validate(PUBCOMP)
+}
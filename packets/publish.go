@@ -2,9 +2,7 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 )
 
@@ -20,11 +18,19 @@ type Publish struct {
 }
 
 func (p *Publish) String() string {
-	return fmt.Sprintf("PUBLISH: PacketID:%d QOS:%d Topic:%s Duplicate:%t Retain:%t Payload:\n%s\nProperties\n%s", p.PacketID, p.QoS, p.Topic, p.Duplicate, p.Retain, string(p.Payload), p.Properties)
+	return newText(PUBLISH).field("PacketID", p.PacketID).field("QOS", p.QoS).field("Topic", p.Topic).
+		field("Duplicate", p.Duplicate).field("Retain", p.Retain).binary("Payload", p.Payload).
+		properties("Properties", p.Properties).String()
 }
 
 //Unpack is the implementation of the interface required function for a packet
 func (p *Publish) Unpack(r *bytes.Buffer) error {
+	return p.unpack(r, true)
+}
+
+// unpack decodes the packet from r; the payload refers to the memory held by
+// r unless copyPayload is set
+func (p *Publish) unpack(r *bytes.Buffer, copyPayload bool) error {
 	var err error
 	p.Topic, err = readString(r)
 	if err != nil {
@@ -42,10 +48,13 @@ func (p *Publish) Unpack(r *bytes.Buffer) error {
 		return err
 	}
 
-	p.Payload, err = ioutil.ReadAll(r)
-	if err != nil {
-		return err
+	if !copyPayload {
+		p.Payload = r.Next(r.Len())
+		return nil
 	}
+	// The payload is copied, as the buffer may be reused for the next packet
+	p.Payload = make([]byte, r.Len())
+	copy(p.Payload, r.Next(r.Len()))
 
 	return nil
 }
@@ -65,6 +74,14 @@ func (p *Publish) Buffers() net.Buffers {
 
 // WriteTo is the implementation of the interface required function for a packet
 func (p *Publish) WriteTo(w io.Writer) (int64, error) {
+	cp := &ControlPacket{FixedHeader: FixedHeader{Type: PUBLISH, Flags: p.flags()}}
+	cp.Content = p
+
+	return cp.WriteTo(w)
+}
+
+// flags returns the fixed header flags for the packet
+func (p *Publish) flags() byte {
 	f := p.QoS << 1
 	if p.Duplicate {
 		f |= 1 << 3
@@ -72,9 +89,29 @@ func (p *Publish) WriteTo(w io.Writer) (int64, error) {
 	if p.Retain {
 		f |= 1
 	}
+	return f
+}
 
-	cp := &ControlPacket{FixedHeader: FixedHeader{Type: PUBLISH, Flags: f}}
-	cp.Content = p
-
-	return cp.WriteTo(w)
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (p *Publish) Validate() error {
+	if p.QoS > 2 {
+		return malformed(PUBLISH, "invalid QoS %d", p.QoS)
+	}
+	if p.QoS == 0 {
+		if p.Duplicate {
+			return protocolError(PUBLISH, "duplicate flag set on a QoS 0 message")
+		}
+	} else if err := validatePacketID(PUBLISH, p.PacketID); err != nil {
+		return err
+	}
+	if !validString(p.Topic) {
+		return malformed(PUBLISH, "topic is not a valid UTF-8 string")
+	}
+	if !validTopicName(p.Topic) {
+		return protocolError(PUBLISH, "topic %q contains wildcards", p.Topic)
+	}
+	if p.Topic == "" && (p.Properties == nil || p.Properties.TopicAlias == nil) {
+		return protocolError(PUBLISH, "no topic or topic alias")
+	}
+	return p.Properties.Validate(PUBLISH)
 }
@@ -2,10 +2,8 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
-	"strings"
 )
 
 // Pubrec is the Variable Header definition for a Pubrec control packet
@@ -29,16 +27,8 @@ const (
 )
 
 func (p *Pubrec) String() string {
-	var b strings.Builder
-
-	fmt.Fprintf(&b, "PUBREC: ReasonCode:%X PacketID:%d", p.ReasonCode, p.PacketID)
-	if p.Properties != nil {
-		fmt.Fprintf(&b, " Properties:\n%s", p.Properties)
-	} else {
-		fmt.Fprint(&b, "\n")
-	}
-
-	return b.String()
+	return newText(PUBREC).field("PacketID", p.PacketID).reason("ReasonCode", p.ReasonCode).
+		properties("Properties", p.Properties).String()
 }
 
 //Unpack is the implementation of the interface required function for a packet
@@ -115,3 +105,11 @@ func (p *Pubrec) Reason() string {
 
 	return ""
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (p *Pubrec) Validate() error {
+	if err := validatePacketID(PUBREC, p.PacketID); err != nil {
+		return err
+	}
+	return p.Properties.Validate(PUBREC)
+}
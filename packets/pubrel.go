@@ -2,10 +2,8 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
-	"strings"
 )
 
 // Pubrel is the Variable Header definition for a Pubrel control packet
@@ -16,16 +14,8 @@ type Pubrel struct {
 }
 
 func (p *Pubrel) String() string {
-	var b strings.Builder
-
-	fmt.Fprintf(&b, "PUBREL: ReasonCode:%X PacketID:%d", p.ReasonCode, p.PacketID)
-	if p.Properties != nil {
-		fmt.Fprintf(&b, " Properties:\n%s", p.Properties)
-	} else {
-		fmt.Fprint(&b, "\n")
-	}
-
-	return b.String()
+	return newText(PUBREL).field("PacketID", p.PacketID).reason("ReasonCode", p.ReasonCode).
+		properties("Properties", p.Properties).String()
 }
 
 //Unpack is the implementation of the interface required function for a packet
@@ -75,3 +65,11 @@ func (p *Pubrel) WriteTo(w io.Writer) (int64, error) {
 
 	return cp.WriteTo(w)
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (p *Pubrel) Validate() error {
+	if err := validatePacketID(PUBREL, p.PacketID); err != nil {
+		return err
+	}
+	return p.Properties.Validate(PUBREL)
+}
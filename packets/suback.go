@@ -2,7 +2,6 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
 )
@@ -15,7 +14,8 @@ type Suback struct {
 }
 
 func (s *Suback) String() string {
-	return fmt.Sprintf("SUBACK: ReasonCode:%v PacketID:%d Properties:\n%s", s.Reasons, s.PacketID, s.Properties)
+	return newText(SUBACK).field("PacketID", s.PacketID).reasons("Reasons", s.Reasons).
+		properties("Properties", s.Properties).String()
 }
 
 // SubackGrantedQoS0, etc are the list of valid suback reason codes.
@@ -47,7 +47,7 @@ func (s *Suback) Unpack(r *bytes.Buffer) error {
 		return err
 	}
 
-	s.Reasons = r.Bytes()
+	s.Reasons = append([]byte(nil), r.Bytes()...)
 
 	return nil
 }
@@ -101,3 +101,11 @@ func (s *Suback) Reason(index int) string {
 	}
 	return "Invalid Reason index"
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (s *Suback) Validate() error {
+	if err := validatePacketID(SUBACK, s.PacketID); err != nil {
+		return err
+	}
+	return s.Properties.Validate(SUBACK)
+}
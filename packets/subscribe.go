@@ -2,7 +2,6 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
 	"strings"
@@ -11,24 +10,24 @@ import (
 // Subscribe is the Variable Header definition for a Subscribe control packet
 type Subscribe struct {
 	Properties    *Properties
-	Subscriptions map[string]SubOptions
+	Subscriptions []SubOptions
 	PacketID      uint16
 }
 
 func (s *Subscribe) String() string {
-	var b strings.Builder
-
-	fmt.Fprintf(&b, "SUBSCRIBE: PacketID:%d Subscriptions:\n", s.PacketID)
-	for sub, o := range s.Subscriptions {
-		fmt.Fprintf(&b, "\t%s: QOS:%d RetainHandling:%X NoLocal:%t RetainAsPublished:%t\n", sub, o.QoS, o.RetainHandling, o.NoLocal, o.RetainAsPublished)
+	t := newText(SUBSCRIBE).field("PacketID", s.PacketID)
+	for _, o := range s.Subscriptions {
+		t.line().field("Topic", o.Topic).field("QOS", o.QoS).field("RetainHandling", o.RetainHandling>>4).
+			field("NoLocal", o.NoLocal).field("RetainAsPublished", o.RetainAsPublished)
 	}
-	fmt.Fprintf(&b, "Properties:\n%s", s.Properties)
 
-	return b.String()
+	return t.properties("Properties", s.Properties).String()
 }
 
-// SubOptions is the struct representing the options for a subscription
+// SubOptions is the struct representing the options for a subscription,
+// Topic is the topic filter the options apply to
 type SubOptions struct {
+	Topic             string
 	QoS               byte
 	RetainHandling    byte
 	NoLocal           bool
@@ -58,8 +57,8 @@ func (s *SubOptions) Unpack(r *bytes.Buffer) error {
 	}
 
 	s.QoS = b & 0x03
-	s.NoLocal = (b & 1 << 2) == 1
-	s.RetainAsPublished = (b & 1 << 3) == 1
+	s.NoLocal = (b & (1 << 2)) != 0
+	s.RetainAsPublished = (b & (1 << 3)) != 0
 	s.RetainHandling = b & 0x30
 
 	return nil
@@ -87,7 +86,8 @@ func (s *Subscribe) Unpack(r *bytes.Buffer) error {
 		if err = so.Unpack(r); err != nil {
 			return err
 		}
-		s.Subscriptions[t] = so
+		so.Topic = t
+		s.Subscriptions = append(s.Subscriptions, so)
 	}
 
 	return nil
@@ -98,8 +98,8 @@ func (s *Subscribe) Buffers() net.Buffers {
 	var b bytes.Buffer
 	writeUint16(s.PacketID, &b)
 	var subs bytes.Buffer
-	for t, o := range s.Subscriptions {
-		writeString(t, &subs)
+	for _, o := range s.Subscriptions {
+		writeString(o.Topic, &subs)
 		subs.WriteByte(o.Pack())
 	}
 	idvp := s.Properties.Pack(SUBSCRIBE)
@@ -114,3 +114,25 @@ func (s *Subscribe) WriteTo(w io.Writer) (int64, error) {
 
 	return cp.WriteTo(w)
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (s *Subscribe) Validate() error {
+	if err := validatePacketID(SUBSCRIBE, s.PacketID); err != nil {
+		return err
+	}
+	if len(s.Subscriptions) == 0 {
+		return protocolError(SUBSCRIBE, "no subscriptions")
+	}
+	for _, o := range s.Subscriptions {
+		if o.QoS > 2 || o.RetainHandling&^0x30 != 0 || o.RetainHandling == 0x30 {
+			return malformed(SUBSCRIBE, "invalid subscription options for %q", o.Topic)
+		}
+		if err := validateFilter(SUBSCRIBE, o.Topic); err != nil {
+			return err
+		}
+		if o.NoLocal && strings.HasPrefix(o.Topic, "$share/") {
+			return protocolError(SUBSCRIBE, "no local set on shared subscription %q", o.Topic)
+		}
+	}
+	return s.Properties.Validate(SUBSCRIBE)
+}
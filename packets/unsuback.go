@@ -2,7 +2,6 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
 )
@@ -15,7 +14,8 @@ type Unsuback struct {
 }
 
 func (u *Unsuback) String() string {
-	return fmt.Sprintf("UNSUBACK: ReasonCode:%v PacketID:%d Properties:\n%s", u.Reasons, u.PacketID, u.Properties)
+	return newText(UNSUBACK).field("PacketID", u.PacketID).reasons("Reasons", u.Reasons).
+		properties("Properties", u.Properties).String()
 }
 
 // UnsubackSuccess, etc are the list of valid unsuback reason codes.
@@ -42,7 +42,7 @@ func (u *Unsuback) Unpack(r *bytes.Buffer) error {
 		return err
 	}
 
-	u.Reasons = r.Bytes()
+	u.Reasons = append([]byte(nil), r.Bytes()...)
 
 	return nil
 }
@@ -86,3 +86,11 @@ func (u *Unsuback) Reason(index int) string {
 	}
 	return "Invalid Reason index"
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (u *Unsuback) Validate() error {
+	if err := validatePacketID(UNSUBACK, u.PacketID); err != nil {
+		return err
+	}
+	return u.Properties.Validate(UNSUBACK)
+}
@@ -2,7 +2,6 @@ package packets
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"net"
 )
@@ -15,7 +14,12 @@ type Unsubscribe struct {
 }
 
 func (u *Unsubscribe) String() string {
-	return fmt.Sprintf("UNSUBSCRIBE: PacketID:%d Topics:%v Properties:\n%s", u.PacketID, u.Topics, u.Properties)
+	t := newText(UNSUBSCRIBE).field("PacketID", u.PacketID)
+	for _, topic := range u.Topics {
+		t.line().field("Topic", topic)
+	}
+
+	return t.properties("Properties", u.Properties).String()
 }
 
 // Unpack is the implementation of the interface required function for a packet
@@ -65,3 +69,19 @@ func (u *Unsubscribe) WriteTo(w io.Writer) (int64, error) {
 
 	return cp.WriteTo(w)
 }
+
+// Validate checks that the packet conforms to the MQTT v5 specification
+func (u *Unsubscribe) Validate() error {
+	if err := validatePacketID(UNSUBSCRIBE, u.PacketID); err != nil {
+		return err
+	}
+	if len(u.Topics) == 0 {
+		return protocolError(UNSUBSCRIBE, "no topic filters")
+	}
+	for _, t := range u.Topics {
+		if err := validateFilter(UNSUBSCRIBE, t); err != nil {
+			return err
+		}
+	}
+	return u.Properties.Validate(UNSUBSCRIBE)
+}
@@ -0,0 +1,231 @@
+package packets
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrMalformedPacket and ErrProtocolError can be used with errors.Is to determine the category of a ValidationError
+var (
+	ErrMalformedPacket = errors.New("malformed packet")
+	ErrProtocolError   = errors.New("protocol error")
+)
+
+// maxSubscriptionIdentifier is the largest value that can be encoded as a variable byte integer
+const maxSubscriptionIdentifier = 268435455
+
+// ValidationError is returned by Validate, and ReadPacketStrict, when a packet does not conform to the MQTT v5
+// specification. ReasonCode is either DisconnectMalformedPacket (0x81) or DisconnectProtocolError (0x82) and is
+// suitable for use in the DISCONNECT sent in response.
+type ValidationError struct {
+	PacketType byte
+	ReasonCode byte
+	Reason     string
+}
+
+func (e *ValidationError) Error() string {
+	kind := ErrProtocolError
+	if e.ReasonCode == DisconnectMalformedPacket {
+		kind = ErrMalformedPacket
+	}
+	return fmt.Sprintf("%s (%s): %s", kind, packetTypeName(e.PacketType), e.Reason)
+}
+
+// Is allows errors.Is(err, ErrMalformedPacket) and errors.Is(err, ErrProtocolError) to be used
+func (e *ValidationError) Is(target error) bool {
+	switch target {
+	case ErrMalformedPacket:
+		return e.ReasonCode == DisconnectMalformedPacket
+	case ErrProtocolError:
+		return e.ReasonCode == DisconnectProtocolError
+	}
+	return false
+}
+
+func malformed(pt byte, format string, a ...interface{}) error {
+	return &ValidationError{PacketType: pt, ReasonCode: DisconnectMalformedPacket, Reason: fmt.Sprintf(format, a...)}
+}
+
+func protocolError(pt byte, format string, a ...interface{}) error {
+	return &ValidationError{PacketType: pt, ReasonCode: DisconnectProtocolError, Reason: fmt.Sprintf(format, a...)}
+}
+
+// Validate checks that the ControlPacket conforms to the MQTT v5 specification; the fixed header flags are checked
+// before the content is validated
+func (c *ControlPacket) Validate() error {
+	switch c.Type {
+	case PUBLISH:
+		// flags are derived from the content
+	case PUBREL, SUBSCRIBE, UNSUBSCRIBE:
+		if c.Flags != 2 {
+			return malformed(c.Type, "invalid fixed header flags %X", c.Flags)
+		}
+	default:
+		if c.Flags != 0 {
+			return malformed(c.Type, "invalid fixed header flags %X", c.Flags)
+		}
+	}
+	if v, ok := c.Content.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// Validate checks that the properties are permitted in, and have valid values for, packet type p (WILLPROPERTIES
+// for the will properties of a CONNECT packet). Properties are checked in a fixed order, so the same error is always
+// reported for a given set of properties.
+func (i *Properties) Validate(p byte) error {
+	if i == nil {
+		return nil
+	}
+	pt, kind := p, "property"
+	if p == WILLPROPERTIES {
+		pt, kind = CONNECT, "will property"
+	}
+	for _, id := range i.present() {
+		if !ValidateID(p, id) {
+			return malformed(pt, "%s %d is not permitted", kind, id)
+		}
+	}
+	return i.validateValues(pt)
+}
+
+// present returns the identifiers of the properties that have been set
+func (i *Properties) present() []byte {
+	var ids []byte
+	add := func(set bool, id byte) {
+		if set {
+			ids = append(ids, id)
+		}
+	}
+	add(i.PayloadFormat != nil, PropPayloadFormat)
+	add(i.MessageExpiry != nil, PropMessageExpiry)
+	add(i.ContentType != "", PropContentType)
+	add(i.ResponseTopic != "", PropResponseTopic)
+	add(len(i.CorrelationData) > 0, PropCorrelationData)
+	add(i.SubscriptionIdentifier != nil, PropSubscriptionIdentifier)
+	add(i.SessionExpiryInterval != nil, PropSessionExpiryInterval)
+	add(i.AssignedClientID != "", PropAssignedClientID)
+	add(i.ServerKeepAlive != nil, PropServerKeepAlive)
+	add(i.AuthMethod != "", PropAuthMethod)
+	add(len(i.AuthData) > 0, PropAuthData)
+	add(i.RequestProblemInfo != nil, PropRequestProblemInfo)
+	add(i.WillDelayInterval != nil, PropWillDelayInterval)
+	add(i.RequestResponseInfo != nil, PropRequestResponseInfo)
+	add(i.ResponseInfo != "", PropResponseInfo)
+	add(i.ServerReference != "", PropServerReference)
+	add(i.ReasonString != "", PropReasonString)
+	add(i.ReceiveMaximum != nil, PropReceiveMaximum)
+	add(i.TopicAliasMaximum != nil, PropTopicAliasMaximum)
+	add(i.TopicAlias != nil, PropTopicAlias)
+	add(i.MaximumQOS != nil, PropMaximumQOS)
+	add(i.RetainAvailable != nil, PropRetainAvailable)
+	add(len(i.User) > 0, PropUser)
+	add(i.MaximumPacketSize != nil, PropMaximumPacketSize)
+	add(i.WildcardSubAvailable != nil, PropWildcardSubAvailable)
+	add(i.SubIDAvailable != nil, PropSubIDAvailable)
+	add(i.SharedSubAvailable != nil, PropSharedSubAvailable)
+	return ids
+}
+
+// validateValues checks the values of any properties that have been set (p is used in any error returned)
+func (i *Properties) validateValues(p byte) error {
+	if i == nil {
+		return nil
+	}
+	for _, b := range []struct {
+		id byte
+		v  *byte
+	}{
+		{PropPayloadFormat, i.PayloadFormat},
+		{PropRequestProblemInfo, i.RequestProblemInfo},
+		{PropRequestResponseInfo, i.RequestResponseInfo},
+		{PropMaximumQOS, i.MaximumQOS},
+		{PropRetainAvailable, i.RetainAvailable},
+		{PropWildcardSubAvailable, i.WildcardSubAvailable},
+		{PropSubIDAvailable, i.SubIDAvailable},
+		{PropSharedSubAvailable, i.SharedSubAvailable},
+	} {
+		if b.v != nil && *b.v > 1 {
+			return protocolError(p, "property %d has invalid value %d", b.id, *b.v)
+		}
+	}
+	if i.ReceiveMaximum != nil && *i.ReceiveMaximum == 0 {
+		return protocolError(p, "property %d must not be 0", PropReceiveMaximum)
+	}
+	if i.TopicAlias != nil && *i.TopicAlias == 0 {
+		return protocolError(p, "property %d must not be 0", PropTopicAlias)
+	}
+	if i.MaximumPacketSize != nil && *i.MaximumPacketSize == 0 {
+		return protocolError(p, "property %d must not be 0", PropMaximumPacketSize)
+	}
+	if i.SubscriptionIdentifier != nil {
+		if *i.SubscriptionIdentifier == 0 {
+			return protocolError(p, "subscription identifier must not be 0")
+		}
+		if *i.SubscriptionIdentifier < 0 || *i.SubscriptionIdentifier > maxSubscriptionIdentifier {
+			return malformed(p, "subscription identifier %d out of range", *i.SubscriptionIdentifier)
+		}
+	}
+	for _, str := range []struct {
+		id byte
+		s  string
+	}{
+		{PropContentType, i.ContentType},
+		{PropResponseTopic, i.ResponseTopic},
+		{PropAssignedClientID, i.AssignedClientID},
+		{PropAuthMethod, i.AuthMethod},
+		{PropResponseInfo, i.ResponseInfo},
+		{PropServerReference, i.ServerReference},
+		{PropReasonString, i.ReasonString},
+	} {
+		if !validString(str.s) {
+			return malformed(p, "property %d is not a valid UTF-8 string", str.id)
+		}
+	}
+	for _, u := range i.User {
+		if !validString(u.Key) || !validString(u.Value) {
+			return malformed(p, "user property is not a valid UTF-8 string")
+		}
+	}
+	if i.ResponseTopic != "" && !validTopicName(i.ResponseTopic) {
+		return protocolError(p, "response topic %q contains wildcards", i.ResponseTopic)
+	}
+	if len(i.CorrelationData) > 65535 || len(i.AuthData) > 65535 {
+		return malformed(p, "binary property too long")
+	}
+	if len(i.AuthData) > 0 && i.AuthMethod == "" {
+		return protocolError(p, "authentication data without an authentication method")
+	}
+	return nil
+}
+
+// validString reports whether s can be encoded as an MQTT UTF-8 string (valid UTF-8, no null characters and at
+// most 65535 bytes)
+func validString(s string) bool {
+	return len(s) <= 65535 && utf8.ValidString(s) && !strings.ContainsRune(s, 0)
+}
+
+// validTopicName reports whether t, which must be a valid string, contains no wildcard characters
+func validTopicName(t string) bool {
+	return !strings.ContainsAny(t, "+#")
+}
+
+// validateFilter checks the topic filter f in a packet of type pt; the syntax of the filter is not checked because
+// the receiver reports invalid filters with a reason code (0x8F) in the SUBACK or UNSUBACK
+func validateFilter(pt byte, f string) error {
+	if !validString(f) {
+		return malformed(pt, "topic filter is not a valid UTF-8 string")
+	}
+	return nil
+}
+
+// validatePacketID checks that a packet identifier has been set
+func validatePacketID(pt byte, id uint16) error {
+	if id == 0 {
+		return protocolError(pt, "packet identifier must not be 0")
+	}
+	return nil
+}
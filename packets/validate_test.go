@@ -0,0 +1,175 @@
+package packets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	zero := uint16(0)
+	two := byte(2)
+	subID := 0
+	tests := []struct {
+		name   string
+		packet interface{ Validate() error }
+		want   error // nil, ErrMalformedPacket or ErrProtocolError
+	}{
+		{"valid connect", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, ClientID: "test"}, nil},
+		{"connect version", &Connect{ProtocolName: "MQTT", ProtocolVersion: 4}, ErrProtocolError},
+		{"connect will qos without will", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, WillQOS: 1}, ErrMalformedPacket},
+		{"connect will wildcard", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, WillFlag: true, WillTopic: "a/#"}, ErrProtocolError},
+		{"connect invalid utf8", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, ClientID: "\xff"}, ErrMalformedPacket},
+		{"connect disallowed property", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, Properties: &Properties{TopicAlias: new(uint16)}}, ErrMalformedPacket},
+		{"connect receive maximum 0", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, Properties: &Properties{ReceiveMaximum: &zero}}, ErrProtocolError},
+		{"valid connect will", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, WillFlag: true, WillTopic: "a", WillProperties: &Properties{PayloadFormat: new(byte), WillDelayInterval: new(uint32), ContentType: "text/plain"}}, nil},
+		{"connect will topic alias", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, WillFlag: true, WillTopic: "a", WillProperties: &Properties{TopicAlias: new(uint16)}}, ErrMalformedPacket},
+		{"connect will auth method", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, WillFlag: true, WillTopic: "a", WillProperties: &Properties{AuthMethod: "method"}}, ErrMalformedPacket},
+		{"connect will session expiry", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, WillFlag: true, WillTopic: "a", WillProperties: &Properties{SessionExpiryInterval: new(uint32)}}, ErrMalformedPacket},
+		{"connect auth data without method", &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, Properties: &Properties{AuthData: []byte{1}}}, ErrProtocolError},
+		{"connack session present on failure", &Connack{ReasonCode: ConnackNotAuthorized, SessionPresent: true}, ErrProtocolError},
+		{"connack maximum qos 2", &Connack{Properties: &Properties{MaximumQOS: &two}}, ErrProtocolError},
+		{"valid publish", &Publish{Topic: "a/b", QoS: 1, PacketID: 1, Properties: &Properties{}}, nil},
+		{"publish qos 3", &Publish{Topic: "a/b", QoS: 3, PacketID: 1}, ErrMalformedPacket},
+		{"publish wildcard", &Publish{Topic: "a/+", QoS: 0}, ErrProtocolError},
+		{"publish no packet id", &Publish{Topic: "a/b", QoS: 1}, ErrProtocolError},
+		{"publish dup qos 0", &Publish{Topic: "a/b", Duplicate: true}, ErrProtocolError},
+		{"publish no topic", &Publish{}, ErrProtocolError},
+		{"publish topic alias 0", &Publish{Topic: "a", Properties: &Properties{TopicAlias: &zero}}, ErrProtocolError},
+		{"publish alias only", &Publish{Properties: &Properties{TopicAlias: new(uint16)}}, ErrProtocolError},
+		{"publish session expiry", &Publish{Topic: "a", Properties: &Properties{SessionExpiryInterval: new(uint32)}}, ErrMalformedPacket},
+		{"puback no packet id", &Puback{}, ErrProtocolError},
+		{"pubrel", &Pubrel{PacketID: 1}, nil},
+		{"valid subscribe", &Subscribe{PacketID: 1, Subscriptions: []SubOptions{{Topic: "a/#", QoS: 2, RetainHandling: 0x20}, {Topic: "$share/g/+/b"}}}, nil},
+		{"empty subscribe", &Subscribe{PacketID: 1}, ErrProtocolError},
+		{"subscribe qos 3", &Subscribe{PacketID: 1, Subscriptions: []SubOptions{{Topic: "a", QoS: 3}}}, ErrMalformedPacket},
+		{"subscribe retain handling 3", &Subscribe{PacketID: 1, Subscriptions: []SubOptions{{Topic: "a", RetainHandling: 0x30}}}, ErrMalformedPacket},
+		{"subscribe shared no local", &Subscribe{PacketID: 1, Subscriptions: []SubOptions{{Topic: "$share/g/a", NoLocal: true}}}, ErrProtocolError},
+		{"subscribe subscription id 0", &Subscribe{PacketID: 1, Subscriptions: []SubOptions{{Topic: "a"}}, Properties: &Properties{SubscriptionIdentifier: &subID}}, ErrProtocolError},
+		{"suback", &Suback{PacketID: 1, Reasons: []byte{0}}, nil},
+		{"empty unsubscribe", &Unsubscribe{PacketID: 1}, ErrProtocolError},
+		{"unsubscribe invalid utf8", &Unsubscribe{PacketID: 1, Topics: []string{"a\x00"}}, ErrMalformedPacket},
+		{"subscribe invalid filter", &Subscribe{PacketID: 1, Subscriptions: []SubOptions{{Topic: "a/#/b"}}}, nil},
+		{"disconnect", &Disconnect{ReasonCode: DisconnectServerMoved, Properties: &Properties{ServerReference: "other"}}, nil},
+		{"disconnect assigned client id", &Disconnect{Properties: &Properties{AssignedClientID: "x"}}, ErrMalformedPacket},
+		{"auth reason code", &Auth{ReasonCode: 0x80}, ErrProtocolError},
+		{"pingreq", &Pingreq{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.packet.Validate()
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+			var ve *ValidationError
+			require.True(t, errors.As(err, &ve))
+			if tt.want == ErrMalformedPacket {
+				assert.Equal(t, byte(DisconnectMalformedPacket), ve.ReasonCode)
+			} else {
+				assert.Equal(t, byte(DisconnectProtocolError), ve.ReasonCode)
+			}
+		})
+	}
+}
+
+func TestValidatePropertiesOrder(t *testing.T) {
+	two := byte(2)
+	p := &Properties{
+		PayloadFormat:      &two,
+		RequestProblemInfo: &two,
+		MaximumQOS:         &two,
+		SharedSubAvailable: &two,
+		ContentType:        "\xff",
+		ReasonString:       "\xff",
+	}
+	// The first invalid property (in a fixed order) is always reported
+	for i := 0; i < 20; i++ {
+		err := p.validateValues(CONNACK)
+		require.Error(t, err)
+		assert.Equal(t, "protocol error (CONNACK): property 1 has invalid value 2", err.Error())
+	}
+
+	c := &Connect{ProtocolName: "MQTT", ProtocolVersion: 5, WillFlag: true, WillTopic: "a", WillProperties: &Properties{
+		TopicAlias:            new(uint16),
+		AuthMethod:            "method",
+		SessionExpiryInterval: new(uint32),
+	}}
+	for i := 0; i < 20; i++ {
+		err := c.Validate()
+		require.Error(t, err)
+		assert.Equal(t, "malformed packet (CONNECT): will property 17 is not permitted", err.Error())
+	}
+}
+
+func TestReadPacketStrict(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"valid publish", []byte{0x30, 0x04, 0x00, 0x01, 'a', 0x00}, nil},
+		{"publish qos 3", []byte{0x36, 0x06, 0x00, 0x01, 'a', 0x00, 0x01, 0x00}, ErrMalformedPacket},
+		{"publish wildcard", []byte{0x30, 0x04, 0x00, 0x01, '#', 0x00}, ErrProtocolError},
+		{"puback flags", []byte{0x41, 0x02, 0x00, 0x01}, ErrMalformedPacket},
+		{"pubrel flags", []byte{0x60, 0x02, 0x00, 0x01}, ErrMalformedPacket},
+		{"trailing bytes", []byte{0xC0, 0x01, 0x00}, ErrMalformedPacket},
+		{"truncated properties", []byte{0x30, 0x05, 0x00, 0x01, 'a', 0x05, 0x01}, ErrMalformedPacket},
+		{"invalid property", []byte{0x30, 0x06, 0x00, 0x01, 'a', 0x02, 0x11, 0x00}, ErrMalformedPacket},
+		{"reserved packet type", []byte{0x00, 0x00}, ErrMalformedPacket},
+		{"empty subscribe", []byte{0x82, 0x03, 0x00, 0x01, 0x00}, ErrProtocolError},
+		{"zero length disconnect", []byte{0xE0, 0x00}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPacketStrict(bytes.NewReader(tt.data))
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+
+	// The non-strict ReadPacket accepts packets that are decodable
+	cp, err := ReadPacket(bytes.NewReader([]byte{0x30, 0x04, 0x00, 0x01, '#', 0x00}))
+	require.NoError(t, err)
+	assert.Equal(t, "#", cp.Content.(*Publish).Topic)
+
+	// Errors from the reader are not validation errors
+	_, err = ReadPacketStrict(bytes.NewReader([]byte{0x30, 0x04, 0x00}))
+	var ve *ValidationError
+	assert.False(t, errors.As(err, &ve))
+}
+
+func TestValidateRoundTrip(t *testing.T) {
+	for _, pt := range []byte{CONNECT, CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBACK, UNSUBACK, PINGREQ, PINGRESP, DISCONNECT, AUTH} {
+		cp := NewControlPacket(pt)
+		switch c := cp.Content.(type) {
+		case *Puback:
+			c.PacketID = 1
+		case *Pubrec:
+			c.PacketID = 1
+		case *Pubrel:
+			c.PacketID = 1
+		case *Pubcomp:
+			c.PacketID = 1
+		case *Suback:
+			c.PacketID = 1
+		case *Unsuback:
+			c.PacketID = 1
+		}
+		require.NoError(t, cp.Validate(), cp.PacketType())
+
+		var b bytes.Buffer
+		_, err := cp.WriteTo(&b)
+		require.NoError(t, err)
+		_, err = ReadPacketStrict(&b)
+		assert.NoError(t, err, cp.PacketType())
+	}
+}
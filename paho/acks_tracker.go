@@ -66,6 +66,13 @@ func (t *acksTracker) flush(do func([]*packets.Publish)) {
 	t.order = t.order[len(buf):]
 }
 
+// pending returns the number of received messages that have not yet been acknowledged to the server
+func (t *acksTracker) pending() int {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return len(t.order)
+}
+
 // reset should be used upon disconnections
 func (t *acksTracker) reset() {
 	t.mx.Lock()
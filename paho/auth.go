@@ -1,8 +1,69 @@
 package paho
 
+import (
+	"errors"
+	"fmt"
+)
+
 // Auther is the interface for something that implements the extended authentication
 // flows in MQTT v5
 type Auther interface {
 	Authenticate(*Auth) *Auth
 	Authenticated()
 }
+
+// AuthVerifier may optionally be implemented by an Auther that needs to
+// check the data sent by the server when an enhanced authentication
+// exchange completes successfully (e.g. to verify a server signature).
+// VerifyAuth is passed the properties of the successful CONNACK or AUTH
+// packet; if it returns an error the Connect (or Authenticate) call fails
+// with that error and the connection is closed (after sending a DISCONNECT
+// with reason code 0x87 Not authorized).
+type AuthVerifier interface {
+	VerifyAuth(*AuthProperties) error
+}
+
+var (
+	// ErrAuthInProgress is returned from Authenticate if a re-authentication
+	// exchange is already in progress
+	ErrAuthInProgress = errors.New("previous authentication is still in progress")
+	// ErrNoAuthHandler is returned when the server continues an enhanced
+	// authentication exchange but no AuthHandler has been configured
+	ErrNoAuthHandler = errors.New("enhanced authentication flow started but no AuthHandler configured")
+)
+
+// AuthError is returned from Authenticate when the server rejects the
+// re-authentication (by sending a DISCONNECT)
+type AuthError struct {
+	ReasonCode   byte
+	ReasonString string
+}
+
+func (e *AuthError) Error() string {
+	if e.ReasonString != "" {
+		return fmt.Sprintf("authentication failed (reason code 0x%02X): %s", e.ReasonCode, e.ReasonString)
+	}
+	return fmt.Sprintf("authentication failed (reason code 0x%02X)", e.ReasonCode)
+}
+
+// authExchange tracks a re-authentication exchange, initiated by the client
+// via Authenticate, that is in progress (the server cannot initiate one)
+type authExchange struct {
+	result chan authResult // buffered so the outcome can always be delivered
+}
+
+// authResult is the outcome of a re-authentication exchange
+type authResult struct {
+	resp *AuthResponse
+	err  error
+}
+
+func newAuthExchange() *authExchange {
+	return &authExchange{result: make(chan authResult, 1)}
+}
+
+// complete passes the outcome of the exchange to the caller of Authenticate
+// (if any); it must be called at most once.
+func (e *authExchange) complete(resp *AuthResponse, err error) {
+	e.result <- authResult{resp: resp, err: err}
+}
@@ -23,8 +23,13 @@ const (
 
 const defaultSendAckInterval = 50 * time.Millisecond
 
+// drainPollInterval is how often Drain checks whether all messages have been acknowledged
+const drainPollInterval = 10 * time.Millisecond
+
 var (
 	ErrManualAcknowledgmentDisabled = errors.New("manual acknowledgments disabled")
+	// ErrDraining is returned from Publish once Drain has been called
+	ErrDraining = errors.New("client is draining, no new publications accepted")
 )
 
 type (
@@ -35,8 +40,10 @@ type (
 	ClientConfig struct {
 		ClientID string
 		// Conn is the connection to broker.
-		// BEWARE that most wrapped net.Conn implementations like tls.Conn are
-		// not thread safe for writing. To fix, use packets.NewThreadSafeConn
+		// The Client writes to Conn from a single goroutine so it need not be
+		// thread safe for writing, unless a custom PingHandler also writes to
+		// it directly. BEWARE that most wrapped net.Conn implementations like
+		// tls.Conn are not; in that case use packets.NewThreadSafeConn
 		// wrapper or extend the custom net.Conn struct with sync.Locker.
 		Conn          net.Conn
 		MIDs          MIDService
@@ -55,6 +62,17 @@ type (
 		// Topic Alias Handler extension which will automatically assign
 		// and use topic alias values rather than topic strings.
 		PublishHook func(*Publish)
+		// PublishContextHook is called, after PublishHook, with the context
+		// passed to Publish; this allows values carried by the context (e.g.
+		// a trace context) to be added to the Publish.
+		PublishContextHook func(context.Context, *Publish)
+		// InboundInterceptors and OutboundInterceptors are called, in
+		// order, with each packet received from, or to be sent to, the
+		// server (see PacketInterceptor). If the PingHandler has a
+		// SetPacketWriter(func(packets.Packet) error) method it will be
+		// called so that PINGREQ packets are also intercepted.
+		InboundInterceptors  []PacketInterceptor
+		OutboundInterceptors []PacketInterceptor
 		// EnableManualAcknowledgment is used to control the acknowledgment of packets manually.
 		// BEWARE that the MQTT specs require clients to send acknowledgments in the order in which the corresponding
 		// PUBLISH packets were received.
@@ -65,13 +83,25 @@ type (
 		// SendAcksInterval is used only when EnableManualAcknowledgment is true
 		// it determines how often the client tries to send a batch of acknowledgments in the right order to the server.
 		SendAcksInterval time.Duration
+		// Metrics is used to record information about the operation of the
+		// client (packets sent/received, publish latency etc), the default
+		// is NOOPMetrics. If the PingHandler has a SetMetrics(Metrics)
+		// method it will be called so that ping round trip times are
+		// recorded.
+		Metrics Metrics
+		// Logger receives structured log entries from the client, it is
+		// also passed to the PingHandler and Router if they have a
+		// SetLogger(StructuredLogger) method. If nil, entries are written
+		// to the Loggers set with SetDebugLogger and SetErrorLogger.
+		Logger StructuredLogger
 	}
 	// Client is the struct representing an MQTT client
 	Client struct {
 		mu sync.Mutex
 		ClientConfig
-		// raCtx is used for handling the MQTTv5 authentication exchange.
-		raCtx          *CPContext
+		// raCtx is the re-authentication exchange in progress (if any), it
+		// is protected by mu
+		raCtx          *authExchange
 		stop           chan struct{}
 		publishPackets chan *packets.Publish
 		acksTracker    acksTracker
@@ -80,8 +110,20 @@ type (
 		clientProps    CommsProperties
 		serverInflight *semaphore.Weighted
 		clientInflight *semaphore.Weighted
-		debug          Logger
-		errors         Logger
+		debug          Logger // set by SetDebugLogger (used if Logger is nil)
+		errors         Logger // set by SetErrorLogger (used if Logger is nil)
+		log            LevelLogger
+		// draining and inflight (outgoing QoS1/2 messages not yet fully
+		// acknowledged) are used by Drain() and protected by inflightMu
+		inflightMu sync.Mutex
+		draining   bool
+		inflight   int
+		// reader buffers the packets read from Conn and queue holds those
+		// waiting to be written; they are created when first used
+		// (protected by ioMu)
+		ioMu   sync.Mutex
+		reader *packets.Reader
+		queue  *writeQueue
 	}
 
 	// CommsProperties is a struct of the communication properties that may
@@ -109,6 +151,9 @@ type (
 // The default client uses the provided PingHandler, MessageID and
 // StandardRouter implementations, and a noop Persistence.
 // These should be replaced if desired before the client is connected.
+// The Persistence holds outbound QoS1/2 packets that have not been fully
+// acknowledged; if the Connack indicates that a session is present these
+// are resent, otherwise the Persistence is Reset().
 // client.Conn *MUST* be set to an already connected net.Conn before
 // Connect() is called.
 func NewClient(conf ClientConfig) *Client {
@@ -154,6 +199,21 @@ func NewClient(conf ClientConfig) *Client {
 	if c.OnClientError == nil {
 		c.OnClientError = func(e error) {}
 	}
+	if c.Metrics == nil {
+		c.Metrics = NOOPMetrics{}
+	}
+	if pm, ok := c.PingHandler.(interface{ SetMetrics(Metrics) }); ok {
+		pm.SetMetrics(c.Metrics)
+	}
+	if pw, ok := c.PingHandler.(interface {
+		SetPacketWriter(func(packets.Packet) error)
+	}); ok {
+		pw.SetPacketWriter(func(p packets.Packet) error {
+			_, err := c.write(p)
+			return err
+		})
+	}
+	c.setLogger()
 
 	return c
 }
@@ -174,6 +234,7 @@ func (c *Client) Connect(ctx context.Context, cp *Connect) (*Connack, error) {
 		close(c.stop)
 		close(c.publishPackets)
 		_ = c.Conn.Close()
+		c.stopWriting()
 		c.mu.Unlock()
 	}
 
@@ -203,7 +264,7 @@ func (c *Client) Connect(ctx context.Context, cp *Connect) (*Connack, error) {
 		}
 	}
 
-	c.debug.Println("connecting")
+	c.log.Info("connecting", ClientIDField(cp.ClientID))
 	connCtx, cf := context.WithTimeout(ctx, c.PacketTimeout)
 	defer cf()
 
@@ -211,13 +272,13 @@ func (c *Client) Connect(ctx context.Context, cp *Connect) (*Connack, error) {
 	ccp.ProtocolName = "MQTT"
 	ccp.ProtocolVersion = 5
 
-	c.debug.Println("sending CONNECT")
-	if _, err := ccp.WriteTo(c.Conn); err != nil {
+	c.log.Debug("sending packet", PacketTypeField(packets.CONNECT))
+	if _, err := c.write(ccp); err != nil {
 		cleanup()
 		return nil, err
 	}
 
-	c.debug.Println("waiting for CONNACK/AUTH")
+	c.log.Debug("waiting for CONNACK/AUTH")
 	var (
 		caPacket    *packets.Connack
 		caPacketCh  = make(chan *packets.Connack)
@@ -227,12 +288,12 @@ func (c *Client) Connect(ctx context.Context, cp *Connect) (*Connack, error) {
 	select {
 	case <-connCtx.Done():
 		if ctxErr := connCtx.Err(); ctxErr != nil {
-			c.debug.Println(fmt.Sprintf("terminated due to context: %v", ctxErr))
+			c.log.Debug("terminated due to context", ErrorField(ctxErr))
 		}
 		cleanup()
 		return nil, connCtx.Err()
 	case err := <-caPacketErr:
-		c.debug.Println(err)
+		c.log.Debug("connect failed", ErrorField(err))
 		cleanup()
 		return nil, err
 	case caPacket = <-caPacketCh:
@@ -242,7 +303,7 @@ func (c *Client) Connect(ctx context.Context, cp *Connect) (*Connack, error) {
 
 	if ca.ReasonCode >= 0x80 {
 		var reason string
-		c.debug.Println("received an error code in Connack:", ca.ReasonCode)
+		c.log.Debug("received an error code in CONNACK", ReasonCodeField(ca.ReasonCode))
 		if ca.Properties != nil {
 			reason = ca.Properties.ReasonString
 		}
@@ -281,32 +342,41 @@ func (c *Client) Connect(ctx context.Context, cp *Connect) (*Connack, error) {
 	c.serverInflight = semaphore.NewWeighted(int64(c.serverProps.ReceiveMaximum))
 	c.clientInflight = semaphore.NewWeighted(int64(c.clientProps.ReceiveMaximum))
 
-	c.debug.Println("received CONNACK, starting PingHandler")
+	c.log.Info("connected", ClientIDField(c.ClientID))
+	c.log.Debug("starting PingHandler")
 	c.workers.Add(1)
 	go func() {
 		defer c.workers.Done()
-		defer c.debug.Println("returning from ping handler worker")
+		defer c.log.Debug("returning from ping handler worker")
 		c.PingHandler.Start(c.Conn, time.Duration(keepalive)*time.Second)
 	}()
 
-	c.debug.Println("starting publish packets loop")
+	c.log.Debug("starting publish packets loop")
 	c.workers.Add(1)
 	go func() {
 		defer c.workers.Done()
-		defer c.debug.Println("returning from publish packets loop worker")
+		defer c.log.Debug("returning from publish packets loop worker")
 		c.routePublishPackets()
 	}()
 
-	c.debug.Println("starting incoming")
+	c.log.Debug("starting incoming")
 	c.workers.Add(1)
 	go func() {
 		defer c.workers.Done()
-		defer c.debug.Println("returning from incoming worker")
+		defer c.log.Debug("returning from incoming worker")
 		c.incoming()
 	}()
 
+	if ca.SessionPresent {
+		c.log.Debug("session present, resending persisted packets")
+		c.resendPersisted()
+	} else {
+		c.log.Debug("no session present, resetting persistence")
+		c.Persistence.Reset()
+	}
+
 	if c.EnableManualAcknowledgment {
-		c.debug.Println("starting acking routine")
+		c.log.Debug("starting acking routine")
 
 		c.acksTracker.reset()
 		sendAcksInterval := defaultSendAckInterval
@@ -317,7 +387,7 @@ func (c *Client) Connect(ctx context.Context, cp *Connect) (*Connack, error) {
 		c.workers.Add(1)
 		go func() {
 			defer c.workers.Done()
-			defer c.debug.Println("returning from ack tracker routine")
+			defer c.log.Debug("returning from ack tracker routine")
 			t := time.NewTicker(sendAcksInterval)
 			for {
 				select {
@@ -337,14 +407,77 @@ func (c *Client) Connect(ctx context.Context, cp *Connect) (*Connack, error) {
 	return ca, nil
 }
 
+// resendPersisted is called when the server has an existing session for
+// this client, any QoS1/2 PUBLISH or PUBREL packets that were not fully
+// acknowledged on a previous connection are resent (retaining their
+// original messageid). The responses are handled as normal by incoming()
+// and the packets removed from the Persistence once acknowledged.
+func (c *Client) resendPersisted() {
+	for _, cp := range c.Persistence.All() {
+		if cp.Content == nil {
+			continue
+		}
+		id := cp.PacketID()
+		cpCtx := &CPContext{context.Background(), make(chan packets.ControlPacket, 1)}
+		if r, ok := c.MIDs.(midReserver); ok {
+			if err := r.Reserve(id, cpCtx); err != nil {
+				c.log.Error("unable to resend persisted packet", PacketIDField(id), ErrorField(err))
+				continue
+			}
+		}
+
+		switch p := cp.Content.(type) {
+		case *packets.Publish:
+			c.log.Debug("resending packet", PacketTypeField(packets.PUBLISH), PacketIDField(id))
+			p.Duplicate = true
+			if _, err := c.write(p); err != nil {
+				if errors.Is(err, ErrDropPacket) {
+					c.Persistence.Delete(id)
+					c.MIDs.Free(id)
+					continue
+				}
+				c.log.Error("failed to resend packet", PacketTypeField(packets.PUBLISH), PacketIDField(id), ErrorField(err))
+			}
+		case *packets.Pubrel:
+			c.log.Debug("resending packet", PacketTypeField(packets.PUBREL), PacketIDField(id))
+			if _, err := c.write(p); err != nil {
+				c.log.Error("failed to resend packet", PacketTypeField(packets.PUBREL), PacketIDField(id), ErrorField(err))
+			}
+		default:
+			c.log.Debug("ignoring persisted packet", PacketTypeField(cp.Type), PacketIDField(id))
+			c.MIDs.Free(id)
+			continue
+		}
+
+		c.addInflight(1)
+		c.workers.Add(1)
+		go func() {
+			defer c.workers.Done()
+			defer c.addInflight(-1)
+			select {
+			case <-c.stop:
+				// Connection lost; the packet remains persisted and will be resent on the next connection
+			case <-cpCtx.Return:
+				c.Persistence.Delete(id)
+			}
+			c.MIDs.Free(id)
+		}()
+	}
+}
+
 func (c *Client) Ack(pb *Publish) error {
+	return c.ackPacket(pb.Packet())
+}
+
+// ackPacket marks pb as acknowledged (when EnableManualAcknowledgment is set)
+func (c *Client) ackPacket(pb *packets.Publish) error {
 	if !c.EnableManualAcknowledgment {
 		return ErrManualAcknowledgmentDisabled
 	}
 	if pb.QoS == 0 {
 		return nil
 	}
-	return c.acksTracker.markAsAcked(pb.Packet())
+	return c.acksTracker.markAsAcked(pb)
 }
 
 func (c *Client) ack(pb *packets.Publish) {
@@ -354,25 +487,27 @@ func (c *Client) ack(pb *packets.Publish) {
 			Properties: &packets.Properties{},
 			PacketID:   pb.PacketID,
 		}
-		c.debug.Println("sending PUBACK")
-		_, err := pa.WriteTo(c.Conn)
+		c.log.Debug("sending packet", PacketTypeField(packets.PUBACK), PacketIDField(pb.PacketID))
+		_, err := c.write(&pa)
 		if err != nil {
-			c.errors.Printf("failed to send PUBACK for %d: %s", pb.PacketID, err)
+			c.log.Error("failed to send packet", PacketTypeField(packets.PUBACK), PacketIDField(pb.PacketID), ErrorField(err))
 		}
 	case 2:
 		pr := packets.Pubrec{
 			Properties: &packets.Properties{},
 			PacketID:   pb.PacketID,
 		}
-		c.debug.Printf("sending PUBREC")
-		_, err := pr.WriteTo(c.Conn)
+		c.log.Debug("sending packet", PacketTypeField(packets.PUBREC), PacketIDField(pb.PacketID))
+		_, err := c.write(&pr)
 		if err != nil {
-			c.errors.Printf("failed to send PUBREC for %d: %s", pb.PacketID, err)
+			c.log.Error("failed to send packet", PacketTypeField(packets.PUBREC), PacketIDField(pb.PacketID), ErrorField(err))
 		}
 	}
 }
 
 func (c *Client) routePublishPackets() {
+	ctx, cancel := c.stopContext()
+	defer cancel()
 	for {
 		select {
 		case <-c.stop:
@@ -383,7 +518,7 @@ func (c *Client) routePublishPackets() {
 			}
 
 			if !c.ClientConfig.EnableManualAcknowledgment {
-				c.Router.Route(pb)
+				c.route(ctx, pb)
 				c.ack(pb)
 				continue
 			}
@@ -392,55 +527,73 @@ func (c *Client) routePublishPackets() {
 				c.acksTracker.add(pb)
 			}
 
-			c.Router.Route(pb)
+			c.route(ctx, pb)
 		}
 	}
 }
 
+// route passes pb to the Router; if the Router is a ContextRouter the
+// context passed carries the MessageInfo and is cancelled when the
+// client stops
+func (c *Client) route(ctx context.Context, pb *packets.Publish) {
+	cr, ok := c.Router.(ContextRouter)
+	if !ok {
+		c.Router.Route(pb)
+		return
+	}
+	cr.RouteContext(contextWithMessageInfo(ctx, MessageInfo{
+		Received: time.Now(),
+		Client:   c,
+		Ack:      func() error { return c.ackPacket(pb) },
+	}), pb)
+}
+
+// stopContext returns a context that is cancelled when the client stops
+// (or cancel is called)
+func (c *Client) stopContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := c.stop
+	go func() {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
 // incoming is the Client function that reads and handles incoming
 // packets from the server. The function is started as a goroutine
 // from Connect(), it exits when it receives a server initiated
 // Disconnect, the Stop channel is closed or there is an error reading
 // a packet from the network connection
 func (c *Client) incoming() {
-	defer c.debug.Println("client stopping, incoming stopping")
+	defer c.log.Debug("client stopping, incoming stopping")
 	for {
 		select {
 		case <-c.stop:
 			return
 		default:
-			recv, err := packets.ReadPacket(c.Conn)
+			recv, err := c.read()
 			if err != nil {
 				go c.error(err)
 				return
 			}
 			switch recv.Type {
 			case packets.CONNACK:
-				c.debug.Println("received CONNACK")
+				c.log.Debug("received unexpected packet", PacketTypeField(packets.CONNACK))
 				go c.error(fmt.Errorf("received unexpected CONNACK"))
 				return
 			case packets.AUTH:
-				c.debug.Println("received AUTH")
-				ap := recv.Content.(*packets.Auth)
-				switch ap.ReasonCode {
-				case 0x0:
-					if c.AuthHandler != nil {
-						go c.AuthHandler.Authenticated()
-					}
-					if c.raCtx != nil {
-						c.raCtx.Return <- *recv
-					}
-				case 0x18:
-					if c.AuthHandler != nil {
-						if _, err := c.AuthHandler.Authenticate(AuthFromPacketAuth(ap)).Packet().WriteTo(c.Conn); err != nil {
-							go c.error(err)
-							return
-						}
-					}
+				c.log.Debug("received packet", PacketTypeField(packets.AUTH), ReasonCodeField(recv.Content.(*packets.Auth).ReasonCode))
+				if err := c.handleAuth(recv.Content.(*packets.Auth)); err != nil {
+					go c.error(err)
+					return
 				}
 			case packets.PUBLISH:
 				pb := recv.Content.(*packets.Publish)
-				c.debug.Printf("received QoS%d PUBLISH", pb.QoS)
+				c.log.Debug("received packet", PacketTypeField(packets.PUBLISH), PacketIDField(pb.PacketID), TopicField(pb.Topic), Field{Key: "qos", Value: pb.QoS})
 				c.mu.Lock()
 				select {
 				case <-c.stop:
@@ -451,24 +604,24 @@ func (c *Client) incoming() {
 					c.mu.Unlock()
 				}
 			case packets.PUBACK, packets.PUBCOMP, packets.SUBACK, packets.UNSUBACK:
-				c.debug.Printf("received %s packet with id %d", recv.PacketType(), recv.PacketID())
+				c.log.Debug("received packet", PacketTypeField(recv.Type), PacketIDField(recv.PacketID()))
 				if cpCtx := c.MIDs.Get(recv.PacketID()); cpCtx != nil {
 					cpCtx.Return <- *recv
 				} else {
-					c.debug.Println("received a response for a message ID we don't know:", recv.PacketID())
+					c.log.Debug("received a response for an unknown packet ID", PacketTypeField(recv.Type), PacketIDField(recv.PacketID()))
 				}
 			case packets.PUBREC:
-				c.debug.Println("received PUBREC for", recv.PacketID())
+				c.log.Debug("received packet", PacketTypeField(packets.PUBREC), PacketIDField(recv.PacketID()))
 				if cpCtx := c.MIDs.Get(recv.PacketID()); cpCtx == nil {
-					c.debug.Println("received a PUBREC for a message ID we don't know:", recv.PacketID())
+					c.log.Debug("received a response for an unknown packet ID", PacketTypeField(packets.PUBREC), PacketIDField(recv.PacketID()))
 					pl := packets.Pubrel{
 						PacketID:   recv.Content.(*packets.Pubrec).PacketID,
 						ReasonCode: 0x92,
 					}
-					c.debug.Println("sending PUBREL for", pl.PacketID)
-					_, err := pl.WriteTo(c.Conn)
+					c.log.Debug("sending packet", PacketTypeField(packets.PUBREL), PacketIDField(pl.PacketID))
+					_, err := c.write(&pl)
 					if err != nil {
-						c.errors.Printf("failed to send PUBREL for %d: %s", pl.PacketID, err)
+						c.log.Error("failed to send packet", PacketTypeField(packets.PUBREL), PacketIDField(pl.PacketID), ErrorField(err))
 					}
 				} else {
 					pr := recv.Content.(*packets.Pubrec)
@@ -479,15 +632,19 @@ func (c *Client) incoming() {
 						pl := packets.Pubrel{
 							PacketID: pr.PacketID,
 						}
-						c.debug.Println("sending PUBREL for", pl.PacketID)
-						_, err := pl.WriteTo(c.Conn)
+						c.Persistence.Put(pl.PacketID, packets.ControlPacket{
+							FixedHeader: packets.FixedHeader{Type: packets.PUBREL, Flags: 2},
+							Content:     &pl,
+						})
+						c.log.Debug("sending packet", PacketTypeField(packets.PUBREL), PacketIDField(pl.PacketID))
+						_, err := c.write(&pl)
 						if err != nil {
-							c.errors.Printf("failed to send PUBREL for %d: %s", pl.PacketID, err)
+							c.log.Error("failed to send packet", PacketTypeField(packets.PUBREL), PacketIDField(pl.PacketID), ErrorField(err))
 						}
 					}
 				}
 			case packets.PUBREL:
-				c.debug.Println("received PUBREL for", recv.PacketID())
+				c.log.Debug("received packet", PacketTypeField(packets.PUBREL), PacketIDField(recv.PacketID()))
 				//Auto respond to pubrels unless failure code
 				pr := recv.Content.(*packets.Pubrel)
 				if pr.ReasonCode >= 0x80 {
@@ -497,16 +654,17 @@ func (c *Client) incoming() {
 					pc := packets.Pubcomp{
 						PacketID: pr.PacketID,
 					}
-					c.debug.Println("sending PUBCOMP for", pr.PacketID)
-					_, err := pc.WriteTo(c.Conn)
+					c.log.Debug("sending packet", PacketTypeField(packets.PUBCOMP), PacketIDField(pr.PacketID))
+					_, err := c.write(&pc)
 					if err != nil {
-						c.errors.Printf("failed to send PUBCOMP for %d: %s", pc.PacketID, err)
+						c.log.Error("failed to send packet", PacketTypeField(packets.PUBCOMP), PacketIDField(pc.PacketID), ErrorField(err))
 					}
 				}
 			case packets.DISCONNECT:
-				c.debug.Println("received DISCONNECT")
-				if c.raCtx != nil {
-					c.raCtx.Return <- *recv
+				c.log.Info("received packet", PacketTypeField(packets.DISCONNECT), ReasonCodeField(recv.Content.(*packets.Disconnect).ReasonCode))
+				if ra := c.takeAuthExchange(); ra != nil {
+					d := recv.Content.(*packets.Disconnect)
+					ra.complete(AuthResponseFromPacketDisconnect(d), &AuthError{ReasonCode: d.ReasonCode, ReasonString: d.Properties.ReasonString})
 				}
 				go func() {
 					if c.OnServerDisconnect != nil {
@@ -517,7 +675,7 @@ func (c *Client) incoming() {
 				}()
 				return
 			case packets.PINGRESP:
-				c.debug.Println("received PINGRESP")
+				c.log.Debug("received packet", PacketTypeField(packets.PINGRESP))
 				c.PingHandler.PingResp()
 			}
 		}
@@ -538,13 +696,15 @@ func (c *Client) close() {
 	close(c.stop)
 	close(c.publishPackets)
 
-	c.debug.Println("client stopped")
+	c.log.Debug("client stopped")
 	c.PingHandler.Stop()
-	c.debug.Println("ping stopped")
+	c.log.Debug("ping stopped")
 	_ = c.Conn.Close()
-	c.debug.Println("conn closed")
+	c.log.Debug("conn closed")
+	c.stopWriting()
+	c.log.Debug("writer stopped")
 	c.acksTracker.reset()
-	c.debug.Println("acks tracker reset")
+	c.log.Debug("acks tracker reset")
 }
 
 // error is called to signify that an error situation has occurred, this
@@ -552,7 +712,7 @@ func (c *Client) close() {
 // which results in the other client goroutines terminating.
 // It also closes the client network connection.
 func (c *Client) error(e error) {
-	c.debug.Println("error called:", e)
+	c.log.Debug("client error", ErrorField(e))
 	c.close()
 	c.workers.Wait()
 	go c.OnClientError(e)
@@ -561,68 +721,161 @@ func (c *Client) error(e error) {
 func (c *Client) serverDisconnect(d *Disconnect) {
 	c.close()
 	c.workers.Wait()
-	c.debug.Println("calling OnServerDisconnect")
+	c.log.Debug("calling OnServerDisconnect")
 	go c.OnServerDisconnect(d)
 }
 
+// handleAuth processes an AUTH packet received once the connection is up;
+// this will be part of a re-authentication exchange initiated by the client
+// (via Authenticate). Only the client may send reason code 0x19
+// (Re-authenticate) so, if the server does, the connection is closed with a
+// DISCONNECT (reason code 0x82 Protocol Error); if the AuthVerifier rejects
+// the outcome of the exchange reason code 0x87 (Not authorized) is used. An
+// error is returned if the connection cannot continue.
+func (c *Client) handleAuth(ap *packets.Auth) error {
+	switch ap.ReasonCode {
+	case packets.AuthSuccess:
+		ra := c.takeAuthExchange()
+		if ra == nil {
+			c.log.Debug("received AUTH success with no authentication in progress")
+			return nil
+		}
+		ar := AuthResponseFromPacketAuth(ap)
+		if v, ok := c.AuthHandler.(AuthVerifier); ok {
+			if vErr := v.VerifyAuth(ar.Properties); vErr != nil {
+				err := fmt.Errorf("server authentication failed: %w", vErr)
+				ra.complete(ar, err)
+				c.sendDisconnect(packets.DisconnectNotAuthorized, "server authentication failed")
+				return err
+			}
+		}
+		if c.AuthHandler != nil {
+			go c.AuthHandler.Authenticated()
+		}
+		ra.complete(ar, nil)
+		return nil
+	case packets.AuthReauthenticate:
+		err := fmt.Errorf("received AUTH with reason code 0x%02X (Re-authenticate) from server", ap.ReasonCode)
+		c.failAuthExchange(err)
+		c.sendDisconnect(packets.DisconnectProtocolError, "re-authentication may only be initiated by the client")
+		return err
+	case packets.AuthContinueAuthentication:
+		if c.AuthHandler == nil {
+			c.failAuthExchange(ErrNoAuthHandler)
+			return ErrNoAuthHandler
+		}
+		resp := c.AuthHandler.Authenticate(AuthFromPacketAuth(ap))
+		if resp == nil {
+			err := fmt.Errorf("AuthHandler returned no response to AUTH")
+			c.failAuthExchange(err)
+			return err
+		}
+		c.log.Debug("sending packet", PacketTypeField(packets.AUTH))
+		if _, err := c.write(resp.Packet()); err != nil {
+			c.failAuthExchange(err)
+			return err
+		}
+		return nil
+	default:
+		err := fmt.Errorf("received AUTH with invalid reason code 0x%02X", ap.ReasonCode)
+		c.failAuthExchange(err)
+		return err
+	}
+}
+
+// sendDisconnect sends a DISCONNECT, with the provided reason code, when the
+// client is about to close the connection due to an error by the server
+// (any error writing the packet is logged, as the connection is closing
+// anyway)
+func (c *Client) sendDisconnect(code byte, reason string) {
+	c.log.Debug("sending packet", PacketTypeField(packets.DISCONNECT), ReasonCodeField(code))
+	d := &packets.Disconnect{ReasonCode: code, Properties: &packets.Properties{ReasonString: reason}}
+	if _, err := c.write(d); err != nil {
+		c.log.Warn("failed to send DISCONNECT", ErrorField(err))
+	}
+}
+
+// takeAuthExchange removes, and returns, the re-authentication exchange in
+// progress (nil if there is none)
+func (c *Client) takeAuthExchange() *authExchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ra := c.raCtx
+	c.raCtx = nil
+	return ra
+}
+
+// failAuthExchange ends the re-authentication exchange in progress (if any)
+// with the provided error
+func (c *Client) failAuthExchange(err error) {
+	if ra := c.takeAuthExchange(); ra != nil {
+		ra.complete(nil, err)
+	}
+}
+
 // Authenticate is used to initiate a reauthentication of credentials with the
-// server. This function sends the initial Auth packet to start the reauthentication
-// then relies on the client AuthHandler managing any further requests from the
-// server until either a successful Auth packet is passed back, or a Disconnect
-// is received.
+// server. This function sends the initial Auth packet (which must have reason
+// code 0x19) to start the reauthentication then relies on the client
+// AuthHandler managing any further requests from the server until either a
+// successful Auth packet is passed back, or a Disconnect is received.
+// If the server rejects the reauthentication the AuthResponse (with Success
+// false) is returned along with an *AuthError. An error is also returned if
+// the context is done, or the connection is lost, before the exchange
+// completes.
 func (c *Client) Authenticate(ctx context.Context, a *Auth) (*AuthResponse, error) {
-	c.debug.Println("client initiated reauthentication")
+	c.log.Debug("client initiated reauthentication")
+	if a.ReasonCode != packets.AuthReauthenticate {
+		return nil, fmt.Errorf("reauthentication must use reason code 0x%02X", packets.AuthReauthenticate)
+	}
 
 	c.mu.Lock()
 	if c.raCtx != nil {
 		c.mu.Unlock()
-		return nil, fmt.Errorf("previous authentication is still in progress")
+		return nil, ErrAuthInProgress
 	}
-	c.raCtx = &CPContext{ctx, make(chan packets.ControlPacket, 1)}
+	ra := newAuthExchange()
+	c.raCtx = ra
+	stop := c.stop
 	c.mu.Unlock()
 	defer func() {
 		c.mu.Lock()
-		c.raCtx = nil
+		if c.raCtx == ra {
+			c.raCtx = nil
+		}
 		c.mu.Unlock()
 	}()
 
-	c.debug.Println("sending AUTH")
-	if _, err := a.Packet().WriteTo(c.Conn); err != nil {
+	c.log.Debug("sending packet", PacketTypeField(packets.AUTH))
+	if _, err := c.write(a.Packet()); err != nil {
 		return nil, err
 	}
 
-	var rp packets.ControlPacket
 	select {
 	case <-ctx.Done():
-		if ctxErr := ctx.Err(); ctxErr != nil {
-			c.debug.Println(fmt.Sprintf("terminated due to context: %v", ctxErr))
-			return nil, ctxErr
-		}
-	case rp = <-c.raCtx.Return:
-	}
-
-	switch rp.Type {
-	case packets.AUTH:
-		//If we've received one here it must be successful, the only way
-		//to abort a reauth is a server initiated disconnect
-		return AuthResponseFromPacketAuth(rp.Content.(*packets.Auth)), nil
-	case packets.DISCONNECT:
-		return AuthResponseFromPacketDisconnect(rp.Content.(*packets.Disconnect)), nil
+		c.log.Debug("terminated due to context", ErrorField(ctx.Err()))
+		return nil, ctx.Err()
+	case <-stop:
+		return nil, fmt.Errorf("connection closed during authentication")
+	case r := <-ra.result:
+		return r.resp, r.err
 	}
-
-	return nil, fmt.Errorf("error with Auth, didn't receive Auth or Disconnect")
 }
 
 // Subscribe is used to send a Subscription request to the MQTT server.
 // It is passed a pre-prepared Subscribe packet and blocks waiting for
 // a response Suback, or for the timeout to fire. Any response Suback
-// is returned from the function, along with any errors.
+// is returned from the function, along with any errors. If the server
+// rejects any of the requested subscriptions the error is a
+// *SubscribeError detailing the outcome for each topic filter.
 func (c *Client) Subscribe(ctx context.Context, s *Subscribe) (*Suback, error) {
+	if len(s.Subscriptions) == 0 {
+		return nil, fmt.Errorf("cannot send a subscribe with no subscriptions")
+	}
 	if !c.serverProps.WildcardSubAvailable {
-		for t := range s.Subscriptions {
-			if strings.ContainsAny(t, "#+") {
+		for _, sub := range s.Subscriptions {
+			if strings.ContainsAny(sub.Topic, "#+") {
 				// Using a wildcard in a subscription when not supported
-				return nil, fmt.Errorf("cannot subscribe to %s, server does not support wildcards", t)
+				return nil, fmt.Errorf("cannot subscribe to %s, server does not support wildcards", sub.Topic)
 			}
 		}
 	}
@@ -630,14 +883,14 @@ func (c *Client) Subscribe(ctx context.Context, s *Subscribe) (*Suback, error) {
 		return nil, fmt.Errorf("cannot send subscribe with subID set, server does not support subID")
 	}
 	if !c.serverProps.SharedSubAvailable {
-		for t := range s.Subscriptions {
-			if strings.HasPrefix(t, "$share") {
-				return nil, fmt.Errorf("cannont subscribe to %s, server does not support shared subscriptions", t)
+		for _, sub := range s.Subscriptions {
+			if strings.HasPrefix(sub.Topic, "$share") {
+				return nil, fmt.Errorf("cannont subscribe to %s, server does not support shared subscriptions", sub.Topic)
 			}
 		}
 	}
 
-	c.debug.Printf("subscribing to %+v", s.Subscriptions)
+	c.log.Debug("subscribing", Field{Key: "subscriptions", Value: s.Subscriptions})
 
 	subCtx, cf := context.WithTimeout(ctx, c.PacketTimeout)
 	defer cf()
@@ -652,17 +905,17 @@ func (c *Client) Subscribe(ctx context.Context, s *Subscribe) (*Suback, error) {
 	defer c.MIDs.Free(mid)
 	sp.PacketID = mid
 
-	c.debug.Println("sending SUBSCRIBE")
-	if _, err := sp.WriteTo(c.Conn); err != nil {
+	c.log.Debug("sending packet", PacketTypeField(packets.SUBSCRIBE))
+	if _, err := c.write(sp); err != nil {
 		return nil, err
 	}
-	c.debug.Println("waiting for SUBACK")
+	c.log.Debug("waiting for SUBACK")
 	var sap packets.ControlPacket
 
 	select {
 	case <-subCtx.Done():
 		if ctxErr := subCtx.Err(); ctxErr != nil {
-			c.debug.Println(fmt.Sprintf("terminated due to context: %v", ctxErr))
+			c.log.Debug("terminated due to context", ErrorField(ctxErr))
 			return nil, ctxErr
 		}
 	case sap = <-cpCtx.Return:
@@ -671,24 +924,19 @@ func (c *Client) Subscribe(ctx context.Context, s *Subscribe) (*Suback, error) {
 	if sap.Type != packets.SUBACK {
 		return nil, fmt.Errorf("received %d instead of Suback", sap.Type)
 	}
-	c.debug.Println("received SUBACK")
+	c.log.Debug("received packet", PacketTypeField(packets.SUBACK))
 
 	sa := SubackFromPacketSuback(sap.Content.(*packets.Suback))
-	switch {
-	case len(sa.Reasons) == 1:
-		if sa.Reasons[0] >= 0x80 {
-			var reason string
-			c.debug.Println("received an error code in Suback:", sa.Reasons[0])
-			if sa.Properties != nil {
-				reason = sa.Properties.ReasonString
-			}
-			return sa, fmt.Errorf("failed to subscribe to topic: %s", reason)
-		}
-	default:
-		for _, code := range sa.Reasons {
-			if code >= 0x80 {
-				c.debug.Println("received an error code in Suback:", code)
-				return sa, fmt.Errorf("at least one requested subscription failed")
+	results, err := sa.Results(s)
+	if err != nil {
+		return sa, err
+	}
+	for _, r := range results {
+		if !r.Succeeded() {
+			c.log.Debug("subscription failed", TopicField(r.Topic), ReasonCodeField(r.ReasonCode))
+			return sa, &SubscribeError{
+				Results:      results,
+				ReasonString: sa.Properties.ReasonString,
 			}
 		}
 	}
@@ -701,7 +949,7 @@ func (c *Client) Subscribe(ctx context.Context, s *Subscribe) (*Suback, error) {
 // a response Unsuback, or for the timeout to fire. Any response Unsuback
 // is returned from the function, along with any errors.
 func (c *Client) Unsubscribe(ctx context.Context, u *Unsubscribe) (*Unsuback, error) {
-	c.debug.Printf("unsubscribing from %+v", u.Topics)
+	c.log.Debug("unsubscribing", Field{Key: "topics", Value: u.Topics})
 	unsubCtx, cf := context.WithTimeout(ctx, c.PacketTimeout)
 	defer cf()
 	cpCtx := &CPContext{unsubCtx, make(chan packets.ControlPacket, 1)}
@@ -715,17 +963,17 @@ func (c *Client) Unsubscribe(ctx context.Context, u *Unsubscribe) (*Unsuback, er
 	defer c.MIDs.Free(mid)
 	up.PacketID = mid
 
-	c.debug.Println("sending UNSUBSCRIBE")
-	if _, err := up.WriteTo(c.Conn); err != nil {
+	c.log.Debug("sending packet", PacketTypeField(packets.UNSUBSCRIBE))
+	if _, err := c.write(up); err != nil {
 		return nil, err
 	}
-	c.debug.Println("waiting for UNSUBACK")
+	c.log.Debug("waiting for UNSUBACK")
 	var uap packets.ControlPacket
 
 	select {
 	case <-unsubCtx.Done():
 		if ctxErr := unsubCtx.Err(); ctxErr != nil {
-			c.debug.Println(fmt.Sprintf("terminated due to context: %v", ctxErr))
+			c.log.Debug("terminated due to context", ErrorField(ctxErr))
 			return nil, ctxErr
 		}
 	case uap = <-cpCtx.Return:
@@ -734,14 +982,14 @@ func (c *Client) Unsubscribe(ctx context.Context, u *Unsubscribe) (*Unsuback, er
 	if uap.Type != packets.UNSUBACK {
 		return nil, fmt.Errorf("received %d instead of Unsuback", uap.Type)
 	}
-	c.debug.Println("received SUBACK")
+	c.log.Debug("received packet", PacketTypeField(packets.UNSUBACK))
 
 	ua := UnsubackFromPacketUnsuback(uap.Content.(*packets.Unsuback))
 	switch {
 	case len(ua.Reasons) == 1:
 		if ua.Reasons[0] >= 0x80 {
 			var reason string
-			c.debug.Println("received an error code in Unsuback:", ua.Reasons[0])
+			c.log.Debug("received an error code in UNSUBACK", ReasonCodeField(ua.Reasons[0]))
 			if ua.Properties != nil {
 				reason = ua.Properties.ReasonString
 			}
@@ -750,7 +998,7 @@ func (c *Client) Unsubscribe(ctx context.Context, u *Unsubscribe) (*Unsuback, er
 	default:
 		for _, code := range ua.Reasons {
 			if code >= 0x80 {
-				c.debug.Println("received an error code in Suback:", code)
+				c.log.Debug("received an error code in UNSUBACK", ReasonCodeField(code))
 				return ua, fmt.Errorf("at least one requested unsubscribe failed")
 			}
 		}
@@ -782,27 +1030,47 @@ func (c *Client) Publish(ctx context.Context, p *Publish) (*PublishResponse, err
 	if c.ClientConfig.PublishHook != nil {
 		c.ClientConfig.PublishHook(p)
 	}
+	if c.ClientConfig.PublishContextHook != nil {
+		c.ClientConfig.PublishContextHook(ctx, p)
+	}
 
-	c.debug.Printf("sending message to %s", p.Topic)
+	c.log.Debug("publishing", TopicField(p.Topic), Field{Key: "qos", Value: p.QoS})
 
 	pb := p.Packet()
 
+	c.inflightMu.Lock()
+	if c.draining {
+		c.inflightMu.Unlock()
+		return nil, ErrDraining
+	}
+	if p.QoS == 1 || p.QoS == 2 {
+		c.inflight++ // within the lock so that Drain cannot miss this message
+		c.Metrics.Inflight(c.inflight)
+	}
+	c.inflightMu.Unlock()
+
+	start := time.Now()
 	switch p.QoS {
 	case 0:
-		c.debug.Println("sending QoS0 message")
-		if _, err := pb.WriteTo(c.Conn); err != nil {
+		c.log.Debug("sending QoS0 message")
+		_, err := c.write(pb)
+		c.Metrics.PublishCompleted(p.QoS, time.Since(start), err)
+		if err != nil {
 			return nil, err
 		}
 		return nil, nil
 	case 1, 2:
-		return c.publishQoS12(ctx, pb)
+		defer c.addInflight(-1)
+		pr, err := c.publishQoS12(ctx, pb)
+		c.Metrics.PublishCompleted(p.QoS, time.Since(start), err)
+		return pr, err
 	}
 
 	return nil, fmt.Errorf("QoS isn't 0, 1 or 2")
 }
 
 func (c *Client) publishQoS12(ctx context.Context, pb *packets.Publish) (*PublishResponse, error) {
-	c.debug.Println("sending QoS12 message")
+	c.log.Debug("sending QoS12 message")
 	pubCtx, cf := context.WithTimeout(ctx, c.PacketTimeout)
 	defer cf()
 	if err := c.serverInflight.Acquire(pubCtx, 1); err != nil {
@@ -818,7 +1086,14 @@ func (c *Client) publishQoS12(ctx context.Context, pb *packets.Publish) (*Publis
 	defer c.MIDs.Free(mid)
 	pb.PacketID = mid
 
-	if _, err := pb.WriteTo(c.Conn); err != nil {
+	c.Persistence.Put(mid, packets.ControlPacket{
+		FixedHeader: packets.FixedHeader{Type: packets.PUBLISH},
+		Content:     pb,
+	})
+	if _, err := c.write(pb); err != nil {
+		if errors.Is(err, ErrDropPacket) {
+			c.Persistence.Delete(mid) // nothing was sent so there is nothing to resend
+		}
 		return nil, err
 	}
 	var resp packets.ControlPacket
@@ -826,11 +1101,18 @@ func (c *Client) publishQoS12(ctx context.Context, pb *packets.Publish) (*Publis
 	select {
 	case <-pubCtx.Done():
 		if ctxErr := pubCtx.Err(); ctxErr != nil {
-			c.debug.Println(fmt.Sprintf("terminated due to context: %v", ctxErr))
+			c.log.Debug("terminated due to context", ErrorField(ctxErr))
+			select {
+			case <-c.stop:
+				// Connection lost; the message remains persisted so it can be resent if the session is resumed
+			default:
+				c.Persistence.Delete(mid)
+			}
 			return nil, ctxErr
 		}
 	case resp = <-cpCtx.Return:
 	}
+	c.Persistence.Delete(mid)
 
 	switch pb.QoS {
 	case 1:
@@ -840,7 +1122,7 @@ func (c *Client) publishQoS12(ctx context.Context, pb *packets.Publish) (*Publis
 
 		pr := PublishResponseFromPuback(resp.Content.(*packets.Puback))
 		if pr.ReasonCode >= 0x80 {
-			c.debug.Println("received an error code in Puback:", pr.ReasonCode)
+			c.log.Debug("received an error code in PUBACK", PacketIDField(pb.PacketID), ReasonCodeField(pr.ReasonCode))
 			return pr, fmt.Errorf("error publishing: %s", resp.Content.(*packets.Puback).Reason())
 		}
 		return pr, nil
@@ -850,7 +1132,7 @@ func (c *Client) publishQoS12(ctx context.Context, pb *packets.Publish) (*Publis
 			pr := PublishResponseFromPubcomp(resp.Content.(*packets.Pubcomp))
 			return pr, nil
 		case packets.PUBREC:
-			c.debug.Printf("received PUBREC for %s (must have errored)", pb.PacketID)
+			c.log.Debug("received PUBREC instead of PUBCOMP (must have errored)", PacketIDField(pb.PacketID), ReasonCodeField(resp.Content.(*packets.Pubrec).ReasonCode))
 			pr := PublishResponseFromPubrec(resp.Content.(*packets.Pubrec))
 			return pr, nil
 		default:
@@ -858,32 +1140,50 @@ func (c *Client) publishQoS12(ctx context.Context, pb *packets.Publish) (*Publis
 		}
 	}
 
-	c.debug.Println("ended up with a non QoS1/2 message:", pb.QoS)
+	c.log.Debug("ended up with a non QoS1/2 message", Field{Key: "qos", Value: pb.QoS})
 	return nil, fmt.Errorf("ended up with a non QoS1/2 message: %d", pb.QoS)
 }
 
 func (c *Client) expectConnack(packet chan<- *packets.Connack, errs chan<- error) {
-	recv, err := packets.ReadPacket(c.Conn)
+	recv, err := c.read()
 	if err != nil {
 		errs <- err
 		return
 	}
 	switch r := recv.Content.(type) {
 	case *packets.Connack:
-		c.debug.Println("received CONNACK")
+		c.log.Debug("received packet", PacketTypeField(packets.CONNACK), ReasonCodeField(r.ReasonCode))
 		if r.ReasonCode == packets.ConnackSuccess && r.Properties != nil && r.Properties.AuthMethod != "" {
 			// Successful connack and AuthMethod is defined, must have successfully authed during connect
-			go c.AuthHandler.Authenticated()
+			if v, ok := c.AuthHandler.(AuthVerifier); ok {
+				if err := v.VerifyAuth(&AuthProperties{AuthMethod: r.Properties.AuthMethod, AuthData: r.Properties.AuthData}); err != nil {
+					c.sendDisconnect(packets.DisconnectNotAuthorized, "server authentication failed")
+					errs <- fmt.Errorf("server authentication failed: %w", err)
+					return
+				}
+			}
+			if c.AuthHandler != nil {
+				go c.AuthHandler.Authenticated()
+			}
 		}
 		packet <- r
 	case *packets.Auth:
-		c.debug.Println("received AUTH")
+		c.log.Debug("received packet", PacketTypeField(packets.AUTH), ReasonCodeField(r.ReasonCode))
 		if c.AuthHandler == nil {
-			errs <- fmt.Errorf("enhanced authentication flow started but no AuthHandler configured")
+			errs <- ErrNoAuthHandler
+			return
+		}
+		if r.ReasonCode != packets.AuthContinueAuthentication {
+			errs <- fmt.Errorf("received AUTH with unexpected reason code 0x%02X during connect", r.ReasonCode)
 			return
 		}
-		c.debug.Println("sending AUTH")
-		_, err := c.AuthHandler.Authenticate(AuthFromPacketAuth(r)).Packet().WriteTo(c.Conn)
+		resp := c.AuthHandler.Authenticate(AuthFromPacketAuth(r))
+		if resp == nil {
+			errs <- fmt.Errorf("AuthHandler returned no response to AUTH")
+			return
+		}
+		c.log.Debug("sending packet", PacketTypeField(packets.AUTH))
+		_, err := c.write(resp.Packet())
 		if err != nil {
 			errs <- fmt.Errorf("error sending authentication packet: %w", err)
 			return
@@ -896,13 +1196,64 @@ func (c *Client) expectConnack(packet chan<- *packets.Connack, errs chan<- error
 
 }
 
+// addInflight adjusts the count of outgoing QoS1/2 messages that have
+// not been fully acknowledged
+func (c *Client) addInflight(n int) {
+	c.inflightMu.Lock()
+	c.inflight += n
+	c.Metrics.Inflight(c.inflight)
+	c.inflightMu.Unlock()
+}
+
+// Drain is used prior to Disconnect to shut down gracefully. Once called
+// Publish will return ErrDraining; Drain then blocks until all outgoing
+// QoS1/2 messages have been acknowledged by the server and, if
+// EnableManualAcknowledgment is set, all received messages have been
+// acknowledged (via Ack) and those acknowledgements sent. An error is
+// returned if the context is done or the connection is lost before this
+// completes (in which case unacknowledged messages remain in the
+// Persistence).
+func (c *Client) Drain(ctx context.Context) error {
+	c.log.Debug("draining")
+	c.inflightMu.Lock()
+	c.draining = true
+	c.inflightMu.Unlock()
+
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+	for {
+		if c.EnableManualAcknowledgment {
+			c.acksTracker.flush(func(pbs []*packets.Publish) {
+				for _, pb := range pbs {
+					c.ack(pb)
+				}
+			})
+		}
+		c.inflightMu.Lock()
+		inflight := c.inflight
+		c.inflightMu.Unlock()
+		if inflight == 0 && c.acksTracker.pending() == 0 {
+			c.log.Debug("drained")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.stop:
+			return fmt.Errorf("connection lost while draining")
+		case <-t.C:
+		}
+	}
+}
+
 // Disconnect is used to send a Disconnect packet to the MQTT server
 // Whether or not the attempt to send the Disconnect packet fails
 // (and if it does this function returns any error) the network connection
 // is closed.
 func (c *Client) Disconnect(d *Disconnect) error {
-	c.debug.Println("disconnecting")
-	_, err := d.Packet().WriteTo(c.Conn)
+	c.log.Info("disconnecting", ReasonCodeField(d.ReasonCode))
+	_, err := c.write(d.Packet())
 
 	c.close()
 	c.workers.Wait()
@@ -911,13 +1262,42 @@ func (c *Client) Disconnect(d *Disconnect) error {
 }
 
 // SetDebugLogger takes an instance of the paho Logger interface
-// and sets it to be used by the debug log endpoint
+// and sets it to be used by the debug log endpoint (entries below
+// LevelWarn are written to it unless a StructuredLogger is in use)
 func (c *Client) SetDebugLogger(l Logger) {
 	c.debug = l
+	c.setLogger()
 }
 
 // SetErrorLogger takes an instance of the paho Logger interface
-// and sets it to be used by the error log endpoint
+// and sets it to be used by the error log endpoint (entries at
+// LevelWarn and above are written to it unless a StructuredLogger is
+// in use)
 func (c *Client) SetErrorLogger(l Logger) {
 	c.errors = l
+	c.setLogger()
+}
+
+// SetLogger sets the StructuredLogger used by the client (and the
+// PingHandler and Router if they have a SetLogger method), replacing
+// any Loggers set with SetDebugLogger and SetErrorLogger
+func (c *Client) SetLogger(l StructuredLogger) {
+	c.Logger = l
+	c.setLogger()
+}
+
+// setLogger configures the logging for the client, Logger is used if set
+// otherwise the debug and errors Loggers are adapted
+func (c *Client) setLogger() {
+	if c.Logger == nil {
+		c.log = LevelLogger{NewLoggerAdapter(c.debug, c.errors)}
+		return
+	}
+	c.log = LevelLogger{c.Logger}
+	if ls, ok := c.PingHandler.(interface{ SetLogger(StructuredLogger) }); ok {
+		ls.SetLogger(c.Logger)
+	}
+	if ls, ok := c.Router.(interface{ SetLogger(StructuredLogger) }); ok {
+		ls.SetLogger(c.Logger)
+	}
 }
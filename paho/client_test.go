@@ -109,10 +109,10 @@ func TestClientSubscribe(t *testing.T) {
 	go c.PingHandler.Start(c.Conn, 30*time.Second)
 
 	s := &Subscribe{
-		Subscriptions: map[string]SubscribeOptions{
-			"test/1": {QoS: 1},
-			"test/2": {QoS: 2},
-			"test/3": {QoS: 0},
+		Subscriptions: []SubscribeOptions{
+			{Topic: "test/1", QoS: 1},
+			{Topic: "test/2", QoS: 2},
+			{Topic: "test/3", QoS: 0},
 		},
 	}
 
@@ -123,6 +123,48 @@ func TestClientSubscribe(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 }
 
+func TestClientSubscribePartialFailure(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.SUBACK, &packets.Suback{
+		Reasons:    []byte{1, packets.SubackNotauthorized, 0},
+		Properties: &packets.Properties{},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	c := NewClient(ClientConfig{
+		Conn: ts.ClientConn(),
+	})
+	require.NotNil(t, c)
+	c.SetDebugLogger(log.New(os.Stderr, "SUBSCRIBEPARTIAL: ", log.LstdFlags))
+
+	c.stop = make(chan struct{})
+	c.publishPackets = make(chan *packets.Publish)
+	go c.incoming()
+	go c.PingHandler.Start(c.Conn, 30*time.Second)
+
+	s := &Subscribe{
+		Subscriptions: []SubscribeOptions{
+			{Topic: "test/1", QoS: 1},
+			{Topic: "test/2", QoS: 2},
+			{Topic: "test/3", QoS: 0},
+		},
+	}
+
+	sa, err := c.Subscribe(context.Background(), s)
+	require.NotNil(t, sa)
+	var se *SubscribeError
+	require.True(t, errors.As(err, &se))
+	assert.Equal(t, []SubscribeResult{
+		{Topic: "test/1", ReasonCode: 1},
+		{Topic: "test/2", ReasonCode: packets.SubackNotauthorized},
+		{Topic: "test/3", ReasonCode: 0},
+	}, se.Results)
+	assert.Equal(t, []SubscribeResult{{Topic: "test/2", ReasonCode: packets.SubackNotauthorized}}, se.Failed())
+
+	time.Sleep(10 * time.Millisecond)
+}
+
 func TestClientUnsubscribe(t *testing.T) {
 	ts := newTestServer()
 	ts.SetResponse(packets.UNSUBACK, &packets.Unsuback{
@@ -692,6 +734,221 @@ func TestAuthenticateOnConnect(t *testing.T) {
 	assert.False(t, waitTimeout(&wg, 1*time.Second))
 }
 
+// startAuthClient returns a client, using ts, that is ready for re-authentication
+func startAuthClient(t *testing.T, ts *testServer, ah Auther, prefix string) *Client {
+	c := NewClient(ClientConfig{
+		Conn:        ts.ClientConn(),
+		AuthHandler: ah,
+	})
+	require.NotNil(t, c)
+	c.SetDebugLogger(log.New(os.Stderr, prefix, log.LstdFlags))
+
+	c.serverInflight = semaphore.NewWeighted(10000)
+	c.clientInflight = semaphore.NewWeighted(10000)
+	c.stop = make(chan struct{})
+	c.publishPackets = make(chan *packets.Publish)
+	go c.incoming()
+	go c.PingHandler.Start(c.Conn, 30*time.Second)
+	return c
+}
+
+func TestAuthenticateRejected(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.AUTH, &packets.Disconnect{
+		ReasonCode: packets.DisconnectNotAuthorized,
+		Properties: &packets.Properties{
+			ReasonString: "bad credentials",
+		},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	c := startAuthClient(t, ts, &fakeAuth{}, "AUTHENTICATEREJECTED: ")
+
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	ar, err := c.Authenticate(ctx, &Auth{
+		ReasonCode: packets.AuthReauthenticate,
+		Properties: &AuthProperties{AuthMethod: "TEST"},
+	})
+	var authErr *AuthError
+	require.True(t, errors.As(err, &authErr), "expected AuthError, got %v", err)
+	assert.Equal(t, byte(packets.DisconnectNotAuthorized), authErr.ReasonCode)
+	assert.Equal(t, "bad credentials", authErr.ReasonString)
+	require.NotNil(t, ar)
+	assert.False(t, ar.Success)
+}
+
+// rejectingAuth is an Auther whose AuthVerifier rejects the server
+type rejectingAuth struct{ fakeAuth }
+
+func (r *rejectingAuth) VerifyAuth(*AuthProperties) error { return errors.New("bad server signature") }
+
+func TestAuthenticateVerifyAuthRejected(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.AUTH, &packets.Auth{
+		ReasonCode: packets.AuthSuccess,
+		Properties: &packets.Properties{AuthMethod: "TEST"},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	errs := make(chan error, 1)
+	c := startAuthClient(t, ts, &rejectingAuth{}, "VERIFYAUTHREJECTED: ")
+	c.OnClientError = func(err error) { errs <- err }
+
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	_, err := c.Authenticate(ctx, &Auth{
+		ReasonCode: packets.AuthReauthenticate,
+		Properties: &AuthProperties{AuthMethod: "TEST"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server authentication failed")
+
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("client did not close the connection")
+	}
+	require.Eventually(t, func() bool { return len(ts.ReceivedDisconnects()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, byte(packets.DisconnectNotAuthorized), ts.ReceivedDisconnects()[0].ReasonCode)
+}
+
+func TestConnectVerifyAuthRejected(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.CONNACK, &packets.Connack{
+		ReasonCode: packets.ConnackSuccess,
+		Properties: &packets.Properties{AuthMethod: "TEST"},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	c := NewClient(ClientConfig{
+		Conn:        ts.ClientConn(),
+		AuthHandler: &rejectingAuth{},
+	})
+	require.NotNil(t, c)
+
+	_, err := c.Connect(context.Background(), &Connect{
+		KeepAlive:  30,
+		ClientID:   "testClient",
+		CleanStart: true,
+		Properties: &ConnectProperties{AuthMethod: "TEST"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server authentication failed")
+	require.Eventually(t, func() bool { return len(ts.ReceivedDisconnects()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, byte(packets.DisconnectNotAuthorized), ts.ReceivedDisconnects()[0].ReasonCode)
+}
+
+func TestAuthenticateErrors(t *testing.T) {
+	ts := newTestServer() // no response to AUTH
+	go ts.Run()
+	defer ts.Stop()
+
+	c := startAuthClient(t, ts, &fakeAuth{}, "AUTHENTICATEERRORS: ")
+	reauth := &Auth{
+		ReasonCode: packets.AuthReauthenticate,
+		Properties: &AuthProperties{AuthMethod: "TEST"},
+	}
+
+	_, err := c.Authenticate(context.Background(), &Auth{ReasonCode: packets.AuthContinueAuthentication})
+	assert.Error(t, err, "expected reason code to be checked")
+
+	ctx, cf := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := c.Authenticate(ctx, reauth)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, err = c.Authenticate(context.Background(), reauth)
+	assert.True(t, errors.Is(err, ErrAuthInProgress), "expected ErrAuthInProgress, got %v", err)
+
+	cf()
+	select {
+	case err = <-done:
+		assert.True(t, errors.Is(err, context.Canceled), "expected context.Canceled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Authenticate did not return when context cancelled")
+	}
+
+	c.mu.Lock()
+	assert.Nil(t, c.raCtx, "exchange should be cleared once Authenticate returns")
+	c.mu.Unlock()
+}
+
+func TestServerReauthenticateIsProtocolError(t *testing.T) {
+	called := make(chan struct{}, 1)
+	auther := TestAuth{
+		auther: func(a *Auth) *Auth {
+			called <- struct{}{}
+			return a
+		},
+		authenticated: func() {},
+	}
+	ts := newTestServer()
+	go ts.Run()
+	defer ts.Stop()
+
+	errs := make(chan error, 1)
+	c := startAuthClient(t, ts, &auther, "SERVERREAUTH: ")
+	c.OnClientError = func(err error) { errs <- err }
+
+	err := ts.SendPacket(&packets.Auth{
+		ReasonCode: packets.AuthReauthenticate,
+		Properties: &packets.Properties{
+			AuthMethod: "testauth",
+			AuthData:   []byte("server data"),
+		},
+	})
+	require.NoError(t, err)
+
+	select {
+	case err = <-errs:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("client did not close the connection")
+	}
+	require.Eventually(t, func() bool { return len(ts.ReceivedDisconnects()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, byte(packets.DisconnectProtocolError), ts.ReceivedDisconnects()[0].ReasonCode)
+	select {
+	case <-called:
+		t.Error("AuthHandler should not be called")
+	default:
+	}
+}
+
+func TestConnectAuthMethodWithoutAuthHandler(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.CONNACK, &packets.Connack{
+		ReasonCode: packets.ConnackSuccess,
+		Properties: &packets.Properties{
+			AuthMethod: "TOKEN",
+		},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	c := NewClient(ClientConfig{
+		Conn: ts.ClientConn(),
+	})
+	require.NotNil(t, c)
+
+	ca, err := c.Connect(context.Background(), &Connect{
+		KeepAlive:  30,
+		ClientID:   "testClient",
+		CleanStart: true,
+		Properties: &ConnectProperties{
+			AuthMethod: "TOKEN",
+			AuthData:   []byte("token"),
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0), ca.ReasonCode)
+}
+
 func TestCleanup(t *testing.T) {
 	ts := newTestServer()
 	go ts.Run()
@@ -745,6 +1002,86 @@ func TestCleanup(t *testing.T) {
 	assert.Equal(t, uint8(0), ca.ReasonCode)
 }
 
+func TestSessionResumeResendsPersisted(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.CONNACK, &packets.Connack{
+		ReasonCode:     0,
+		SessionPresent: true,
+		Properties:     &packets.Properties{},
+	})
+	ts.SetResponse(packets.PUBACK, &packets.Puback{
+		ReasonCode: packets.PubackSuccess,
+		Properties: &packets.Properties{},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	p := &MemoryPersistence{}
+	p.Open()
+	p.Put(5, packets.ControlPacket{
+		FixedHeader: packets.FixedHeader{Type: packets.PUBLISH},
+		Content: &packets.Publish{
+			PacketID:   5,
+			QoS:        1,
+			Topic:      "test/resend",
+			Payload:    []byte("resend me"),
+			Properties: &packets.Properties{},
+		},
+	})
+
+	c := NewClient(ClientConfig{
+		Conn:        ts.ClientConn(),
+		Persistence: p,
+	})
+	require.NotNil(t, c)
+	c.SetDebugLogger(log.New(os.Stderr, "SESSIONRESUME: ", log.LstdFlags))
+	t.Cleanup(c.close)
+
+	ca, err := c.Connect(context.Background(), &Connect{
+		KeepAlive: 30,
+		ClientID:  "testClient",
+	})
+	require.Nil(t, err)
+	assert.True(t, ca.SessionPresent)
+
+	// the resent message is removed from the persistence once the PUBACK is received
+	assert.Eventually(t, func() bool { return len(p.All()) == 0 }, time.Second, 10*time.Millisecond)
+	assert.Nil(t, c.MIDs.Get(5))
+}
+
+func TestNoSessionResetsPersistence(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.CONNACK, &packets.Connack{
+		ReasonCode:     0,
+		SessionPresent: false,
+		Properties:     &packets.Properties{},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	p := &MemoryPersistence{}
+	p.Open()
+	p.Put(5, packets.ControlPacket{
+		FixedHeader: packets.FixedHeader{Type: packets.PUBREL, Flags: 2},
+		Content:     &packets.Pubrel{PacketID: 5},
+	})
+
+	c := NewClient(ClientConfig{
+		Conn:        ts.ClientConn(),
+		Persistence: p,
+	})
+	require.NotNil(t, c)
+	t.Cleanup(c.close)
+
+	_, err := c.Connect(context.Background(), &Connect{
+		KeepAlive:  30,
+		ClientID:   "testClient",
+		CleanStart: true,
+	})
+	require.Nil(t, err)
+	assert.Empty(t, p.All())
+}
+
 func TestDisconnect(t *testing.T) {
 	ts := newTestServer()
 	ts.SetResponse(packets.CONNACK, &packets.Connack{
@@ -903,3 +1240,119 @@ func isChannelClosed(ch chan struct{}) (closed bool) {
 	ch <- struct{}{}
 	return
 }
+
+func TestClientDrain(t *testing.T) {
+	ts := newTestServer() // No PUBACK response so the publish remains in-flight
+	go ts.Run()
+	defer ts.Stop()
+
+	c := NewClient(ClientConfig{
+		Conn: ts.ClientConn(),
+	})
+	require.NotNil(t, c)
+	c.SetDebugLogger(log.New(os.Stderr, "DRAIN: ", log.LstdFlags))
+
+	c.serverInflight = semaphore.NewWeighted(10000)
+	c.clientInflight = semaphore.NewWeighted(10000)
+	c.stop = make(chan struct{})
+	c.publishPackets = make(chan *packets.Publish)
+	go c.incoming()
+	go c.PingHandler.Start(c.Conn, 30*time.Second)
+
+	published := make(chan error, 1)
+	go func() {
+		_, err := c.Publish(context.Background(), &Publish{Topic: "test/1", QoS: 1, Payload: []byte("test payload")})
+		published <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // allow the PUBLISH to be sent
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.Drain(ctx))
+
+	_, err := c.Publish(context.Background(), &Publish{Topic: "test/1", QoS: 0, Payload: []byte("too late")})
+	assert.Equal(t, ErrDraining, err)
+
+	drained := make(chan error, 1)
+	go func() { drained <- c.Drain(context.Background()) }()
+	select {
+	case <-drained:
+		t.Fatal("Drain returned while message in-flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.Nil(t, ts.SendPacket(&packets.Puback{PacketID: 1, Properties: &packets.Properties{}}))
+	require.Nil(t, <-published)
+	select {
+	case err := <-drained:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not return after message acknowledged")
+	}
+}
+
+func TestContextMessageHandler(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.CONNACK, &packets.Connack{
+		ReasonCode: 0,
+		Properties: &packets.Properties{
+			MaximumQOS: Byte(1),
+		},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	c := NewClient(ClientConfig{
+		Conn:                       ts.ClientConn(),
+		EnableManualAcknowledgment: true,
+	})
+	require.NotNil(t, c)
+	c.SetDebugLogger(log.New(os.Stderr, "CONTEXTHANDLER: ", log.LstdFlags))
+
+	received := make(chan context.Context, 1)
+	r := NewStandardRouter()
+	r.RegisterContextHandler("test/+", func(ctx context.Context, p *Publish) {
+		mi, ok := MessageInfoFromContext(ctx)
+		require.True(t, ok)
+		require.NoError(t, mi.Ack())
+		received <- ctx
+	})
+	c.Router = r
+
+	ca, err := c.Connect(context.Background(), &Connect{
+		KeepAlive:  30,
+		ClientID:   "testClient",
+		CleanStart: true,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, uint8(0), ca.ReasonCode)
+
+	before := time.Now()
+	require.NoError(t, ts.SendPacket(&packets.Publish{
+		PacketID:   1,
+		Topic:      "test/1",
+		Payload:    []byte("test payload"),
+		QoS:        1,
+		Properties: &packets.Properties{},
+	}))
+
+	var ctx context.Context
+	select {
+	case ctx = <-received:
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+	mi, _ := MessageInfoFromContext(ctx)
+	assert.Equal(t, "test/+", mi.Filter)
+	assert.Equal(t, c, mi.Client)
+	assert.False(t, mi.Received.Before(before))
+	require.Eventually(t, func() bool { return len(ts.ReceivedPubacks()) == 1 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, ctx.Err())
+
+	require.NoError(t, c.Disconnect(&Disconnect{}))
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled when the client stopped")
+	}
+}
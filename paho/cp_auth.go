@@ -72,6 +72,8 @@ func AuthResponseFromPacketAuth(a *packets.Auth) *AuthResponse {
 		Success:    true,
 		ReasonCode: a.ReasonCode,
 		Properties: &AuthProperties{
+			AuthMethod:   a.Properties.AuthMethod,
+			AuthData:     a.Properties.AuthData,
 			ReasonString: a.Properties.ReasonString,
 			User:         UserPropertiesFromPacketUser(a.Properties.User),
 		},
@@ -82,7 +84,7 @@ func AuthResponseFromPacketAuth(a *packets.Auth) *AuthResponse {
 // returns a paho library AuthResponse
 func AuthResponseFromPacketDisconnect(d *packets.Disconnect) *AuthResponse {
 	return &AuthResponse{
-		Success:    true,
+		Success:    false,
 		ReasonCode: d.ReasonCode,
 		Properties: &AuthProperties{
 			ReasonString: d.Properties.ReasonString,
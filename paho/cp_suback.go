@@ -1,6 +1,11 @@
 package paho
 
-import "github.com/eclipse/paho.golang/packets"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eclipse/paho.golang/packets"
+)
 
 type (
 	// Suback is a representation of an MQTT suback packet
@@ -39,3 +44,82 @@ func SubackFromPacketSuback(s *packets.Suback) *Suback {
 		},
 	}
 }
+
+// SubscribeResult is the outcome of a single subscription requested in a
+// Subscribe, Topic is the topic filter that was requested and ReasonCode is
+// the value returned for it by the server in the Suback
+type SubscribeResult struct {
+	Topic      string
+	ReasonCode byte
+}
+
+// Succeeded returns true if the server accepted the subscription
+func (s SubscribeResult) Succeeded() bool {
+	return s.ReasonCode < 0x80
+}
+
+// GrantedQoS returns the maximum QoS the server granted for the
+// subscription, it is only meaningful if Succeeded() returns true
+func (s SubscribeResult) GrantedQoS() byte {
+	return s.ReasonCode
+}
+
+// Reason returns a string representation of the meaning of the ReasonCode
+func (s SubscribeResult) Reason() string {
+	return (&packets.Suback{Reasons: []byte{s.ReasonCode}}).Reason(0)
+}
+
+// Results pairs each of the subscriptions in s with the reason code the
+// server returned for it in the Suback on which it is called. An error is
+// returned if the number of reason codes does not match the number of
+// subscriptions requested.
+func (s *Suback) Results(sub *Subscribe) ([]SubscribeResult, error) {
+	if len(s.Reasons) != len(sub.Subscriptions) {
+		return nil, fmt.Errorf("suback contains %d reason codes for %d subscriptions", len(s.Reasons), len(sub.Subscriptions))
+	}
+	r := make([]SubscribeResult, len(s.Reasons))
+	for i, code := range s.Reasons {
+		r[i] = SubscribeResult{
+			Topic:      sub.Subscriptions[i].Topic,
+			ReasonCode: code,
+		}
+	}
+
+	return r, nil
+}
+
+// SubscribeError is returned from Subscribe when the server rejects one or
+// more of the requested subscriptions, Results contains the outcome of every
+// requested subscription (including those that succeeded)
+type SubscribeError struct {
+	Results      []SubscribeResult
+	ReasonString string
+}
+
+// Failed returns the results of the subscriptions that were not accepted
+// by the server
+func (e *SubscribeError) Failed() []SubscribeResult {
+	var r []SubscribeResult
+	for _, v := range e.Results {
+		if !v.Succeeded() {
+			r = append(r, v)
+		}
+	}
+
+	return r
+}
+
+func (e *SubscribeError) Error() string {
+	var b strings.Builder
+
+	failed := e.Failed()
+	fmt.Fprintf(&b, "%d of %d requested subscriptions failed:", len(failed), len(e.Results))
+	for _, v := range failed {
+		fmt.Fprintf(&b, " %s (reason code 0x%02X)", v.Topic, v.ReasonCode)
+	}
+	if e.ReasonString != "" {
+		fmt.Fprintf(&b, ": %s", e.ReasonString)
+	}
+
+	return b.String()
+}
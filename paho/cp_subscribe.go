@@ -6,11 +6,14 @@ type (
 	// Subscribe is a representation of a MQTT subscribe packet
 	Subscribe struct {
 		Properties    *SubscribeProperties
-		Subscriptions map[string]SubscribeOptions
+		Subscriptions []SubscribeOptions
 	}
 
-	// SubscribeOptions is the struct representing the options for a subscription
+	// SubscribeOptions is the struct representing the options for a subscription,
+	// Topic is the topic filter being subscribed to. Subscriptions are sent to
+	// the server in the order they appear in Subscribe.Subscriptions
 	SubscribeOptions struct {
+		Topic             string
 		QoS               byte
 		RetainHandling    byte
 		NoLocal           bool
@@ -35,12 +38,13 @@ func (s *Subscribe) InitProperties(prop *packets.Properties) {
 	}
 }
 
-// PacketSubOptionsFromSubscribeOptions returns a map of string to packet
-// library SubOptions for the paho Subscribe on which it is called
-func (s *Subscribe) PacketSubOptionsFromSubscribeOptions() map[string]packets.SubOptions {
-	r := make(map[string]packets.SubOptions)
-	for k, v := range s.Subscriptions {
-		r[k] = packets.SubOptions{
+// PacketSubOptionsFromSubscribeOptions returns a slice of packet library
+// SubOptions for the paho Subscribe on which it is called
+func (s *Subscribe) PacketSubOptionsFromSubscribeOptions() []packets.SubOptions {
+	r := make([]packets.SubOptions, len(s.Subscriptions))
+	for i, v := range s.Subscriptions {
+		r[i] = packets.SubOptions{
+			Topic:             v.Topic,
 			QoS:               v.QoS,
 			NoLocal:           v.NoLocal,
 			RetainAsPublished: v.RetainAsPublished,
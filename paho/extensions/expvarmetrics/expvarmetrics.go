@@ -0,0 +1,137 @@
+// Package expvarmetrics provides a paho.Metrics implementation that publishes the client metrics via expvar (so they
+// are available, as JSON, from /debug/vars when the default HTTP mux is in use).
+//
+// The following variables are maintained within the map:
+//
+//	packets_sent, packets_received    - maps of packet type (e.g. PUBLISH) to count
+//	bytes_sent, bytes_received        - total bytes
+//	publish_total, publish_errors     - number of calls to Publish that have completed (and of those, how many failed)
+//	publish_latency_us                - total latency of successful publishes (in microseconds; divide by
+//	                                    publish_total - publish_errors for the mean)
+//	publish_latency_last_us           - latency of the most recent successful publish
+//	inflight                          - current number of QoS1/2 messages awaiting acknowledgement
+//	ping_rtt_us                       - round trip time of the most recent ping
+//	reconnects                        - number of times the connection has been re-established
+package expvarmetrics
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// packetTypeNames maps packet type to name
+var packetTypeNames = [...]string{
+	"UNKNOWN",
+	"CONNECT",
+	"CONNACK",
+	"PUBLISH",
+	"PUBACK",
+	"PUBREC",
+	"PUBREL",
+	"PUBCOMP",
+	"SUBSCRIBE",
+	"SUBACK",
+	"UNSUBSCRIBE",
+	"UNSUBACK",
+	"PINGREQ",
+	"PINGRESP",
+	"DISCONNECT",
+	"AUTH",
+}
+
+// Metrics implements paho.Metrics, recording values in an expvar.Map
+type Metrics struct {
+	packetsSent     *expvar.Map
+	packetsReceived *expvar.Map
+	bytesSent       *expvar.Int
+	bytesReceived   *expvar.Int
+	publishTotal    *expvar.Int
+	publishErrors   *expvar.Int
+	publishLatency  *expvar.Int
+	publishLast     *expvar.Int
+	inflight        *expvar.Int
+	pingRTT         *expvar.Int
+	reconnects      *expvar.Int
+}
+
+var _ paho.Metrics = (*Metrics)(nil)
+
+// New returns Metrics published via expvar under name; as with expvar.NewMap it panics if the name is already in use.
+func New(name string) *Metrics {
+	return NewWithMap(expvar.NewMap(name))
+}
+
+// NewWithMap returns Metrics that record values in m (which may, or may not, be published)
+func NewWithMap(m *expvar.Map) *Metrics {
+	newInt := func(name string) *expvar.Int {
+		v := new(expvar.Int)
+		m.Set(name, v)
+		return v
+	}
+	newMap := func(name string) *expvar.Map {
+		v := new(expvar.Map).Init()
+		m.Set(name, v)
+		return v
+	}
+	return &Metrics{
+		packetsSent:     newMap("packets_sent"),
+		packetsReceived: newMap("packets_received"),
+		bytesSent:       newInt("bytes_sent"),
+		bytesReceived:   newInt("bytes_received"),
+		publishTotal:    newInt("publish_total"),
+		publishErrors:   newInt("publish_errors"),
+		publishLatency:  newInt("publish_latency_us"),
+		publishLast:     newInt("publish_latency_last_us"),
+		inflight:        newInt("inflight"),
+		pingRTT:         newInt("ping_rtt_us"),
+		reconnects:      newInt("reconnects"),
+	}
+}
+
+// packetTypeName returns the name used to record packets of type pt
+func packetTypeName(pt byte) string {
+	if int(pt) < len(packetTypeNames) {
+		return packetTypeNames[pt]
+	}
+	return packetTypeNames[0]
+}
+
+// PacketSent implements paho.Metrics
+func (m *Metrics) PacketSent(packetType byte, size int) {
+	m.packetsSent.Add(packetTypeName(packetType), 1)
+	m.bytesSent.Add(int64(size))
+}
+
+// PacketReceived implements paho.Metrics
+func (m *Metrics) PacketReceived(packetType byte, size int) {
+	m.packetsReceived.Add(packetTypeName(packetType), 1)
+	m.bytesReceived.Add(int64(size))
+}
+
+// PublishCompleted implements paho.Metrics
+func (m *Metrics) PublishCompleted(_ byte, latency time.Duration, err error) {
+	m.publishTotal.Add(1)
+	if err != nil {
+		m.publishErrors.Add(1)
+		return
+	}
+	m.publishLatency.Add(latency.Microseconds())
+	m.publishLast.Set(latency.Microseconds())
+}
+
+// Inflight implements paho.Metrics
+func (m *Metrics) Inflight(count int) {
+	m.inflight.Set(int64(count))
+}
+
+// PingRTT implements paho.Metrics
+func (m *Metrics) PingRTT(rtt time.Duration) {
+	m.pingRTT.Set(rtt.Microseconds())
+}
+
+// Reconnected implements paho.Metrics
+func (m *Metrics) Reconnected() {
+	m.reconnects.Add(1)
+}
@@ -0,0 +1,50 @@
+package expvarmetrics
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+func TestMetrics(t *testing.T) {
+	vars := new(expvar.Map).Init()
+	m := NewWithMap(vars)
+
+	m.PacketSent(packets.PUBLISH, 20)
+	m.PacketSent(packets.PUBLISH, 30)
+	m.PacketSent(packets.PINGREQ, 2)
+	m.PacketReceived(packets.PUBACK, 4)
+	m.PacketReceived(99, 1)
+	m.PublishCompleted(1, 3*time.Millisecond, nil)
+	m.PublishCompleted(1, time.Millisecond, nil)
+	m.PublishCompleted(1, time.Second, errors.New("failed"))
+	m.Inflight(3)
+	m.PingRTT(1500 * time.Microsecond)
+	m.Reconnected()
+
+	for name, want := range map[string]string{
+		"packets_sent":            `{"PINGREQ": 1, "PUBLISH": 2}`,
+		"packets_received":        `{"PUBACK": 1, "UNKNOWN": 1}`,
+		"bytes_sent":              "52",
+		"bytes_received":          "5",
+		"publish_total":           "3",
+		"publish_errors":          "1",
+		"publish_latency_us":      "4000",
+		"publish_latency_last_us": "1000",
+		"inflight":                "3",
+		"ping_rtt_us":             "1500",
+		"reconnects":              "1",
+	} {
+		v := vars.Get(name)
+		if v == nil {
+			t.Errorf("%s not set", name)
+			continue
+		}
+		if got := v.String(); got != want {
+			t.Errorf("%s: expected %s, got %s", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Package scram provides a paho.Auther implementing the Salted Challenge Response Authentication Mechanism
+// (RFC 5802 / RFC 7677) for MQTT v5 enhanced authentication.
+//
+// The exchange begins with the client-first-message being sent as the AuthData of the CONNECT packet (see
+// SetConnectProperties) or of an AUTH packet with reason code 0x19 (see Reauthenticate). The server responds with an
+// AUTH packet (0x18) containing the server-first-message, to which Authenticate responds with the
+// client-final-message. The server-final-message, received in the CONNACK (or final AUTH), is checked by VerifyAuth
+// so that the client knows that the server also has the credentials.
+package scram
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// Mechanism identifies a SCRAM variant (the hash function used)
+type Mechanism struct {
+	Name string           // The authentication method (e.g. SCRAM-SHA-256)
+	Hash func() hash.Hash // The hash function
+}
+
+var (
+	SHA1   = Mechanism{Name: "SCRAM-SHA-1", Hash: sha1.New}
+	SHA256 = Mechanism{Name: "SCRAM-SHA-256", Hash: sha256.New}
+	SHA512 = Mechanism{Name: "SCRAM-SHA-512", Hash: sha512.New}
+)
+
+// DefaultMaxIterations is the highest iteration count that an Auther will accept from the server by default (the
+// work required is proportional to the count, so a malicious server could otherwise use a huge value to tie up the
+// client); see SetMaxIterations.
+const DefaultMaxIterations = 1 << 20
+
+// gs2Header indicates that channel binding is not supported and no authorization identity is provided
+const gs2Header = "n,,"
+
+// Auther implements paho.Auther and paho.AuthVerifier for SCRAM authentication. An Auther holds the state of a single
+// exchange at a time (each call to ClientFirst begins a new exchange).
+type Auther struct {
+	mechanism Mechanism
+	username  string
+	password  string
+
+	mu             sync.Mutex
+	clientNonce    string
+	clientFirst    string // client-first-message-bare
+	maxIterations  int    // the highest iteration count accepted from the server
+	serverSig      []byte // expected ServerSignature (set once the server-first-message is processed)
+	err            error  // the error that caused the exchange to fail (if any)
+	authenticated  bool
+	onAuthenticate func() // called (within a goroutine) by Authenticated
+}
+
+// NewAuther returns an Auther that will authenticate as username using the provided password and mechanism.
+// The password should already be normalised (SASLprep is not applied).
+func NewAuther(m Mechanism, username, password string) *Auther {
+	return &Auther{
+		mechanism:     m,
+		username:      username,
+		password:      password,
+		maxIterations: DefaultMaxIterations,
+	}
+}
+
+// SetMaxIterations sets the highest iteration count that will be accepted from the server (the exchange fails if the
+// server-first-message specifies a higher count); the default is DefaultMaxIterations.
+func (a *Auther) SetMaxIterations(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxIterations = n
+}
+
+// OnAuthenticated sets a function that will be called when an authentication exchange completes successfully
+func (a *Auther) OnAuthenticated(fn func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onAuthenticate = fn
+}
+
+// Method returns the name of the authentication method (e.g. SCRAM-SHA-256)
+func (a *Auther) Method() string {
+	return a.mechanism.Name
+}
+
+// ClientFirst begins a new exchange and returns the client-first-message (to be sent as AuthData)
+func (a *Auther) ClientFirst() ([]byte, error) {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clientNonce = base64.RawStdEncoding.EncodeToString(nonce)
+	a.clientFirst = "n=" + escapeName(a.username) + ",r=" + a.clientNonce
+	a.serverSig = nil
+	a.err = nil
+	a.authenticated = false
+	return []byte(gs2Header + a.clientFirst), nil
+}
+
+// SetConnectProperties begins a new exchange, setting the AuthMethod and AuthData in the Connect packet properties
+func (a *Auther) SetConnectProperties(cp *paho.Connect) error {
+	data, err := a.ClientFirst()
+	if err != nil {
+		return err
+	}
+	if cp.Properties == nil {
+		cp.Properties = &paho.ConnectProperties{
+			RequestProblemInfo: true, // the default when no properties are sent
+		}
+	}
+	cp.Properties.AuthMethod = a.Method()
+	cp.Properties.AuthData = data
+	return nil
+}
+
+// Reauthenticate begins a new exchange, returning an AUTH packet suitable for passing to paho.Client.Authenticate
+func (a *Auther) Reauthenticate() (*paho.Auth, error) {
+	data, err := a.ClientFirst()
+	if err != nil {
+		return nil, err
+	}
+	return &paho.Auth{
+		ReasonCode: packets.AuthReauthenticate,
+		Properties: &paho.AuthProperties{
+			AuthMethod: a.Method(),
+			AuthData:   data,
+		},
+	}, nil
+}
+
+// Authenticate is the paho.Auther implementation; it processes the server-first-message and returns an AUTH packet
+// containing the client-final-message. If the server-first-message is invalid the exchange is failed (see Err) and
+// the AUTH packet returned contains no data (the server will reject this).
+func (a *Auther) Authenticate(in *paho.Auth) *paho.Auth {
+	out := &paho.Auth{
+		ReasonCode: packets.AuthContinueAuthentication,
+		Properties: &paho.AuthProperties{AuthMethod: a.Method()},
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var serverFirst []byte
+	if in.Properties != nil {
+		serverFirst = in.Properties.AuthData
+	}
+	clientFinal, err := a.clientFinal(string(serverFirst))
+	if err != nil {
+		a.err = err
+		return out
+	}
+	out.Properties.AuthData = []byte(clientFinal)
+	return out
+}
+
+// clientFinal processes the server-first-message and returns the client-final-message (mu must be held)
+func (a *Auther) clientFinal(serverFirst string) (string, error) {
+	if a.clientFirst == "" {
+		return "", errors.New("exchange not started")
+	}
+	attrs, err := parseAttributes(serverFirst)
+	if err != nil {
+		return "", err
+	}
+	if e, ok := attrs['e']; ok {
+		return "", fmt.Errorf("server error: %s", e)
+	}
+	nonce, saltB64, iterStr := attrs['r'], attrs['s'], attrs['i']
+	if !strings.HasPrefix(nonce, a.clientNonce) || len(nonce) == len(a.clientNonce) {
+		return "", errors.New("invalid server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(saltB64)
+	if err != nil || len(salt) == 0 {
+		return "", errors.New("invalid salt")
+	}
+	iterations, err := strconv.Atoi(iterStr)
+	if err != nil || iterations < 1 {
+		return "", errors.New("invalid iteration count")
+	}
+	if iterations > a.maxIterations {
+		return "", fmt.Errorf("iteration count (%d) exceeds maximum (%d)", iterations, a.maxIterations)
+	}
+
+	salted := saltedPassword(a.mechanism.Hash, []byte(a.password), salt, iterations)
+	clientKey := hmacSum(a.mechanism.Hash, salted, []byte("Client Key"))
+	storedKey := hashSum(a.mechanism.Hash, clientKey)
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte(gs2Header)) + ",r=" + nonce
+	authMessage := []byte(a.clientFirst + "," + serverFirst + "," + withoutProof)
+
+	proof := hmacSum(a.mechanism.Hash, storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	serverKey := hmacSum(a.mechanism.Hash, salted, []byte("Server Key"))
+	a.serverSig = hmacSum(a.mechanism.Hash, serverKey, authMessage)
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// VerifyAuth is the paho.AuthVerifier implementation; it checks the server signature in the server-final-message
+func (a *Auther) VerifyAuth(p *paho.AuthProperties) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	if a.serverSig == nil {
+		return errors.New("authentication exchange incomplete")
+	}
+	var serverFinal string
+	if p != nil {
+		serverFinal = string(p.AuthData)
+	}
+	attrs, err := parseAttributes(serverFinal)
+	if err == nil {
+		if e, ok := attrs['e']; ok {
+			err = fmt.Errorf("server error: %s", e)
+		} else if sig, decErr := base64.StdEncoding.DecodeString(attrs['v']); decErr != nil || subtle.ConstantTimeCompare(sig, a.serverSig) != 1 {
+			err = errors.New("invalid server signature")
+		}
+	}
+	if err != nil {
+		a.err = err
+		return err
+	}
+	a.authenticated = true
+	return nil
+}
+
+// Authenticated is the paho.Auther implementation; it is called when the server indicates that authentication
+// was successful
+func (a *Auther) Authenticated() {
+	a.mu.Lock()
+	fn := a.onAuthenticate
+	a.mu.Unlock()
+	if fn != nil {
+		go fn()
+	}
+}
+
+// Err returns the error that caused the most recent exchange to fail (nil if it has not failed)
+func (a *Auther) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// escapeName encodes ',' and '=' as required in the SCRAM username (saslname)
+func escapeName(s string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s)
+}
+
+// parseAttributes parses a SCRAM message (comma separated attr=value pairs)
+func parseAttributes(msg string) (map[byte]string, error) {
+	attrs := make(map[byte]string)
+	for _, f := range strings.Split(msg, ",") {
+		if len(f) < 2 || f[1] != '=' {
+			return nil, fmt.Errorf("invalid SCRAM message %q", msg)
+		}
+		attrs[f[0]] = f[2:]
+	}
+	return attrs, nil
+}
+
+// saltedPassword implements Hi() from RFC 5802 (which is PBKDF2 with HMAC as the pseudorandom function and a single
+// block of output)
+func saltedPassword(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// hmacSum returns HMAC(key, data)
+func hmacSum(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// hashSum returns H(data)
+func hashSum(h func() hash.Hash, data []byte) []byte {
+	d := h()
+	d.Write(data)
+	return d.Sum(nil)
+}
@@ -0,0 +1,300 @@
+package scram
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// fakeServer implements the server side of a SCRAM exchange for a single user
+type fakeServer struct {
+	t          *testing.T
+	mechanism  Mechanism
+	salt       []byte
+	iterations int
+	storedKey  []byte
+	serverKey  []byte
+	badSig     bool // send an incorrect server signature
+
+	connected   bool   // CONNACK has been sent (so further exchanges are re-authentication)
+	nonce       string // combined nonce for the exchange in progress
+	authMessage string // client-first-message-bare + "," + server-first-message
+}
+
+func newFakeServer(t *testing.T, m Mechanism, password string) *fakeServer {
+	s := &fakeServer{t: t, mechanism: m, salt: []byte("fake server salt"), iterations: 4096}
+	salted := saltedPassword(m.Hash, []byte(password), s.salt, s.iterations)
+	s.storedKey = hashSum(m.Hash, hmacSum(m.Hash, salted, []byte("Client Key")))
+	s.serverKey = hmacSum(m.Hash, salted, []byte("Server Key"))
+	return s
+}
+
+// serverFirst processes the client-first-message and returns the server-first-message
+func (s *fakeServer) serverFirst(clientFirst []byte) []byte {
+	msg := string(clientFirst)
+	if !strings.HasPrefix(msg, gs2Header) {
+		s.t.Errorf("unexpected gs2 header in %q", msg)
+	}
+	bare := strings.TrimPrefix(msg, gs2Header)
+	attrs, err := parseAttributes(bare)
+	if err != nil {
+		s.t.Error(err)
+	}
+	if attrs['n'] != "user=3Dname" {
+		s.t.Errorf("expected escaped username, got %q", attrs['n'])
+	}
+	s.nonce = attrs['r'] + "serverpart"
+	first := "r=" + s.nonce + ",s=" + base64.StdEncoding.EncodeToString(s.salt) + ",i=4096"
+	s.authMessage = bare + "," + first
+	return []byte(first)
+}
+
+// serverFinal verifies the client-final-message, returning the server-final-message (or false if the proof is invalid)
+func (s *fakeServer) serverFinal(clientFinal []byte) ([]byte, bool) {
+	msg := string(clientFinal)
+	idx := strings.LastIndex(msg, ",p=")
+	attrs, err := parseAttributes(msg)
+	if err != nil || idx < 0 {
+		s.t.Errorf("invalid client-final-message %q", msg)
+		return nil, false
+	}
+	if attrs['r'] != s.nonce || attrs['c'] != "biws" {
+		s.t.Errorf("unexpected client-final-message %q", msg)
+	}
+	authMessage := []byte(s.authMessage + "," + msg[:idx])
+	proof, _ := base64.StdEncoding.DecodeString(attrs['p'])
+	clientKey := hmacSum(s.mechanism.Hash, s.storedKey, authMessage)
+	if len(proof) != len(clientKey) {
+		return nil, false
+	}
+	for i := range clientKey {
+		clientKey[i] ^= proof[i]
+	}
+	if !bytes.Equal(hashSum(s.mechanism.Hash, clientKey), s.storedKey) {
+		return nil, false
+	}
+	sig := hmacSum(s.mechanism.Hash, s.serverKey, authMessage)
+	if s.badSig {
+		sig[0] ^= 0xff
+	}
+	return []byte("v=" + base64.StdEncoding.EncodeToString(sig)), true
+}
+
+// run handles packets received over conn until it is closed
+func (s *fakeServer) run(conn net.Conn) {
+	defer conn.Close()
+	for {
+		recv, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := recv.Content.(type) {
+		case *packets.Connect:
+			if p.Properties == nil || p.Properties.AuthMethod != s.mechanism.Name {
+				s.t.Errorf("unexpected auth method in CONNECT: %+v", p.Properties)
+				return
+			}
+			s.sendAuth(conn, packets.AuthContinueAuthentication, s.serverFirst(p.Properties.AuthData))
+		case *packets.Auth:
+			switch p.ReasonCode {
+			case packets.AuthReauthenticate:
+				s.sendAuth(conn, packets.AuthContinueAuthentication, s.serverFirst(p.Properties.AuthData))
+			case packets.AuthContinueAuthentication:
+				final, ok := s.serverFinal(p.Properties.AuthData)
+				if !ok {
+					if s.connected {
+						_, _ = (&packets.Disconnect{ReasonCode: packets.DisconnectNotAuthorized}).WriteTo(conn)
+					} else {
+						_, _ = (&packets.Connack{ReasonCode: packets.ConnackNotAuthorized}).WriteTo(conn)
+					}
+					return
+				}
+				if s.connected {
+					s.sendAuth(conn, packets.AuthSuccess, final)
+					continue
+				}
+				s.connected = true
+				_, _ = (&packets.Connack{
+					ReasonCode: packets.ConnackSuccess,
+					Properties: &packets.Properties{AuthMethod: s.mechanism.Name, AuthData: final},
+				}).WriteTo(conn)
+			}
+		case *packets.Disconnect:
+			return
+		}
+	}
+}
+
+// sendAuth sends an AUTH packet containing data
+func (s *fakeServer) sendAuth(conn net.Conn, rc byte, data []byte) {
+	_, _ = (&packets.Auth{
+		ReasonCode: rc,
+		Properties: &packets.Properties{AuthMethod: s.mechanism.Name, AuthData: data},
+	}).WriteTo(conn)
+}
+
+// connect establishes a connection to the fake server, authenticating via a
+func connect(t *testing.T, s *fakeServer, a *Auther) (*paho.Client, error) {
+	clientConn, serverConn := net.Pipe()
+	go s.run(serverConn)
+
+	c := paho.NewClient(paho.ClientConfig{Conn: clientConn, AuthHandler: a})
+	cp := &paho.Connect{ClientID: "test", KeepAlive: 30, CleanStart: true}
+	if err := a.SetConnectProperties(cp); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := c.Connect(ctx, cp)
+	return c, err
+}
+
+func TestAuther(t *testing.T) {
+	for _, m := range []Mechanism{SHA1, SHA256, SHA512} {
+		t.Run(m.Name, func(t *testing.T) {
+			s := newFakeServer(t, m, "pencil")
+			a := NewAuther(m, "user=name", "pencil")
+			authenticated := make(chan struct{}, 2)
+			a.OnAuthenticated(func() { authenticated <- struct{}{} })
+
+			c, err := connect(t, s, a)
+			if err != nil {
+				t.Fatalf("connect failed: %s", err)
+			}
+			defer c.Disconnect(&paho.Disconnect{})
+			select {
+			case <-authenticated:
+			case <-time.After(time.Second):
+				t.Fatal("Authenticated not called")
+			}
+
+			reauth, err := a.Reauthenticate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			ar, err := c.Authenticate(ctx, reauth)
+			if err != nil {
+				t.Fatalf("re-authentication failed: %s", err)
+			}
+			if !ar.Success {
+				t.Fatalf("expected successful re-authentication, got %+v", ar)
+			}
+			if a.Err() != nil {
+				t.Fatalf("unexpected error: %s", a.Err())
+			}
+		})
+	}
+}
+
+func TestAutherWrongPassword(t *testing.T) {
+	s := newFakeServer(t, SHA256, "pencil")
+	a := NewAuther(SHA256, "user=name", "pen")
+	if _, err := connect(t, s, a); err == nil {
+		t.Fatal("expected connect to fail")
+	}
+}
+
+func TestAutherBadServerSignature(t *testing.T) {
+	s := newFakeServer(t, SHA256, "pencil")
+	s.badSig = true
+	a := NewAuther(SHA256, "user=name", "pencil")
+	_, err := connect(t, s, a)
+	if err == nil || !strings.Contains(err.Error(), "invalid server signature") {
+		t.Fatalf("expected server signature to be rejected, got %v", err)
+	}
+	if a.Err() == nil {
+		t.Fatal("expected Err to report the failure")
+	}
+}
+
+func TestAutherInvalidServerFirst(t *testing.T) {
+	a := NewAuther(SHA256, "user", "pencil")
+	first, err := a.ClientFirst()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := strings.TrimPrefix(string(first), gs2Header+"n=user,r=")
+
+	for _, msg := range []string{
+		"r=othernonce,s=c2FsdA==,i=4096",    // nonce not prefixed by client nonce
+		"r=" + nonce + ",s=c2FsdA==,i=4096", // server did not extend the nonce
+		"r=" + nonce + "x,s=!!,i=4096",      // invalid salt
+		"r=" + nonce + "x,s=c2FsdA==,i=0",   // invalid iteration count
+		"e=unknown-user",
+		"garbage",
+	} {
+		if _, err := a.ClientFirst(); err != nil {
+			t.Fatal(err)
+		}
+		a.clientNonce = nonce
+		out := a.Authenticate(&paho.Auth{
+			ReasonCode: packets.AuthContinueAuthentication,
+			Properties: &paho.AuthProperties{AuthMethod: SHA256.Name, AuthData: []byte(msg)},
+		})
+		if len(out.Properties.AuthData) != 0 || a.Err() == nil {
+			t.Errorf("expected %q to be rejected", msg)
+		}
+	}
+}
+
+func TestAutherMaxIterations(t *testing.T) {
+	for _, tc := range []struct {
+		max        int // 0 means use the default
+		iterations string
+		accept     bool
+	}{
+		{max: 4096, iterations: "4096", accept: true},
+		{max: 4095, iterations: "4096", accept: false},
+		{iterations: "1048576", accept: true},
+		{iterations: "2000000000", accept: false},
+	} {
+		a := NewAuther(SHA256, "user", "pencil")
+		if tc.max != 0 {
+			a.SetMaxIterations(tc.max)
+		}
+		first, err := a.ClientFirst()
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := strings.TrimPrefix(string(first), gs2Header+"n=user,r=")
+		out := a.Authenticate(&paho.Auth{
+			ReasonCode: packets.AuthContinueAuthentication,
+			Properties: &paho.AuthProperties{AuthMethod: SHA256.Name, AuthData: []byte("r=" + nonce + "x,s=c2FsdA==,i=" + tc.iterations)},
+		})
+		if tc.accept && (a.Err() != nil || len(out.Properties.AuthData) == 0) {
+			t.Errorf("max %d: expected %s iterations to be accepted, got %v", tc.max, tc.iterations, a.Err())
+		}
+		if !tc.accept && (a.Err() == nil || !strings.Contains(a.Err().Error(), "exceeds maximum")) {
+			t.Errorf("max %d: expected %s iterations to be rejected, got %v", tc.max, tc.iterations, a.Err())
+		}
+	}
+}
+
+// TestAutherRFC7677 checks the exchange against the SCRAM-SHA-256 test vector from RFC 7677
+func TestAutherRFC7677(t *testing.T) {
+	a := NewAuther(SHA256, "user", "pencil")
+	a.clientNonce = "rOprNGfwEbeRWgbNEkqO"
+	a.clientFirst = "n=user,r=rOprNGfwEbeRWgbNEkqO"
+	out := a.Authenticate(&paho.Auth{
+		ReasonCode: packets.AuthContinueAuthentication,
+		Properties: &paho.AuthProperties{
+			AuthData: []byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"),
+		},
+	})
+	want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if got := string(out.Properties.AuthData); got != want {
+		t.Fatalf("expected client-final-message %q, got %q", want, got)
+	}
+	if err := a.VerifyAuth(&paho.AuthProperties{AuthData: []byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")}); err != nil {
+		t.Fatalf("server signature rejected: %s", err)
+	}
+}
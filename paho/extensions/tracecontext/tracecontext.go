@@ -0,0 +1,169 @@
+// Package tracecontext propagates distributed trace context across MQTT hops using the W3C Trace Context format
+// (https://www.w3.org/TR/trace-context/), carried in the `traceparent` and `tracestate` user properties of PUBLISH
+// packets.
+//
+// The core library does not depend upon any particular tracing implementation; instead a Tracer converts between a
+// context.Context and the two header values. W3C provides a Tracer that simply stores the headers in the context
+// (see ContextWithTraceContext), and an adapter for OpenTelemetry (or any other tracer) can be written in a few
+// lines, e.g. using the propagation.TraceContext propagator with a propagation.MapCarrier.
+//
+// To inject the trace context on publish set ClientConfig.PublishContextHook (paho or autopaho) to PublishHook(t);
+// to extract it on receipt wrap the message handler with ContextHandler(t, h) or Handler(t, h) (or call Extract).
+package tracecontext
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// The user property keys used to carry the trace context
+const (
+	TraceParentKey = "traceparent"
+	TraceStateKey  = "tracestate"
+)
+
+// Tracer converts between a context and the W3C trace context headers
+type Tracer interface {
+	// Inject returns the traceparent and tracestate for the span carried by ctx (traceParent should be empty if
+	// there is no span, in which case nothing is added to the Publish)
+	Inject(ctx context.Context) (traceParent, traceState string)
+	// Extract returns a context, derived from ctx, carrying the received trace context (if the values are invalid
+	// ctx should be returned unchanged)
+	Extract(ctx context.Context, traceParent, traceState string) context.Context
+}
+
+// TraceContext holds the W3C trace context headers
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+}
+
+type contextKey struct{}
+
+// ContextWithTraceContext returns a context, derived from ctx, carrying tc
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, tc)
+}
+
+// FromContext returns the TraceContext carried by ctx (if any)
+func FromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(contextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// W3C is a Tracer that stores the trace context headers in the context (see ContextWithTraceContext and
+// FromContext); it is useful where the trace context is passed on, rather than used to create spans. Invalid
+// traceparent values are discarded.
+type W3C struct{}
+
+var _ Tracer = W3C{}
+
+// Inject implements Tracer
+func (W3C) Inject(ctx context.Context) (string, string) {
+	tc, ok := FromContext(ctx)
+	if !ok || !ValidTraceParent(tc.TraceParent) {
+		return "", ""
+	}
+	return tc.TraceParent, tc.TraceState
+}
+
+// Extract implements Tracer
+func (W3C) Extract(ctx context.Context, traceParent, traceState string) context.Context {
+	if !ValidTraceParent(traceParent) {
+		return ctx
+	}
+	return ContextWithTraceContext(ctx, TraceContext{TraceParent: traceParent, TraceState: traceState})
+}
+
+// ValidTraceParent reports whether s is a valid traceparent header (version-traceid-parentid-flags, with the
+// trace id and parent id not all zeros). Future versions, which may append further fields, are accepted.
+func ValidTraceParent(s string) bool {
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" {
+		return false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return false
+	}
+	return isHex(parts[1], 32) && !isZero(parts[1]) &&
+		isHex(parts[2], 16) && !isZero(parts[2]) &&
+		isHex(parts[3], 2)
+}
+
+// isHex reports whether s consists of n lowercase hex digits
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// isZero reports whether s consists entirely of '0'
+func isZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+// PublishHook returns a function, suitable for ClientConfig.PublishContextHook, that adds the trace context carried
+// by the context passed to Publish to the user properties (replacing any existing trace context properties)
+func PublishHook(t Tracer) func(context.Context, *paho.Publish) {
+	return func(ctx context.Context, p *paho.Publish) {
+		Inject(t, ctx, p)
+	}
+}
+
+// Inject adds the trace context carried by ctx to the user properties of p (replacing any existing trace
+// context properties); p is unchanged if ctx does not carry a trace context.
+func Inject(t Tracer, ctx context.Context, p *paho.Publish) {
+	traceParent, traceState := t.Inject(ctx)
+	if traceParent == "" {
+		return
+	}
+	if p.Properties == nil {
+		p.Properties = &paho.PublishProperties{}
+	}
+	user := make(paho.UserProperties, 0, len(p.Properties.User)+2)
+	for _, u := range p.Properties.User {
+		if u.Key != TraceParentKey && u.Key != TraceStateKey {
+			user = append(user, u)
+		}
+	}
+	user.Add(TraceParentKey, traceParent)
+	if traceState != "" {
+		user.Add(TraceStateKey, traceState)
+	}
+	p.Properties.User = user
+}
+
+// Extract returns a context, derived from ctx, carrying the trace context received in p (if any)
+func Extract(t Tracer, ctx context.Context, p *paho.Publish) context.Context {
+	if p.Properties == nil {
+		return ctx
+	}
+	traceParent := p.Properties.User.Get(TraceParentKey)
+	if traceParent == "" {
+		return ctx
+	}
+	// Multiple tracestate headers are combined (as they would be in HTTP)
+	traceState := strings.Join(p.Properties.User.GetAll(TraceStateKey), ",")
+	return t.Extract(ctx, traceParent, traceState)
+}
+
+// Handler returns a paho.MessageHandler that calls h with a context carrying the trace context received in the
+// message (derived from context.Background())
+func Handler(t Tracer, h paho.ContextMessageHandler) paho.MessageHandler {
+	return func(p *paho.Publish) {
+		h(Extract(t, context.Background(), p), p)
+	}
+}
+
+// ContextHandler returns a paho.ContextMessageHandler that calls h with a context, derived from that passed by the
+// router, carrying the trace context received in the message
+func ContextHandler(t Tracer, h paho.ContextMessageHandler) paho.ContextMessageHandler {
+	return func(ctx context.Context, p *paho.Publish) {
+		h(Extract(t, ctx, p), p)
+	}
+}
@@ -0,0 +1,139 @@
+package tracecontext
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	testTraceState  = "congo=t61rcWkgMzE"
+)
+
+func TestValidTraceParent(t *testing.T) {
+	for _, tt := range []struct {
+		in    string
+		valid bool
+	}{
+		{testTraceParent, true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true}, // future version
+		{"", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01", false},
+	} {
+		if got := ValidTraceParent(tt.in); got != tt.valid {
+			t.Errorf("ValidTraceParent(%q) = %v, want %v", tt.in, got, tt.valid)
+		}
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	ctx := ContextWithTraceContext(context.Background(), TraceContext{TraceParent: testTraceParent, TraceState: testTraceState})
+	p := &paho.Publish{
+		Topic: "test",
+		Properties: &paho.PublishProperties{
+			User: paho.UserProperties{
+				{Key: "a", Value: "b"},
+				{Key: TraceParentKey, Value: "stale"},
+			},
+		},
+	}
+	Inject(W3C{}, ctx, p)
+	if got := p.Properties.User.GetAll(TraceParentKey); len(got) != 1 || got[0] != testTraceParent {
+		t.Fatalf("unexpected traceparent %v", got)
+	}
+	if got := p.Properties.User.Get(TraceStateKey); got != testTraceState {
+		t.Fatalf("unexpected tracestate %q", got)
+	}
+	if got := p.Properties.User.Get("a"); got != "b" {
+		t.Fatalf("existing user property lost")
+	}
+
+	tc, ok := FromContext(Extract(W3C{}, context.Background(), p))
+	if !ok || tc.TraceParent != testTraceParent || tc.TraceState != testTraceState {
+		t.Fatalf("unexpected trace context %+v (%v)", tc, ok)
+	}
+
+	// Nothing should be added if the context carries no trace context
+	p = &paho.Publish{Topic: "test"}
+	Inject(W3C{}, context.Background(), p)
+	if p.Properties != nil {
+		t.Fatalf("unexpected properties %+v", p.Properties)
+	}
+	if _, ok := FromContext(Extract(W3C{}, context.Background(), p)); ok {
+		t.Fatal("unexpected trace context extracted")
+	}
+
+	// Invalid values should not be propagated
+	p.Properties = &paho.PublishProperties{User: paho.UserProperties{{Key: TraceParentKey, Value: "invalid"}}}
+	if _, ok := FromContext(Extract(W3C{}, context.Background(), p)); ok {
+		t.Fatal("invalid traceparent extracted")
+	}
+}
+
+// TestRoundTrip publishes a message, which the server echoes back, and checks that the trace context reaches the
+// handler
+func TestRoundTrip(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		defer serverConn.Close()
+		for {
+			recv, err := packets.ReadPacket(serverConn)
+			if err != nil {
+				return
+			}
+			switch p := recv.Content.(type) {
+			case *packets.Connect:
+				_, _ = (&packets.Connack{ReasonCode: packets.ConnackSuccess, Properties: &packets.Properties{}}).WriteTo(serverConn)
+			case *packets.Publish:
+				_, _ = p.WriteTo(serverConn)
+			case *packets.Disconnect:
+				return
+			}
+		}
+	}()
+
+	received := make(chan context.Context, 1)
+	c := paho.NewClient(paho.ClientConfig{
+		Conn:               clientConn,
+		PublishContextHook: PublishHook(W3C{}),
+		Router: paho.NewSingleContextHandlerRouter(ContextHandler(W3C{}, func(ctx context.Context, p *paho.Publish) {
+			received <- ctx
+		})),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Connect(ctx, &paho.Connect{ClientID: "test", KeepAlive: 30, CleanStart: true}); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	defer c.Disconnect(&paho.Disconnect{})
+
+	pubCtx := ContextWithTraceContext(ctx, TraceContext{TraceParent: testTraceParent, TraceState: testTraceState})
+	if _, err := c.Publish(pubCtx, &paho.Publish{Topic: "test", Payload: []byte("hello")}); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+
+	select {
+	case rctx := <-received:
+		tc, ok := FromContext(rctx)
+		if !ok || tc.TraceParent != testTraceParent || tc.TraceState != testTraceState {
+			t.Fatalf("unexpected trace context %+v (%v)", tc, ok)
+		}
+		if _, ok := paho.MessageInfoFromContext(rctx); !ok {
+			t.Fatal("router context not passed on")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
@@ -0,0 +1,154 @@
+package paho
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+// ErrDropPacket may be returned by a PacketInterceptor to silently discard
+// a packet; an outbound packet is not sent and an inbound packet is ignored.
+// The send is treated as successful unless the server would have responded
+// to the packet (a CONNECT, QoS 1/2 PUBLISH, SUBSCRIBE or UNSUBSCRIBE); in
+// that case Connect, Publish, Subscribe or Unsubscribe returns ErrDropPacket
+// immediately (rather than waiting for a response that will never arrive)
+// and any packet identifier is released.
+var ErrDropPacket = errors.New("packet dropped by interceptor")
+
+// PacketInterceptor is a type for a function that is called with each
+// control packet sent, or received, by the Client (including CONNECT,
+// AUTH, PINGREQ etc). The interceptor may inspect or modify the packet
+// (the Content may be replaced, but should not be nil) or reject it by
+// returning an error; an outbound packet that is rejected is not sent and
+// the error is returned to the caller, an inbound packet that is rejected
+// is treated as a protocol error (the connection will be closed). Return
+// ErrDropPacket to discard a packet without error.
+// Interceptors are called synchronously from the client's goroutines so
+// must be thread safe and should return promptly.
+type PacketInterceptor func(*packets.ControlPacket) error
+
+// intercept passes cp through the interceptors in order, stopping at the
+// first error
+func intercept(interceptors []PacketInterceptor, cp *packets.ControlPacket) error {
+	for _, i := range interceptors {
+		if err := i(cp); err != nil {
+			return err
+		}
+	}
+	// An interceptor may have replaced the packet
+	if pt := packetType(cp.Content); pt != 0 {
+		cp.Type = pt
+	}
+	return nil
+}
+
+// write passes the packet p through the outbound interceptors then queues
+// it to be written to the connection, waiting until it has been written,
+// and records it in the metrics. ErrDropPacket is returned if a packet that
+// awaits a response is dropped by an interceptor.
+func (c *Client) write(p packets.Packet) (int64, error) {
+	if len(c.OutboundInterceptors) > 0 {
+		cp := &packets.ControlPacket{FixedHeader: packets.FixedHeader{Type: packetType(p), Flags: headerFlags(p)}, Content: p}
+		if err := intercept(c.OutboundInterceptors, cp); err != nil {
+			if errors.Is(err, ErrDropPacket) {
+				c.log.Debug("outbound packet dropped by interceptor", PacketTypeField(cp.Type))
+				if awaitsResponse(p) {
+					return 0, ErrDropPacket
+				}
+				return 0, nil
+			}
+			return 0, fmt.Errorf("outbound %s rejected by interceptor: %w", cp.PacketType(), err)
+		}
+		p = cp.Content
+	}
+	n, err := c.outbound().write(p)
+	if err == nil {
+		c.Metrics.PacketSent(packetType(p), int(n))
+	}
+	return n, err
+}
+
+// headerFlags returns the flags (the low four bits of the first byte of the
+// fixed header) that will be sent with p
+func headerFlags(p packets.Packet) byte {
+	switch p := p.(type) {
+	case *packets.Publish:
+		f := p.QoS << 1
+		if p.Duplicate {
+			f |= 1 << 3
+		}
+		if p.Retain {
+			f |= 1
+		}
+		return f
+	case *packets.Pubrel, *packets.Subscribe, *packets.Unsubscribe:
+		return 2
+	}
+	return 0
+}
+
+// awaitsResponse reports whether the sender of p waits for the server to
+// respond to it
+func awaitsResponse(p packets.Packet) bool {
+	switch p := p.(type) {
+	case *packets.Publish:
+		return p.QoS > 0
+	case *packets.Connect, *packets.Subscribe, *packets.Unsubscribe:
+		return true
+	}
+	return false
+}
+
+// read reads the next packet from the connection, recording it in the
+// metrics, and passes it through the inbound interceptors (packets that
+// are dropped by an interceptor are skipped)
+func (c *Client) read() (*packets.ControlPacket, error) {
+	for {
+		recv, err := c.packetReader().ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		c.Metrics.PacketReceived(recv.Type, recv.Size())
+		if err = intercept(c.InboundInterceptors, recv); err != nil {
+			if errors.Is(err, ErrDropPacket) {
+				c.log.Debug("inbound packet dropped by interceptor", PacketTypeField(recv.Type))
+				continue
+			}
+			return nil, fmt.Errorf("inbound %s rejected by interceptor: %w", recv.PacketType(), err)
+		}
+		return recv, nil
+	}
+}
+
+// packetReader returns the packets.Reader used to read from the connection
+func (c *Client) packetReader() *packets.Reader {
+	c.ioMu.Lock()
+	defer c.ioMu.Unlock()
+	if c.reader == nil {
+		c.reader = packets.NewReader(c.Conn)
+	}
+	return c.reader
+}
+
+// outbound returns the queue through which packets are written to the
+// connection, starting the goroutine that writes them when first called
+func (c *Client) outbound() *writeQueue {
+	c.ioMu.Lock()
+	defer c.ioMu.Unlock()
+	if c.queue == nil {
+		c.queue = newWriteQueue(packets.NewWriter(c.Conn))
+		go c.queue.run()
+	}
+	return c.queue
+}
+
+// stopWriting stops the goroutine that writes to the connection (this is
+// called after the connection has been closed)
+func (c *Client) stopWriting() {
+	c.ioMu.Lock()
+	defer c.ioMu.Unlock()
+	if c.queue != nil {
+		c.queue.close()
+	}
+}
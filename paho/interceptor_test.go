@@ -0,0 +1,286 @@
+package paho
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/semaphore"
+)
+
+// packetRecorder records the type of each packet passed to its interceptor
+type packetRecorder struct {
+	mu    sync.Mutex
+	types []byte
+}
+
+func (r *packetRecorder) intercept(cp *packets.ControlPacket) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types = append(r.types, cp.Type)
+	return nil
+}
+
+func (r *packetRecorder) seen(pt byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.types {
+		if t == pt {
+			return true
+		}
+	}
+	return false
+}
+
+// startInterceptedClient returns a client, using ts, with the provided interceptors
+func startInterceptedClient(t *testing.T, ts *testServer, cfg ClientConfig) *Client {
+	cfg.Conn = ts.ClientConn()
+	c := NewClient(cfg)
+	require.NotNil(t, c)
+	c.SetDebugLogger(log.New(os.Stderr, "INTERCEPTOR: ", log.LstdFlags))
+
+	c.serverInflight = semaphore.NewWeighted(10000)
+	c.clientInflight = semaphore.NewWeighted(10000)
+	c.stop = make(chan struct{})
+	c.publishPackets = make(chan *packets.Publish)
+	go c.incoming()
+	return c
+}
+
+func TestInterceptorsSeeAllPackets(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.CONNACK, &packets.Connack{
+		ReasonCode: packets.ConnackSuccess,
+		Properties: &packets.Properties{},
+	})
+	ts.SetResponse(packets.SUBACK, &packets.Suback{
+		Reasons:    []byte{1},
+		Properties: &packets.Properties{},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	var in, out packetRecorder
+	c := NewClient(ClientConfig{
+		Conn:                 ts.ClientConn(),
+		InboundInterceptors:  []PacketInterceptor{in.intercept},
+		OutboundInterceptors: []PacketInterceptor{out.intercept},
+	})
+	require.NotNil(t, c)
+	c.SetDebugLogger(log.New(os.Stderr, "INTERCEPTOR: ", log.LstdFlags))
+
+	_, err := c.Connect(context.Background(), &Connect{KeepAlive: 1, ClientID: "testClient", CleanStart: true})
+	require.NoError(t, err)
+	_, err = c.Subscribe(context.Background(), &Subscribe{Subscriptions: []SubscribeOptions{{Topic: "test/1", QoS: 1}}})
+	require.NoError(t, err)
+
+	// The PingHandler sends PINGREQ via the client so these are also intercepted
+	deadline := time.Now().Add(3 * time.Second)
+	for !in.seen(packets.PINGRESP) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = c.Disconnect(&Disconnect{})
+
+	for _, pt := range []byte{packets.CONNECT, packets.SUBSCRIBE, packets.PINGREQ, packets.DISCONNECT} {
+		assert.True(t, out.seen(pt), "outbound packet type %d not intercepted", pt)
+	}
+	for _, pt := range []byte{packets.CONNACK, packets.SUBACK, packets.PINGRESP} {
+		assert.True(t, in.seen(pt), "inbound packet type %d not intercepted", pt)
+	}
+}
+
+func TestOutboundInterceptorReject(t *testing.T) {
+	ts := newTestServer()
+	go ts.Run()
+	defer ts.Stop()
+
+	errRejected := errors.New("topic not permitted")
+	c := startInterceptedClient(t, ts, ClientConfig{
+		OutboundInterceptors: []PacketInterceptor{func(cp *packets.ControlPacket) error {
+			if p, ok := cp.Content.(*packets.Publish); ok && p.Topic == "secret" {
+				return errRejected
+			}
+			return nil
+		}},
+	})
+
+	_, err := c.Publish(context.Background(), &Publish{Topic: "secret", Payload: []byte("x")})
+	assert.True(t, errors.Is(err, errRejected), "expected interceptor error, got %v", err)
+	_, err = c.Publish(context.Background(), &Publish{Topic: "public", Payload: []byte("x")})
+	assert.NoError(t, err)
+}
+
+func TestOutboundInterceptorDrop(t *testing.T) {
+	ts := newTestServer()
+	go ts.Run()
+	defer ts.Stop()
+
+	m := newRecordingMetrics()
+	c := startInterceptedClient(t, ts, ClientConfig{
+		Metrics: m,
+		OutboundInterceptors: []PacketInterceptor{func(*packets.ControlPacket) error {
+			return ErrDropPacket
+		}},
+	})
+
+	_, err := c.Publish(context.Background(), &Publish{Topic: "test/1", Payload: []byte("x")})
+	assert.NoError(t, err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	assert.Equal(t, 0, m.sent[packets.PUBLISH], "dropped packet should not be sent")
+}
+
+func TestOutboundInterceptorDropAwaitingResponse(t *testing.T) {
+	ts := newTestServer()
+	go ts.Run()
+	defer ts.Stop()
+
+	var ids []uint16
+	persistence := &MemoryPersistence{}
+	persistence.Open()
+	c := startInterceptedClient(t, ts, ClientConfig{
+		Persistence: persistence,
+		OutboundInterceptors: []PacketInterceptor{func(cp *packets.ControlPacket) error {
+			ids = append(ids, cp.PacketID())
+			return ErrDropPacket
+		}},
+	})
+
+	// Nothing is sent so no response will arrive; the call must not wait for one
+	start := time.Now()
+	_, err := c.Publish(context.Background(), &Publish{Topic: "test/1", QoS: 1, Payload: []byte("x")})
+	assert.True(t, errors.Is(err, ErrDropPacket), "expected ErrDropPacket, got %v", err)
+	_, err = c.Subscribe(context.Background(), &Subscribe{Subscriptions: []SubscribeOptions{{Topic: "test/2"}}})
+	assert.True(t, errors.Is(err, ErrDropPacket), "expected ErrDropPacket, got %v", err)
+	assert.Less(t, int64(time.Since(start)), int64(c.PacketTimeout/2))
+
+	require.Len(t, ids, 2)
+	for _, id := range ids {
+		assert.Nil(t, c.MIDs.Get(id), "packet identifier %d should be released", id)
+	}
+	assert.Empty(t, persistence.All(), "dropped PUBLISH should not be persisted")
+	c.inflightMu.Lock()
+	assert.Equal(t, 0, c.inflight)
+	c.inflightMu.Unlock()
+}
+
+func TestOutboundInterceptorFlags(t *testing.T) {
+	ts := newTestServer()
+	go ts.Run()
+	defer ts.Stop()
+
+	flags := make(map[byte]byte)
+	var mu sync.Mutex
+	c := startInterceptedClient(t, ts, ClientConfig{
+		OutboundInterceptors: []PacketInterceptor{func(cp *packets.ControlPacket) error {
+			mu.Lock()
+			defer mu.Unlock()
+			flags[cp.Type] = cp.Flags
+			return ErrDropPacket
+		}},
+	})
+
+	// The flags match those that would be sent (as when the packet is created with packets.NewControlPacket)
+	_, _ = c.Publish(context.Background(), &Publish{Topic: "test/1", QoS: 2, Retain: true, Payload: []byte("x")})
+	_, _ = c.Subscribe(context.Background(), &Subscribe{Subscriptions: []SubscribeOptions{{Topic: "test/2"}}})
+	_, _ = c.Unsubscribe(context.Background(), &Unsubscribe{Topics: []string{"test/2"}})
+	_, _ = c.write(&packets.Pubrel{PacketID: 1, Properties: &packets.Properties{}})
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, byte(2<<1|1), flags[packets.PUBLISH])
+	assert.Equal(t, byte(2), flags[packets.SUBSCRIBE])
+	assert.Equal(t, byte(2), flags[packets.UNSUBSCRIBE])
+	assert.Equal(t, byte(2), flags[packets.PUBREL])
+}
+
+func TestOutboundInterceptorDropConnect(t *testing.T) {
+	ts := newTestServer()
+	go ts.Run()
+	defer ts.Stop()
+
+	c := NewClient(ClientConfig{
+		Conn: ts.ClientConn(),
+		OutboundInterceptors: []PacketInterceptor{func(*packets.ControlPacket) error {
+			return ErrDropPacket
+		}},
+	})
+	require.NotNil(t, c)
+
+	// The CONNACK will never arrive so Connect must not wait for it
+	start := time.Now()
+	_, err := c.Connect(context.Background(), &Connect{KeepAlive: 30, ClientID: "testClient", CleanStart: true})
+	assert.True(t, errors.Is(err, ErrDropPacket), "expected ErrDropPacket, got %v", err)
+	assert.Less(t, int64(time.Since(start)), int64(c.PacketTimeout/2))
+}
+
+func TestInboundInterceptorModify(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.PUBACK, &packets.Puback{
+		ReasonCode: packets.PubackSuccess,
+		Properties: &packets.Properties{},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	c := startInterceptedClient(t, ts, ClientConfig{
+		InboundInterceptors: []PacketInterceptor{func(cp *packets.ControlPacket) error {
+			if pa, ok := cp.Content.(*packets.Puback); ok {
+				pa.ReasonCode = packets.PubackQuotaExceeded
+			}
+			return nil
+		}},
+	})
+
+	pr, err := c.Publish(context.Background(), &Publish{Topic: "test/1", QoS: 1, Payload: []byte("x")})
+	assert.Error(t, err)
+	require.NotNil(t, pr)
+	assert.Equal(t, byte(packets.PubackQuotaExceeded), pr.ReasonCode)
+}
+
+func TestInboundInterceptorDropAndReject(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.PUBACK, &packets.Puback{
+		ReasonCode: packets.PubackSuccess,
+		Properties: &packets.Properties{},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	errRejected := errors.New("unexpected PINGRESP")
+	clientErr := make(chan error, 1)
+	c := startInterceptedClient(t, ts, ClientConfig{
+		OnClientError: func(err error) { clientErr <- err },
+		InboundInterceptors: []PacketInterceptor{func(cp *packets.ControlPacket) error {
+			switch cp.Type {
+			case packets.PUBACK:
+				return ErrDropPacket
+			case packets.PINGRESP:
+				return errRejected
+			}
+			return nil
+		}},
+	})
+
+	// The PUBACK is dropped, simulating a lost acknowledgement
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := c.Publish(ctx, &Publish{Topic: "test/1", QoS: 1, Payload: []byte("x")})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "expected timeout, got %v", err)
+
+	// A rejected inbound packet is a client error
+	require.NoError(t, ts.SendPacket(&packets.Pingresp{}))
+	select {
+	case err = <-clientErr:
+		assert.True(t, errors.Is(err, errRejected), "expected interceptor error, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("rejected packet did not result in an error")
+	}
+}
@@ -3,6 +3,7 @@ package paho
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/eclipse/paho.golang/packets"
@@ -33,6 +34,14 @@ type MIDService interface {
 	Clear()
 }
 
+// midReserver is implemented by MIDServices (including the library
+// provided MIDs) that allow a specific messageid to be claimed, this is
+// required to track the responses to messages resent when a session is
+// resumed
+type midReserver interface {
+	Reserve(uint16, *CPContext) error
+}
+
 // CPContext is the struct that is used to return responses to
 // ControlPackets that have them, eg: the suback to a subscribe.
 // The response packet is send down the Return channel and the
@@ -78,6 +87,23 @@ func (m *MIDs) Get(i uint16) *CPContext {
 	return m.index[i]
 }
 
+// Reserve marks the messageid i as in use and associates it with the
+// *CPContext c. It is used when resending persisted messages on the
+// resumption of a session (these must retain their original messageid).
+// An error is returned if the messageid is invalid or already in use.
+func (m *MIDs) Reserve(i uint16, c *CPContext) error {
+	m.Lock()
+	defer m.Unlock()
+	if i < midMin {
+		return fmt.Errorf("invalid message id %d", i)
+	}
+	if m.index[i] != nil {
+		return fmt.Errorf("message id %d already in use", i)
+	}
+	m.index[i] = c
+	return nil
+}
+
 // Free is the library provided MIDService's implementation of
 // the required interface function()
 func (m *MIDs) Free(i uint16) {
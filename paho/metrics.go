@@ -0,0 +1,101 @@
+package paho
+
+import (
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+type (
+	// Metrics interface allows implementations to record information about
+	// the operation of the client (e.g. to expose counters for monitoring).
+	// Methods are called synchronously from the client's goroutines so must
+	// be thread safe and should return promptly.
+	Metrics interface {
+		// PacketSent is called when a packet (of type packetType, e.g.
+		// packets.PUBLISH) has been written to the connection; size is the
+		// number of bytes written
+		PacketSent(packetType byte, size int)
+		// PacketReceived is called when a packet has been read from the
+		// connection; size is the number of bytes read
+		PacketReceived(packetType byte, size int)
+		// PublishCompleted is called when a call to Publish completes; latency
+		// is the time between the call and the final acknowledgement (or the
+		// packet being written for QoS0)
+		PublishCompleted(qos byte, latency time.Duration, err error)
+		// Inflight is called when the number of outgoing QoS1/2 messages
+		// awaiting acknowledgement changes
+		Inflight(count int)
+		// PingRTT is called when a PINGRESP is received with the time since
+		// the corresponding PINGREQ was sent
+		PingRTT(rtt time.Duration)
+		// Reconnected is called (by autopaho) when the connection to the
+		// server has been re-established
+		Reconnected()
+	}
+
+	// NOOPMetrics implements Metrics without performing any operation, it
+	// is the default.
+	NOOPMetrics struct{}
+)
+
+// PacketSent is the library provided NOOPMetrics's
+// implementation of the required interface function()
+func (NOOPMetrics) PacketSent(byte, int) {}
+
+// PacketReceived is the library provided NOOPMetrics's
+// implementation of the required interface function()
+func (NOOPMetrics) PacketReceived(byte, int) {}
+
+// PublishCompleted is the library provided NOOPMetrics's
+// implementation of the required interface function()
+func (NOOPMetrics) PublishCompleted(byte, time.Duration, error) {}
+
+// Inflight is the library provided NOOPMetrics's
+// implementation of the required interface function()
+func (NOOPMetrics) Inflight(int) {}
+
+// PingRTT is the library provided NOOPMetrics's
+// implementation of the required interface function()
+func (NOOPMetrics) PingRTT(time.Duration) {}
+
+// Reconnected is the library provided NOOPMetrics's
+// implementation of the required interface function()
+func (NOOPMetrics) Reconnected() {}
+
+// packetType returns the control packet type of p
+func packetType(p packets.Packet) byte {
+	switch p.(type) {
+	case *packets.Connect:
+		return packets.CONNECT
+	case *packets.Connack:
+		return packets.CONNACK
+	case *packets.Publish:
+		return packets.PUBLISH
+	case *packets.Puback:
+		return packets.PUBACK
+	case *packets.Pubrec:
+		return packets.PUBREC
+	case *packets.Pubrel:
+		return packets.PUBREL
+	case *packets.Pubcomp:
+		return packets.PUBCOMP
+	case *packets.Subscribe:
+		return packets.SUBSCRIBE
+	case *packets.Suback:
+		return packets.SUBACK
+	case *packets.Unsubscribe:
+		return packets.UNSUBSCRIBE
+	case *packets.Unsuback:
+		return packets.UNSUBACK
+	case *packets.Pingreq:
+		return packets.PINGREQ
+	case *packets.Pingresp:
+		return packets.PINGRESP
+	case *packets.Disconnect:
+		return packets.DISCONNECT
+	case *packets.Auth:
+		return packets.AUTH
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package paho
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/semaphore"
+)
+
+// recordingMetrics is a Metrics implementation that records the calls made
+type recordingMetrics struct {
+	mu        sync.Mutex
+	sent      map[byte]int
+	sentBytes int
+	received  map[byte]int
+	publishes []time.Duration
+	inflight  []int
+	pingRTT   []time.Duration
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{sent: make(map[byte]int), received: make(map[byte]int)}
+}
+
+func (m *recordingMetrics) PacketSent(pt byte, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent[pt]++
+	m.sentBytes += size
+}
+
+func (m *recordingMetrics) PacketReceived(pt byte, _ int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received[pt]++
+}
+
+func (m *recordingMetrics) PublishCompleted(_ byte, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.publishes = append(m.publishes, latency)
+	}
+}
+
+func (m *recordingMetrics) Inflight(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inflight = append(m.inflight, n)
+}
+
+func (m *recordingMetrics) PingRTT(rtt time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pingRTT = append(m.pingRTT, rtt)
+}
+
+func (m *recordingMetrics) Reconnected() {}
+
+func TestClientMetrics(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.PUBACK, &packets.Puback{
+		ReasonCode: packets.PubackSuccess,
+		Properties: &packets.Properties{},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	m := newRecordingMetrics()
+	c := NewClient(ClientConfig{
+		Conn:    ts.ClientConn(),
+		Metrics: m,
+	})
+	require.NotNil(t, c)
+	c.SetDebugLogger(log.New(os.Stderr, "METRICS: ", log.LstdFlags))
+
+	c.serverInflight = semaphore.NewWeighted(10000)
+	c.clientInflight = semaphore.NewWeighted(10000)
+	c.stop = make(chan struct{})
+	c.publishPackets = make(chan *packets.Publish)
+	go c.incoming()
+	go c.PingHandler.Start(c.Conn, 30*time.Second)
+
+	p := &Publish{
+		Topic:   "test/1",
+		QoS:     1,
+		Payload: []byte("test payload"),
+	}
+	_, err := c.Publish(context.Background(), p)
+	require.NoError(t, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	assert.Equal(t, 1, m.sent[packets.PUBLISH])
+	assert.Equal(t, 25, m.sentBytes) // fixed header (2) + topic (8) + packet id (2) + properties (1) + payload (12)
+	assert.Equal(t, 1, m.received[packets.PUBACK])
+	assert.Len(t, m.publishes, 1)
+	assert.Equal(t, []int{1, 0}, m.inflight)
+}
+
+func TestPingHandlerMetrics(t *testing.T) {
+	m := newRecordingMetrics()
+	p := DefaultPingerWithCustomFailHandler(func(error) {})
+	p.SetMetrics(m)
+
+	p.PingResp() // no ping outstanding so nothing should be recorded
+	p.lastPing = time.Now().Add(-50 * time.Millisecond)
+	p.pingOutstanding = 1
+	p.PingResp()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	require.Len(t, m.pingRTT, 1)
+	assert.True(t, m.pingRTT[0] >= 50*time.Millisecond, "unexpected RTT %s", m.pingRTT[0])
+}
@@ -47,6 +47,9 @@ func (m *MemoryPersistence) Open() {
 // the required interface function()
 func (m *MemoryPersistence) Put(id uint16, cp packets.ControlPacket) {
 	m.Lock()
+	if m.packets == nil {
+		m.packets = make(map[uint16]packets.ControlPacket)
+	}
 	m.packets[id] = cp
 	m.Unlock()
 }
@@ -62,9 +65,9 @@ func (m *MemoryPersistence) Get(id uint16) packets.ControlPacket {
 // All is the library provided MemoryPersistence's implementation of
 // the required interface function()
 func (m *MemoryPersistence) All() []packets.ControlPacket {
-	m.Lock()
+	m.RLock()
 	defer m.RUnlock()
-	ret := make([]packets.ControlPacket, len(m.packets))
+	ret := make([]packets.ControlPacket, 0, len(m.packets))
 
 	for _, cp := range m.packets {
 		ret = append(ret, cp)
@@ -40,9 +40,12 @@ type PingHandler struct {
 	lastPing        time.Time
 	conn            net.Conn
 	stop            chan struct{}
+	stopPending     bool // Stop was called before Start
 	pingFailHandler PingFailHandler
 	pingOutstanding int32
-	debug           Logger
+	log             LevelLogger
+	metrics         Metrics
+	writePacket     func(packets.Packet) error
 }
 
 // DefaultPingerWithCustomFailHandler returns an instance of the
@@ -52,7 +55,7 @@ type PingHandler struct {
 func DefaultPingerWithCustomFailHandler(pfh PingFailHandler) *PingHandler {
 	return &PingHandler{
 		pingFailHandler: pfh,
-		debug:           NOOPLogger{},
+		metrics:         NOOPMetrics{},
 	}
 }
 
@@ -60,32 +63,41 @@ func DefaultPingerWithCustomFailHandler(pfh PingFailHandler) *PingHandler {
 // the required interface function()
 func (p *PingHandler) Start(c net.Conn, pt time.Duration) {
 	p.mu.Lock()
+	if p.stopPending {
+		p.stopPending = false
+		p.mu.Unlock()
+		return
+	}
 	p.conn = c
-	p.stop = make(chan struct{})
+	stop := make(chan struct{})
+	p.stop = stop
 	p.mu.Unlock()
 	checkTicker := time.NewTicker(pt / 4)
 	defer checkTicker.Stop()
 	for {
 		select {
-		case <-p.stop:
+		case <-stop:
 			return
 		case <-checkTicker.C:
 			if atomic.LoadInt32(&p.pingOutstanding) > 0 && time.Since(p.lastPing) > (pt+pt>>1) {
+				p.log.Warn("ping response timed out")
 				p.pingFailHandler(fmt.Errorf("ping resp timed out"))
 				//ping outstanding and not reset in 1.5 times ping timer
 				return
 			}
-			if time.Since(p.lastPing) >= pt {
-				//time to send a ping
-				if _, err := packets.NewControlPacket(packets.PINGREQ).WriteTo(p.conn); err != nil {
+			if atomic.LoadInt32(&p.pingOutstanding) == 0 && time.Since(p.lastPing) >= pt {
+				//time to send a ping (a further ping whilst one is outstanding would reset the timeout above)
+				if err := p.sendPingreq(); err != nil {
 					if p.pingFailHandler != nil {
 						p.pingFailHandler(err)
 					}
 					return
 				}
 				atomic.AddInt32(&p.pingOutstanding, 1)
+				p.mu.Lock()
 				p.lastPing = time.Now()
-				p.debug.Println("pingHandler sending ping request")
+				p.mu.Unlock()
+				p.log.Debug("sending packet", PacketTypeField(packets.PINGREQ))
 			}
 		}
 	}
@@ -97,9 +109,11 @@ func (p *PingHandler) Stop() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.stop == nil {
+		// Start is called in a goroutine so may not have run yet
+		p.stopPending = true
 		return
 	}
-	p.debug.Println("pingHandler stopping")
+	p.log.Debug("pingHandler stopping")
 	select {
 	case <-p.stop:
 		//Already stopped, do nothing
@@ -111,12 +125,55 @@ func (p *PingHandler) Stop() {
 // PingResp is the library provided Pinger's implementation of
 // the required interface function()
 func (p *PingHandler) PingResp() {
-	p.debug.Println("pingHandler resetting pingOutstanding")
-	atomic.StoreInt32(&p.pingOutstanding, 0)
+	p.log.Debug("pingHandler resetting pingOutstanding")
+	if atomic.SwapInt32(&p.pingOutstanding, 0) > 0 {
+		p.mu.Lock()
+		rtt := time.Since(p.lastPing)
+		m := p.metrics
+		p.mu.Unlock()
+		if m != nil {
+			m.PingRTT(rtt)
+		}
+	}
 }
 
 // SetDebug sets the logger l to be used for printing debug
 // information for the pinger
 func (p *PingHandler) SetDebug(l Logger) {
-	p.debug = l
+	p.log = LevelLogger{NewLoggerAdapter(l, nil)}
+}
+
+// SetLogger sets the StructuredLogger to be used by the pinger
+func (p *PingHandler) SetLogger(l StructuredLogger) {
+	p.log = LevelLogger{l}
+}
+
+// SetMetrics sets the Metrics used to record the round trip time of
+// each ping
+func (p *PingHandler) SetMetrics(m Metrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metrics = m
+}
+
+// SetPacketWriter sets the function used to send PINGREQ packets (by
+// default they are written directly to the connection); the Client uses
+// this so that pings pass through its interceptors and metrics
+func (p *PingHandler) SetPacketWriter(w func(packets.Packet) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writePacket = w
+}
+
+// sendPingreq sends a PINGREQ to the server
+func (p *PingHandler) sendPingreq() error {
+	p.mu.Lock()
+	w := p.writePacket
+	conn := p.conn
+	p.mu.Unlock()
+	if w != nil {
+		return w(&packets.Pingreq{})
+	}
+	_, err := (&packets.Pingreq{}).WriteTo(conn)
+	return err
 }
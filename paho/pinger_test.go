@@ -0,0 +1,48 @@
+package paho
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// startPinger runs p.Start in a goroutine, returning a channel that is closed when it returns
+func startPinger(p *PingHandler, conn net.Conn) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		p.Start(conn, time.Second)
+		close(done)
+	}()
+	return done
+}
+
+func TestPingHandlerStop(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	p := DefaultPingerWithCustomFailHandler(nil)
+	done := startPinger(p, client)
+	time.Sleep(10 * time.Millisecond) // allow Start to run
+	p.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+}
+
+func TestPingHandlerStopBeforeStart(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// The Client calls Start in a goroutine, so Stop (e.g. when the connection is lost) may be called first
+	p := DefaultPingerWithCustomFailHandler(nil)
+	p.Stop()
+	select {
+	case <-startPinger(p, client):
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return when Stop had already been called")
+	}
+}
@@ -1,8 +1,10 @@
 package paho
 
 import (
+	"context"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/eclipse/paho.golang/packets"
 )
@@ -11,6 +13,56 @@ import (
 // by a Router when it has received a Publish.
 type MessageHandler func(*Publish)
 
+// ContextMessageHandler is a variant of MessageHandler that is also passed
+// a context. When routed by a Client the context is cancelled when the
+// client stops and carries a MessageInfo (see MessageInfoFromContext).
+type ContextMessageHandler func(context.Context, *Publish)
+
+// MessageInfo holds metadata about a received message, it is available
+// to ContextMessageHandlers via MessageInfoFromContext
+type MessageInfo struct {
+	// Filter is the topic filter the handler was registered with (empty
+	// when routed by a SingleHandlerRouter)
+	Filter string
+	// Received is the time at which the client received the message
+	Received time.Time
+	// Client is the client that received the message (nil if the message
+	// was not routed by a Client)
+	Client *Client
+	// Ack acknowledges the message, it must be called when the client
+	// has EnableManualAcknowledgment set (see Client.Ack); it is nil if
+	// the message was not routed by a Client
+	Ack func() error
+}
+
+type messageInfoKey struct{}
+
+// MessageInfoFromContext returns the MessageInfo carried by ctx (if any)
+func MessageInfoFromContext(ctx context.Context) (MessageInfo, bool) {
+	mi, ok := ctx.Value(messageInfoKey{}).(MessageInfo)
+	return mi, ok
+}
+
+// contextWithMessageInfo returns a context, derived from ctx, carrying mi
+func contextWithMessageInfo(ctx context.Context, mi MessageInfo) context.Context {
+	return context.WithValue(ctx, messageInfoKey{}, mi)
+}
+
+// contextWithFilter returns a context, derived from ctx, carrying a
+// MessageInfo with the Filter set
+func contextWithFilter(ctx context.Context, filter string) context.Context {
+	mi, _ := MessageInfoFromContext(ctx)
+	mi.Filter = filter
+	return contextWithMessageInfo(ctx, mi)
+}
+
+// handlerWithContext converts a MessageHandler to a ContextMessageHandler
+func handlerWithContext(h MessageHandler) ContextMessageHandler {
+	return func(_ context.Context, p *Publish) {
+		h(p)
+	}
+}
+
 // Router is an interface of the functions for a struct that is
 // used to handle invoking MessageHandlers depending on the
 // the topic the message was published on.
@@ -27,28 +79,47 @@ type Router interface {
 	SetDebugLogger(Logger)
 }
 
+// ContextRouter is a Router that also supports ContextMessageHandlers.
+// If the Client's Router is a ContextRouter then RouteContext is called
+// in place of Route.
+// RegisterContextHandler() takes a string of the topic, and a
+// ContextMessageHandler to be invoked when Publishes are received that
+// match that topic
+// RouteContext() takes a context, which is passed on to the handlers, and
+// a Publish message and determines which handlers should be invoked
+type ContextRouter interface {
+	Router
+	RegisterContextHandler(string, ContextMessageHandler)
+	RouteContext(context.Context, *packets.Publish)
+}
+
 // StandardRouter is a library provided implementation of a Router that
 // allows for unique and multiple MessageHandlers per topic
 type StandardRouter struct {
 	sync.RWMutex
-	subscriptions map[string][]MessageHandler
+	subscriptions map[string][]ContextMessageHandler
 	aliases       map[uint16]string
-	debug         Logger
+	log           LevelLogger
 }
 
 // NewStandardRouter instantiates and returns an instance of a StandardRouter
 func NewStandardRouter() *StandardRouter {
 	return &StandardRouter{
-		subscriptions: make(map[string][]MessageHandler),
+		subscriptions: make(map[string][]ContextMessageHandler),
 		aliases:       make(map[uint16]string),
-		debug:         NOOPLogger{},
 	}
 }
 
 // RegisterHandler is the library provided StandardRouter's
 // implementation of the required interface function()
 func (r *StandardRouter) RegisterHandler(topic string, h MessageHandler) {
-	r.debug.Println("registering handler for:", topic)
+	r.RegisterContextHandler(topic, handlerWithContext(h))
+}
+
+// RegisterContextHandler is the library provided StandardRouter's
+// implementation of the ContextRouter interface function()
+func (r *StandardRouter) RegisterContextHandler(topic string, h ContextMessageHandler) {
+	r.log.Debug("registering handler", TopicField(topic))
 	r.Lock()
 	defer r.Unlock()
 
@@ -58,7 +129,7 @@ func (r *StandardRouter) RegisterHandler(topic string, h MessageHandler) {
 // UnregisterHandler is the library provided StandardRouter's
 // implementation of the required interface function()
 func (r *StandardRouter) UnregisterHandler(topic string) {
-	r.debug.Println("unregistering handler for:", topic)
+	r.log.Debug("unregistering handler", TopicField(topic))
 	r.Lock()
 	defer r.Unlock()
 
@@ -68,7 +139,14 @@ func (r *StandardRouter) UnregisterHandler(topic string) {
 // Route is the library provided StandardRouter's implementation
 // of the required interface function()
 func (r *StandardRouter) Route(pb *packets.Publish) {
-	r.debug.Println("routing message for:", pb.Topic)
+	r.RouteContext(context.Background(), pb)
+}
+
+// RouteContext is the library provided StandardRouter's implementation
+// of the ContextRouter interface function(); the context passed to each
+// handler carries a MessageInfo with the matching Filter set
+func (r *StandardRouter) RouteContext(ctx context.Context, pb *packets.Publish) {
+	r.log.Debug("routing message", TopicField(pb.Topic))
 	r.RLock()
 	defer r.RUnlock()
 
@@ -76,14 +154,14 @@ func (r *StandardRouter) Route(pb *packets.Publish) {
 
 	var topic string
 	if pb.Properties.TopicAlias != nil {
-		r.debug.Println("message is using topic aliasing")
+		r.log.Debug("message is using topic aliasing", Field{Key: "topic_alias", Value: *pb.Properties.TopicAlias})
 		if pb.Topic != "" {
 			//Register new alias
-			r.debug.Printf("registering new topic alias '%d' for topic '%s'", *pb.Properties.TopicAlias, m.Topic)
+			r.log.Debug("registering new topic alias", Field{Key: "topic_alias", Value: *pb.Properties.TopicAlias}, TopicField(pb.Topic))
 			r.aliases[*pb.Properties.TopicAlias] = pb.Topic
 		}
 		if t, ok := r.aliases[*pb.Properties.TopicAlias]; ok {
-			r.debug.Printf("aliased topic '%d' translates to '%s'", *pb.Properties.TopicAlias, m.Topic)
+			r.log.Debug("aliased topic translated", Field{Key: "topic_alias", Value: *pb.Properties.TopicAlias}, TopicField(t))
 			topic = t
 		}
 	} else {
@@ -92,9 +170,10 @@ func (r *StandardRouter) Route(pb *packets.Publish) {
 
 	for route, handlers := range r.subscriptions {
 		if match(route, topic) {
-			r.debug.Println("found handler for:", route)
+			r.log.Debug("found handler", Field{Key: "route", Value: route})
+			hctx := contextWithFilter(ctx, route)
 			for _, handler := range handlers {
-				handler(m)
+				handler(hctx, m)
 			}
 		}
 	}
@@ -103,7 +182,12 @@ func (r *StandardRouter) Route(pb *packets.Publish) {
 // SetDebugLogger sets the logger l to be used for printing debug
 // information for the router
 func (r *StandardRouter) SetDebugLogger(l Logger) {
-	r.debug = l
+	r.log = LevelLogger{NewLoggerAdapter(l, nil)}
+}
+
+// SetLogger sets the StructuredLogger to be used by the router
+func (r *StandardRouter) SetLogger(l StructuredLogger) {
+	r.log = LevelLogger{l}
 }
 
 func match(route, topic string) bool {
@@ -159,23 +243,34 @@ func topicSplit(topic string) []string {
 type SingleHandlerRouter struct {
 	sync.Mutex
 	aliases map[uint16]string
-	handler MessageHandler
-	debug   Logger
+	handler ContextMessageHandler
+	log     LevelLogger
 }
 
 // NewSingleHandlerRouter instantiates and returns an instance of a SingleHandlerRouter
 func NewSingleHandlerRouter(h MessageHandler) *SingleHandlerRouter {
+	return NewSingleContextHandlerRouter(handlerWithContext(h))
+}
+
+// NewSingleContextHandlerRouter instantiates and returns an instance of a
+// SingleHandlerRouter that invokes a ContextMessageHandler
+func NewSingleContextHandlerRouter(h ContextMessageHandler) *SingleHandlerRouter {
 	return &SingleHandlerRouter{
 		aliases: make(map[uint16]string),
 		handler: h,
-		debug:   NOOPLogger{},
 	}
 }
 
 // RegisterHandler is the library provided SingleHandlerRouter's
 // implementation of the required interface function()
 func (s *SingleHandlerRouter) RegisterHandler(topic string, h MessageHandler) {
-	s.debug.Println("registering handler for:", topic)
+	s.RegisterContextHandler(topic, handlerWithContext(h))
+}
+
+// RegisterContextHandler is the library provided SingleHandlerRouter's
+// implementation of the ContextRouter interface function()
+func (s *SingleHandlerRouter) RegisterContextHandler(topic string, h ContextMessageHandler) {
+	s.log.Debug("registering handler", TopicField(topic))
 	s.handler = h
 }
 
@@ -186,27 +281,38 @@ func (s *SingleHandlerRouter) UnregisterHandler(topic string) {}
 // Route is the library provided SingleHandlerRouter's
 // implementation of the required interface function()
 func (s *SingleHandlerRouter) Route(pb *packets.Publish) {
+	s.RouteContext(context.Background(), pb)
+}
+
+// RouteContext is the library provided SingleHandlerRouter's
+// implementation of the ContextRouter interface function()
+func (s *SingleHandlerRouter) RouteContext(ctx context.Context, pb *packets.Publish) {
 	m := PublishFromPacketPublish(pb)
 
-	s.debug.Println("routing message for:", m.Topic)
+	s.log.Debug("routing message", TopicField(m.Topic))
 
 	if pb.Properties.TopicAlias != nil {
-		s.debug.Println("message is using topic aliasing")
+		s.log.Debug("message is using topic aliasing", Field{Key: "topic_alias", Value: *pb.Properties.TopicAlias})
 		if pb.Topic != "" {
 			//Register new alias
-			s.debug.Printf("registering new topic alias '%d' for topic '%s'", *pb.Properties.TopicAlias, m.Topic)
+			s.log.Debug("registering new topic alias", Field{Key: "topic_alias", Value: *pb.Properties.TopicAlias}, TopicField(pb.Topic))
 			s.aliases[*pb.Properties.TopicAlias] = pb.Topic
 		}
 		if t, ok := s.aliases[*pb.Properties.TopicAlias]; ok {
-			s.debug.Printf("aliased topic '%d' translates to '%s'", *pb.Properties.TopicAlias, m.Topic)
+			s.log.Debug("aliased topic translated", Field{Key: "topic_alias", Value: *pb.Properties.TopicAlias}, TopicField(t))
 			m.Topic = t
 		}
 	}
-	s.handler(m)
+	s.handler(ctx, m)
 }
 
 // SetDebugLogger sets the logger l to be used for printing debug
 // information for the router
 func (s *SingleHandlerRouter) SetDebugLogger(l Logger) {
-	s.debug = l
+	s.log = LevelLogger{NewLoggerAdapter(l, nil)}
+}
+
+// SetLogger sets the StructuredLogger to be used by the router
+func (s *SingleHandlerRouter) SetLogger(l StructuredLogger) {
+	s.log = LevelLogger{l}
 }
@@ -1,8 +1,12 @@
 package paho
 
 import (
+	"context"
 	"reflect"
+	"sort"
 	"testing"
+
+	"github.com/eclipse/paho.golang/packets"
 )
 
 func Test_match(t *testing.T) {
@@ -73,3 +77,21 @@ func Test_routeSplit(t *testing.T) {
 		})
 	}
 }
+
+func TestStandardRouterContextHandler(t *testing.T) {
+	r := NewStandardRouter()
+	var filters []string
+	r.RegisterContextHandler("a/#", func(ctx context.Context, p *Publish) {
+		mi, _ := MessageInfoFromContext(ctx)
+		filters = append(filters, mi.Filter)
+	})
+	r.RegisterHandler("a/b", func(p *Publish) {
+		filters = append(filters, "handler")
+	})
+
+	r.Route(&packets.Publish{Topic: "a/b", Properties: &packets.Properties{}})
+	sort.Strings(filters)
+	if !reflect.DeepEqual(filters, []string{"a/#", "handler"}) {
+		t.Fatalf("unexpected handlers called: %v", filters)
+	}
+}
@@ -27,9 +27,10 @@ type testServer struct {
 	stop       chan struct{}
 	responses  map[byte]packets.Packet
 
-	receivedMu      sync.Mutex
-	receivedPubacks []*packets.Puback
-	receivedPubrecs []*packets.Pubrec
+	receivedMu          sync.Mutex
+	receivedPubacks     []*packets.Puback
+	receivedPubrecs     []*packets.Pubrec
+	receivedDisconnects []*packets.Disconnect
 }
 
 func newTestServer() *testServer {
@@ -157,7 +158,10 @@ func (t *testServer) Run() {
 					}
 				}
 			case packets.DISCONNECT:
-				log.Println("received")
+				log.Println("received", recv.Content.(*packets.Disconnect))
+				t.receivedMu.Lock()
+				t.receivedDisconnects = append(t.receivedDisconnects, recv.Content.(*packets.Disconnect))
+				t.receivedMu.Unlock()
 			case packets.PINGREQ:
 				log.Println("test server sending pingresp")
 				pr := packets.NewControlPacket(packets.PINGRESP)
@@ -188,3 +192,13 @@ func (t *testServer) ReceivedPubrecs() []packets.Pubrec {
 	}
 	return packets
 }
+
+func (t *testServer) ReceivedDisconnects() []packets.Disconnect {
+	t.receivedMu.Lock()
+	defer t.receivedMu.Unlock()
+	packets := make([]packets.Disconnect, len(t.receivedDisconnects))
+	for k := range t.receivedDisconnects {
+		packets[k] = *t.receivedDisconnects[k]
+	}
+	return packets
+}
@@ -1,5 +1,13 @@
 package paho
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
 type (
 	// Logger interface allows implementations to provide to this package any
 	// object that implements the methods defined in it.
@@ -10,7 +18,48 @@ type (
 
 	// NOOPLogger implements the logger that does not perform any operation
 	// by default. This allows us to efficiently discard the unwanted messages.
+	// It implements both Logger and StructuredLogger.
 	NOOPLogger struct{}
+
+	// LogLevel is the severity of a log entry
+	LogLevel int
+
+	// Field is a key-value pair providing context for a log entry
+	Field struct {
+		Key   string
+		Value interface{}
+	}
+
+	// StructuredLogger interface allows implementations to receive levelled
+	// log entries, with key-value fields, from this package (e.g. to pass
+	// them on to a structured logging library).
+	StructuredLogger interface {
+		Log(level LogLevel, msg string, fields ...Field)
+	}
+
+	// LevelLogger wraps a StructuredLogger providing a method for each level;
+	// it discards entries if the StructuredLogger is nil.
+	LevelLogger struct {
+		StructuredLogger
+	}
+)
+
+// The log levels in order of increasing severity
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Keys of the fields added to log entries by this library
+const (
+	KeyPacketType = "packet_type"
+	KeyPacketID   = "packet_id"
+	KeyTopic      = "topic"
+	KeyClientID   = "client_id"
+	KeyReasonCode = "reason_code"
+	KeyError      = "error"
 )
 
 // Println is the library provided NOOPLogger's
@@ -20,3 +69,143 @@ func (NOOPLogger) Println(v ...interface{}) {}
 // Printf is the library provided NOOPLogger's
 // implementation of the required interface function(){}
 func (NOOPLogger) Printf(format string, v ...interface{}) {}
+
+// Log is the library provided NOOPLogger's
+// implementation of the required interface function()
+func (NOOPLogger) Log(LogLevel, string, ...Field) {}
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// PacketTypeField returns a field holding the name of the packet type pt
+// (e.g. packets.PUBLISH)
+func PacketTypeField(pt byte) Field {
+	name := "UNKNOWN"
+	if pt > 0 && pt <= packets.AUTH {
+		name = (&packets.ControlPacket{FixedHeader: packets.FixedHeader{Type: pt}}).PacketType()
+	}
+	return Field{Key: KeyPacketType, Value: name}
+}
+
+// PacketIDField returns a field holding a packet identifier
+func PacketIDField(id uint16) Field {
+	return Field{Key: KeyPacketID, Value: id}
+}
+
+// TopicField returns a field holding a topic (or topic filter)
+func TopicField(topic string) Field {
+	return Field{Key: KeyTopic, Value: topic}
+}
+
+// ClientIDField returns a field holding a client identifier
+func ClientIDField(id string) Field {
+	return Field{Key: KeyClientID, Value: id}
+}
+
+// ReasonCodeField returns a field holding an MQTT reason code
+func ReasonCodeField(rc byte) Field {
+	return Field{Key: KeyReasonCode, Value: rc}
+}
+
+// ErrorField returns a field holding an error
+func ErrorField(err error) Field {
+	return Field{Key: KeyError, Value: err}
+}
+
+// Debug logs msg at LevelDebug
+func (l LevelLogger) Debug(msg string, fields ...Field) {
+	l.log(LevelDebug, msg, fields)
+}
+
+// Info logs msg at LevelInfo
+func (l LevelLogger) Info(msg string, fields ...Field) {
+	l.log(LevelInfo, msg, fields)
+}
+
+// Warn logs msg at LevelWarn
+func (l LevelLogger) Warn(msg string, fields ...Field) {
+	l.log(LevelWarn, msg, fields)
+}
+
+// Error logs msg at LevelError
+func (l LevelLogger) Error(msg string, fields ...Field) {
+	l.log(LevelError, msg, fields)
+}
+
+func (l LevelLogger) log(level LogLevel, msg string, fields []Field) {
+	switch l.StructuredLogger.(type) {
+	case nil, NOOPLogger:
+		return
+	}
+	l.StructuredLogger.Log(level, msg, fields...)
+}
+
+// loggerAdapter is a StructuredLogger that writes to Loggers
+type loggerAdapter struct {
+	debug  Logger
+	errors Logger
+}
+
+// NewLoggerAdapter returns a StructuredLogger that writes entries, formatted
+// as "msg key=value ...", to Loggers. Entries at LevelWarn and above are
+// written to errors, others to debug; either may be nil (or a NOOPLogger), in
+// which case the corresponding entries are discarded without being formatted.
+// If both are discarded a NOOPLogger is returned.
+func NewLoggerAdapter(debug, errors Logger) StructuredLogger {
+	debug, errors = discardNOOP(debug), discardNOOP(errors)
+	if debug == nil && errors == nil {
+		return NOOPLogger{}
+	}
+	return loggerAdapter{debug: debug, errors: errors}
+}
+
+// discardNOOP returns nil if l is a NOOPLogger (otherwise l)
+func discardNOOP(l Logger) Logger {
+	switch l.(type) {
+	case NOOPLogger, *NOOPLogger:
+		return nil
+	}
+	return l
+}
+
+// Log is the library provided loggerAdapter's
+// implementation of the required interface function()
+func (a loggerAdapter) Log(level LogLevel, msg string, fields ...Field) {
+	l := a.debug
+	if level >= LevelWarn {
+		l = a.errors
+	}
+	if l == nil {
+		return
+	}
+	l.Println(FormatLogEntry(msg, fields...))
+}
+
+// FormatLogEntry returns msg followed by the fields, in the format key=value
+// (values containing spaces are quoted)
+func FormatLogEntry(msg string, fields ...Field) string {
+	if len(fields) == 0 {
+		return msg
+	}
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, f := range fields {
+		v := fmt.Sprint(f.Value)
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", f.Key, v)
+	}
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package paho
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/semaphore"
+)
+
+// lineLogger is a Logger that records each line logged
+type lineLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *lineLogger) Println(v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprint(v...))
+}
+
+func (l *lineLogger) Printf(format string, v ...interface{}) {
+	l.Println(fmt.Sprintf(format, v...))
+}
+
+// logEntry is a single call to StructuredLogger.Log
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+// entryLogger is a StructuredLogger that records each entry
+type entryLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *entryLogger) Log(level LogLevel, msg string, fields ...Field) {
+	e := logEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+}
+
+// find returns the first entry with the specified message and packet type
+func (l *entryLogger) find(msg, packetType string) (logEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.entries {
+		if e.msg == msg && e.fields[KeyPacketType] == packetType {
+			return e, true
+		}
+	}
+	return logEntry{}, false
+}
+
+func TestFormatLogEntry(t *testing.T) {
+	assert.Equal(t, "msg", FormatLogEntry("msg"))
+	assert.Equal(t, `sending packet packet_type=PUBLISH packet_id=5 topic="a b" error="" other=<nil>`,
+		FormatLogEntry("sending packet", PacketTypeField(packets.PUBLISH), PacketIDField(5), TopicField("a b"),
+			Field{Key: KeyError, Value: ""}, Field{Key: "other", Value: nil}))
+	assert.Equal(t, "x packet_type=UNKNOWN reason_code=135", FormatLogEntry("x", PacketTypeField(99), ReasonCodeField(0x87)))
+}
+
+func TestLoggerAdapter(t *testing.T) {
+	debug, errs := &lineLogger{}, &lineLogger{}
+	l := LevelLogger{NewLoggerAdapter(debug, errs)}
+	l.Debug("debug", ClientIDField("c1"))
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error", ErrorField(errors.New("failed")))
+
+	assert.Equal(t, []string{"debug client_id=c1", "info"}, debug.lines)
+	assert.Equal(t, []string{"warn", "error error=failed"}, errs.lines)
+
+	// nil Loggers, or StructuredLogger, discard entries
+	LevelLogger{NewLoggerAdapter(nil, nil)}.Error("discarded")
+	LevelLogger{}.Error("discarded")
+}
+
+func TestDefaultLoggerAllocations(t *testing.T) {
+	c := NewClient(ClientConfig{})
+	require.IsType(t, NOOPLogger{}, c.log.StructuredLogger)
+
+	err := errors.New("failed")
+	entry := func(l LevelLogger) func() {
+		return func() {
+			l.Debug("sending packet", PacketTypeField(packets.PUBLISH), PacketIDField(500), TopicField("a/b"), ErrorField(err))
+		}
+	}
+	// Constructing the fields may allocate but disabled entries must not be formatted
+	baseline := testing.AllocsPerRun(100, entry(LevelLogger{}))
+	assert.Equal(t, baseline, testing.AllocsPerRun(100, entry(c.log)), "default client")
+	assert.Equal(t, baseline, testing.AllocsPerRun(100, entry(LevelLogger{NewLoggerAdapter(NOOPLogger{}, &lineLogger{})})), "debug discarded")
+	assert.Equal(t, baseline, testing.AllocsPerRun(100, entry(LevelLogger{NewLoggerAdapter(&NOOPLogger{}, nil)})), "pointer to NOOPLogger")
+}
+
+func TestClientStructuredLogger(t *testing.T) {
+	ts := newTestServer()
+	ts.SetResponse(packets.PUBACK, &packets.Puback{
+		ReasonCode: packets.PubackNotAuthorized,
+		Properties: &packets.Properties{},
+	})
+	go ts.Run()
+	defer ts.Stop()
+
+	l := &entryLogger{}
+	router := NewStandardRouter()
+	c := NewClient(ClientConfig{
+		Conn:   ts.ClientConn(),
+		Router: router,
+		Logger: l,
+	})
+	require.NotNil(t, c)
+	c.SetDebugLogger(&lineLogger{}) // ignored as Logger is set
+	assert.Equal(t, l, router.log.StructuredLogger, "Logger should be passed to the router")
+
+	c.serverInflight = semaphore.NewWeighted(10000)
+	c.clientInflight = semaphore.NewWeighted(10000)
+	c.stop = make(chan struct{})
+	c.publishPackets = make(chan *packets.Publish)
+	go c.incoming()
+	go c.PingHandler.Start(c.Conn, 30*time.Second)
+
+	_, err := c.Publish(context.Background(), &Publish{
+		Topic:   "test/1",
+		QoS:     1,
+		Payload: []byte("test payload"),
+	})
+	require.Error(t, err)
+
+	e, ok := l.find("received packet", "PUBACK")
+	require.True(t, ok, "expected PUBACK to be logged")
+	assert.Equal(t, LevelDebug, e.level)
+	assert.Equal(t, uint16(1), e.fields[KeyPacketID])
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found bool
+	for _, e := range l.entries {
+		if e.msg == "received an error code in PUBACK" {
+			found = true
+			assert.Equal(t, LevelDebug, e.level) // returned to the caller so not a client warning
+			assert.Equal(t, byte(packets.PubackNotAuthorized), e.fields[KeyReasonCode])
+		}
+	}
+	assert.True(t, found, "expected error code to be logged")
+}
@@ -0,0 +1,176 @@
+package paho
+
+import (
+	"sync"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+// maxBatch is the number of packets written before the connection is
+// flushed, even if more packets are waiting
+const maxBatch = 64
+
+// Packets written by the Client are queued and written to the connection by
+// a single goroutine. This means that the connection is never written to
+// concurrently, packets that are queued while a write is in progress are
+// sent together (with a single write to the connection where possible) and
+// acknowledgements and PINGREQs can overtake PUBLISH (and other) packets
+// that are waiting to be sent.
+
+// queuedPacket is a packet waiting to be written; done is signalled once
+// n and err have been set
+type queuedPacket struct {
+	p    packets.Packet
+	n    int64
+	err  error
+	done chan struct{}
+}
+
+var queuedPacketPool = sync.Pool{New: func() interface{} {
+	return &queuedPacket{done: make(chan struct{}, 1)}
+}}
+
+// writeQueue holds the packets waiting to be written by run
+type writeQueue struct {
+	w *packets.Writer
+
+	mu       sync.Mutex
+	priority []*queuedPacket // acknowledgements and PINGREQ
+	normal   []*queuedPacket // everything else, in the order queued
+	closed   bool
+	ready    chan struct{} // signalled when a packet is queued
+	stop     chan struct{} // closed by close
+}
+
+// newWriteQueue returns a writeQueue that writes to w; run must be called
+// to write the packets
+func newWriteQueue(w *packets.Writer) *writeQueue {
+	return &writeQueue{
+		w:     w,
+		ready: make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+	}
+}
+
+// isPriority reports whether p should be sent ahead of other packets
+func isPriority(p packets.Packet) bool {
+	switch p.(type) {
+	case *packets.Pingreq, *packets.Puback, *packets.Pubrec, *packets.Pubrel, *packets.Pubcomp:
+		return true
+	}
+	return false
+}
+
+// write queues p and waits until it has been written (and flushed) to the
+// connection, returning the size of the packet
+func (q *writeQueue) write(p packets.Packet) (int64, error) {
+	qp := queuedPacketPool.Get().(*queuedPacket)
+	qp.p = p
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		qp.p = nil
+		queuedPacketPool.Put(qp)
+		return q.writeNow(p)
+	}
+	if isPriority(p) {
+		q.priority = append(q.priority, qp)
+	} else {
+		q.normal = append(q.normal, qp)
+	}
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	<-qp.done
+	n, err := qp.n, qp.err
+	qp.p, qp.n, qp.err = nil, 0, nil
+	queuedPacketPool.Put(qp)
+	return n, err
+}
+
+// next removes, and returns, the next packet to be written (nil if there
+// are none)
+func (q *writeQueue) next() *queuedPacket {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var qp *queuedPacket
+	switch {
+	case len(q.priority) > 0:
+		qp, q.priority[0] = q.priority[0], nil
+		q.priority = q.priority[1:]
+	case len(q.normal) > 0:
+		qp, q.normal[0] = q.normal[0], nil
+		q.normal = q.normal[1:]
+	}
+	return qp
+}
+
+// run writes queued packets until close is called; packets are flushed to
+// the connection when no more are waiting (or maxBatch have been written)
+func (q *writeQueue) run() {
+	batch := make([]*queuedPacket, 0, maxBatch)
+	for {
+		select {
+		case <-q.ready:
+		case <-q.stop:
+			q.drain()
+			return
+		}
+		for {
+			qp := q.next()
+			if qp != nil {
+				qp.n, qp.err = q.w.WritePacket(qp.p)
+				batch = append(batch, qp)
+				if len(batch) < maxBatch {
+					continue
+				}
+			}
+			if len(batch) == 0 {
+				break
+			}
+			err := q.w.Flush()
+			for i, qp := range batch {
+				if qp.err == nil {
+					qp.err = err
+				}
+				qp.done <- struct{}{}
+				batch[i] = nil
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+// drain writes any packets that are still waiting once run has stopped
+func (q *writeQueue) drain() {
+	for qp := q.next(); qp != nil; qp = q.next() {
+		qp.n, qp.err = q.writeNow(qp.p)
+		qp.done <- struct{}{}
+	}
+}
+
+// writeNow writes p, and flushes it to the connection, from the calling
+// goroutine; this is used once the queue has been closed (at which point
+// the connection has been closed, so the error from the connection is
+// returned)
+func (q *writeQueue) writeNow(p packets.Packet) (int64, error) {
+	n, err := q.w.WritePacket(p)
+	if err == nil {
+		err = q.w.Flush()
+	}
+	return n, err
+}
+
+// close stops run; any packets subsequently written are written directly
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+	}
+}
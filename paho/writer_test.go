@@ -0,0 +1,111 @@
+package paho
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedWriter blocks writes until gate is closed, signalling entered when
+// the first write starts, and records the data written
+type gatedWriter struct {
+	entered chan struct{}
+	gate    chan struct{}
+	once    sync.Once
+
+	mu     sync.Mutex
+	writes int
+	buf    bytes.Buffer
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{entered: make(chan struct{}), gate: make(chan struct{})}
+}
+
+func (g *gatedWriter) Write(b []byte) (int, error) {
+	g.once.Do(func() { close(g.entered) })
+	<-g.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writes++
+	return g.buf.Write(b)
+}
+
+// queued returns the number of packets waiting in each of q's queues
+func (q *writeQueue) queued() (priority, normal int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.priority), len(q.normal)
+}
+
+func TestWriteQueuePriorityAndBatching(t *testing.T) {
+	g := newGatedWriter()
+	q := newWriteQueue(packets.NewWriter(g))
+	go q.run()
+	t.Cleanup(q.close)
+
+	var wg sync.WaitGroup
+	write := func(p packets.Packet) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := q.write(p)
+			assert.NoError(t, err)
+		}()
+	}
+
+	// The first packet is written immediately, the rest are queued behind it
+	write(&packets.Publish{Topic: "a", QoS: 1, PacketID: 1})
+	select {
+	case <-g.entered:
+	case <-time.After(time.Second):
+		t.Fatal("first packet not written")
+	}
+	waitQueued := func(priority, normal int) {
+		assert.Eventually(t, func() bool {
+			p, n := q.queued()
+			return p == priority && n == normal
+		}, time.Second, time.Millisecond)
+	}
+	write(&packets.Publish{Topic: "a", QoS: 1, PacketID: 2})
+	waitQueued(0, 1)
+	write(&packets.Subscribe{PacketID: 3, Subscriptions: []packets.SubOptions{{Topic: "b"}}})
+	waitQueued(0, 2)
+	write(&packets.Puback{PacketID: 10})
+	waitQueued(1, 2)
+	write(&packets.Pingreq{})
+	waitQueued(2, 2)
+
+	close(g.gate)
+	wg.Wait()
+
+	var got []string
+	for g.buf.Len() > 0 {
+		cp, err := packets.ReadPacket(&g.buf)
+		require.NoError(t, err)
+		got = append(got, cp.PacketType())
+	}
+	assert.Equal(t, []string{"PUBLISH", "PUBACK", "PINGREQ", "PUBLISH", "SUBSCRIBE"}, got)
+	assert.Equal(t, 2, g.writes, "queued packets should be written together")
+}
+
+type closedWriter struct{}
+
+func (closedWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestWriteQueueClosed(t *testing.T) {
+	q := newWriteQueue(packets.NewWriter(closedWriter{}))
+	go q.run()
+	_, err := q.write(&packets.Pingreq{})
+	assert.Equal(t, io.ErrClosedPipe, err)
+
+	q.close()
+	_, err = q.write(&packets.Pingreq{})
+	assert.Equal(t, io.ErrClosedPipe, err)
+}